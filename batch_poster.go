@@ -0,0 +1,330 @@
+package viper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gottingen/gekko/multierr"
+	"github.com/gottingen/viper/vipercore"
+)
+
+const _httpMaxRetryAfter = time.Minute
+
+// batchPosterConfig configures a batchPoster. Its zero values aren't
+// defaulted; the sinks built on it do that.
+type batchPosterConfig struct {
+	// Name and Noun describe the sink and what its batches hold in errors, as
+	// in "HTTP sink" and "log lines".
+	Name string
+	Noun string
+	// ErrClosed is returned when writing to or syncing a closed sink.
+	ErrClosed error
+
+	Endpoint      string
+	ContentType   string
+	Gzip          bool
+	Headers       http.Header
+	Client        *http.Client
+	Timeout       time.Duration
+	BatchSize     int
+	BatchBytes    int // zero doesn't limit the size of a batch
+	FlushInterval time.Duration
+	QueueSize     int
+	MaxRetries    int
+	RetryBackoff  time.Duration
+	ErrorOutput   vipercore.WriteSyncer
+	Metrics       vipercore.Metrics // may be nil
+
+	// Split turns a line written to the sink into the items it adds to the
+	// batch. The items must not refer to the line, which is reused.
+	Split func(line []byte) ([][]byte, error)
+	// Encode turns a batch of items into a request body.
+	Encode func(items [][]byte) ([]byte, error)
+}
+
+// batchPoster collects the lines written to a sink into batches and posts
+// them to an HTTP endpoint from a single goroutine, so that writes never wait
+// for the endpoint. It's shared by the HTTP and OTLP sinks.
+type batchPoster struct {
+	cfg batchPosterConfig
+
+	mu      sync.Mutex
+	closed  bool
+	partial []byte
+	items   [][]byte
+	size    int
+
+	batches  chan [][]byte
+	flushes  chan chan error
+	closing  chan struct{}
+	done     chan struct{}
+	closeErr error // from the posts made while closing
+
+	dropMu  sync.Mutex
+	dropped int
+}
+
+func newBatchPoster(cfg batchPosterConfig) *batchPoster {
+	p := &batchPoster{
+		cfg:     cfg,
+		batches: make(chan [][]byte, cfg.QueueSize),
+		flushes: make(chan chan error),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *batchPoster) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, p.cfg.ErrClosed
+	}
+	var err error
+	data := append(p.partial, b...)
+	p.partial = nil
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			p.partial = append([]byte(nil), data...)
+			break
+		}
+		if line := bytes.TrimSpace(data[:i]); len(line) > 0 {
+			items, splitErr := p.cfg.Split(line)
+			err = multierr.Append(err, splitErr)
+			for _, item := range items {
+				p.items = append(p.items, item)
+				p.size += len(item) + 1
+			}
+		}
+		data = data[i+1:]
+	}
+	if len(p.items) >= p.cfg.BatchSize || (p.cfg.BatchBytes > 0 && p.size >= p.cfg.BatchBytes) {
+		err = multierr.Append(err, p.queue())
+	}
+	return len(b), err
+}
+
+// queue hands the current batch to the posting goroutine, dropping it if too
+// many batches are already waiting. It must be called with the mutex held.
+func (p *batchPoster) queue() error {
+	batch := p.items
+	p.items = nil
+	p.size = 0
+	select {
+	case p.batches <- batch:
+		return nil
+	default:
+		p.dropMu.Lock()
+		p.dropped += len(batch)
+		p.dropMu.Unlock()
+		p.record(len(batch), vipercore.Metrics.SinkDropped)
+		return fmt.Errorf("%s's queue is full, dropped %d %s", p.cfg.Name, len(batch), p.cfg.Noun)
+	}
+}
+
+// Sync posts everything written so far and waits for the posts to finish.
+func (p *batchPoster) Sync() error {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return p.cfg.ErrClosed
+	}
+
+	done := make(chan error, 1)
+	select {
+	case p.flushes <- done:
+		return <-done
+	case <-p.done:
+		return p.cfg.ErrClosed
+	}
+}
+
+// Close posts everything written so far and stops the posting goroutine.
+// Retries that are waiting are given up.
+func (p *batchPoster) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return p.cfg.ErrClosed
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	close(p.closing)
+	<-p.done
+	return p.closeErr
+}
+
+// run posts batches until the sink is closed.
+func (p *batchPoster) run() {
+	defer close(p.done)
+
+	var tick <-chan time.Time
+	if p.cfg.FlushInterval > 0 {
+		ticker := time.NewTicker(p.cfg.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case batch := <-p.batches:
+			p.post(batch)
+		case <-tick:
+			p.flush()
+		case done := <-p.flushes:
+			done <- p.flush()
+		case <-p.closing:
+			p.closeErr = p.flush()
+			return
+		}
+	}
+}
+
+// flush posts the queued batches, and then the lines that don't fill a batch
+// yet.
+func (p *batchPoster) flush() error {
+	var err error
+	for n := len(p.batches); n > 0; n-- {
+		err = multierr.Append(err, p.post(<-p.batches))
+	}
+
+	p.mu.Lock()
+	batch := p.items
+	p.items = nil
+	p.size = 0
+	p.mu.Unlock()
+	return multierr.Append(err, p.post(batch))
+}
+
+// post posts a batch, retrying if the endpoint is unavailable, and reports
+// batches that can't be delivered and lines that were dropped.
+func (p *batchPoster) post(batch [][]byte) error {
+	p.reportDropped()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := p.send(batch)
+	if err == nil {
+		return nil
+	}
+	p.record(len(batch), vipercore.Metrics.SinkWriteError)
+	err = fmt.Errorf("can't post %d %s: %v", len(batch), p.cfg.Noun, err)
+	fmt.Fprintf(p.cfg.ErrorOutput, "%v %s error: %v\n", time.Now(), p.cfg.Name, err)
+	p.cfg.ErrorOutput.Sync()
+	return err
+}
+
+func (p *batchPoster) send(batch [][]byte) error {
+	body, err := p.cfg.Encode(batch)
+	if err != nil {
+		return err
+	}
+	if p.cfg.Gzip {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("can't compress request: %v", err)
+		}
+		body = compressed.Bytes()
+	}
+
+	backoff := p.cfg.RetryBackoff
+	for retry := 0; ; retry++ {
+		wait, err := p.request(body)
+		if err == nil {
+			return nil
+		}
+		if wait < 0 || retry >= p.cfg.MaxRetries {
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-p.closing:
+			timer.Stop()
+			return fmt.Errorf("%v (gave up retrying since the sink was closed)", err)
+		}
+		backoff *= 2
+	}
+}
+
+// request makes a single request. If it fails, it also returns how long to
+// wait before retrying: zero to use the backoff, or a negative wait if the
+// request shouldn't be retried.
+func (p *batchPoster) request(body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	for key, vals := range p.cfg.Headers {
+		req.Header[key] = vals
+	}
+	req.Header.Set("Content-Type", p.cfg.ContentType)
+	if p.cfg.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		var wait time.Duration
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+		if wait > _httpMaxRetryAfter {
+			wait = _httpMaxRetryAfter
+		}
+		return wait, fmt.Errorf("%s responded with %s", p.cfg.Endpoint, resp.Status)
+	default:
+		return -1, fmt.Errorf("%s responded with %s", p.cfg.Endpoint, resp.Status)
+	}
+}
+
+// record reports n items to the Metrics, if there are any.
+func (p *batchPoster) record(n int, report func(vipercore.Metrics)) {
+	if p.cfg.Metrics == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		report(p.cfg.Metrics)
+	}
+}
+
+func (p *batchPoster) reportDropped() {
+	p.dropMu.Lock()
+	dropped := p.dropped
+	p.dropped = 0
+	p.dropMu.Unlock()
+	if dropped > 0 {
+		fmt.Fprintf(p.cfg.ErrorOutput, "%v %s error: dropped %d %s, queue is full\n", time.Now(), p.cfg.Name, dropped, p.cfg.Noun)
+		p.cfg.ErrorOutput.Sync()
+	}
+}
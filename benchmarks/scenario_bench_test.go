@@ -219,6 +219,15 @@ func BenchmarkWithoutFields(b *testing.B) {
 			}
 		})
 	})
+	b.Run("viper.MessagePack", func(b *testing.B) {
+		logger := newMessagePackLogger(viper.DebugLevel)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				logger.Info(getMessage(0))
+			}
+		})
+	})
 	b.Run("viper.Check", func(b *testing.B) {
 		logger := newViperLogger(viper.DebugLevel)
 		b.ResetTimer()
@@ -357,6 +366,15 @@ func BenchmarkAccumulatedContext(b *testing.B) {
 			}
 		})
 	})
+	b.Run("viper.MessagePack", func(b *testing.B) {
+		logger := newMessagePackLogger(viper.DebugLevel).With(fakeFields()...)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				logger.Info(getMessage(0))
+			}
+		})
+	})
 	b.Run("viper.Check", func(b *testing.B) {
 		logger := newViperLogger(viper.DebugLevel).With(fakeFields()...)
 		b.ResetTimer()
@@ -477,6 +495,15 @@ func BenchmarkAddingFields(b *testing.B) {
 			}
 		})
 	})
+	b.Run("viper.MessagePack", func(b *testing.B) {
+		logger := newMessagePackLogger(viper.DebugLevel)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				logger.Info(getMessage(0), fakeFields()...)
+			}
+		})
+	})
 	b.Run("viper.Check", func(b *testing.B) {
 		logger := newViperLogger(viper.DebugLevel)
 		b.ResetTimer()
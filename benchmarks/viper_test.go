@@ -86,10 +86,20 @@ func (u *user) MarshalLogObject(enc vipercore.ObjectEncoder) error {
 }
 
 func newViperLogger(lvl vipercore.Level) *viper.Logger {
+	return newEncoderLogger(lvl, vipercore.NewJSONEncoder)
+}
+
+func newMessagePackLogger(lvl vipercore.Level) *viper.Logger {
+	return newEncoderLogger(lvl, func(ec vipercore.EncoderConfig) vipercore.Encoder {
+		return vipercore.NewMessagePackEncoder(ec)
+	})
+}
+
+func newEncoderLogger(lvl vipercore.Level, newEncoder func(vipercore.EncoderConfig) vipercore.Encoder) *viper.Logger {
 	ec := viper.NewProductionEncoderConfig()
 	ec.EncodeDuration = vipercore.NanosDurationEncoder
 	ec.EncodeTime = vipercore.EpochNanosTimeEncoder
-	enc := vipercore.NewJSONEncoder(ec)
+	enc := newEncoder(ec)
 	return viper.New(vipercore.NewCore(
 		enc,
 		&vtest.Discarder{},
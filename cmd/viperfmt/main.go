@@ -0,0 +1,244 @@
+
+
+// viperfmt pretty-prints JSON logs written by viper's JSON encoder.
+//
+// It reads JSON lines from the files named on the command line, or from
+// standard input if there are none, and writes them out with the console
+// encoder. Lines that aren't JSON objects are copied through unchanged.
+//
+//   kubectl logs my-pod | viperfmt -level warn -exclude pid,hostname
+//
+// By default, viperfmt expects the keys and time format of
+// viper.NewProductionEncoderConfig; flags let you override them.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	"github.com/gottingen/viper/vipercore"
+)
+
+type options struct {
+	input     vipercore.EncoderConfig
+	minLevel  *vipercore.Level
+	include   map[string]bool
+	exclude   map[string]bool
+	colorMode string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts, files, err := parseFlags(args, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		fmt.Fprintf(stderr, "viperfmt: %v\n", err)
+		return 2
+	}
+
+	out := bufio.NewWriter(stdout)
+	defer out.Flush()
+	f := newFormatter(opts, useColor(opts.colorMode, stdout))
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	status := 0
+	for _, name := range files {
+		if err := formatFile(f, name, stdin, out); err != nil {
+			fmt.Fprintf(stderr, "viperfmt: %v\n", err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func parseFlags(args []string, stderr io.Writer) (options, []string, error) {
+	input := viper.NewProductionEncoderConfig()
+	var (
+		opts             options
+		level            string
+		include, exclude string
+		timeEncoding     string
+		fs               = flag.NewFlagSet("viperfmt", flag.ContinueOnError)
+	)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: viperfmt [flags] [file ...]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&input.MessageKey, "message-key", input.MessageKey, "key of the log message")
+	fs.StringVar(&input.LevelKey, "level-key", input.LevelKey, "key of the log level")
+	fs.StringVar(&input.TimeKey, "time-key", input.TimeKey, "key of the timestamp")
+	fs.StringVar(&input.NameKey, "name-key", input.NameKey, "key of the logger name")
+	fs.StringVar(&input.CallerKey, "caller-key", input.CallerKey, "key of the caller")
+	fs.StringVar(&input.StacktraceKey, "stacktrace-key", input.StacktraceKey, "key of the stacktrace")
+	fs.StringVar(&timeEncoding, "time-encoding", "epoch", `encoding of the timestamps: "epoch", "millis", "nanos", "iso8601", "rfc3339", or "rfc3339nano"`)
+	fs.StringVar(&level, "level", "", "only show entries at or above this level")
+	fs.StringVar(&include, "fields", "", "comma-separated keys of the only fields to show")
+	fs.StringVar(&exclude, "exclude", "", "comma-separated keys of fields to hide")
+	fs.StringVar(&opts.colorMode, "color", "auto", `color levels: "auto", "always", or "never"`)
+	if err := fs.Parse(args); err != nil {
+		return opts, nil, err
+	}
+
+	if err := input.EncodeTime.UnmarshalText([]byte(timeEncoding)); err != nil {
+		return opts, nil, err
+	}
+	if level != "" {
+		var lvl vipercore.Level
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return opts, nil, err
+		}
+		opts.minLevel = &lvl
+	}
+	switch opts.colorMode {
+	case "auto", "always", "never":
+	default:
+		return opts, nil, fmt.Errorf("invalid -color mode %q", opts.colorMode)
+	}
+	opts.input = input
+	opts.include = keySet(include)
+	opts.exclude = keySet(exclude)
+	return opts, fs.Args(), nil
+}
+
+func keySet(list string) map[string]bool {
+	if list == "" {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, k := range strings.Split(list, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			set[k] = true
+		}
+	}
+	return set
+}
+
+// useColor reports whether to color levels, detecting terminals in auto mode.
+func useColor(mode string, w io.Writer) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+type formatter struct {
+	opts options
+	dec  vipercore.Decoder
+	enc  vipercore.Encoder
+	// untimed formats entries that don't record a time, rather than printing
+	// the zero time.
+	untimed vipercore.Encoder
+}
+
+func newFormatter(opts options, color bool) *formatter {
+	output := viper.NewDevelopmentEncoderConfig()
+	output.EncodeCaller = vipercore.FullCallerEncoder // callers are already trimmed
+	if color {
+		output.EncodeLevel = vipercore.CapitalColorLevelEncoder
+	}
+	untimed := output
+	untimed.TimeKey = ""
+	return &formatter{
+		opts:    opts,
+		dec:     vipercore.NewJSONDecoder(opts.input),
+		enc:     vipercore.NewConsoleEncoder(output),
+		untimed: vipercore.NewConsoleEncoder(untimed),
+	}
+}
+
+func formatFile(f *formatter, name string, stdin io.Reader, out io.Writer) error {
+	r := stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if werr := f.formatLine(line, out); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("can't read %s: %v", name, err)
+		}
+	}
+}
+
+func (f *formatter) formatLine(line []byte, out io.Writer) error {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return passthrough(line, out)
+	}
+	ent, fields, err := f.dec.Decode(trimmed)
+	if err != nil {
+		return passthrough(line, out)
+	}
+	if f.opts.minLevel != nil && !f.opts.minLevel.Enabled(ent.Level) {
+		return nil
+	}
+
+	kept := fields[:0]
+	for _, field := range fields {
+		if f.opts.include != nil && !f.opts.include[field.Key] {
+			continue
+		}
+		if f.opts.exclude[field.Key] {
+			continue
+		}
+		kept = append(kept, field)
+	}
+
+	enc := f.enc
+	if ent.Time.IsZero() {
+		enc = f.untimed
+	}
+	buf, err := enc.EncodeEntry(ent, kept)
+	if err != nil {
+		return passthrough(line, out)
+	}
+	_, err = out.Write(buf.Bytes())
+	buffer.Put(buf)
+	return err
+}
+
+func passthrough(line []byte, out io.Writer) error {
+	if _, err := out.Write(line); err != nil {
+		return err
+	}
+	if line[len(line)-1] != '\n' {
+		_, err := io.WriteString(out, "\n")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleLogs = `{"level":"debug","ts":1500000000.5,"caller":"app/main.go:10","msg":"starting","pid":42}
+not json at all
+{"level":"warn","ts":1500000001,"logger":"db","msg":"slow query","elapsed":1.5,"pid":42}
+{"level":"error","ts":1500000002,"msg":"failed","error":"boom","stacktrace":"main.main\n\t/app/main.go:20"}
+{"truncated":
+`
+
+func runViperfmt(t testing.TB, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// withoutTimes strips the leading timestamp from each formatted entry, since
+// it's rendered in the local time zone.
+func withoutTimes(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "20") {
+			lines[i] = line[strings.IndexByte(line, '\t')+1:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestFormat(t *testing.T) {
+	code, stdout, stderr := runViperfmt(t, sampleLogs)
+	assert.Equal(t, 0, code, "Unexpected exit code.")
+	assert.Empty(t, stderr, "Unexpected error output.")
+	assert.Equal(
+		t,
+		"DEBUG\tapp/main.go:10\tstarting\t{\"pid\": 42}\n"+
+			"not json at all\n"+
+			"WARN\tdb\tslow query\t{\"elapsed\": 1.5, \"pid\": 42}\n"+
+			"ERROR\tfailed\t{\"error\": \"boom\"}\n"+
+			"main.main\n\t/app/main.go:20\n"+
+			"{\"truncated\":\n",
+		withoutTimes(stdout),
+		"Unexpected formatted output.",
+	)
+}
+
+func TestFormatFilters(t *testing.T) {
+	code, stdout, _ := runViperfmt(t, sampleLogs, "-level", "warn", "-exclude", "pid", "-color", "never")
+	assert.Equal(t, 0, code, "Unexpected exit code.")
+	assert.Equal(
+		t,
+		"not json at all\n"+
+			"WARN\tdb\tslow query\t{\"elapsed\": 1.5}\n"+
+			"ERROR\tfailed\t{\"error\": \"boom\"}\n"+
+			"main.main\n\t/app/main.go:20\n"+
+			"{\"truncated\":\n",
+		withoutTimes(stdout),
+		"Unexpected output filtering levels and excluding fields.",
+	)
+
+	_, stdout, _ = runViperfmt(t, sampleLogs, "-fields", "elapsed", "-level", "warn")
+	assert.Contains(t, stdout, "slow query\t{\"elapsed\": 1.5}\n", "Expected only included fields.")
+	assert.Contains(t, stdout, "ERROR\tfailed\n", "Expected fields to be dropped.")
+}
+
+func TestFormatColor(t *testing.T) {
+	_, stdout, _ := runViperfmt(t, sampleLogs, "-color", "always")
+	assert.Contains(t, stdout, "\x1b[33mWARN\x1b[0m", "Expected colored levels.")
+
+	_, stdout, _ = runViperfmt(t, sampleLogs, "-color", "auto")
+	assert.NotContains(t, stdout, "\x1b[", "Expected no colors when not writing to a terminal.")
+}
+
+func TestFormatCustomKeys(t *testing.T) {
+	logs := `{"severity":"ERROR","time":"2017-07-14T02:40:00.000Z","message":"custom"}` + "\n"
+	_, stdout, _ := runViperfmt(t, logs, "-level-key", "severity", "-time-key", "time", "-message-key", "message", "-time-encoding", "iso8601")
+	assert.Equal(t, "ERROR\tcustom\n", withoutTimes(stdout), "Unexpected output with custom keys.")
+}
+
+func TestFormatFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viperfmt")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"level":"info","msg":"from file"}`), 0644))
+
+	code, stdout, stderr := runViperfmt(t, "", path, filepath.Join(dir, "missing.log"))
+	assert.Equal(t, 1, code, "Expected a failure exit code for a missing file.")
+	assert.Equal(t, "INFO\tfrom file\n", stdout, "Unexpected output from file.")
+	assert.Contains(t, stderr, "missing.log", "Expected an error about the missing file.")
+}
+
+func TestBadFlags(t *testing.T) {
+	for _, args := range [][]string{{"-level", "nope"}, {"-color", "sometimes"}, {"-not-a-flag"}} {
+		code, _, stderr := runViperfmt(t, "", args...)
+		assert.Equal(t, 2, code, "Unexpected exit code for %v.", args)
+		assert.NotEmpty(t, stderr, "Expected an error for %v.", args)
+	}
+
+	code, _, stderr := runViperfmt(t, "", "-h")
+	assert.Equal(t, 0, code, "Unexpected exit code for help.")
+	assert.Contains(t, stderr, "usage: viperfmt", "Expected usage.")
+}
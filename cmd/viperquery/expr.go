@@ -0,0 +1,517 @@
+
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gottingen/viper/vipercore"
+)
+
+// An expr is a compiled filter expression.
+type expr interface {
+	match(r *record) bool
+}
+
+type orExpr struct{ left, right expr }
+
+func (e orExpr) match(r *record) bool { return e.left.match(r) || e.right.match(r) }
+
+type andExpr struct{ left, right expr }
+
+func (e andExpr) match(r *record) bool { return e.left.match(r) && e.right.match(r) }
+
+type notExpr struct{ e expr }
+
+func (e notExpr) match(r *record) bool { return !e.e.match(r) }
+
+type matchAll struct{}
+
+func (matchAll) match(*record) bool { return true }
+
+// existsExpr matches records that have a non-empty value for an attribute.
+type existsExpr struct{ attr attribute }
+
+func (e existsExpr) match(r *record) bool {
+	_, ok := r.value(e.attr)
+	return ok
+}
+
+// An attrKind identifies a part of an entry.
+type attrKind int
+
+const (
+	attrField attrKind = iota
+	attrLevel
+	attrTime
+	attrLogger
+	attrMessage
+	attrCaller
+	attrStack
+)
+
+// An attribute is a part of an entry that expressions and group-by keys can
+// refer to.
+type attribute struct {
+	kind attrKind
+	key  string // field key for attrField
+	name string // as written
+}
+
+// resolveAttribute maps a name to a part of the entry, either by its
+// canonical name or by the key configured for the input. Any other name
+// refers to a field.
+func resolveAttribute(name string, cfg *vipercore.EncoderConfig) attribute {
+	a := attribute{name: name}
+	switch {
+	case name == "level" || name == cfg.LevelKey:
+		a.kind = attrLevel
+	case name == "time" || name == cfg.TimeKey:
+		a.kind = attrTime
+	case name == "logger" || name == cfg.NameKey:
+		a.kind = attrLogger
+	case name == "msg" || name == "message" || name == cfg.MessageKey:
+		a.kind = attrMessage
+	case name == "caller" || name == cfg.CallerKey:
+		a.kind = attrCaller
+	case name == "stacktrace" || name == cfg.StacktraceKey:
+		a.kind = attrStack
+	default:
+		a.kind = attrField
+		a.key = name
+	}
+	return a
+}
+
+// A literal is the right-hand side of a comparison, pre-parsed into every
+// type it could be compared as.
+type literal struct {
+	text string
+	glob *regexp.Regexp // set for unquoted values containing wildcards
+	re   *regexp.Regexp // set for regexp matches
+
+	num   float64
+	isNum bool
+	dur   time.Duration
+	isDur bool
+	t     time.Time
+	isT   bool
+}
+
+func newLiteral(text string, quoted bool, op string) (literal, error) {
+	lit := literal{text: text}
+	if op == "~" || op == "!~" {
+		re, err := regexp.Compile(text)
+		if err != nil {
+			return lit, err
+		}
+		lit.re = re
+		return lit, nil
+	}
+	if !quoted && strings.ContainsAny(text, "*?") {
+		lit.glob = compileGlob(text)
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		lit.num, lit.isNum = f, true
+	} else if d, err := time.ParseDuration(text); err == nil {
+		lit.dur, lit.isDur = d, true
+	}
+	if t, err := parseTime(text); err == nil {
+		lit.t, lit.isT = t, true
+	}
+	return lit, nil
+}
+
+// compileGlob converts a pattern in which * matches any run of characters and
+// ? matches any single character to an anchored regexp.
+func compileGlob(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// parseTime parses an RFC 3339 timestamp or a date.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// comparison compares an attribute of each record against a literal.
+type comparison struct {
+	attr attribute
+	op   string
+	lit  literal
+	lvl  vipercore.Level // the literal as a level, for attrLevel
+
+	durationUnit time.Duration // of numeric duration fields
+}
+
+func (c *comparison) match(r *record) bool {
+	v, ok := r.value(c.attr)
+	if !ok {
+		return false
+	}
+	if c.lit.re != nil {
+		matched := c.lit.re.MatchString(stringValue(v))
+		return matched == (c.op == "~")
+	}
+
+	switch v := v.(type) {
+	case vipercore.Level:
+		return compareResult(compareLevels(v, c.lvl), c.op)
+	case time.Time:
+		return compareTimes(v, c.lit.t, c.op)
+	case bool:
+		return c.compareStrings(strconv.FormatBool(v))
+	case int64:
+		return c.compareNumber(float64(v))
+	case float64:
+		return c.compareNumber(v)
+	case string:
+		if c.lit.isDur {
+			if d, err := time.ParseDuration(v); err == nil {
+				return compareResult(compareInts(int64(d), int64(c.lit.dur)), c.op)
+			}
+		}
+		if c.lit.isNum {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return c.compareNumber(f)
+			}
+		}
+		if c.lit.isT && c.attr.kind == attrField {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return compareTimes(t, c.lit.t, c.op)
+			}
+		}
+		return c.compareStrings(v)
+	default:
+		return c.compareStrings(stringValue(v))
+	}
+}
+
+func (c *comparison) compareNumber(f float64) bool {
+	switch {
+	case c.lit.isDur:
+		d := time.Duration(f * float64(c.durationUnit))
+		return compareResult(compareInts(int64(d), int64(c.lit.dur)), c.op)
+	case c.lit.isNum:
+		switch {
+		case f < c.lit.num:
+			return compareResult(-1, c.op)
+		case f > c.lit.num:
+			return compareResult(1, c.op)
+		default:
+			return compareResult(0, c.op)
+		}
+	default:
+		return c.compareStrings(strconv.FormatFloat(f, 'f', -1, 64))
+	}
+}
+
+func (c *comparison) compareStrings(s string) bool {
+	if c.lit.glob != nil && (c.op == "=" || c.op == "!=") {
+		return c.lit.glob.MatchString(s) == (c.op == "=")
+	}
+	return compareResult(strings.Compare(s, c.lit.text), c.op)
+}
+
+func compareTimes(t, lit time.Time, op string) bool {
+	switch {
+	case t.Before(lit):
+		return compareResult(-1, op)
+	case t.After(lit):
+		return compareResult(1, op)
+	default:
+		return compareResult(0, op)
+	}
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareLevels compares levels by importance, which isn't the order of their
+// values.
+func compareLevels(a, b vipercore.Level) int {
+	switch {
+	case a == b:
+		return 0
+	case b.Enabled(a):
+		return 1
+	default:
+		return -1
+	}
+}
+
+func compareResult(cmp int, op string) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// parser compiles filter expressions:
+//
+//   expr       = and { ("or" | "||") and }
+//   and        = not { ("and" | "&&") not }
+//   not        = ("not" | "!") not | "(" expr ")" | comparison
+//   comparison = name [ op value ]
+//   op         = "=" | "==" | "!=" | "<" | "<=" | ">" | ">=" | "~" | "!~"
+//
+// Values are either quoted with double or single quotes or run until the next
+// space or closing parenthesis.
+type parser struct {
+	src          string
+	pos          int
+	cfg          *vipercore.EncoderConfig
+	durationUnit time.Duration
+}
+
+// parseExpr compiles src. An empty expression matches every record.
+func parseExpr(src string, cfg *vipercore.EncoderConfig, durationUnit time.Duration) (expr, error) {
+	p := &parser{src: src, cfg: cfg, durationUnit: durationUnit}
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return matchAll{}, nil
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	return e, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid expression at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") || p.symbol("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") || p.symbol("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.keyword("not") || p.symbol("!") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	}
+	if p.symbol("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.symbol(")") {
+			return nil, p.errorf("missing closing parenthesis")
+		}
+		return e, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && isNameByte(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		if p.pos == len(p.src) {
+			return nil, p.errorf("unexpected end of expression")
+		}
+		return nil, p.errorf("expected a name, got %q", p.src[p.pos:])
+	}
+	attr := resolveAttribute(p.src[start:p.pos], p.cfg)
+
+	op := p.operator()
+	if op == "" {
+		return existsExpr{attr}, nil
+	}
+	text, quoted, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	lit, err := newLiteral(text, quoted, op)
+	if err != nil {
+		return nil, p.errorf("invalid regexp %q: %v", text, err)
+	}
+
+	c := &comparison{attr: attr, op: op, lit: lit, durationUnit: p.durationUnit}
+	switch {
+	case lit.re != nil:
+	case attr.kind == attrLevel:
+		if err := c.lvl.UnmarshalText([]byte(text)); err != nil {
+			return nil, p.errorf("%v", err)
+		}
+	case attr.kind == attrTime && !lit.isT:
+		return nil, p.errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD", text)
+	}
+	return c, nil
+}
+
+var _operators = []string{"==", "!=", "<=", ">=", "!~", "=", "<", ">", "~"}
+
+func (p *parser) operator() string {
+	p.skipSpace()
+	for _, op := range _operators {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			if op == "==" {
+				return "="
+			}
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *parser) value() (string, bool, error) {
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return "", false, p.errorf("missing value")
+	}
+	switch q := p.src[p.pos]; q {
+	case '"', '\'':
+		end := p.pos + 1
+		for ; end < len(p.src) && p.src[end] != q; end++ {
+			if p.src[end] == '\\' && q == '"' {
+				end++
+			}
+		}
+		if end >= len(p.src) {
+			return "", false, p.errorf("unterminated quoted value")
+		}
+		raw := p.src[p.pos : end+1]
+		p.pos = end + 1
+		if q == '\'' {
+			return raw[1 : len(raw)-1], true, nil
+		}
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", false, p.errorf("invalid quoted value %s", raw)
+		}
+		return s, true, nil
+	}
+	start := p.pos
+	for p.pos < len(p.src) && !isSpace(p.src[p.pos]) && p.src[p.pos] != ')' {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", false, p.errorf("missing value")
+	}
+	return p.src[start:p.pos], false, nil
+}
+
+// keyword consumes a case-insensitive keyword that isn't followed by more of
+// a name.
+func (p *parser) keyword(kw string) bool {
+	p.skipSpace()
+	end := p.pos + len(kw)
+	if end > len(p.src) || !strings.EqualFold(p.src[p.pos:end], kw) {
+		return false
+	}
+	if end < len(p.src) && isNameByte(p.src[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *parser) symbol(sym string) bool {
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.pos:], sym) {
+		return false
+	}
+	// Don't mistake the != and !~ operators for negation.
+	if sym == "!" && p.pos+1 < len(p.src) && (p.src[p.pos+1] == '=' || p.src[p.pos+1] == '~') {
+		return false
+	}
+	p.pos += len(sym)
+	return true
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && isSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isNameByte(c byte) bool {
+	switch {
+	case isSpace(c):
+		return false
+	case strings.IndexByte(`()=!<>~"'&|`, c) >= 0:
+		return false
+	}
+	return true
+}
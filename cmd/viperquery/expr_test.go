@@ -0,0 +1,108 @@
+
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/viper"
+	"github.com/gottingen/viper/vipercore"
+)
+
+func TestExprMatch(t *testing.T) {
+	cfg := viper.NewProductionEncoderConfig()
+	line := `{"level":"warn","ts":1500000000,"logger":"payments.db","caller":"db/conn.go:12","msg":"slow query",` +
+		`"latency":0.73,"elapsed":"1.5s","rows":120,"retried":true,"table":"orders","user":{"id":7,"name":"alice"},` +
+		`"deadline":"2017-07-14T03:00:00Z"}`
+	ent, fields, err := vipercore.NewJSONDecoder(cfg).Decode([]byte(line))
+	require.NoError(t, err, "Unexpected error decoding test entry.")
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"", true},
+		{"level>=warn", true},
+		{"level > warn", false},
+		{"level=WARN", true},
+		{"level<error && level>info", true},
+		{"level>notice && level<critical", true},
+		{"level<=trace", false},
+		{"logger=payments.*", true},
+		{"logger=payments", false},
+		{"logger!=auth*", true},
+		{`logger="payments.*"`, false},
+		{"logger~^pay", true},
+		{"logger!~^pay", false},
+		{"msg='slow query'", true},
+		{`message="slow query"`, true},
+		{"msg~slow", true},
+		{"caller=db/conn.go:12", true},
+		{"stacktrace", false},
+		{"latency>500ms", true},
+		{"latency>1s", false},
+		{"latency>0.5", true},
+		{"elapsed>=1500ms", true},
+		{"rows=120", true},
+		{"rows>100 and rows<=120", true},
+		{"rows>=1e3", false},
+		{"retried=true", true},
+		{"table=orders or table=users", true},
+		{"not table=orders", false},
+		{"!(table=orders)", false},
+		{"table!=orders", false},
+		{"missing=1", false},
+		{"missing", false},
+		{"not missing", true},
+		{"user.id=7", true},
+		{"user.name=al*", true},
+		{"user~alice", true},
+		{"time>=2017-07-14", true},
+		{"ts<2017-07-14T02:40:00Z", false},
+		{"deadline>2017-07-14T02:59:59Z", true},
+		{"(level=error or level=warn) and (rows>1000 or latency>=730ms)", true},
+		{"level=error or level=warn and rows>1000", false},
+		{"NOT level=error AND latency>1ms", true},
+	}
+	for _, tt := range tests {
+		e, err := parseExpr(tt.expr, &cfg, time.Second)
+		if !assert.NoError(t, err, "Unexpected error parsing %q.", tt.expr) {
+			continue
+		}
+		assert.Equal(t, tt.want, e.match(&record{ent: ent, fields: fields}), "Unexpected result for %q.", tt.expr)
+	}
+}
+
+func TestExprNanosDurations(t *testing.T) {
+	cfg := viper.NewProductionEncoderConfig()
+	ent, fields, err := vipercore.NewJSONDecoder(cfg).Decode([]byte(`{"latency":730000000}`))
+	require.NoError(t, err, "Unexpected error decoding test entry.")
+
+	e, err := parseExpr("latency>500ms", &cfg, time.Nanosecond)
+	require.NoError(t, err, "Unexpected error parsing expression.")
+	assert.True(t, e.match(&record{ent: ent, fields: fields}), "Expected nanosecond durations to be compared.")
+}
+
+func TestExprErrors(t *testing.T) {
+	cfg := viper.NewProductionEncoderConfig()
+	for _, src := range []string{
+		"level>=",
+		"level>=loud",
+		"time>yesterday",
+		"(level=warn",
+		"level=warn)",
+		"level=warn and",
+		"= 3",
+		"msg~(",
+		`msg="unterminated`,
+		"msg='unterminated",
+		"a=1 b=2",
+	} {
+		_, err := parseExpr(src, &cfg, time.Second)
+		assert.Error(t, err, "Expected an error parsing %q.", src)
+	}
+}
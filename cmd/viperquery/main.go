@@ -0,0 +1,333 @@
+// viperquery searches and summarizes structured logs written by viper's JSON
+// encoder or in logfmt.
+//
+// It reads log lines from the files named after the expression, or from
+// standard input if there are none, and writes out the entries that match
+// the expression using any registered encoder:
+//
+//   viperquery 'level>=warn and logger=payments.* and latency>500ms' app.log
+//
+// Expressions compare the entry's level, time, logger, msg, caller, and
+// stacktrace, or any field, against a value with =, !=, <, <=, >, >=, ~
+// (regexp match), or !~, and combine comparisons with and, or, not, and
+// parentheses. A name on its own matches entries that have it. Levels compare
+// by severity, and numeric fields compare as numbers or, against values like
+// 500ms, as durations. In unquoted values, * and ? are wildcards for = and !=.
+// Dotted names like user.id look inside object fields. An empty expression
+// matches every entry.
+//
+// Instead of printing entries, -count prints the number of matches and
+// -group-by prints the number of matches for each distinct combination of
+// values of the given names.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	"github.com/gottingen/viper/vipercore"
+)
+
+// _now is the time that relative -since and -until values are measured from.
+var _now = time.Now
+
+type options struct {
+	input        vipercore.EncoderConfig
+	durationUnit time.Duration
+	format       string
+	since, until time.Time
+	count        bool
+	groupBy      []attribute
+	output       string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts, args, err := parseFlags(args, stderr)
+	if err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		fmt.Fprintf(stderr, "viperquery: %v\n", err)
+		return 2
+	}
+
+	var src string
+	if len(args) > 0 {
+		src, args = args[0], args[1:]
+	}
+	filter, err := parseExpr(src, &opts.input, opts.durationUnit)
+	if err != nil {
+		fmt.Fprintf(stderr, "viperquery: %v\n", err)
+		return 2
+	}
+
+	out := bufio.NewWriter(stdout)
+	defer out.Flush()
+	q, err := newQuery(opts, filter, out)
+	if err != nil {
+		fmt.Fprintf(stderr, "viperquery: %v\n", err)
+		return 2
+	}
+
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	status := 0
+	for _, name := range args {
+		if err := q.scanFile(name, stdin); err != nil {
+			fmt.Fprintf(stderr, "viperquery: %v\n", err)
+			status = 1
+		}
+	}
+	if err := q.summarize(); err != nil {
+		fmt.Fprintf(stderr, "viperquery: %v\n", err)
+		status = 1
+	}
+	if q.skipped > 0 {
+		fmt.Fprintf(stderr, "viperquery: skipped %d lines that couldn't be decoded\n", q.skipped)
+	}
+	return status
+}
+
+func parseFlags(args []string, stderr io.Writer) (options, []string, error) {
+	input := viper.NewProductionEncoderConfig()
+	var (
+		opts                      options
+		timeEncoding, durEncoding string
+		since, until, groupBy     string
+		fs                        = flag.NewFlagSet("viperquery", flag.ContinueOnError)
+	)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: viperquery [flags] [expression [file ...]]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&input.MessageKey, "message-key", input.MessageKey, "key of the log message")
+	fs.StringVar(&input.LevelKey, "level-key", input.LevelKey, "key of the log level")
+	fs.StringVar(&input.TimeKey, "time-key", input.TimeKey, "key of the timestamp")
+	fs.StringVar(&input.NameKey, "name-key", input.NameKey, "key of the logger name")
+	fs.StringVar(&input.CallerKey, "caller-key", input.CallerKey, "key of the caller")
+	fs.StringVar(&input.StacktraceKey, "stacktrace-key", input.StacktraceKey, "key of the stacktrace")
+	fs.StringVar(&timeEncoding, "time-encoding", "epoch", `encoding of the timestamps: "epoch", "millis", "nanos", "iso8601", "rfc3339", or "rfc3339nano"`)
+	fs.StringVar(&durEncoding, "duration-encoding", "seconds", `encoding of numeric durations: "seconds" or "nanos"`)
+	fs.StringVar(&opts.format, "format", "auto", `input format: "json", "logfmt", or "auto" to detect each line's format`)
+	fs.StringVar(&since, "since", "", "only match entries at or after this RFC 3339 time, date, or duration ago")
+	fs.StringVar(&until, "until", "", "only match entries before this RFC 3339 time, date, or duration ago")
+	fs.BoolVar(&opts.count, "count", false, "print the number of matching entries instead of the entries")
+	fs.StringVar(&groupBy, "group-by", "", "comma-separated names to count matching entries by")
+	fs.StringVar(&opts.output, "output", "json", "name of the registered encoding to write matching entries in")
+	if err := fs.Parse(args); err != nil {
+		return opts, nil, err
+	}
+
+	if err := input.EncodeTime.UnmarshalText([]byte(timeEncoding)); err != nil {
+		return opts, nil, err
+	}
+	switch durEncoding {
+	case "seconds":
+		opts.durationUnit = time.Second
+	case "nanos":
+		opts.durationUnit = time.Nanosecond
+	default:
+		return opts, nil, fmt.Errorf("invalid -duration-encoding %q", durEncoding)
+	}
+	switch opts.format {
+	case "auto", "json", "logfmt":
+	default:
+		return opts, nil, fmt.Errorf("invalid -format %q", opts.format)
+	}
+	var err error
+	if opts.since, err = parseBound("since", since); err != nil {
+		return opts, nil, err
+	}
+	if opts.until, err = parseBound("until", until); err != nil {
+		return opts, nil, err
+	}
+	for _, name := range strings.Split(groupBy, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.groupBy = append(opts.groupBy, resolveAttribute(name, &input))
+		}
+	}
+	opts.input = input
+	return opts, fs.Args(), nil
+}
+
+// parseBound parses a -since or -until value, which is either a time or a
+// duration before now.
+func parseBound(name, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := parseTime(s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return _now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid -%s %q, expected a time, date, or duration", name, s)
+}
+
+type query struct {
+	opts   options
+	filter expr
+	out    io.Writer
+
+	json, logfmt     vipercore.Decoder
+	enc, untimedEnc  vipercore.Encoder
+	matched, skipped int
+	groups           map[string]int
+}
+
+func newQuery(opts options, filter expr, out io.Writer) (*query, error) {
+	output := opts.input
+	output.EncodeCaller = vipercore.FullCallerEncoder // callers are already trimmed
+	enc, err := viper.NewEncoder(opts.output, output)
+	if err != nil {
+		return nil, err
+	}
+	// Entries that don't record a time are written without one, rather than
+	// with the zero time.
+	output.TimeKey = ""
+	untimedEnc, err := viper.NewEncoder(opts.output, output)
+	if err != nil {
+		return nil, err
+	}
+	return &query{
+		opts:       opts,
+		filter:     filter,
+		out:        out,
+		json:       vipercore.NewJSONDecoder(opts.input),
+		logfmt:     vipercore.NewLogfmtDecoder(opts.input),
+		enc:        enc,
+		untimedEnc: untimedEnc,
+		groups:     make(map[string]int),
+	}, nil
+}
+
+func (q *query) scanFile(name string, stdin io.Reader) error {
+	r := stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if werr := q.scanLine(bytes.TrimSpace(line)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("can't read %s: %v", name, err)
+		}
+	}
+}
+
+func (q *query) scanLine(line []byte) error {
+	dec := q.logfmt
+	if q.opts.format == "json" || (q.opts.format == "auto" && line[0] == '{') {
+		dec = q.json
+	}
+	ent, fields, err := dec.Decode(line)
+	if err != nil {
+		q.skipped++
+		return nil
+	}
+
+	if !q.opts.since.IsZero() || !q.opts.until.IsZero() {
+		if ent.Time.IsZero() ||
+			(!q.opts.since.IsZero() && ent.Time.Before(q.opts.since)) ||
+			(!q.opts.until.IsZero() && !ent.Time.Before(q.opts.until)) {
+			return nil
+		}
+	}
+	r := &record{ent: ent, fields: fields}
+	if !q.filter.match(r) {
+		return nil
+	}
+
+	q.matched++
+	switch {
+	case len(q.opts.groupBy) > 0:
+		q.groups[groupKey(r, q.opts.groupBy)]++
+		return nil
+	case q.opts.count:
+		return nil
+	}
+
+	enc := q.enc
+	if ent.Time.IsZero() {
+		enc = q.untimedEnc
+	}
+	buf, err := enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	_, err = q.out.Write(buf.Bytes())
+	buffer.Put(buf)
+	return err
+}
+
+// groupKey renders the values of the group-by attributes as logfmt.
+func groupKey(r *record, attrs []attribute) string {
+	parts := make([]string, len(attrs))
+	for i, a := range attrs {
+		v, ok := r.value(a)
+		s := "<none>"
+		if ok {
+			s = stringValue(v)
+		}
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
+		}
+		parts[i] = a.name + "=" + s
+	}
+	return strings.Join(parts, " ")
+}
+
+// summarize writes the number of matches or the groups, most common first.
+func (q *query) summarize() error {
+	switch {
+	case len(q.opts.groupBy) > 0:
+		keys := make([]string, 0, len(q.groups))
+		for k := range q.groups {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if q.groups[keys[i]] != q.groups[keys[j]] {
+				return q.groups[keys[i]] > q.groups[keys[j]]
+			}
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys {
+			if _, err := fmt.Fprintf(q.out, "%d\t%s\n", q.groups[k], k); err != nil {
+				return err
+			}
+		}
+	case q.opts.count:
+		_, err := fmt.Fprintln(q.out, q.matched)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleLogs = `{"level":"info","ts":1500000000,"logger":"payments.api","msg":"charged","latency":0.2,"user":{"id":7}}
+{"level":"warn","ts":1500000060,"logger":"payments.db","msg":"slow query","latency":0.73}
+level=error ts=1500000120 logger=auth msg="login failed" user=bob
+not a log line
+{"level":"warn","logger":"payments.db","msg":"no time"}
+`
+
+func runViperquery(t testing.TB, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestQuery(t *testing.T) {
+	code, stdout, stderr := runViperquery(t, sampleLogs, "level>=warn and logger=payments.* and latency>500ms")
+	assert.Equal(t, 0, code, "Unexpected exit code.")
+	assert.Equal(
+		t,
+		`{"level":"warn","ts":1500000060,"logger":"payments.db","msg":"slow query","latency":0.73}`+"\n",
+		stdout,
+		"Unexpected matches.",
+	)
+	assert.Equal(t, "viperquery: skipped 1 lines that couldn't be decoded\n", stderr, "Unexpected error output.")
+}
+
+func TestQueryReencodes(t *testing.T) {
+	_, stdout, _ := runViperquery(t, sampleLogs, "level=error")
+	assert.Equal(
+		t,
+		`{"level":"error","ts":1500000120,"logger":"auth","msg":"login failed","user":"bob"}`+"\n",
+		stdout,
+		"Expected logfmt entries to be re-encoded as JSON.",
+	)
+
+	_, stdout, _ = runViperquery(t, sampleLogs, "-output", "console", "msg='no time'")
+	assert.Equal(t, "warn\tpayments.db\tno time\n", stdout, "Expected console output without a time.")
+
+	code, _, stderr := runViperquery(t, sampleLogs, "-output", "nope")
+	assert.Equal(t, 2, code, "Unexpected exit code for an unknown encoding.")
+	assert.Contains(t, stderr, "nope", "Expected an error about the unknown encoding.")
+}
+
+func TestQueryFormat(t *testing.T) {
+	_, stdout, stderr := runViperquery(t, sampleLogs, "-format", "logfmt", "-count")
+	assert.Equal(t, "1\n", stdout, "Expected only logfmt lines to be decoded.")
+	assert.Contains(t, stderr, "skipped 4 lines", "Unexpected skipped lines.")
+
+	_, stdout, _ = runViperquery(t, sampleLogs, "-format", "json", "-count")
+	assert.Equal(t, "3\n", stdout, "Expected only JSON lines to be decoded.")
+}
+
+func TestQueryTimeRange(t *testing.T) {
+	_, stdout, _ := runViperquery(t, sampleLogs, "-count", "-since", "2017-07-14T02:41:00Z")
+	assert.Equal(t, "2\n", stdout, "Unexpected count with -since.")
+
+	_, stdout, _ = runViperquery(t, sampleLogs, "-count", "-since", "2017-07-14T02:40:00Z", "-until", "2017-07-14T02:42:00Z")
+	assert.Equal(t, "2\n", stdout, "Unexpected count with -since and -until.")
+
+	defer func(now func() time.Time) { _now = now }(_now)
+	_now = func() time.Time { return time.Unix(1500000150, 0) }
+	_, stdout, _ = runViperquery(t, sampleLogs, "-count", "-since", "1m")
+	assert.Equal(t, "1\n", stdout, "Unexpected count with a relative -since.")
+}
+
+func TestQueryGroupBy(t *testing.T) {
+	_, stdout, _ := runViperquery(t, sampleLogs, "-group-by", "level, logger", "")
+	assert.Equal(
+		t,
+		"2\tlevel=warn logger=payments.db\n"+
+			"1\tlevel=error logger=auth\n"+
+			"1\tlevel=info logger=payments.api\n",
+		stdout,
+		"Unexpected groups.",
+	)
+
+	_, stdout, _ = runViperquery(t, sampleLogs, "-group-by", "user,msg", "level<=error")
+	assert.Equal(
+		t,
+		"1\tuser=\"{\\\"id\\\":7}\" msg=charged\n"+
+			"1\tuser=<none> msg=\"no time\"\n"+
+			"1\tuser=<none> msg=\"slow query\"\n"+
+			"1\tuser=bob msg=\"login failed\"\n",
+		stdout,
+		"Unexpected groups with missing and object values.",
+	)
+}
+
+func TestQueryFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viperquery")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte(sampleLogs), 0644))
+
+	code, stdout, stderr := runViperquery(t, "", "-count", "level=warn", path, path, filepath.Join(dir, "missing.log"))
+	assert.Equal(t, 1, code, "Expected a failure exit code for a missing file.")
+	assert.Equal(t, "4\n", stdout, "Unexpected count across files.")
+	assert.Contains(t, stderr, "missing.log", "Expected an error about the missing file.")
+}
+
+func TestQueryBadArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"level>="},
+		{"-format", "xml"},
+		{"-duration-encoding", "weeks"},
+		{"-since", "last tuesday"},
+		{"-not-a-flag"},
+	} {
+		code, _, stderr := runViperquery(t, "", args...)
+		assert.Equal(t, 2, code, "Unexpected exit code for %v.", args)
+		assert.NotEmpty(t, stderr, "Expected an error for %v.", args)
+	}
+}
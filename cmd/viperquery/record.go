@@ -0,0 +1,84 @@
+
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gottingen/viper/vipercore"
+)
+
+// A record is a decoded log entry.
+type record struct {
+	ent    vipercore.Entry
+	fields []vipercore.Field
+	values map[string]interface{} // fields, encoded lazily
+}
+
+// value returns the value of an attribute, reporting false if the record
+// doesn't have one.
+func (r *record) value(a attribute) (interface{}, bool) {
+	switch a.kind {
+	case attrLevel:
+		return r.ent.Level, true
+	case attrTime:
+		return r.ent.Time, !r.ent.Time.IsZero()
+	case attrLogger:
+		return r.ent.LoggerName, r.ent.LoggerName != ""
+	case attrMessage:
+		return r.ent.Message, r.ent.Message != ""
+	case attrCaller:
+		return r.ent.Caller.String(), r.ent.Caller.Defined
+	case attrStack:
+		return r.ent.Stack, r.ent.Stack != ""
+	default:
+		return r.field(a.key)
+	}
+}
+
+// field looks up a field by key. Keys containing dots also match fields
+// nested in objects, so "user.id" finds the id of a "user" object.
+func (r *record) field(key string) (interface{}, bool) {
+	if r.values == nil {
+		enc := vipercore.NewMapObjectEncoder()
+		for _, f := range r.fields {
+			f.AddTo(enc)
+		}
+		r.values = enc.Fields
+	}
+	if v, ok := r.values[key]; ok {
+		return v, true
+	}
+
+	var v interface{} = r.values
+	for _, part := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// stringValue formats a value for string comparisons and group-by output.
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case vipercore.Level:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}, nil:
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v)
+}
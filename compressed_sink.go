@@ -0,0 +1,196 @@
+
+
+package viper
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gottingen/viper/internal/zstd"
+)
+
+const (
+	_compressionGzip = "gzip"
+	_compressionZstd = "zstd"
+	_compressionNone = "none"
+)
+
+var (
+	_compressionMutex sync.RWMutex
+	_compressions     map[string]*compression // keyed by name
+)
+
+func init() {
+	resetCompressionRegistry()
+}
+
+// A CompressedWriter compresses the data written to it into an underlying
+// writer.
+type CompressedWriter interface {
+	// Write compresses data, which may be buffered.
+	Write([]byte) (int, error)
+	// Flush writes the buffered data, so that everything written so far can
+	// be decompressed.
+	Flush() error
+	// Close flushes the buffered data and writes the stream's trailer. It
+	// doesn't close the underlying writer.
+	Close() error
+}
+
+type compression struct {
+	extension string
+	newWriter func(io.Writer) (CompressedWriter, error)
+}
+
+func resetCompressionRegistry() {
+	_compressionMutex.Lock()
+	defer _compressionMutex.Unlock()
+
+	_compressions = map[string]*compression{
+		_compressionGzip: {
+			extension: ".gz",
+			newWriter: func(w io.Writer) (CompressedWriter, error) {
+				return gzip.NewWriter(w), nil
+			},
+		},
+		_compressionZstd: {
+			extension: ".zst",
+			newWriter: func(w io.Writer) (CompressedWriter, error) {
+				return zstd.NewWriter(w), nil
+			},
+		},
+	}
+}
+
+// RegisterCompression registers a compression for file sinks, which are then
+// compressed if their path ends in the extension or their URL's compress
+// query parameter is the name. Viper registers "gzip" for files ending in
+// ".gz" and "zstd" for files ending in ".zst". For example, to write ".xz"
+// files with github.com/ulikunitz/xz:
+//
+//   viper.RegisterCompression("xz", ".xz", func(w io.Writer) (viper.CompressedWriter, error) {
+//     return xz.NewWriter(w)
+//   })
+//
+// Attempting to register a name or an extension that's already in use
+// returns an error.
+func RegisterCompression(name, extension string, newWriter func(io.Writer) (CompressedWriter, error)) error {
+	_compressionMutex.Lock()
+	defer _compressionMutex.Unlock()
+
+	if name == "" || name == _compressionNone {
+		return fmt.Errorf("%q is not a valid compression name", name)
+	}
+	if newWriter == nil {
+		return fmt.Errorf("compression %q requires a writer constructor", name)
+	}
+	if _, ok := _compressions[name]; ok {
+		return fmt.Errorf("compression already registered for name %q", name)
+	}
+	for other, c := range _compressions {
+		if extension != "" && c.extension == extension {
+			return fmt.Errorf("extension %q already registered for compression %q", extension, other)
+		}
+	}
+	_compressions[name] = &compression{extension: extension, newWriter: newWriter}
+	return nil
+}
+
+// compressionFor returns the compression of a file sink, which is the one
+// named by the compress query parameter or, if it's empty, the one whose
+// extension the path ends in. It returns nil if the file isn't compressed.
+func compressionFor(path, name string) (*compression, error) {
+	_compressionMutex.RLock()
+	defer _compressionMutex.RUnlock()
+
+	if name == _compressionNone {
+		return nil, nil
+	}
+	if name == "" {
+		ext := filepath.Ext(path)
+		for n, c := range _compressions {
+			if ext != "" && c.extension == ext {
+				name = n
+				break
+			}
+		}
+		if name == "" {
+			return nil, nil
+		}
+	}
+	c, ok := _compressions[name]
+	if !ok {
+		return nil, fmt.Errorf("no compression registered for name %q", name)
+	}
+	return c, nil
+}
+
+var errCompressedSinkClosed = errors.New("compressed file sink is closed")
+
+type compressedFileSink struct {
+	mu     sync.Mutex
+	file   *os.File
+	w      CompressedWriter
+	closed bool
+}
+
+// newCompressedFileSink opens a file for appending and compresses the data
+// written to it. Each time the file is opened, a new compressed stream is
+// appended to it, which gzip and zstd decompress as one.
+func newCompressedFileSink(path string, c *compression) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w, err := c.newWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("can't compress %s: %v", path, err)
+	}
+	return &compressedFileSink{file: f, w: w}, nil
+}
+
+func (s *compressedFileSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, errCompressedSinkClosed
+	}
+	return s.w.Write(p)
+}
+
+// Sync flushes the compressor before syncing the file, so that everything
+// logged so far can be recovered if the process crashes.
+func (s *compressedFileSink) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errCompressedSinkClosed
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *compressedFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errCompressedSinkClosed
+	}
+	s.closed = true
+	err := s.w.Close()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package viper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gottingen/viper/vipercore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withTempDir(t testing.TB, f func(dir string)) {
+	dir, err := ioutil.TempDir("", "viper-compressed-test")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+	f(dir)
+}
+
+// gunzipFile decompresses as much of a gzipped file as possible, returning
+// the data and whether the stream was complete.
+func gunzipFile(t testing.TB, path string) (string, bool) {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "Failed to read file.")
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err, "Expected a gzipped file.")
+	out, err := ioutil.ReadAll(zr)
+	return string(out), err == nil
+}
+
+func TestCompressedFileSinkGzip(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		path := filepath.Join(dir, "app.log.gz")
+		ws, close, err := Open(path)
+		require.NoError(t, err, "Unexpected error opening a gzipped file.")
+
+		ws.Write([]byte("one\n"))
+		require.NoError(t, ws.Sync(), "Unexpected error syncing.")
+		out, complete := gunzipFile(t, path)
+		assert.Equal(t, "one\n", out, "Expected synced lines to be recoverable.")
+		assert.False(t, complete, "Expected the stream to be unfinished before closing.")
+
+		ws.Write([]byte("two\n"))
+		close()
+		out, complete = gunzipFile(t, path)
+		assert.Equal(t, "one\ntwo\n", out, "Unexpected contents after closing.")
+		assert.True(t, complete, "Expected closing to finish the stream.")
+
+		// Reopening appends another stream, which decompresses with the first.
+		ws, close, err = Open(path)
+		require.NoError(t, err, "Unexpected error reopening a gzipped file.")
+		ws.Write([]byte("three\n"))
+		close()
+		out, complete = gunzipFile(t, path)
+		assert.Equal(t, "one\ntwo\nthree\n", out, "Expected both streams to decompress.")
+		assert.True(t, complete, "Expected both streams to be finished.")
+	})
+}
+
+func TestCompressedFileSinkZstd(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		path := filepath.Join(dir, "app.log.zst")
+		for _, line := range []string{"one\n", "two\n"} {
+			ws, close, err := Open(path)
+			require.NoError(t, err, "Unexpected error opening a zstd file.")
+			ws.Write([]byte(line))
+			require.NoError(t, ws.Sync(), "Unexpected error syncing.")
+			close()
+		}
+
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err, "Failed to read file.")
+		assert.True(t, bytes.HasPrefix(data, []byte{0x28, 0xB5, 0x2F, 0xFD}), "Expected a zstd frame.")
+		if zstdPath, err := exec.LookPath("zstd"); err == nil {
+			out, err := exec.Command(zstdPath, "-d", "-c", path).Output()
+			require.NoError(t, err, "Unexpected error decompressing with zstd.")
+			assert.Equal(t, "one\ntwo\n", string(out), "Expected both frames to decompress.")
+		}
+	})
+}
+
+func TestCompressedFileSinkQuery(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		compressed := filepath.Join(dir, "compressed.log")
+		plain := filepath.Join(dir, "plain.log.gz")
+		ws, close, err := Open("file://"+compressed+"?compress=gzip", "file://"+plain+"?compress=none")
+		require.NoError(t, err, "Unexpected error opening files.")
+		ws.Write([]byte("hi\n"))
+		close()
+
+		out, complete := gunzipFile(t, compressed)
+		assert.Equal(t, "hi\n", out, "Expected compress=gzip to compress the file.")
+		assert.True(t, complete, "Expected closing to finish the stream.")
+		data, err := ioutil.ReadFile(plain)
+		require.NoError(t, err, "Failed to read file.")
+		assert.Equal(t, "hi\n", string(data), "Expected compress=none to write plain text.")
+	})
+}
+
+// upperWriter is a stand-in compressor that uppercases its input and marks
+// flushes and the end of the stream.
+type upperWriter struct {
+	w io.Writer
+}
+
+func (u upperWriter) Write(p []byte) (int, error) {
+	return u.w.Write(bytes.ToUpper(p))
+}
+
+func (u upperWriter) Flush() error {
+	_, err := io.WriteString(u.w, "<flush>")
+	return err
+}
+
+func (u upperWriter) Close() error {
+	_, err := io.WriteString(u.w, "<end>")
+	return err
+}
+
+func TestRegisterCompression(t *testing.T) {
+	defer resetCompressionRegistry()
+	newUpper := func(w io.Writer) (CompressedWriter, error) { return upperWriter{w}, nil }
+
+	withTempDir(t, func(dir string) {
+		path := filepath.Join(dir, "app.log.up")
+		_, _, err := Open(path, "file://"+filepath.Join(dir, "other.log")+"?compress=upper")
+		require.Error(t, err, "Expected an error opening files before registering a compression.")
+
+		require.NoError(t, RegisterCompression("upper", ".up", newUpper), "Unexpected error registering a compression.")
+
+		ws, close, err := Open(path, "file://"+filepath.Join(dir, "other.log")+"?compress=upper")
+		require.NoError(t, err, "Unexpected error opening files.")
+		ws.Write([]byte("hi\n"))
+		ws.Sync()
+		close()
+		for _, name := range []string{"app.log.up", "other.log"} {
+			data, err := ioutil.ReadFile(filepath.Join(dir, name))
+			require.NoError(t, err, "Failed to read file.")
+			assert.Equal(t, "HI\n<flush><end>", string(data), "Unexpected contents of %s.", name)
+		}
+	})
+
+	assert.Error(t, RegisterCompression("gzip", ".gz", newUpper), "Expected an error registering gzip again.")
+	assert.Error(t, RegisterCompression("zstd", ".zst", newUpper), "Expected an error registering zstd again.")
+	assert.Error(t, RegisterCompression("upper", ".up", newUpper), "Expected an error registering a compression again.")
+	assert.Error(t, RegisterCompression("other", ".gz", newUpper), "Expected an error reusing an extension.")
+	assert.Error(t, RegisterCompression("other", ".zst", newUpper), "Expected an error reusing zstd's extension.")
+	assert.Error(t, RegisterCompression("", ".x", newUpper), "Expected an error without a name.")
+	assert.Error(t, RegisterCompression("none", ".x", newUpper), "Expected an error registering none.")
+	assert.Error(t, RegisterCompression("nil", ".x", nil), "Expected an error without a constructor.")
+}
+
+func TestCompressedFileSinkErrors(t *testing.T) {
+	for _, bad := range []string{
+		"file:///tmp/app.log?compress=lz4",
+		"file:///tmp/app.log?compress=gzip&level=9",
+	} {
+		_, err := newSink(bad)
+		assert.Error(t, err, "Expected an error opening %q.", bad)
+	}
+	_, err := newSink("stderr?compress=gzip")
+	assert.Error(t, err, "Expected an error compressing stderr.")
+
+	withTempDir(t, func(dir string) {
+		sink, err := newSink(filepath.Join(dir, "app.log.gz"))
+		require.NoError(t, err, "Unexpected error opening a gzipped file.")
+		require.NoError(t, sink.Close(), "Unexpected error closing sink.")
+		_, err = sink.Write([]byte("late\n"))
+		assert.Equal(t, errCompressedSinkClosed, err, "Expected an error writing to a closed sink.")
+		assert.Equal(t, errCompressedSinkClosed, sink.Sync(), "Expected an error syncing a closed sink.")
+		assert.Equal(t, errCompressedSinkClosed, sink.Close(), "Expected an error closing twice.")
+	})
+}
+
+func TestConfigClosesCompressedFiles(t *testing.T) {
+	withTempDir(t, func(dir string) {
+		path := filepath.Join(dir, "app.log.gz")
+		outputPath := filepath.Join(dir, "errors.log.gz")
+		errPath := filepath.Join(dir, "internal.log.gz")
+		minLevel := ErrorLevel
+
+		for _, cfg := range []Config{
+			{OutputPaths: []string{path}},
+			{Outputs: []OutputConfig{{Name: "errors", MinLevel: &minLevel, OutputPaths: []string{outputPath}}}},
+		} {
+			cfg.Level = NewAtomicLevelAt(InfoLevel)
+			cfg.Encoding = "console"
+			cfg.EncoderConfig = vipercore.EncoderConfig{MessageKey: "msg"}
+			cfg.ErrorOutputPaths = []string{errPath}
+			logger, close, err := cfg.BuildWithClose()
+			require.NoError(t, err, "Unexpected error building logger.")
+			logger.Error("compressed")
+			close()
+		}
+
+		for _, p := range []string{path, outputPath, errPath} {
+			out, complete := gunzipFile(t, p)
+			assert.True(t, complete, "Expected closing the logger to finish the stream in %s.", p)
+			if p != errPath {
+				assert.Equal(t, "compressed\n", out, "Unexpected contents in %s.", p)
+			}
+			if gzipPath, err := exec.LookPath("gzip"); err == nil {
+				out, err := exec.Command(gzipPath, "-t", p).CombinedOutput()
+				assert.NoError(t, err, "Expected gzip -t to pass for %s: %s", p, out)
+			}
+		}
+	})
+}
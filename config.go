@@ -3,6 +3,8 @@
 package viper
 
 import (
+	"errors"
+	"fmt"
 	"sort"
 	"time"
 
@@ -19,6 +21,113 @@ type SamplingConfig struct {
 	Thereafter int `json:"thereafter" yaml:"thereafter"`
 }
 
+// OutputConfig describes one of a Config's named outputs. Each output has its
+// own range of levels, encoding, and destinations, which makes it simple to,
+// for example, send error logs to one file and everything else to another.
+type OutputConfig struct {
+	// Name identifies the output in error messages and must be unique within
+	// a Config.
+	Name string `json:"name" yaml:"name"`
+	// MinLevel and MaxLevel bound the range of levels written to this output,
+	// inclusive. A nil bound leaves that end of the range open. Either way,
+	// entries must also be enabled by Config.Level.
+	MinLevel *vipercore.Level `json:"minLevel" yaml:"minLevel"`
+	MaxLevel *vipercore.Level `json:"maxLevel" yaml:"maxLevel"`
+	// Encoding sets the output's encoding. It defaults to Config.Encoding.
+	Encoding string `json:"encoding" yaml:"encoding"`
+	// EncoderConfig overrides parts of Config.EncoderConfig for this output.
+	// Non-empty keys and non-nil encoders replace those of the Config.
+	EncoderConfig vipercore.EncoderConfig `json:"encoderConfig" yaml:"encoderConfig"`
+	// OutputPaths is a list of URLs or file paths to write this output to.
+	// See Open for details.
+	OutputPaths []string `json:"outputPaths" yaml:"outputPaths"`
+}
+
+// Enabled reports whether the level is within the output's level range, which
+// lets an OutputConfig be used as a vipercore.LevelEnabler.
+func (out OutputConfig) Enabled(lvl vipercore.Level) bool {
+	if out.MinLevel != nil && !out.MinLevel.Enabled(lvl) {
+		return false
+	}
+	return out.MaxLevel == nil || lvl.Enabled(*out.MaxLevel)
+}
+
+func (out OutputConfig) validate() error {
+	if out.Name == "" {
+		return errors.New("outputs must have a name")
+	}
+	if len(out.OutputPaths) == 0 {
+		return fmt.Errorf("output %q must have at least one output path", out.Name)
+	}
+	if out.MinLevel != nil && out.MaxLevel != nil && !out.MinLevel.Enabled(*out.MaxLevel) {
+		return fmt.Errorf("output %q has a minimum level above its maximum level", out.Name)
+	}
+	return nil
+}
+
+func (out OutputConfig) buildEncoder(cfg Config) (vipercore.Encoder, error) {
+	encoding := out.Encoding
+	if encoding == "" {
+		encoding = cfg.Encoding
+	}
+	return newOutputEncoder(encoding, mergeEncoderConfig(cfg.EncoderConfig, out.EncoderConfig), out.OutputPaths)
+}
+
+func mergeEncoderConfig(base, override vipercore.EncoderConfig) vipercore.EncoderConfig {
+	mergeKey := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	mergeKey(&base.MessageKey, override.MessageKey)
+	mergeKey(&base.LevelKey, override.LevelKey)
+	mergeKey(&base.TimeKey, override.TimeKey)
+	mergeKey(&base.NameKey, override.NameKey)
+	mergeKey(&base.CallerKey, override.CallerKey)
+	mergeKey(&base.StacktraceKey, override.StacktraceKey)
+	mergeKey(&base.LineEnding, override.LineEnding)
+	if override.EncodeLevel != nil {
+		base.EncodeLevel = override.EncodeLevel
+	}
+	if override.EncodeTime != nil {
+		base.EncodeTime = override.EncodeTime
+	}
+	if override.EncodeDuration != nil {
+		base.EncodeDuration = override.EncodeDuration
+	}
+	if override.EncodeCaller != nil {
+		base.EncodeCaller = override.EncodeCaller
+	}
+	if override.EncodeName != nil {
+		base.EncodeName = override.EncodeName
+	}
+	return base
+}
+
+// levelFilteredCore restricts a Core to the levels enabled by a LevelEnabler.
+type levelFilteredCore struct {
+	vipercore.Core
+	enab vipercore.LevelEnabler
+}
+
+func (c *levelFilteredCore) Enabled(lvl vipercore.Level) bool {
+	return c.enab.Enabled(lvl) && c.Core.Enabled(lvl)
+}
+
+func (c *levelFilteredCore) With(fields []Field) vipercore.Core {
+	return &levelFilteredCore{
+		Core: c.Core.With(fields),
+		enab: c.enab,
+	}
+}
+
+func (c *levelFilteredCore) Check(ent vipercore.Entry, ce *vipercore.CheckedEntry) *vipercore.CheckedEntry {
+	if !c.enab.Enabled(ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
 // Config offers a declarative way to construct a logger. It doesn't do
 // anything that can't be done with New, Options, and the various
 // vipercore.WriteSyncer and vipercore.Core wrappers, but it's a simpler way to
@@ -26,9 +135,10 @@ type SamplingConfig struct {
 //
 // Note that Config intentionally supports only the most common options. More
 // unusual logging setups (logging to network connections or message queues,
-// splitting output between multiple files, etc.) are possible, but require
-// direct use of the vipercore package. For sample code, see the package-level
-// BasicConfiguration and AdvancedConfiguration examples.
+// filtering output with custom logic, etc.) are possible, but require direct
+// use of the vipercore package; for message queues, see its NewProducerCore.
+// For sample code, see the package-level BasicConfiguration and
+// AdvancedConfiguration examples.
 //
 // For an example showing runtime log level changes, see the documentation for
 // AtomicLevel.
@@ -49,25 +159,37 @@ type Config struct {
 	DisableStacktrace bool `json:"disableStacktrace" yaml:"disableStacktrace"`
 	// Sampling sets a sampling policy. A nil SamplingConfig disables sampling.
 	Sampling *SamplingConfig `json:"sampling" yaml:"sampling"`
-	// Encoding sets the logger's encoding. Valid values are "json" and
-	// "console", as well as any third-party encodings registered via
-	// RegisterEncoder.
+	// Encoding sets the logger's encoding. Valid values are "json",
+	// "console", "pretty", "msgpack", "msgpack-framed", "cbor", "protobuf",
+	// "otlp", "ecs", "gelf", "gcp", and "journald", as well as any
+	// third-party encodings registered via RegisterEncoder.
 	Encoding string `json:"encoding" yaml:"encoding"`
 	// EncoderConfig sets options for the chosen encoder. See
 	// vipercore.EncoderConfig for details.
 	EncoderConfig vipercore.EncoderConfig `json:"encoderConfig" yaml:"encoderConfig"`
 	// OutputPaths is a list of URLs or file paths to write logging output to.
-	// See Open for details.
+	// See Open for details. It's ignored if Outputs is set.
 	OutputPaths []string `json:"outputPaths" yaml:"outputPaths"`
+	// Outputs is a list of named outputs, each with its own level range,
+	// encoding, and destinations. If set, it replaces OutputPaths, and each
+	// entry is written to every output whose level range includes it.
+	Outputs []OutputConfig `json:"outputs" yaml:"outputs"`
 	// ErrorOutputPaths is a list of URLs to write internal logger errors to.
 	// The default is standard error.
 	//
-	// Note that this setting only affects internal errors; for sample code that
-	// sends error-level logs to a different location from info- and debug-level
-	// logs, see the package-level AdvancedConfiguration example.
+	// Note that this setting only affects internal errors; to send error-level
+	// logs to a different location from info- and debug-level logs, use
+	// Outputs.
 	ErrorOutputPaths []string `json:"errorOutputPaths" yaml:"errorOutputPaths"`
 	// InitialFields is a collection of fields to add to the root logger.
+	// Encoders that describe the source of the logs separately from its
+	// entries, like the "otlp" encoder, write them as a resource instead; see
+	// vipercore.ResourceEncoder.
 	InitialFields map[string]interface{} `json:"initialFields" yaml:"initialFields"`
+	// Metrics, if set, receives the number of entries written, dropped by
+	// sampling, and failed, along with write latencies. See
+	// vipercore.NewMetricsRecorder, PublishExpvarMetrics, and MetricsHandler.
+	Metrics vipercore.Metrics `json:"-" yaml:"-"`
 }
 
 // NewProductionEncoderConfig returns an opinionated EncoderConfig for
@@ -88,6 +210,49 @@ func NewProductionEncoderConfig() vipercore.EncoderConfig {
 	}
 }
 
+// NewECSEncoderConfig returns an EncoderConfig for the "ecs" encoder, which
+// uses the field names of the Elastic Common Schema and writes times in
+// RFC3339 format with nanoseconds and durations in nanoseconds, as ECS
+// expects for fields like event.duration.
+func NewECSEncoderConfig() vipercore.EncoderConfig {
+	return vipercore.EncoderConfig{
+		TimeKey:        "@timestamp",
+		LevelKey:       "log.level",
+		NameKey:        "log.logger",
+		CallerKey:      "log.origin",
+		MessageKey:     "message",
+		StacktraceKey:  "error.stack_trace",
+		LineEnding:     vipercore.DefaultLineEnding,
+		EncodeLevel:    vipercore.LowercaseLevelEncoder,
+		EncodeTime:     vipercore.RFC3339NanoTimeEncoder,
+		EncodeDuration: vipercore.NanosDurationEncoder,
+		EncodeCaller:   vipercore.FullCallerEncoder,
+	}
+}
+
+// NewGCPEncoderConfig returns an EncoderConfig for Google Cloud Logging, as
+// used by GKE, Cloud Run, and other Google Cloud environments that collect
+// structured logs from standard output. It writes the severity, timestamp,
+// and logging.googleapis.com/sourceLocation that Cloud Logging recognizes,
+// and stacktraces as the stack_trace that Error Reporting reads. Use it with
+// the "gcp" encoder to also mark errors with stacktraces for Error Reporting,
+// and add trace context to entries with GCPTrace and GCPSpanID.
+func NewGCPEncoderConfig() vipercore.EncoderConfig {
+	return vipercore.EncoderConfig{
+		TimeKey:        "timestamp",
+		LevelKey:       "severity",
+		NameKey:        "logger",
+		CallerKey:      "logging.googleapis.com/sourceLocation",
+		MessageKey:     "message",
+		StacktraceKey:  "stack_trace",
+		LineEnding:     vipercore.DefaultLineEnding,
+		EncodeLevel:    vipercore.GCPLevelEncoder,
+		EncodeTime:     vipercore.GCPTimeEncoder,
+		EncodeDuration: vipercore.StringDurationEncoder,
+		EncodeCaller:   vipercore.GCPCallerEncoder,
+	}
+}
+
 // NewProductionConfig is a reasonable production logging configuration.
 // Logging is enabled at InfoLevel and above.
 //
@@ -144,26 +309,35 @@ func NewDevelopmentConfig() Config {
 	}
 }
 
-// Build constructs a logger from the Config and Options.
+// Build constructs a logger from the Config and Options. The outputs it
+// opens are never closed; use BuildWithClose for outputs that must be closed,
+// like compressed files, whose streams are only finished when they're closed.
 func (cfg Config) Build(opts ...Option) (*Logger, error) {
-	enc, err := cfg.buildEncoder()
-	if err != nil {
-		return nil, err
-	}
+	log, _, err := cfg.BuildWithClose(opts...)
+	return log, err
+}
 
-	sink, errSink, err := cfg.openSinks()
+// BuildWithClose constructs a logger like Build, and also returns a function
+// that syncs the logger and closes its outputs and error outputs. Call it
+// when the program shuts down; the logger mustn't be used afterwards.
+func (cfg Config) BuildWithClose(opts ...Option) (*Logger, func(), error) {
+	core, errSink, closeSinks, err := cfg.buildCore()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	log := New(
-		vipercore.NewCore(enc, sink, cfg.Level),
+		core,
 		cfg.buildOptions(errSink)...,
 	)
 	if len(opts) > 0 {
 		log = log.WithOptions(opts...)
 	}
-	return log, nil
+	close := func() {
+		log.Sync()
+		closeSinks()
+	}
+	return log, close, nil
 }
 
 func (cfg Config) buildOptions(errSink vipercore.WriteSyncer) []Option {
@@ -187,39 +361,134 @@ func (cfg Config) buildOptions(errSink vipercore.WriteSyncer) []Option {
 
 	if cfg.Sampling != nil {
 		opts = append(opts, WrapCore(func(core vipercore.Core) vipercore.Core {
-			return vipercore.NewSampler(core, time.Second, int(cfg.Sampling.Initial), int(cfg.Sampling.Thereafter))
+			return vipercore.NewSamplerWithMetrics(core, time.Second, int(cfg.Sampling.Initial), int(cfg.Sampling.Thereafter), cfg.Metrics)
 		}))
 	}
 
-	if len(cfg.InitialFields) > 0 {
-		fs := make([]Field, 0, len(cfg.InitialFields))
-		keys := make([]string, 0, len(cfg.InitialFields))
-		for k := range cfg.InitialFields {
-			keys = append(keys, k)
+	return opts
+}
+
+// buildCore builds the logger's Core and opens its error output. It also
+// returns a function that closes all the sinks it opened.
+func (cfg Config) buildCore() (vipercore.Core, vipercore.WriteSyncer, func(), error) {
+	if len(cfg.Outputs) > 0 {
+		return cfg.buildOutputs()
+	}
+
+	enc, err := cfg.buildEncoder()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sink, errSink, close, err := cfg.openSinks()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cfg.newCore(enc, sink), errSink, close, nil
+}
+
+func (cfg Config) buildOutputs() (vipercore.Core, vipercore.WriteSyncer, func(), error) {
+	// Build all the encoders before opening any sinks, so that we don't leak
+	// open files on configuration errors.
+	names := make(map[string]struct{}, len(cfg.Outputs))
+	encs := make([]vipercore.Encoder, len(cfg.Outputs))
+	for i, out := range cfg.Outputs {
+		if err := out.validate(); err != nil {
+			return nil, nil, nil, err
+		}
+		if _, ok := names[out.Name]; ok {
+			return nil, nil, nil, fmt.Errorf("output %q is configured more than once", out.Name)
+		}
+		names[out.Name] = struct{}{}
+
+		enc, err := out.buildEncoder(cfg)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("can't build encoder for output %q: %v", out.Name, err)
+		}
+		encs[i] = enc
+	}
+
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	cores := make([]vipercore.Core, len(cfg.Outputs))
+	for i, out := range cfg.Outputs {
+		sink, closeOut, err := Open(out.OutputPaths...)
+		if err != nil {
+			closeAll()
+			return nil, nil, nil, fmt.Errorf("can't open output %q: %v", out.Name, err)
 		}
-		sort.Strings(keys)
-		for _, k := range keys {
-			fs = append(fs, Any(k, cfg.InitialFields[k]))
+		closers = append(closers, closeOut)
+		cores[i] = &levelFilteredCore{
+			Core: cfg.newCore(encs[i], sink),
+			enab: out,
 		}
-		opts = append(opts, Fields(fs...))
 	}
 
-	return opts
+	errSink, closeErr, err := Open(cfg.ErrorOutputPaths...)
+	if err != nil {
+		closeAll()
+		return nil, nil, nil, err
+	}
+	closers = append(closers, closeErr)
+	return vipercore.NewTee(cores...), errSink, closeAll, nil
 }
 
-func (cfg Config) openSinks() (vipercore.WriteSyncer, vipercore.WriteSyncer, error) {
+// newCore builds a Core enabled at the Config's level, taking the level's
+// field-based rules into account. The InitialFields are added to the Core's
+// context, unless the encoder writes them as a resource.
+func (cfg Config) newCore(enc vipercore.Encoder, sink vipercore.WriteSyncer) vipercore.Core {
+	fs := cfg.initialFields()
+	re, isResource := enc.(vipercore.ResourceEncoder)
+	if isResource && len(fs) > 0 {
+		enc = re.WithResource(fs)
+	}
+	enab := vipercore.NewLevelRulesEnabler(cfg.Level, cfg.Level.rules)
+	core := vipercore.NewLevelRulesCore(
+		vipercore.NewCoreWithMetrics(enc, sink, enab, cfg.Metrics),
+		cfg.Level,
+		cfg.Level.rules,
+	)
+	if !isResource && len(fs) > 0 {
+		core = core.With(fs)
+	}
+	return core
+}
+
+// initialFields returns the InitialFields sorted by key.
+func (cfg Config) initialFields() []Field {
+	fs := make([]Field, 0, len(cfg.InitialFields))
+	keys := make([]string, 0, len(cfg.InitialFields))
+	for k := range cfg.InitialFields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fs = append(fs, Any(k, cfg.InitialFields[k]))
+	}
+	return fs
+}
+
+func (cfg Config) openSinks() (vipercore.WriteSyncer, vipercore.WriteSyncer, func(), error) {
 	sink, closeOut, err := Open(cfg.OutputPaths...)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	errSink, _, err := Open(cfg.ErrorOutputPaths...)
+	errSink, closeErr, err := Open(cfg.ErrorOutputPaths...)
 	if err != nil {
 		closeOut()
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+	close := func() {
+		closeOut()
+		closeErr()
 	}
-	return sink, errSink, nil
+	return sink, errSink, close, nil
 }
 
 func (cfg Config) buildEncoder() (vipercore.Encoder, error) {
-	return newEncoder(cfg.Encoding, cfg.EncoderConfig)
+	return newOutputEncoder(cfg.Encoding, cfg.EncoderConfig, cfg.OutputPaths)
 }
@@ -3,10 +3,16 @@
 package viper
 
 import (
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	richErrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -88,3 +94,176 @@ func TestConfigWithInvalidPaths(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viper-outputs-test")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+
+	errorsPath := filepath.Join(dir, "errors.log")
+	otherPath := filepath.Join(dir, "other.log")
+	rawJSON := []byte(`{
+	  "level": "debug",
+	  "encoding": "json",
+	  "encoderConfig": {"messageKey": "msg", "levelKey": "level", "levelEncoder": "lowercase"},
+	  "outputs": [
+	    {"name": "errors", "minLevel": "error", "outputPaths": ["` + errorsPath + `"]},
+	    {
+	      "name": "other",
+	      "maxLevel": "warn",
+	      "encoding": "console",
+	      "encoderConfig": {"levelEncoder": "capital"},
+	      "outputPaths": ["` + otherPath + `"]
+	    }
+	  ]
+	}`)
+
+	var cfg Config
+	require.NoError(t, json.Unmarshal(rawJSON, &cfg), "Failed to unmarshal config.")
+	logger, err := cfg.Build()
+	require.NoError(t, err, "Unexpected error constructing logger.")
+
+	logger.Trace("trace")
+	logger.Debug("debug")
+	logger.Warn("warn")
+	logger.Error("error")
+	logger.With(String("k", "v")).Critical("critical")
+	require.NoError(t, logger.Sync(), "Unexpected error syncing.")
+
+	errorLogs, err := ioutil.ReadFile(errorsPath)
+	require.NoError(t, err, "Couldn't read error logs.")
+	assert.Equal(
+		t,
+		`{"level":"error","msg":"error"}`+"\n"+`{"level":"critical","msg":"critical","k":"v"}`+"\n",
+		string(errorLogs),
+		"Unexpected error output.",
+	)
+
+	otherLogs, err := ioutil.ReadFile(otherPath)
+	require.NoError(t, err, "Couldn't read other logs.")
+	assert.Equal(t, "DEBUG\tdebug\nWARN\twarn\n", string(otherLogs), "Unexpected output for other levels.")
+}
+
+func TestConfigInvalidOutputs(t *testing.T) {
+	errorLevel, debugLevel := ErrorLevel, DebugLevel
+	tests := []struct {
+		desc    string
+		outputs []OutputConfig
+	}{
+		{"missing name", []OutputConfig{{OutputPaths: []string{"stdout"}}}},
+		{"missing paths", []OutputConfig{{Name: "out"}}},
+		{
+			"duplicate names",
+			[]OutputConfig{
+				{Name: "out", OutputPaths: []string{"stdout"}},
+				{Name: "out", OutputPaths: []string{"stderr"}},
+			},
+		},
+		{"empty level range", []OutputConfig{{Name: "out", MinLevel: &errorLevel, MaxLevel: &debugLevel, OutputPaths: []string{"stdout"}}}},
+		{"unknown encoding", []OutputConfig{{Name: "out", Encoding: "not-there", OutputPaths: []string{"stdout"}}}},
+		{
+			"output directory doesn't exist",
+			[]OutputConfig{
+				{Name: "good", OutputPaths: []string{"stdout"}},
+				{Name: "bad", OutputPaths: []string{"/tmp/not-there/foo.log"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg := NewProductionConfig()
+			cfg.Outputs = tt.outputs
+			_, err := cfg.Build()
+			assert.Error(t, err, "Expected an error building invalid outputs.")
+		})
+	}
+}
+
+// _ecsFieldSet holds the fields of the Elastic Common Schema that the "ecs"
+// encoder writes with NewECSEncoderConfig, along with the types ECS gives
+// them.
+var _ecsFieldSet = map[string]string{
+	"@timestamp":           "date",
+	"ecs.version":          "keyword",
+	"error.message":        "text",
+	"error.stack_trace":    "wildcard",
+	"error.type":           "keyword",
+	"event.duration":       "long",
+	"log.level":            "keyword",
+	"log.logger":           "keyword",
+	"log.origin.file.line": "long",
+	"log.origin.file.name": "keyword",
+	"log.origin.function":  "keyword",
+	"message":              "match_only_text",
+}
+
+// flattenECS flattens nested objects into ECS's dotted field names.
+func flattenECS(prefix string, obj map[string]interface{}, out map[string]interface{}) {
+	for k, v := range obj {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenECS(k, nested, out)
+			continue
+		}
+		out[k] = v
+	}
+}
+
+func TestECSEncoderConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viper-ecs-test")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ecs.log")
+
+	cfg := NewProductionConfig()
+	cfg.Sampling = nil
+	cfg.Encoding = "ecs"
+	cfg.EncoderConfig = NewECSEncoderConfig()
+	cfg.OutputPaths = []string{path}
+	logger, err := cfg.Build()
+	require.NoError(t, err, "Unexpected error constructing logger.")
+
+	logger.Named("db").Info("query", Duration("event.duration", time.Second))
+	logger.Error("failed", Error(errors.New("plain")))
+	logger.Error("failed", Error(richErrors.New("rich")))
+	require.NoError(t, logger.Sync(), "Unexpected error syncing.")
+
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "Couldn't read logs.")
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Len(t, lines, 3, "Unexpected number of log lines.")
+
+	for _, line := range lines {
+		var obj map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &obj), "Couldn't unmarshal log line %q.", line)
+		fields := make(map[string]interface{})
+		flattenECS("", obj, fields)
+		for k, v := range fields {
+			typ, ok := _ecsFieldSet[k]
+			if !assert.True(t, ok, "Field %q isn't part of ECS.", k) {
+				continue
+			}
+			switch typ {
+			case "long":
+				assert.IsType(t, float64(0), v, "Expected a number for %q.", k)
+			case "date":
+				_, err := time.Parse(time.RFC3339Nano, v.(string))
+				assert.NoError(t, err, "Expected an RFC3339 time for %q.", k)
+			default:
+				assert.IsType(t, "", v, "Expected a string for %q.", k)
+			}
+		}
+		for _, k := range []string{"@timestamp", "log.level", "message", "ecs.version", "log.origin.file.name", "log.origin.file.line"} {
+			assert.Contains(t, fields, k, "Expected every entry to have %q.", k)
+		}
+		assert.Equal(t, "1.6.0", fields["ecs.version"], "Unexpected ECS version.")
+	}
+	assert.Contains(t, lines[0], `"log.logger":"db"`, "Expected the logger name.")
+	assert.Contains(t, lines[1], `"error":{"message":"plain","type":"*errors.errorString"}`, "Expected an ECS error.")
+	assert.Contains(t, lines[1], `"error.stack_trace":"`, "Expected the entry's stacktrace.")
+	assert.Contains(t, lines[2], `"stack_trace":"rich\n`, "Expected the error's own stacktrace.")
+	assert.NotContains(t, lines[2], `"error.stack_trace":"`, "Expected the entry's stacktrace to be omitted.")
+}
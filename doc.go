@@ -73,8 +73,9 @@
 //
 // More unusual configurations (splitting output between files, sending logs
 // to a message queue, etc.) are possible, but require direct use of
-// github.com/gottingen/viper/vipercore. See the package-level AdvancedConfiguration
-// example for sample code.
+// github.com/gottingen/viper/vipercore, whose NewProducerCore batches entries
+// for a message queue. See the package-level AdvancedConfiguration example for
+// sample code.
 //
 // Extending Viper
 //
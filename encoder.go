@@ -5,6 +5,7 @@ package viper
 import (
 	"errors"
 	"fmt"
+	"os"
 	"sync"
 
 	"github.com/gottingen/viper/vipercore"
@@ -14,19 +15,56 @@ var (
 	errNoEncoderNameSpecified = errors.New("no encoder name specified")
 
 	_encoderNameToConstructor = map[string]func(vipercore.EncoderConfig) (vipercore.Encoder, error){
+		"cbor": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewCBOREncoder(encoderConfig), nil
+		},
 		"console": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
 			return vipercore.NewConsoleEncoder(encoderConfig), nil
 		},
+		"ecs": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewECSEncoder(encoderConfig), nil
+		},
+		"gcp": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewGCPEncoder(encoderConfig), nil
+		},
+		"gelf": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewGELFEncoder(encoderConfig), nil
+		},
+		"journald": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewJournaldEncoder(encoderConfig), nil
+		},
 		"json": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
 			return vipercore.NewJSONEncoder(encoderConfig), nil
 		},
+		"msgpack": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewMessagePackEncoder(encoderConfig), nil
+		},
+		"msgpack-framed": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewMessagePackEncoder(encoderConfig, vipercore.MessagePackLengthPrefix()), nil
+		},
+		"otlp": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewOTLPEncoder(encoderConfig), nil
+		},
+		"pretty": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewPrettyConsoleEncoder(encoderConfig, false), nil
+		},
+		"protobuf": func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+			return vipercore.NewProtobufEncoder(encoderConfig), nil
+		},
 	}
 	_encoderMutex sync.RWMutex
 )
 
 // RegisterEncoder registers an encoder constructor, which the Config struct
-// can then reference. By default, the "json" and "console" encoders are
-// registered.
+// can then reference. By default, the "json", "console", "pretty", "msgpack",
+// "msgpack-framed", "cbor", "protobuf", "otlp", "ecs", "gelf", "gcp", and
+// "journald" encoders are registered. The "msgpack-framed" encoder prefixes
+// each entry with its length, as described by
+// vipercore.MessagePackLengthPrefix. When Config builds the "pretty" encoder,
+// it colors its output if all of the output's paths are terminals ("stdout"
+// or "stderr") and the NO_COLOR environment variable isn't set; otherwise, it
+// doesn't. The "ecs" and "gcp" encoders are meant to be used with
+// NewECSEncoderConfig and NewGCPEncoderConfig.
 //
 // Attempting to register an encoder whose name is already taken returns an
 // error.
@@ -43,6 +81,13 @@ func RegisterEncoder(name string, constructor func(vipercore.EncoderConfig) (vip
 	return nil
 }
 
+// NewEncoder constructs the encoder registered under the given name, which is
+// useful for building Cores by hand with the same encodings available to
+// Config.
+func NewEncoder(name string, encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+	return newEncoder(name, encoderConfig)
+}
+
 func newEncoder(name string, encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
 	_encoderMutex.RLock()
 	defer _encoderMutex.RUnlock()
@@ -55,3 +100,62 @@ func newEncoder(name string, encoderConfig vipercore.EncoderConfig) (vipercore.E
 	}
 	return constructor(encoderConfig)
 }
+
+// newOutputEncoder constructs the encoder registered under the given name for
+// an output written to paths, which decide whether the "pretty" encoder colors
+// its output.
+func newOutputEncoder(name string, encoderConfig vipercore.EncoderConfig, paths []string) (vipercore.Encoder, error) {
+	if name == "pretty" {
+		return vipercore.NewPrettyConsoleEncoder(encoderConfig, colorPaths(paths)), nil
+	}
+	return newEncoder(name, encoderConfig)
+}
+
+// colorPaths reports whether to color output written to all of paths, which
+// is only the case if they're all terminals.
+func colorPaths(paths []string) bool {
+	if len(paths) == 0 {
+		return false
+	}
+	for _, path := range paths {
+		var f *os.File
+		switch path {
+		case "stdout":
+			f = os.Stdout
+		case "stderr":
+			f = os.Stderr
+		default:
+			return false
+		}
+		if !colorEnabled(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// colorEnabled reports whether to color output written to f, following the
+// NO_COLOR convention (https://no-color.org).
+func colorEnabled(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// RegisterTemplateEncoder registers an encoder that fills in a template, as
+// described by vipercore.NewTemplateEncoder, so that Config can reference a
+// custom line format by name:
+//
+//   viper.RegisterTemplateEncoder("legacy", "{time} [{level}] ({name}) {message} {fields}", "logfmt")
+//
+// Invalid templates and fields formats are reported immediately.
+func RegisterTemplateEncoder(name, template, fieldsFormat string) error {
+	if _, err := vipercore.NewTemplateEncoder(vipercore.EncoderConfig{}, template, fieldsFormat); err != nil {
+		return err
+	}
+	return RegisterEncoder(name, func(encoderConfig vipercore.EncoderConfig) (vipercore.Encoder, error) {
+		return vipercore.NewTemplateEncoder(encoderConfig, template, fieldsFormat)
+	})
+}
@@ -3,15 +3,20 @@
 package viper
 
 import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gottingen/viper/vipercore"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRegisterDefaultEncoders(t *testing.T) {
-	testEncodersRegistered(t, "console", "json")
+	testEncodersRegistered(t, "console", "json", "pretty", "msgpack", "msgpack-framed", "cbor", "protobuf", "otlp", "ecs", "gelf", "gcp", "journald")
 }
 
 func TestRegisterEncoder(t *testing.T) {
@@ -51,6 +56,17 @@ func TestNewEncoderNoName(t *testing.T) {
 	assert.Equal(t, errNoEncoderNameSpecified, err, "expected an error when creating an encoder with no name")
 }
 
+func TestNewEncoderMessagePackFramed(t *testing.T) {
+	enc, err := NewEncoder("msgpack-framed", NewProductionEncoderConfig())
+	require.NoError(t, err, "Unexpected error building the framed MessagePack encoder.")
+	buf, err := enc.EncodeEntry(vipercore.Entry{Level: InfoLevel, Message: "hello"}, nil)
+	require.NoError(t, err, "Unexpected error encoding an entry.")
+
+	b := buf.Bytes()
+	require.True(t, len(b) > 4, "Expected a length prefix and an entry.")
+	assert.Equal(t, uint32(len(b)-4), binary.BigEndian.Uint32(b), "Expected the entry to be prefixed with its length.")
+}
+
 func testEncoders(f func()) {
 	existing := _encoderNameToConstructor
 	_encoderNameToConstructor = make(map[string]func(vipercore.EncoderConfig) (vipercore.Encoder, error))
@@ -68,3 +84,105 @@ func testEncodersRegistered(t *testing.T, names ...string) {
 func newNilEncoder(_ vipercore.EncoderConfig) (vipercore.Encoder, error) {
 	return nil, nil
 }
+
+func TestColorEnabled(t *testing.T) {
+	// /dev/null is a character device, like a terminal.
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Skipf("can't open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	file, err := ioutil.TempFile("", "color")
+	require.NoError(t, err, "Failed to create temp file.")
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	defer func(v string, ok bool) {
+		if ok {
+			os.Setenv("NO_COLOR", v)
+		} else {
+			os.Unsetenv("NO_COLOR")
+		}
+	}(os.LookupEnv("NO_COLOR"))
+
+	os.Unsetenv("NO_COLOR")
+	assert.True(t, colorEnabled(devNull), "Expected color on a character device.")
+	assert.False(t, colorEnabled(file), "Expected no color in a regular file.")
+
+	os.Setenv("NO_COLOR", "")
+	assert.False(t, colorEnabled(devNull), "Expected NO_COLOR to disable color.")
+}
+
+// withTerminalStdio runs f with standard output and error replaced by a
+// character device, which looks like a terminal, and NO_COLOR unset.
+func withTerminalStdio(t testing.TB, f func()) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("can't open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	defer func(v string, ok bool) {
+		if ok {
+			os.Setenv("NO_COLOR", v)
+		} else {
+			os.Unsetenv("NO_COLOR")
+		}
+	}(os.LookupEnv("NO_COLOR"))
+	os.Unsetenv("NO_COLOR")
+
+	defer func(stdout, stderr *os.File) { os.Stdout, os.Stderr = stdout, stderr }(os.Stdout, os.Stderr)
+	os.Stdout, os.Stderr = devNull, devNull
+	f()
+}
+
+func TestColorPaths(t *testing.T) {
+	withTerminalStdio(t, func() {
+		assert.True(t, colorPaths([]string{"stdout", "stderr"}), "Expected color when every path is a terminal.")
+		assert.False(t, colorPaths([]string{"stderr", "/var/log/app.log"}), "Expected no color when a path is a file.")
+		assert.False(t, colorPaths(nil), "Expected no color without paths.")
+	})
+}
+
+func TestConfigPrettyColorPerOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "color")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+
+	withTerminalStdio(t, func() {
+		cfg := NewDevelopmentConfig()
+		cfg.Encoding = "pretty"
+		cfg.OutputPaths = []string{path}
+		logger, err := cfg.Build()
+		require.NoError(t, err, "Unexpected error building logger.")
+		logger.Info("hello")
+		require.NoError(t, logger.Sync(), "Unexpected error syncing logger.")
+	})
+
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "Failed to read log file.")
+	assert.Contains(t, string(contents), "hello", "Expected the entry to be written.")
+	assert.NotContains(t, string(contents), "\x1b[", "Expected no color in a file, even if standard error is a terminal.")
+}
+
+func TestRegisterTemplateEncoder(t *testing.T) {
+	testEncoders(func() {
+		require.NoError(t, RegisterTemplateEncoder("legacy", "[{level}] {message} {fields}", "logfmt"), "Unexpected error registering a template encoder.")
+		testEncodersRegistered(t, "legacy")
+
+		cfg := NewProductionEncoderConfig()
+		cfg.EncodeLevel = vipercore.CapitalLevelEncoder
+		enc, err := newEncoder("legacy", cfg)
+		require.NoError(t, err, "Unexpected error building a template encoder.")
+		buf, err := enc.EncodeEntry(vipercore.Entry{Level: WarnLevel, Message: "hi"}, []Field{Int("n", 1)})
+		require.NoError(t, err, "Unexpected error encoding an entry.")
+		assert.Equal(t, "[WARN] hi n=1\n", buf.String(), "Unexpected output from a registered template encoder.")
+
+		assert.Error(t, RegisterTemplateEncoder("bad", "{nope}", "logfmt"), "Expected an error for an invalid template.")
+		assert.Error(t, RegisterTemplateEncoder("bad", "{message}", "xml"), "Expected an error for an invalid fields format.")
+		assert.Error(t, RegisterTemplateEncoder("legacy", "{message}", "json"), "Expected an error registering a name twice.")
+		testEncodersRegistered(t, "legacy")
+	})
+}
@@ -86,8 +86,9 @@ func Example_basicConfiguration() {
 
 func Example_advancedConfiguration() {
 	// The bundled Config struct only supports the most common configuration
-	// options. More complex needs, like splitting logs between multiple files
-	// or writing to non-file outputs, require use of the vipercore package.
+	// options. More complex needs, like writing to non-file outputs, require
+	// use of the vipercore package. (To split logs between files by level, see
+	// Config.Outputs.)
 	//
 	// In this example, imagine we're both sending our logs to Kafka and writing
 	// them to the console. We'd like to encode the console output and the Kafka
@@ -95,11 +96,13 @@ func Example_advancedConfiguration() {
 	// high-priority logs.
 
 	// First, define our level-handling logic.
+	// Levels are ordered by importance rather than by value, so compare them
+	// with Enabled.
 	highPriority := viper.LevelEnablerFunc(func(lvl vipercore.Level) bool {
-		return lvl >= vipercore.ErrorLevel
+		return vipercore.ErrorLevel.Enabled(lvl)
 	})
 	lowPriority := viper.LevelEnablerFunc(func(lvl vipercore.Level) bool {
-		return lvl < vipercore.ErrorLevel
+		return !vipercore.ErrorLevel.Enabled(lvl)
 	})
 
 	// Assume that we have clients for two Kafka topics. The clients implement
@@ -0,0 +1,30 @@
+
+
+package viper
+
+// Keys that Google Cloud Logging reads from structured logs to associate
+// entries with traces from Cloud Trace.
+const (
+	GCPTraceKey        = "logging.googleapis.com/trace"
+	GCPSpanIDKey       = "logging.googleapis.com/spanId"
+	GCPTraceSampledKey = "logging.googleapis.com/trace_sampled"
+)
+
+// GCPTrace constructs a field that associates an entry with a trace in
+// Google Cloud Trace. The trace ID is the 32-character hex ID, as found in
+// the X-Cloud-Trace-Context and traceparent headers.
+func GCPTrace(projectID, traceID string) Field {
+	return String(GCPTraceKey, "projects/"+projectID+"/traces/"+traceID)
+}
+
+// GCPSpanID constructs a field that associates an entry with a span of its
+// trace. The span ID is the 16-character hex ID.
+func GCPSpanID(spanID string) Field {
+	return String(GCPSpanIDKey, spanID)
+}
+
+// GCPTraceSampled constructs a field that records whether the entry's trace
+// was sampled.
+func GCPTraceSampled(sampled bool) Field {
+	return Bool(GCPTraceSampledKey, sampled)
+}
@@ -0,0 +1,74 @@
+package viper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/viper/vipercore"
+)
+
+func TestGCPEncoderConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viper-gcp-test")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "gcp.log")
+
+	cfg := NewProductionConfig()
+	cfg.Sampling = nil
+	cfg.Encoding = "gcp"
+	cfg.EncoderConfig = NewGCPEncoderConfig()
+	cfg.OutputPaths = []string{path}
+	logger, err := cfg.Build()
+	require.NoError(t, err, "Unexpected error constructing logger.")
+
+	traced := logger.With(
+		GCPTrace("my-project", "4bf92f3577b34da6a3ce929d0e0e4736"),
+		GCPSpanID("00f067aa0ba902b7"),
+		GCPTraceSampled(true),
+	)
+	traced.Warn("slow")
+	traced.Error("failed")
+	require.NoError(t, logger.Sync(), "Unexpected error syncing.")
+
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "Couldn't read logs.")
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Len(t, lines, 2, "Unexpected number of log lines.")
+
+	var entries [2]map[string]interface{}
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &entries[i]), "Couldn't unmarshal log line %q.", line)
+	}
+	for _, entry := range entries {
+		assert.Equal(t, "projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736", entry[GCPTraceKey], "Unexpected trace.")
+		assert.Equal(t, "00f067aa0ba902b7", entry[GCPSpanIDKey], "Unexpected span ID.")
+		assert.Equal(t, true, entry[GCPTraceSampledKey], "Unexpected sampling decision.")
+
+		ts, ok := entry["timestamp"].(map[string]interface{})
+		if assert.True(t, ok, "Expected a timestamp object.") {
+			assert.Contains(t, ts, "seconds", "Expected seconds in timestamp.")
+			assert.Contains(t, ts, "nanos", "Expected nanos in timestamp.")
+		}
+		loc, ok := entry["logging.googleapis.com/sourceLocation"].(map[string]interface{})
+		if assert.True(t, ok, "Expected a source location object.") {
+			assert.True(t, strings.HasSuffix(loc["file"].(string), "gcp_test.go"), "Unexpected source file %v.", loc["file"])
+			assert.IsType(t, "", loc["line"], "Expected the line as a string.")
+			assert.Equal(t, "github.com/gottingen/viper.TestGCPEncoderConfig", loc["function"], "Unexpected function.")
+		}
+	}
+
+	assert.Equal(t, "WARNING", entries[0]["severity"], "Unexpected severity.")
+	assert.Equal(t, "slow", entries[0]["message"], "Unexpected message.")
+	assert.NotContains(t, entries[0], "@type", "Expected no error event for warnings.")
+
+	assert.Equal(t, "ERROR", entries[1]["severity"], "Unexpected severity.")
+	assert.Equal(t, vipercore.GCPErrorEventType, entries[1]["@type"], "Expected an error event.")
+	assert.NotEmpty(t, entries[1]["stack_trace"], "Expected a stacktrace.")
+}
@@ -0,0 +1,254 @@
+
+
+package viper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	schemeGELF = "gelf"
+
+	_gelfDefaultPort      = "12201"
+	_gelfDefaultChunkSize = 1420
+	_gelfMinChunkSize     = 13
+	_gelfMaxChunks        = 128
+	_gelfChunkHeaderSize  = 12
+	_gelfDefaultTimeout   = 5 * time.Second
+)
+
+// _gelfChunkMagic starts every chunk of a chunked UDP message.
+var _gelfChunkMagic = []byte{0x1e, 0x0f}
+
+var errGELFSinkClosed = errors.New("GELF sink is closed")
+
+// GELFSinkConfig configures a sink that sends GELF messages to Graylog.
+type GELFSinkConfig struct {
+	// Address is the host and port of the GELF input.
+	Address string
+	// Protocol is "udp", the default, or "tcp".
+	Protocol string
+	// ChunkSize is the largest UDP datagram sent; larger messages are split
+	// into chunks. The default of 1420 bytes fits the usual Ethernet MTU.
+	ChunkSize int
+	// DisableCompression sends UDP messages without compressing them with
+	// gzip. Messages sent over TCP are never compressed, since GELF's TCP
+	// framing doesn't allow it.
+	DisableCompression bool
+	// Timeout bounds connecting to the GELF input and each write to it, so
+	// that a stalled TCP input can't block logging indefinitely. The default
+	// is five seconds.
+	Timeout time.Duration
+}
+
+type gelfSink struct {
+	cfg GELFSinkConfig
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// NewGELFSink creates a sink for the "gelf" encoder's output, which sends
+// each line written to it to a Graylog GELF input as a message. Over UDP,
+// messages are compressed with gzip and split into GELF chunks if they don't
+// fit in a datagram; over TCP, they're framed by null bytes. The connection
+// is opened on the first write and reopened after errors.
+//
+// The same sink can be opened with a URL in Config's OutputPaths, like
+// gelf://graylog:12201?protocol=tcp. The port defaults to 12201, and the
+// protocol, chunk, timeout, and compress query parameters set the Protocol,
+// ChunkSize, Timeout, and, if compress is "none", DisableCompression.
+func NewGELFSink(cfg GELFSinkConfig) (Sink, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("GELF sink requires an address")
+	}
+	switch cfg.Protocol {
+	case "":
+		cfg.Protocol = "udp"
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("unsupported GELF protocol %q", cfg.Protocol)
+	}
+	if cfg.ChunkSize == 0 {
+		cfg.ChunkSize = _gelfDefaultChunkSize
+	}
+	if cfg.ChunkSize < _gelfMinChunkSize {
+		return nil, fmt.Errorf("GELF chunk size %d is too small", cfg.ChunkSize)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = _gelfDefaultTimeout
+	}
+	return &gelfSink{cfg: cfg}, nil
+}
+
+func newGELFSinkFromURL(u *url.URL) (Sink, error) {
+	if u.User != nil {
+		return nil, fmt.Errorf("user and password not allowed with GELF URLs: got %v", u)
+	}
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("fragments not allowed with GELF URLs: got %v", u)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("paths not allowed with GELF URLs: got %v", u)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("GELF URLs must have a host: got %v", u)
+	}
+	port := u.Port()
+	if port == "" {
+		port = _gelfDefaultPort
+	}
+	cfg := GELFSinkConfig{Address: net.JoinHostPort(u.Hostname(), port)}
+	for key, vals := range u.Query() {
+		val := vals[len(vals)-1]
+		switch key {
+		case "protocol":
+			cfg.Protocol = val
+		case "chunk":
+			size, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid chunk in GELF URL %v: %v", u, err)
+			}
+			cfg.ChunkSize = size
+		case "timeout":
+			timeout, err := time.ParseDuration(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout in GELF URL %v: %v", u, err)
+			}
+			cfg.Timeout = timeout
+		case "compress":
+			switch val {
+			case "gzip":
+			case "none":
+				cfg.DisableCompression = true
+			default:
+				return nil, fmt.Errorf("unsupported compression %q in GELF URL: got %v", val, u)
+			}
+		default:
+			return nil, fmt.Errorf("unknown query parameter %q in GELF URL: got %v", key, u)
+		}
+	}
+	return NewGELFSink(cfg)
+}
+
+func (s *gelfSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, errGELFSinkClosed
+	}
+	for _, msg := range bytes.Split(p, []byte{'\n'}) {
+		if msg = bytes.TrimSpace(msg); len(msg) == 0 {
+			continue
+		}
+		if err := s.send(msg); err != nil {
+			// Reconnect on the next write.
+			if s.conn != nil {
+				s.conn.Close()
+				s.conn = nil
+			}
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// send sends a message. It must be called with the mutex held.
+func (s *gelfSink) send(msg []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.cfg.Protocol, s.cfg.Address, s.cfg.Timeout)
+		if err != nil {
+			return fmt.Errorf("can't connect to GELF input: %v", err)
+		}
+		s.conn = conn
+	}
+	if s.cfg.Protocol == "tcp" {
+		// Copy the message rather than appending to the caller's buffer.
+		framed := make([]byte, len(msg)+1)
+		copy(framed, msg)
+		return s.write(framed)
+	}
+
+	if !s.cfg.DisableCompression {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(msg)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		msg = buf.Bytes()
+	}
+	if len(msg) <= s.cfg.ChunkSize {
+		return s.write(msg)
+	}
+	return s.sendChunks(msg)
+}
+
+// write writes to the connection, giving up once the Timeout elapses.
+func (s *gelfSink) write(b []byte) error {
+	if err := s.conn.SetWriteDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(b)
+	return err
+}
+
+// sendChunks splits a UDP message into GELF chunks, each prefixed by the
+// chunk magic bytes, a message ID, and the chunk's position.
+func (s *gelfSink) sendChunks(msg []byte) error {
+	size := s.cfg.ChunkSize - _gelfChunkHeaderSize
+	count := (len(msg) + size - 1) / size
+	if count > _gelfMaxChunks {
+		return fmt.Errorf("GELF message of %d bytes needs %d chunks, more than the %d allowed", len(msg), count, _gelfMaxChunks)
+	}
+
+	chunk := make([]byte, 0, s.cfg.ChunkSize)
+	chunk = append(chunk, _gelfChunkMagic...)
+	chunk = append(chunk, make([]byte, 8)...)
+	if _, err := rand.Read(chunk[2:10]); err != nil {
+		return fmt.Errorf("can't generate GELF message ID: %v", err)
+	}
+	chunk = append(chunk, 0, byte(count))
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+		chunk = chunk[:_gelfChunkHeaderSize]
+		chunk[10] = byte(i)
+		chunk = append(chunk, msg[i*size:end]...)
+		if err := s.write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *gelfSink) Sync() error {
+	return nil
+}
+
+func (s *gelfSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errGELFSinkClosed
+	}
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -121,14 +121,20 @@ func redirectStdLogAt(l *Logger, level vipercore.Level) (func(), error) {
 
 func levelToFunc(logger *Logger, lvl vipercore.Level) (func(string, ...Field), error) {
 	switch lvl {
+	case TraceLevel:
+		return logger.Trace, nil
 	case DebugLevel:
 		return logger.Debug, nil
 	case InfoLevel:
 		return logger.Info, nil
+	case NoticeLevel:
+		return logger.Notice, nil
 	case WarnLevel:
 		return logger.Warn, nil
 	case ErrorLevel:
 		return logger.Error, nil
+	case CriticalLevel:
+		return logger.Critical, nil
 	case DPanicLevel:
 		return logger.DPanic, nil
 	case PanicLevel:
@@ -13,9 +13,13 @@ import (
 // ServeHTTP is a simple JSON endpoint that can report on or change the current
 // logging level.
 //
-// GET requests return a JSON description of the current logging level. PUT
-// requests change the logging level and expect a payload like:
+// GET requests return a JSON description of the current logging level and
+// any field-based level rules. PUT requests change the logging level, the
+// rules, or both, and expect a payload like:
 //   {"level":"info"}
+// or
+//   {"rules":[{"key":"user_id","equals":"42","level":"debug"}]}
+// Sending an empty list of rules removes all of them.
 //
 // It's perfectly safe to change the logging level while a program is running.
 func (lvl AtomicLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -23,7 +27,8 @@ func (lvl AtomicLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Error string `json:"error"`
 	}
 	type payload struct {
-		Level *vipercore.Level `json:"level"`
+		Level *vipercore.Level      `json:"level,omitempty"`
+		Rules []vipercore.LevelRule `json:"rules,omitempty"`
 	}
 
 	enc := json.NewEncoder(w)
@@ -32,7 +37,7 @@ func (lvl AtomicLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	case http.MethodGet:
 		current := lvl.Level()
-		enc.Encode(payload{Level: &current})
+		enc.Encode(payload{Level: &current, Rules: lvl.Rules()})
 
 	case http.MethodPut:
 		var req payload
@@ -41,9 +46,14 @@ func (lvl AtomicLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 				return fmt.Sprintf("Request body must be well-formed JSON: %v", err)
 			}
-			if req.Level == nil {
+			if req.Level == nil && req.Rules == nil {
 				return "Must specify a logging level."
 			}
+			if req.Rules != nil {
+				if err := lvl.SetRules(req.Rules); err != nil {
+					return fmt.Sprintf("Invalid level rules: %v", err)
+				}
+			}
 			return ""
 		}(); errmess != "" {
 			w.WriteHeader(http.StatusBadRequest)
@@ -51,7 +61,9 @@ func (lvl AtomicLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		lvl.SetLevel(*req.Level)
+		if req.Level != nil {
+			lvl.SetLevel(*req.Level)
+		}
 		enc.Encode(req)
 
 	default:
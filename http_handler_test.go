@@ -111,3 +111,35 @@ func TestHTTPHandlerMethodNotAllowed(t *testing.T) {
 	assertCodeMethodNotAllowed(t, code)
 	assertJSONError(t, body)
 }
+
+func TestHTTPHandlerPutRules(t *testing.T) {
+	lvl, _ := newHandler()
+
+	code, body := makeRequest(t, "PUT", lvl, strings.NewReader(`{"rules":[{"key":"user_id","equals":"42","level":"debug"}]}`))
+	assertCodeOK(t, code)
+	assert.Equal(t, `{"rules":[{"key":"user_id","equals":"42","level":"debug"}]}`+"\n", body, "Unexpected response body.")
+	assert.Equal(t, InfoLevel, lvl.Level(), "Unexpected level after setting only rules.")
+	assert.Equal(
+		t,
+		[]vipercore.LevelRule{{Key: "user_id", Equals: "42", Level: DebugLevel}},
+		lvl.Rules(),
+		"Unexpected rules after PUT.",
+	)
+
+	code, body = makeRequest(t, "GET", lvl, nil)
+	assertCodeOK(t, code)
+	assert.Equal(t, `{"level":"info","rules":[{"key":"user_id","equals":"42","level":"debug"}]}`+"\n", body, "Unexpected response body.")
+
+	code, _ = makeRequest(t, "PUT", lvl, strings.NewReader(`{"rules":[]}`))
+	assertCodeOK(t, code)
+	assert.Empty(t, lvl.Rules(), "Expected an empty list of rules to remove all rules.")
+}
+
+func TestHTTPHandlerPutInvalidRules(t *testing.T) {
+	lvl, _ := newHandler()
+	code, body := makeRequest(t, "PUT", lvl, strings.NewReader(`{"level":"warn","rules":[{"key":"user_id","level":"debug"}]}`))
+	assertCodeBadRequest(t, code)
+	assertJSONError(t, body)
+	assert.Equal(t, InfoLevel, lvl.Level(), "Expected level to be unchanged after a bad request.")
+	assert.Empty(t, lvl.Rules(), "Expected rules to be unchanged after a bad request.")
+}
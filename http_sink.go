@@ -0,0 +1,240 @@
+
+
+package viper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gottingen/viper/vipercore"
+)
+
+const (
+	schemeHTTP  = "http"
+	schemeHTTPS = "https"
+
+	_httpDefaultBatchSize     = 500
+	_httpDefaultBatchBytes    = 1 << 20
+	_httpDefaultFlushInterval = 5 * time.Second
+	_httpDefaultTimeout       = 10 * time.Second
+	_httpDefaultQueueSize     = 8
+	_httpDefaultRetries       = 3
+	_httpDefaultRetryBackoff  = 500 * time.Millisecond
+
+	// _httpHeaderParam prefixes the URL query parameters that set headers.
+	_httpHeaderParam = "header."
+)
+
+var errHTTPSinkClosed = errors.New("HTTP sink is closed")
+
+// HTTPSinkConfig configures a sink that posts batches of lines to an HTTP
+// endpoint, like the ingestion APIs of many hosted log services.
+type HTTPSinkConfig struct {
+	// Endpoint is the URL that batches are posted to.
+	Endpoint string
+	// BatchSize is the number of lines that triggers a post. The default is
+	// 500.
+	BatchSize int
+	// BatchBytes is the size in bytes of the lines that triggers a post. The
+	// default is 1MiB.
+	BatchBytes int
+	// FlushInterval is how often buffered lines are posted, even if the batch
+	// isn't full. The default is five seconds; a negative interval only posts
+	// full batches and on Sync and Close.
+	FlushInterval time.Duration
+	// Timeout bounds each request. The default is ten seconds.
+	Timeout time.Duration
+	// Headers are added to each request, for example to authenticate.
+	Headers http.Header
+	// DisableCompression posts batches without compressing them with gzip.
+	DisableCompression bool
+	// QueueSize is the number of full batches that can wait to be posted,
+	// which keeps a slow endpoint from using unbounded memory. Batches that
+	// fill up while the queue is full are dropped. The default is eight.
+	QueueSize int
+	// MaxRetries is the number of times a batch is retried after a network
+	// error or a 429 or 5xx response. The default is three; a negative number
+	// disables retries.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, which doubles with
+	// each further retry. A Retry-After header in the response takes
+	// precedence, up to a minute. The default is 500ms.
+	RetryBackoff time.Duration
+	// Client sends the requests. The default is a new http.Client.
+	Client *http.Client
+	// ErrorOutput receives a line for each batch that couldn't be posted and
+	// for each run of dropped batches. The default is standard error.
+	ErrorOutput vipercore.WriteSyncer
+	// Metrics, if set, records each line dropped because the queue is full
+	// with SinkDropped and each line that couldn't be posted with
+	// SinkWriteError.
+	Metrics vipercore.Metrics
+}
+
+type httpSink struct {
+	*batchPoster
+
+	cfg HTTPSinkConfig
+}
+
+// NewHTTPSink creates a sink that collects the lines written to it and posts
+// them in batches to an HTTP endpoint as newline-delimited JSON, compressed
+// with gzip. It's meant for the "json" encoder's output, but posts any lines
+// as they are.
+//
+// Batches are posted from a single goroutine, so writes don't wait for the
+// endpoint. A batch is queued for posting once it reaches BatchSize lines or
+// BatchBytes bytes, and everything written is posted every FlushInterval and
+// when the sink is synced or closed. When QueueSize batches are already
+// waiting, further batches are dropped and the write returns an error. Posts
+// that fail with a network error or a 429 or 5xx response are retried with
+// exponential backoff, and batches that still fail are reported to the
+// ErrorOutput and dropped. Sync waits for everything written before it to be
+// posted and returns an error if any of it couldn't be. Close stops waiting
+// to retry and makes a last attempt to post what's left.
+//
+// The same sink can be opened with an http or https URL in Config's
+// OutputPaths, like https://logs.example.com/ingest?batch=100&interval=1s.
+// The batch, bytes, interval, timeout, queue, and retries query parameters
+// set the BatchSize, BatchBytes, FlushInterval, Timeout, QueueSize, and
+// MaxRetries, compress=none sets DisableCompression, and parameters starting
+// with "header." add headers, as in header.Authorization=Bearer%20token.
+// Other query parameters are left in the endpoint.
+func NewHTTPSink(cfg HTTPSinkConfig) (Sink, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("HTTP sink requires an endpoint")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = _httpDefaultBatchSize
+	}
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = _httpDefaultBatchBytes
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = _httpDefaultFlushInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = _httpDefaultTimeout
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = _httpDefaultQueueSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = _httpDefaultRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = _httpDefaultRetryBackoff
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{}
+	}
+	if cfg.ErrorOutput == nil {
+		cfg.ErrorOutput = vipercore.Lock(os.Stderr)
+	}
+	return &httpSink{
+		batchPoster: newBatchPoster(batchPosterConfig{
+			Name:          "HTTP sink",
+			Noun:          "log lines",
+			ErrClosed:     errHTTPSinkClosed,
+			Endpoint:      cfg.Endpoint,
+			ContentType:   "application/x-ndjson",
+			Gzip:          !cfg.DisableCompression,
+			Headers:       cfg.Headers,
+			Client:        cfg.Client,
+			Timeout:       cfg.Timeout,
+			BatchSize:     cfg.BatchSize,
+			BatchBytes:    cfg.BatchBytes,
+			FlushInterval: cfg.FlushInterval,
+			QueueSize:     cfg.QueueSize,
+			MaxRetries:    cfg.MaxRetries,
+			RetryBackoff:  cfg.RetryBackoff,
+			ErrorOutput:   cfg.ErrorOutput,
+			Metrics:       cfg.Metrics,
+			Split:         splitHTTPLine,
+			Encode:        encodeHTTPLines,
+		}),
+		cfg: cfg,
+	}, nil
+}
+
+func newHTTPSinkFromURL(u *url.URL) (Sink, error) {
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("fragments not allowed with HTTP URLs: got %v", u)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("HTTP URLs must have a host: got %v", u)
+	}
+	var cfg HTTPSinkConfig
+	query := u.Query()
+	for key, vals := range query {
+		val := vals[len(vals)-1]
+		var err error
+		switch key {
+		case "batch":
+			cfg.BatchSize, err = strconv.Atoi(val)
+		case "bytes":
+			cfg.BatchBytes, err = strconv.Atoi(val)
+		case "interval":
+			cfg.FlushInterval, err = time.ParseDuration(val)
+		case "timeout":
+			cfg.Timeout, err = time.ParseDuration(val)
+		case "queue":
+			cfg.QueueSize, err = strconv.Atoi(val)
+		case "retries":
+			cfg.MaxRetries, err = strconv.Atoi(val)
+		case "compress":
+			switch val {
+			case "gzip":
+			case "none":
+				cfg.DisableCompression = true
+			default:
+				return nil, fmt.Errorf("unsupported compression %q in HTTP URL: got %v", val, u)
+			}
+		default:
+			if !strings.HasPrefix(key, _httpHeaderParam) {
+				// Leave the parameter for the endpoint.
+				continue
+			}
+			name := key[len(_httpHeaderParam):]
+			if name == "" {
+				return nil, fmt.Errorf("empty header name in HTTP URL: got %v", u)
+			}
+			if cfg.Headers == nil {
+				cfg.Headers = make(http.Header)
+			}
+			for _, v := range vals {
+				cfg.Headers.Add(name, v)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in HTTP URL %v: %v", key, u, err)
+		}
+		delete(query, key)
+	}
+
+	endpoint := *u
+	endpoint.RawQuery = query.Encode()
+	cfg.Endpoint = endpoint.String()
+	return NewHTTPSink(cfg)
+}
+
+func splitHTTPLine(line []byte) ([][]byte, error) {
+	return [][]byte{append([]byte(nil), line...)}, nil
+}
+
+// encodeHTTPLines joins lines into a body of newline-delimited JSON.
+func encodeHTTPLines(lines [][]byte) ([]byte, error) {
+	var body bytes.Buffer
+	for _, line := range lines {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+	return body.Bytes(), nil
+}
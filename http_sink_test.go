@@ -0,0 +1,307 @@
+package viper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gottingen/viper/internal/vtest"
+	"github.com/gottingen/viper/vipercore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type httpRequest struct {
+	Header http.Header
+	URL    string
+	Lines  []string
+}
+
+// withHTTPServer runs f with a server that records the batches it receives
+// and responds with the statuses returned by respond, which is passed the
+// number of the request.
+func withHTTPServer(t testing.TB, respond func(int) int, f func(url string, requests func() []httpRequest)) {
+	var (
+		mu       sync.Mutex
+		requests []httpRequest
+		calls    int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			require.NoError(t, err, "Expected a gzipped body.")
+			body = zr
+		}
+		data, err := ioutil.ReadAll(body)
+		require.NoError(t, err, "Unexpected error reading request body.")
+
+		mu.Lock()
+		calls++
+		status := respond(calls)
+		if status == http.StatusOK {
+			requests = append(requests, httpRequest{
+				Header: r.Header,
+				URL:    r.URL.String(),
+				Lines:  strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"),
+			})
+		}
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	f(srv.URL, func() []httpRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]httpRequest(nil), requests...)
+	})
+}
+
+func alwaysStatus(status int) func(int) int {
+	return func(int) int { return status }
+}
+
+// waitForRequests waits for the server to have received n requests, since
+// sinks post in the background.
+func waitForRequests(requests func() []httpRequest, n int) []httpRequest {
+	deadline := time.Now().Add(time.Second)
+	for len(requests()) < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return requests()
+}
+
+func TestHTTPSinkBatches(t *testing.T) {
+	withHTTPServer(t, alwaysStatus(http.StatusOK), func(url string, requests func() []httpRequest) {
+		sink, err := NewHTTPSink(HTTPSinkConfig{
+			Endpoint:      url + "/ingest",
+			BatchSize:     2,
+			FlushInterval: -1,
+			Headers:       http.Header{"Authorization": {"Bearer token"}},
+		})
+		require.NoError(t, err, "Unexpected error creating HTTP sink.")
+
+		sink.Write([]byte("{\"n\":1}\n"))
+		assert.Empty(t, requests(), "Expected lines to be buffered.")
+		sink.Write([]byte("{\"n\":2}\n{\"n\":"))
+		sink.Write([]byte("3}\n"))
+		require.Len(t, waitForRequests(requests, 1), 1, "Expected a full batch to be posted.")
+		assert.NoError(t, sink.Sync(), "Unexpected error syncing.")
+		assert.NoError(t, sink.Sync(), "Unexpected error syncing with nothing buffered.")
+		assert.NoError(t, sink.Close(), "Unexpected error closing sink.")
+
+		reqs := requests()
+		require.Len(t, reqs, 2, "Expected the rest to be posted on Sync.")
+		assert.Equal(t, []string{`{"n":1}`, `{"n":2}`}, reqs[0].Lines, "Unexpected first batch.")
+		assert.Equal(t, []string{`{"n":3}`}, reqs[1].Lines, "Unexpected second batch.")
+		assert.Equal(t, "/ingest", reqs[0].URL, "Unexpected path.")
+		assert.Equal(t, "Bearer token", reqs[0].Header.Get("Authorization"), "Expected configured headers.")
+		assert.Equal(t, "application/x-ndjson", reqs[0].Header.Get("Content-Type"), "Unexpected content type.")
+		assert.Equal(t, "gzip", reqs[0].Header.Get("Content-Encoding"), "Expected a gzipped body.")
+
+		_, err = sink.Write([]byte("{}\n"))
+		assert.Equal(t, errHTTPSinkClosed, err, "Expected an error writing to a closed sink.")
+	})
+}
+
+func TestHTTPSinkBatchBytes(t *testing.T) {
+	withHTTPServer(t, alwaysStatus(http.StatusOK), func(url string, requests func() []httpRequest) {
+		sink, err := NewHTTPSink(HTTPSinkConfig{Endpoint: url, BatchBytes: 10, FlushInterval: -1, DisableCompression: true})
+		require.NoError(t, err, "Unexpected error creating HTTP sink.")
+		defer sink.Close()
+
+		sink.Write([]byte("short\n"))
+		assert.Empty(t, requests(), "Expected lines to be buffered.")
+		sink.Write([]byte("longer\n"))
+		reqs := waitForRequests(requests, 1)
+		require.Len(t, reqs, 1, "Expected a batch once it's large enough.")
+		assert.Equal(t, []string{"short", "longer"}, reqs[0].Lines, "Unexpected batch.")
+		assert.Empty(t, reqs[0].Header.Get("Content-Encoding"), "Expected an uncompressed body.")
+	})
+}
+
+func TestHTTPSinkFlushInterval(t *testing.T) {
+	withHTTPServer(t, alwaysStatus(http.StatusOK), func(url string, requests func() []httpRequest) {
+		sink, err := NewHTTPSink(HTTPSinkConfig{Endpoint: url, FlushInterval: time.Millisecond})
+		require.NoError(t, err, "Unexpected error creating HTTP sink.")
+		defer sink.Close()
+
+		sink.Write([]byte("tick\n"))
+		deadline := time.Now().Add(time.Second)
+		for len(requests()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		assert.Len(t, requests(), 1, "Expected a post in the background.")
+	})
+}
+
+func TestHTTPSinkRetries(t *testing.T) {
+	// Fail the first two requests, then succeed.
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	respond := func(n int) int {
+		if n <= len(statuses) {
+			return statuses[n-1]
+		}
+		return http.StatusOK
+	}
+	withHTTPServer(t, respond, func(url string, requests func() []httpRequest) {
+		sink, err := NewHTTPSink(HTTPSinkConfig{Endpoint: url, FlushInterval: -1, RetryBackoff: time.Millisecond})
+		require.NoError(t, err, "Unexpected error creating HTTP sink.")
+		defer sink.Close()
+
+		sink.Write([]byte("retried\n"))
+		assert.NoError(t, sink.Sync(), "Expected the post to succeed after retries.")
+		reqs := requests()
+		require.Len(t, reqs, 1, "Expected one successful post.")
+		assert.Equal(t, []string{"retried"}, reqs[0].Lines, "Unexpected batch.")
+	})
+
+	withHTTPServer(t, alwaysStatus(http.StatusInternalServerError), func(url string, requests func() []httpRequest) {
+		errOut := &vtest.Buffer{}
+		sink, err := NewHTTPSink(HTTPSinkConfig{Endpoint: url, FlushInterval: -1, MaxRetries: 1, RetryBackoff: time.Millisecond, ErrorOutput: errOut})
+		require.NoError(t, err, "Unexpected error creating HTTP sink.")
+		defer sink.Close()
+
+		sink.Write([]byte("lost\n"))
+		err = sink.Sync()
+		require.Error(t, err, "Expected an error once retries run out.")
+		assert.Contains(t, err.Error(), "500 Internal Server Error", "Unexpected error.")
+		assert.Contains(t, errOut.String(), "HTTP sink error: can't post 1 log lines", "Expected the failure to be reported.")
+	})
+}
+
+func TestHTTPSinkNoRetryOnClientErrors(t *testing.T) {
+	var calls int
+	respond := func(n int) int {
+		calls = n
+		return http.StatusBadRequest
+	}
+	withHTTPServer(t, respond, func(url string, requests func() []httpRequest) {
+		m := vipercore.NewMetricsRecorder()
+		sink, err := NewHTTPSink(HTTPSinkConfig{Endpoint: url, FlushInterval: -1, RetryBackoff: time.Millisecond, ErrorOutput: &vtest.Buffer{}, Metrics: m})
+		require.NoError(t, err, "Unexpected error creating HTTP sink.")
+		defer sink.Close()
+
+		sink.Write([]byte("rejected\none\n"))
+		assert.Error(t, sink.Sync(), "Expected an error for a rejected batch.")
+		assert.Equal(t, uint64(2), m.Snapshot().SinkWriteErrors, "Expected the rejected lines to be counted.")
+	})
+	assert.Equal(t, 1, calls, "Expected no retries after a 400.")
+}
+
+func TestHTTPSinkURL(t *testing.T) {
+	withHTTPServer(t, alwaysStatus(http.StatusOK), func(url string, requests func() []httpRequest) {
+		sink, err := newSink(url + "/ingest?batch=1&interval=-1s&compress=none&header.X-Api-Key=secret&token=abc")
+		require.NoError(t, err, "Unexpected error opening HTTP URL.")
+		defer sink.Close()
+		cfg := sink.(*httpSink).cfg
+		assert.Equal(t, 1, cfg.BatchSize, "Unexpected batch size.")
+		assert.Equal(t, -time.Second, cfg.FlushInterval, "Unexpected flush interval.")
+		assert.True(t, cfg.DisableCompression, "Expected compression to be disabled.")
+
+		sink.Write([]byte("hi\n"))
+		reqs := waitForRequests(requests, 1)
+		require.Len(t, reqs, 1, "Expected a post.")
+		assert.Equal(t, "/ingest?token=abc", reqs[0].URL, "Expected other parameters to be left in the endpoint.")
+		assert.Equal(t, "secret", reqs[0].Header.Get("X-Api-Key"), "Expected a header from the URL.")
+	})
+
+	for _, bad := range []string{
+		"http:///path",
+		"https://host#frag",
+		"https://host?batch=many",
+		"https://host?interval=soon",
+		"https://host?retries=x",
+		"https://host?queue=x",
+		"https://host?compress=zstd",
+		"https://host?header.=x",
+	} {
+		_, err := newSink(bad)
+		assert.Error(t, err, "Expected an error opening %q.", bad)
+	}
+	_, err := NewHTTPSink(HTTPSinkConfig{})
+	assert.Error(t, err, "Expected an error without an endpoint.")
+}
+
+func TestHTTPSinkBuffersOwnCopy(t *testing.T) {
+	withHTTPServer(t, alwaysStatus(http.StatusOK), func(url string, requests func() []httpRequest) {
+		sink, err := NewHTTPSink(HTTPSinkConfig{Endpoint: url, FlushInterval: -1})
+		require.NoError(t, err, "Unexpected error creating HTTP sink.")
+		defer sink.Close()
+
+		buf := []byte("original\n")
+		sink.Write(buf)
+		copy(buf, bytes.Repeat([]byte("x"), len(buf)))
+		require.NoError(t, sink.Sync(), "Unexpected error syncing.")
+		assert.Equal(t, []string{"original"}, requests()[0].Lines, "Expected the sink to copy written lines.")
+	})
+}
+
+func TestHTTPSinkQueueFull(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	respond := func(n int) int {
+		if n == 1 {
+			close(entered)
+			<-release
+		}
+		return http.StatusOK
+	}
+	withHTTPServer(t, respond, func(url string, requests func() []httpRequest) {
+		errOut := &vtest.Buffer{}
+		m := vipercore.NewMetricsRecorder()
+		sink, err := NewHTTPSink(HTTPSinkConfig{Endpoint: url, BatchSize: 1, FlushInterval: -1, QueueSize: 1, ErrorOutput: errOut, Metrics: m})
+		require.NoError(t, err, "Unexpected error creating HTTP sink.")
+
+		_, err = sink.Write([]byte("posting\n"))
+		require.NoError(t, err, "Unexpected error queuing the first batch.")
+		<-entered
+		_, err = sink.Write([]byte("queued\n"))
+		assert.NoError(t, err, "Expected a batch to wait while another is posted.")
+		_, err = sink.Write([]byte("dropped\n"))
+		require.Error(t, err, "Expected an error when the queue is full.")
+		assert.Contains(t, err.Error(), "queue is full", "Unexpected error.")
+		close(release)
+
+		require.NoError(t, sink.Close(), "Unexpected error closing sink.")
+		reqs := requests()
+		require.Len(t, reqs, 2, "Expected the queued batches to be posted.")
+		assert.Equal(t, []string{"posting"}, reqs[0].Lines, "Unexpected first batch.")
+		assert.Equal(t, []string{"queued"}, reqs[1].Lines, "Unexpected second batch.")
+		assert.Contains(t, errOut.String(), "dropped 1 log lines, queue is full", "Expected dropped lines to be reported.")
+		assert.Equal(t, uint64(1), m.Snapshot().SinkDropped, "Expected the dropped line to be counted.")
+	})
+}
+
+func TestHTTPSinkCloseStopsRetrying(t *testing.T) {
+	first := make(chan struct{})
+	respond := func(n int) int {
+		if n == 1 {
+			close(first)
+		}
+		return http.StatusServiceUnavailable
+	}
+	withHTTPServer(t, respond, func(url string, _ func() []httpRequest) {
+		errOut := &vtest.Buffer{}
+		sink, err := NewHTTPSink(HTTPSinkConfig{Endpoint: url, BatchSize: 1, FlushInterval: -1, RetryBackoff: time.Hour, ErrorOutput: errOut})
+		require.NoError(t, err, "Unexpected error creating HTTP sink.")
+
+		_, err = sink.Write([]byte("unavailable\n"))
+		require.NoError(t, err, "Expected writes not to wait for the endpoint.")
+		<-first
+
+		start := time.Now()
+		assert.NoError(t, sink.Close(), "Unexpected error closing sink.")
+		assert.True(t, time.Since(start) < 10*time.Second, "Expected Close not to wait for the retry.")
+		assert.Contains(t, errOut.String(), "gave up retrying since the sink was closed", "Expected the lost batch to be reported.")
+	})
+}
@@ -17,6 +17,12 @@ const (
 	White
 )
 
+// Text styles, which can be added like colors.
+const (
+	Bold  Color = 1
+	Faint Color = 2
+)
+
 // Color represents a text color.
 type Color uint8
 
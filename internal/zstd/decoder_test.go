@@ -0,0 +1,374 @@
+package zstd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// decode decompresses a frame written by a Writer. It only handles the parts
+// of the format that the Writer uses, which lets tests check its output
+// without the zstd command.
+func decode(frame []byte) ([]byte, error) {
+	if !bytes.HasPrefix(frame, _magic) {
+		return nil, errors.New("missing magic number")
+	}
+	frame = frame[len(_magic):]
+	if len(frame) < 2 || frame[0] != _frameHeader || frame[1] != _windowDescriptor {
+		return nil, errors.New("unexpected frame header")
+	}
+	frame = frame[2:]
+
+	var (
+		out  []byte
+		rep  = [3]uint32{1, 4, 8}
+		last bool
+	)
+	for !last {
+		if len(frame) < 3 {
+			return nil, errors.New("truncated block header")
+		}
+		h := uint32(frame[0]) | uint32(frame[1])<<8 | uint32(frame[2])<<16
+		last = h&1 == 1
+		size := int(h >> 3)
+		frame = frame[3:]
+		if len(frame) < size {
+			return nil, errors.New("truncated block")
+		}
+		block := frame[:size]
+		frame = frame[size:]
+
+		switch blockType := h >> 1 & 3; blockType {
+		case _blockRaw:
+			out = append(out, block...)
+		case _blockCompressed:
+			var err error
+			if out, err = decodeBlock(out, block, &rep); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected block type %d", blockType)
+		}
+	}
+
+	if len(frame) != 4 {
+		return nil, errors.New("missing checksum")
+	}
+	var digest xxhash64
+	digest.reset()
+	digest.write(out)
+	if binary.LittleEndian.Uint32(frame) != uint32(digest.sum64()) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return out, nil
+}
+
+func decodeBlock(out, block []byte, rep *[3]uint32) ([]byte, error) {
+	lits, block, err := decodeLiterals(block)
+	if err != nil {
+		return nil, err
+	}
+	seqs, err := decodeSequences(block)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range seqs {
+		if int(s.litLen) > len(lits) {
+			return nil, errors.New("sequence has too many literals")
+		}
+		out = append(out, lits[:s.litLen]...)
+		lits = lits[s.litLen:]
+
+		offset := resolveOffset(s.offsetValue, s.litLen, rep)
+		if offset == 0 || int(offset) > len(out) {
+			return nil, fmt.Errorf("offset %d is out of range", offset)
+		}
+		from := len(out) - int(offset)
+		for i := 0; i < int(s.matchLen); i++ {
+			out = append(out, out[from+i])
+		}
+	}
+	return append(out, lits...), nil
+}
+
+// resolveOffset returns the offset an offset value refers to, updating the
+// recent offsets.
+func resolveOffset(value, litLen uint32, rep *[3]uint32) uint32 {
+	if value > 3 {
+		rep[0], rep[1], rep[2] = value-3, rep[0], rep[1]
+		return rep[0]
+	}
+	index := value - 1
+	if litLen == 0 {
+		index++
+	}
+	switch index {
+	case 0:
+		return rep[0]
+	case 1:
+		rep[0], rep[1] = rep[1], rep[0]
+	case 2:
+		rep[0], rep[1], rep[2] = rep[2], rep[0], rep[1]
+	default:
+		rep[0], rep[1], rep[2] = rep[0]-1, rep[0], rep[1]
+	}
+	return rep[0]
+}
+
+func decodeLiterals(b []byte) (lits, rest []byte, err error) {
+	if len(b) == 0 {
+		return nil, nil, errors.New("missing literals")
+	}
+	blockType, format := int(b[0]&3), b[0]>>2&3
+
+	if blockType == _literalsRaw || blockType == _literalsRLE {
+		var size, n int
+		switch format {
+		case 0, 2:
+			size, n = int(b[0]>>3), 1
+		case 1:
+			size, n = int(b[0]>>4)|int(b[1])<<4, 2
+		default:
+			size, n = int(b[0]>>4)|int(b[1])<<4|int(b[2])<<12, 3
+		}
+		b = b[n:]
+		if blockType == _literalsRLE {
+			return bytes.Repeat(b[:1], size), b[1:], nil
+		}
+		return b[:size], b[size:], nil
+	}
+	if blockType != _literalsCompressed {
+		return nil, nil, fmt.Errorf("unexpected literals type %d", blockType)
+	}
+
+	var size, compressed, n int
+	streams := 4
+	switch format {
+	case 0, 1:
+		h := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		size, compressed, n = h>>4&0x3FF, h>>14, 3
+		if format == 0 {
+			streams = 1
+		}
+	case 2:
+		h := int(binary.LittleEndian.Uint32(b))
+		size, compressed, n = h>>4&0x3FFF, h>>18, 4
+	default:
+		h := int(binary.LittleEndian.Uint64(append(b[:5:5], 0, 0, 0)))
+		size, compressed, n = h>>4&0x3FFFF, h>>22, 5
+	}
+	rest = b[n+compressed:]
+	lits, err = decodeHuffman(b[n:n+compressed], size, streams)
+	return lits, rest, err
+}
+
+func decodeHuffman(b []byte, size, streams int) ([]byte, error) {
+	// Read the weights, and infer the last one from the others.
+	listed := int(b[0]) - 127
+	if listed < 1 {
+		return nil, errors.New("unexpected Huffman tree description")
+	}
+	weights := make([]uint8, listed+1)
+	for i := 0; i < listed; i++ {
+		pair := b[1+i/2]
+		if i%2 == 0 {
+			weights[i] = pair >> 4
+		} else {
+			weights[i] = pair & 15
+		}
+	}
+	b = b[1+(listed+1)/2:]
+	total := 0
+	for _, w := range weights {
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	maxBits := bits.Len(uint(total))
+	remainder := 1<<maxBits - total
+	if remainder&(remainder-1) != 0 {
+		return nil, errors.New("Huffman weights don't sum to a power of two")
+	}
+	weights[listed] = uint8(bits.Len(uint(remainder)))
+
+	// Fill the table in order of weight, then of symbol.
+	type entry struct {
+		symbol byte
+		nbBits int
+	}
+	table := make([]entry, 0, 1<<maxBits)
+	for w := 1; w <= maxBits; w++ {
+		for s, sw := range weights {
+			if int(sw) != w {
+				continue
+			}
+			for i := 0; i < 1<<(w-1); i++ {
+				table = append(table, entry{byte(s), maxBits + 1 - w})
+			}
+		}
+	}
+
+	var sizes []int
+	if streams == 1 {
+		sizes = []int{len(b)}
+	} else {
+		sizes = []int{
+			int(binary.LittleEndian.Uint16(b)),
+			int(binary.LittleEndian.Uint16(b[2:])),
+			int(binary.LittleEndian.Uint16(b[4:])),
+		}
+		b = b[6:]
+		sizes = append(sizes, len(b)-sizes[0]-sizes[1]-sizes[2])
+	}
+	segment := (size + streams - 1) / streams
+	var lits []byte
+	for i, n := range sizes {
+		count := segment
+		if i == streams-1 {
+			count = size - segment*(streams-1)
+		}
+		r, err := newBitReader(b[:n])
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < count; j++ {
+			e := table[r.peek(maxBits)]
+			lits = append(lits, e.symbol)
+			r.skip(e.nbBits)
+		}
+		if r.pos != 0 {
+			return nil, errors.New("Huffman stream wasn't consumed")
+		}
+		b = b[n:]
+	}
+	return lits, nil
+}
+
+func decodeSequences(b []byte) ([]sequence, error) {
+	var n int
+	switch {
+	case b[0] < 128:
+		n, b = int(b[0]), b[1:]
+	case b[0] < 255:
+		n, b = int(b[0]-128)<<8|int(b[1]), b[2:]
+	default:
+		n, b = int(b[1])|int(b[2])<<8+0x7F00, b[3:]
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if b[0] != 0 {
+		return nil, errors.New("expected predefined distributions")
+	}
+	r, err := newBitReader(b[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	ll := newFSEDecoder(_litLenDistribution, _litLenTableLog)
+	of := newFSEDecoder(_offsetDistribution, _offsetTableLog)
+	ml := newFSEDecoder(_matchLenDistribution, _matchLenTableLog)
+	llState := r.read(_litLenTableLog)
+	ofState := r.read(_offsetTableLog)
+	mlState := r.read(_matchLenTableLog)
+
+	seqs := make([]sequence, n)
+	for i := range seqs {
+		llCode, mlCode, ofCode := ll[llState].symbol, ml[mlState].symbol, of[ofState].symbol
+		seqs[i].offsetValue = 1<<ofCode + uint32(r.read(int(ofCode)))
+		seqs[i].matchLen = _matchLenBaselines[mlCode] + uint32(r.read(int(_matchLenBits[mlCode])))
+		seqs[i].litLen = _litLenBaselines[llCode] + uint32(r.read(int(_litLenBits[llCode])))
+		if i < n-1 {
+			llState = ll[llState].next(r)
+			mlState = ml[mlState].next(r)
+			ofState = of[ofState].next(r)
+		}
+	}
+	if r.pos != 0 {
+		return nil, errors.New("sequences bitstream wasn't consumed")
+	}
+	return seqs, nil
+}
+
+type fseDecoderEntry struct {
+	symbol   uint8
+	nbBits   int
+	newState int
+}
+
+func (e fseDecoderEntry) next(r *bitReader) int {
+	return e.newState + r.read(e.nbBits)
+}
+
+// newFSEDecoder builds a decoding table the way RFC 8878 describes.
+func newFSEDecoder(norm []int16, tableLog int) []fseDecoderEntry {
+	size := 1 << tableLog
+	table := make([]fseDecoderEntry, size)
+	next := make([]int, len(norm))
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			table[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int(n)
+		}
+	}
+	step, pos := size>>1+size>>3+3, 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			table[pos].symbol = uint8(s)
+			pos = (pos + step) & (size - 1)
+			for pos > high {
+				pos = (pos + step) & (size - 1)
+			}
+		}
+	}
+	for u := range table {
+		s := table[u].symbol
+		state := next[s]
+		next[s]++
+		table[u].nbBits = tableLog - (bits.Len(uint(state)) - 1)
+		table[u].newState = state<<table[u].nbBits - size
+	}
+	return table
+}
+
+// A bitReader reads a bitstream backwards, from the last bit before the
+// marker that ends it. Bits before its start read as zeros.
+type bitReader struct {
+	b   []byte
+	pos int
+}
+
+func newBitReader(b []byte) (*bitReader, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return nil, errors.New("bitstream is missing its end marker")
+	}
+	return &bitReader{b: b, pos: len(b)*8 - bits.LeadingZeros8(b[len(b)-1]) - 1}, nil
+}
+
+func (r *bitReader) peek(n int) int {
+	v := 0
+	for i := r.pos - 1; i >= r.pos-n; i-- {
+		v <<= 1
+		if i >= 0 {
+			v |= int(r.b[i/8] >> (i % 8) & 1)
+		}
+	}
+	return v
+}
+
+func (r *bitReader) skip(n int) {
+	r.pos -= n
+}
+
+func (r *bitReader) read(n int) int {
+	v := r.peek(n)
+	r.skip(n)
+	return v
+}
@@ -0,0 +1,231 @@
+package zstd
+
+import "sort"
+
+// Literals block types.
+const (
+	_literalsRaw        = 0
+	_literalsRLE        = 1
+	_literalsCompressed = 2
+)
+
+const (
+	// _maxCodeLength is the longest Huffman code the format allows.
+	_maxCodeLength = 11
+	// _maxDirectSymbol is the largest literal that Huffman weights can be
+	// described for without compressing the weights.
+	_maxDirectSymbol = 128
+	// _maxSingleStream is the most literals that can be Huffman coded as a
+	// single stream; more are split into four.
+	_maxSingleStream = 1023
+)
+
+// appendLiterals appends a literals section holding lits, Huffman coded if
+// that makes it smaller.
+func appendLiterals(b []byte, lits []byte) []byte {
+	var counts [256]int
+	distinct, maxSymbol := 0, 0
+	for _, c := range lits {
+		if counts[c] == 0 {
+			distinct++
+			if int(c) > maxSymbol {
+				maxSymbol = int(c)
+			}
+		}
+		counts[c]++
+	}
+
+	if distinct == 1 && len(lits) > 1 {
+		b = appendRawLiteralsHeader(b, _literalsRLE, len(lits))
+		return append(b, lits[0])
+	}
+	if distinct > 1 && maxSymbol <= _maxDirectSymbol {
+		if compressed := huffmanLiterals(lits, counts[:maxSymbol+1]); len(compressed) < len(lits) {
+			return appendCompressedLiteralsHeader(b, len(lits), compressed)
+		}
+	}
+	b = appendRawLiteralsHeader(b, _literalsRaw, len(lits))
+	return append(b, lits...)
+}
+
+func appendRawLiteralsHeader(b []byte, blockType, size int) []byte {
+	switch {
+	case size < 1<<5:
+		return append(b, byte(blockType|size<<3))
+	case size < 1<<12:
+		h := blockType | 1<<2 | size<<4
+		return append(b, byte(h), byte(h>>8))
+	default:
+		h := blockType | 3<<2 | size<<4
+		return append(b, byte(h), byte(h>>8), byte(h>>16))
+	}
+}
+
+// appendCompressedLiteralsHeader appends the header of a compressed literals
+// block, followed by the block.
+func appendCompressedLiteralsHeader(b []byte, size int, compressed []byte) []byte {
+	n := len(compressed)
+	switch {
+	case size <= _maxSingleStream:
+		h := _literalsCompressed | size<<4 | n<<14
+		b = append(b, byte(h), byte(h>>8), byte(h>>16))
+	case size < 1<<14 && n < 1<<14:
+		h := uint32(_literalsCompressed|2<<2|size<<4) | uint32(n)<<18
+		b = append(b, byte(h), byte(h>>8), byte(h>>16), byte(h>>24))
+	default:
+		h := uint64(_literalsCompressed|3<<2|size<<4) | uint64(n)<<22
+		b = append(b, byte(h), byte(h>>8), byte(h>>16), byte(h>>24), byte(h>>32))
+	}
+	return append(b, compressed...)
+}
+
+// huffmanLiterals returns the Huffman tree description followed by the
+// Huffman coded streams of lits, whose symbols are counted in counts.
+func huffmanLiterals(lits []byte, counts []int) []byte {
+	lengths := huffmanLengths(counts)
+	maxBits := uint8(0)
+	for _, l := range lengths {
+		if l > maxBits {
+			maxBits = l
+		}
+	}
+
+	// Each symbol's weight is maxBits+1 minus its code length, or zero if
+	// it's absent. The last symbol's weight is implied by the others.
+	weights := make([]uint8, len(lengths))
+	var rankCount [_maxCodeLength + 1]int
+	for s, l := range lengths {
+		if l > 0 {
+			weights[s] = maxBits + 1 - l
+			rankCount[weights[s]]++
+		}
+	}
+	listed := len(weights) - 1
+	out := append(make([]byte, 0, len(lits)), byte(127+listed))
+	for i := 0; i < listed; i += 2 {
+		pair := weights[i] << 4
+		if i+1 < listed {
+			pair |= weights[i+1]
+		}
+		out = append(out, pair)
+	}
+
+	// Codes are assigned in order of weight, then of symbol, like decoders
+	// fill their tables.
+	var next [_maxCodeLength + 1]uint32
+	cells := uint32(0)
+	for w := 1; w <= int(maxBits); w++ {
+		next[w] = cells
+		cells += uint32(rankCount[w]) << (w - 1)
+	}
+	codes := make([]uint32, len(weights))
+	for s, w := range weights {
+		if w > 0 {
+			codes[s] = next[w] >> (w - 1)
+			next[w] += 1 << (w - 1)
+		}
+	}
+
+	if len(lits) <= _maxSingleStream {
+		return append(out, huffmanStream(lits, codes, lengths)...)
+	}
+	// Four streams follow a jump table of the first three's sizes.
+	segment := (len(lits) + 3) / 4
+	jump := len(out)
+	out = append(out, 0, 0, 0, 0, 0, 0)
+	for i := 0; i < 4; i++ {
+		end := (i + 1) * segment
+		if i == 3 {
+			end = len(lits)
+		}
+		stream := huffmanStream(lits[i*segment:end], codes, lengths)
+		if i < 3 {
+			out[jump+2*i] = byte(len(stream))
+			out[jump+2*i+1] = byte(len(stream) >> 8)
+		}
+		out = append(out, stream...)
+	}
+	return out
+}
+
+// huffmanStream codes lits backwards, so that decoders reading the stream
+// backwards get them in order.
+func huffmanStream(lits []byte, codes []uint32, lengths []uint8) []byte {
+	var w bitWriter
+	for i := len(lits) - 1; i >= 0; i-- {
+		w.addBits(codes[lits[i]], lengths[lits[i]])
+	}
+	return w.close()
+}
+
+// huffmanLengths returns the Huffman code lengths for symbols with the given
+// counts, limited to _maxCodeLength by flattening the counts as needed.
+func huffmanLengths(counts []int) []uint8 {
+	scaled := append([]int(nil), counts...)
+	for {
+		lengths := huffmanTree(scaled)
+		longest := uint8(0)
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if longest <= _maxCodeLength {
+			return lengths
+		}
+		for s, c := range scaled {
+			if c > 0 {
+				scaled[s] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanTree returns the depth of each symbol with a non-zero count in a
+// Huffman tree. There must be at least two such symbols.
+func huffmanTree(counts []int) []uint8 {
+	type node struct {
+		count  int
+		parent int
+	}
+	var leaves []int
+	for s, c := range counts {
+		if c > 0 {
+			leaves = append(leaves, s)
+		}
+	}
+	sort.SliceStable(leaves, func(i, j int) bool { return counts[leaves[i]] < counts[leaves[j]] })
+
+	// Leaves come first, in order of count, followed by the internal nodes,
+	// which are created in order of count too, so the two smallest nodes are
+	// always at the front of one of the two runs.
+	nodes := make([]node, len(leaves), 2*len(leaves)-1)
+	for i, s := range leaves {
+		nodes[i].count = counts[s]
+	}
+	leaf, internal := 0, len(leaves)
+	smallest := func() int {
+		if leaf < len(leaves) && (internal == len(nodes) || nodes[leaf].count <= nodes[internal].count) {
+			leaf++
+			return leaf - 1
+		}
+		internal++
+		return internal - 1
+	}
+	for len(nodes) < cap(nodes) {
+		a, b := smallest(), smallest()
+		nodes[a].parent = len(nodes)
+		nodes[b].parent = len(nodes)
+		nodes = append(nodes, node{count: nodes[a].count + nodes[b].count})
+	}
+
+	depths := make([]uint8, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depths[i] = depths[nodes[i].parent] + 1
+	}
+	lengths := make([]uint8, len(counts))
+	for i, s := range leaves {
+		lengths[s] = depths[i]
+	}
+	return lengths
+}
@@ -0,0 +1,269 @@
+package zstd
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// A sequence copies litLen literals, then matchLen bytes from an offset,
+// which is encoded as a value that either refers to a recent offset or is
+// the offset plus three.
+type sequence struct {
+	litLen      uint32
+	matchLen    uint32
+	offsetValue uint32
+}
+
+// The baselines and extra bits of the literal length and match length codes.
+var (
+	_litLenBaselines = []uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	_litLenBits = []uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	_matchLenBaselines = []uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	_matchLenBits = []uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// The predefined distributions of the literal length, match length, and
+// offset codes, which sequences are encoded with. Symbols marked -1 have a
+// probability below one cell.
+var (
+	_litLenDistribution = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	_matchLenDistribution = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	_offsetDistribution = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+
+	_litLenEncoder   = newFSEEncoder(_litLenDistribution, _litLenTableLog)
+	_matchLenEncoder = newFSEEncoder(_matchLenDistribution, _matchLenTableLog)
+	_offsetEncoder   = newFSEEncoder(_offsetDistribution, _offsetTableLog)
+)
+
+// The sizes of the predefined distributions' tables, as powers of two.
+const (
+	_litLenTableLog   = 6
+	_matchLenTableLog = 6
+	_offsetTableLog   = 5
+)
+
+// lengthCode returns the code whose baseline is the largest not above v.
+func lengthCode(v uint32, baselines []uint32) uint8 {
+	return uint8(sort.Search(len(baselines), func(i int) bool { return baselines[i] > v }) - 1)
+}
+
+// appendSequences appends a sequences section that encodes seqs with the
+// predefined distributions.
+func appendSequences(b []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		b = append(b, byte(n))
+	case n < 0x7F00:
+		b = append(b, byte(n>>8)+128, byte(n))
+	default:
+		b = append(b, 0xFF, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return b
+	}
+	// All three codes use Predefined_Mode.
+	b = append(b, 0)
+
+	type coded struct {
+		ll, ml, of                uint8
+		llExtra, mlExtra, ofExtra uint32
+	}
+	codes := make([]coded, n)
+	for i, s := range seqs {
+		c := &codes[i]
+		c.ll = lengthCode(s.litLen, _litLenBaselines)
+		c.llExtra = s.litLen - _litLenBaselines[c.ll]
+		c.ml = lengthCode(s.matchLen, _matchLenBaselines)
+		c.mlExtra = s.matchLen - _matchLenBaselines[c.ml]
+		c.of = uint8(bits.Len32(s.offsetValue) - 1)
+		c.ofExtra = s.offsetValue - 1<<c.of
+	}
+
+	// Decoders read the bitstream backwards, so the sequences are written
+	// last to first and each one's fields in the reverse of the order they're
+	// read in.
+	var (
+		w          bitWriter
+		ll, ml, of fseState
+		last       = codes[n-1]
+	)
+	ll.init(_litLenEncoder, last.ll)
+	ml.init(_matchLenEncoder, last.ml)
+	of.init(_offsetEncoder, last.of)
+	w.addBits(last.llExtra, _litLenBits[last.ll])
+	w.addBits(last.mlExtra, _matchLenBits[last.ml])
+	w.addBits(last.ofExtra, last.of)
+	for i := n - 2; i >= 0; i-- {
+		c := codes[i]
+		of.encode(&w, c.of)
+		ml.encode(&w, c.ml)
+		ll.encode(&w, c.ll)
+		w.addBits(c.llExtra, _litLenBits[c.ll])
+		w.addBits(c.mlExtra, _matchLenBits[c.ml])
+		w.addBits(c.ofExtra, c.of)
+	}
+	ml.flush(&w)
+	of.flush(&w)
+	ll.flush(&w)
+	return append(b, w.close()...)
+}
+
+// A bitWriter writes a bitstream, least significant bits first.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint8
+}
+
+// addBits writes the low n bits of v, where n is at most 32.
+func (w *bitWriter) addBits(v uint32, n uint8) {
+	w.bits |= uint64(v&(1<<n-1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// close ends the bitstream with a set bit, which lets decoders reading it
+// backwards find where it starts, and returns it.
+func (w *bitWriter) close() []byte {
+	w.addBits(1, 1)
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.bits))
+	}
+	return w.out
+}
+
+// An fseEncoder holds the tables that encode symbols with a finite state
+// entropy distribution.
+type fseEncoder struct {
+	tableLog   uint8
+	stateTable []uint16
+	symbols    []fseSymbol
+}
+
+type fseSymbol struct {
+	deltaNbBits    uint32
+	deltaFindState int32
+}
+
+// newFSEEncoder builds the encoding tables for a normalized distribution.
+// Symbols are
+// spread over the table the same way decoders spread them.
+func newFSEEncoder(norm []int16, tableLog uint8) *fseEncoder {
+	tableSize := 1 << tableLog
+	symbolAt := make([]uint8, tableSize)
+	cumul := make([]int, len(norm)+1)
+	high := tableSize - 1
+	for s, n := range norm {
+		if n == -1 {
+			cumul[s+1] = cumul[s] + 1
+			symbolAt[high] = uint8(s)
+			high--
+		} else {
+			cumul[s+1] = cumul[s] + int(n)
+		}
+	}
+
+	step := tableSize>>1 + tableSize>>3 + 3
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			symbolAt[pos] = uint8(s)
+			pos = (pos + step) & (tableSize - 1)
+			for pos > high {
+				pos = (pos + step) & (tableSize - 1)
+			}
+		}
+	}
+
+	enc := &fseEncoder{
+		tableLog:   tableLog,
+		stateTable: make([]uint16, tableSize),
+		symbols:    make([]fseSymbol, len(norm)),
+	}
+	for u, s := range symbolAt {
+		enc.stateTable[cumul[s]] = uint16(tableSize + u)
+		cumul[s]++
+	}
+	total := int32(0)
+	for s, n := range norm {
+		switch n {
+		case -1, 1:
+			enc.symbols[s] = fseSymbol{
+				deltaNbBits:    uint32(tableLog)<<16 - uint32(tableSize),
+				deltaFindState: total - 1,
+			}
+			total++
+		default:
+			maxBitsOut := uint32(tableLog) - uint32(bits.Len32(uint32(n-1))-1)
+			enc.symbols[s] = fseSymbol{
+				deltaNbBits:    maxBitsOut<<16 - uint32(n)<<maxBitsOut,
+				deltaFindState: total - int32(n),
+			}
+			total += int32(n)
+		}
+	}
+	return enc
+}
+
+type fseState struct {
+	enc   *fseEncoder
+	value uint32
+}
+
+// init starts encoding with the last symbol, whose state is written last.
+func (s *fseState) init(enc *fseEncoder, symbol uint8) {
+	s.enc = enc
+	tt := enc.symbols[symbol]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	v := nbBitsOut<<16 - tt.deltaNbBits
+	s.value = uint32(enc.stateTable[int32(v>>nbBitsOut)+tt.deltaFindState])
+}
+
+// encode writes the bits that lead a decoder from the symbol's state to the
+// current one.
+func (s *fseState) encode(w *bitWriter, symbol uint8) {
+	tt := s.enc.symbols[symbol]
+	nbBitsOut := (s.value + tt.deltaNbBits) >> 16
+	w.addBits(s.value, uint8(nbBitsOut))
+	s.value = uint32(s.enc.stateTable[int32(s.value>>nbBitsOut)+tt.deltaFindState])
+}
+
+// flush writes the state, which decoders read first.
+func (s *fseState) flush(w *bitWriter) {
+	w.addBits(s.value, s.enc.tableLog)
+}
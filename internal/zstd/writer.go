@@ -0,0 +1,327 @@
+// Package zstd implements a Zstandard compressor, as described in RFC 8878,
+// so that log files can be written as .zst without adding a dependency.
+//
+// It trades compression ratio for simplicity: matches are found with a
+// bounded hash chain, literals are Huffman coded with directly described
+// weights, and sequences use the format's predefined FSE tables. Its output
+// can be read by any conforming decoder, including the zstd command.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// _windowLog sets the window, which bounds how far back matches can
+	// refer and how much a decoder needs to keep.
+	_windowLog  = 17
+	_windowSize = 1 << _windowLog
+	// _blockSize is the largest block the format allows with this window.
+	_blockSize = 128 << 10
+
+	// _frameHeader sets only the Content_Checksum_flag: the content size
+	// isn't known in advance, so the frame isn't a single segment.
+	_frameHeader = 1 << 2
+	// _windowDescriptor encodes _windowSize as an exponent over 1KB.
+	_windowDescriptor = (_windowLog - 10) << 3
+
+	_hashLog  = 16
+	_minMatch = 4
+	_maxChain = 16
+)
+
+// Block types.
+const (
+	_blockRaw        = 0
+	_blockCompressed = 2
+)
+
+var (
+	errClosed = errors.New("zstd: writer is closed")
+
+	// _magic starts every frame.
+	_magic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
+
+// A Writer compresses the data written to it into a single Zstandard frame.
+// It's not safe for concurrent use.
+type Writer struct {
+	w   io.Writer
+	err error
+
+	wroteHeader bool
+	closed      bool
+
+	// hist holds the data written so far that matches can still refer to,
+	// followed by the pending data that hasn't been compressed yet.
+	hist    []byte
+	pending int
+
+	// table holds, for each hash of four bytes, the last position in hist
+	// plus one at which they were seen, and chain links each position to the
+	// previous one with the same hash.
+	table []int32
+	chain []int32
+
+	// rep holds the recent offsets, which sequences can repeat cheaply.
+	rep [3]uint32
+
+	digest   xxhash64
+	seqs     []sequence
+	literals []byte
+	out      []byte
+}
+
+// NewWriter creates a Writer that writes a compressed frame to w. The frame
+// isn't complete until the Writer is closed.
+func NewWriter(w io.Writer) *Writer {
+	zw := &Writer{
+		w:     w,
+		table: make([]int32, 1<<_hashLog),
+		chain: make([]int32, _windowSize),
+		rep:   [3]uint32{1, 4, 8},
+	}
+	zw.digest.reset()
+	return zw
+}
+
+// Write compresses p. Data is buffered until a full block is available, or
+// until the Writer is flushed or closed.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.digest.write(p)
+	n := len(p)
+	for len(p) > 0 {
+		room := _blockSize - w.pending
+		if room > len(p) {
+			room = len(p)
+		}
+		w.hist = append(w.hist, p[:room]...)
+		w.pending += room
+		p = p[room:]
+		if w.pending == _blockSize {
+			if err := w.writeBlock(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush compresses and writes the pending data as a block, so that everything
+// written so far can be decompressed.
+func (w *Writer) Flush() error {
+	if w.closed {
+		return errClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.pending == 0 {
+		return nil
+	}
+	return w.writeBlock(false)
+}
+
+// Close writes the pending data as the frame's last block, followed by the
+// checksum of the content. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return errClosed
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.writeBlock(true); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], uint32(w.digest.sum64()))
+	_, w.err = w.w.Write(sum[:])
+	return w.err
+}
+
+// writeBlock compresses the pending data, which may be empty, into a block.
+func (w *Writer) writeBlock(last bool) error {
+	out := w.out[:0]
+	if !w.wroteHeader {
+		out = append(out, _magic...)
+		out = append(out, _frameHeader, _windowDescriptor)
+		w.wroteHeader = true
+	}
+
+	start := len(w.hist) - w.pending
+	block := w.hist[start:]
+	// Decoders only track the recent offsets in compressed blocks.
+	rep := w.rep
+	if compressed, ok := w.compress(start, &rep); ok && len(compressed) < len(block) {
+		out = appendBlockHeader(out, last, _blockCompressed, len(compressed))
+		out = append(out, compressed...)
+		w.rep = rep
+	} else {
+		out = appendBlockHeader(out, last, _blockRaw, len(block))
+		out = append(out, block...)
+	}
+	w.pending = 0
+	w.slide()
+
+	w.out = out
+	_, w.err = w.w.Write(out)
+	return w.err
+}
+
+func appendBlockHeader(b []byte, last bool, blockType, size int) []byte {
+	h := uint32(size)<<3 | uint32(blockType)<<1
+	if last {
+		h |= 1
+	}
+	return append(b, byte(h), byte(h>>8), byte(h>>16))
+}
+
+// compress finds matches for the data from start to the end of hist, and
+// encodes it as the content of a compressed block, updating the recent
+// offsets. It reports false if the data is too short to compress.
+func (w *Writer) compress(start int, rep *[3]uint32) ([]byte, bool) {
+	end := len(w.hist)
+	if end-start < _minMatch {
+		return nil, false
+	}
+	w.seqs = w.seqs[:0]
+	w.literals = w.literals[:0]
+
+	anchor := start
+	for p := start; p+_minMatch <= end; {
+		length, offset := w.findMatch(p, end, rep[0])
+		w.insert(p)
+		// Put the match off while the next position has a longer one.
+		for length >= _minMatch && p+1+_minMatch <= end {
+			l, o := w.findMatch(p+1, end, rep[0])
+			if l <= length+1 {
+				break
+			}
+			p++
+			w.insert(p)
+			length, offset = l, o
+		}
+		if length < _minMatch {
+			p++
+			continue
+		}
+		litLen := uint32(p - anchor)
+		w.seqs = append(w.seqs, sequence{
+			litLen:      litLen,
+			matchLen:    uint32(length),
+			offsetValue: offsetValue(uint32(offset), litLen, rep),
+		})
+		w.literals = append(w.literals, w.hist[anchor:p]...)
+		for i := p + 1; i < p+length && i+_minMatch <= end; i++ {
+			w.insert(i)
+		}
+		p += length
+		anchor = p
+	}
+	w.literals = append(w.literals, w.hist[anchor:end]...)
+
+	compressed := appendLiterals(nil, w.literals)
+	compressed = appendSequences(compressed, w.seqs)
+	return compressed, len(compressed) <= _blockSize
+}
+
+// findMatch returns the longest match for the data at p among the previous
+// positions with the same hash, along with its offset. Since repeating the
+// last offset is cheaper, a match at that offset is preferred unless another
+// is more than a byte longer.
+func (w *Writer) findMatch(p, end int, lastOffset uint32) (length, offset int) {
+	repLen := 0
+	if r := int(lastOffset); r <= p {
+		for p+repLen < end && w.hist[p-r+repLen] == w.hist[p+repLen] {
+			repLen++
+		}
+	}
+
+	cand := int(w.table[hash4(w.hist[p:])]) - 1
+	for i := 0; i < _maxChain && cand >= 0 && p-cand <= _windowSize; i++ {
+		n := 0
+		for p+n < end && w.hist[cand+n] == w.hist[p+n] {
+			n++
+		}
+		if n > length {
+			length, offset = n, p-cand
+		}
+		next := int(w.chain[cand&(_windowSize-1)]) - 1
+		if next >= cand {
+			break
+		}
+		cand = next
+	}
+	if repLen >= _minMatch && repLen+1 >= length {
+		return repLen, int(lastOffset)
+	}
+	return length, offset
+}
+
+// offsetValue returns the value that encodes an offset, which refers to one
+// of the recent offsets if possible, and updates them the way decoders do.
+// Which values refer to which recent offsets depends on whether the sequence
+// has literals.
+func offsetValue(offset, litLen uint32, rep *[3]uint32) uint32 {
+	switch {
+	case litLen > 0 && offset == rep[0]:
+		return 1
+	case litLen > 0 && offset == rep[1]:
+		rep[0], rep[1] = rep[1], rep[0]
+		return 2
+	case litLen > 0 && offset == rep[2]:
+		rep[0], rep[1], rep[2] = rep[2], rep[0], rep[1]
+		return 3
+	case litLen == 0 && offset == rep[1]:
+		rep[0], rep[1] = rep[1], rep[0]
+		return 1
+	case litLen == 0 && offset == rep[2]:
+		rep[0], rep[1], rep[2] = rep[2], rep[0], rep[1]
+		return 2
+	}
+	rep[0], rep[1], rep[2] = offset, rep[0], rep[1]
+	return offset + 3
+}
+
+// insert records the position p in the hash table.
+func (w *Writer) insert(p int) {
+	h := hash4(w.hist[p:])
+	w.chain[p&(_windowSize-1)] = w.table[h]
+	w.table[h] = int32(p + 1)
+}
+
+// slide drops the history that matches can no longer refer to. It moves the
+// history by a multiple of the window size, so that positions keep their
+// place in the chain.
+func (w *Writer) slide() {
+	if len(w.hist) < 2*_windowSize {
+		return
+	}
+	shift := (len(w.hist) - _windowSize) &^ (_windowSize - 1)
+	w.hist = w.hist[:copy(w.hist, w.hist[shift:])]
+	rebase := func(positions []int32) {
+		for i, p := range positions {
+			if int(p) <= shift {
+				positions[i] = 0
+			} else {
+				positions[i] = p - int32(shift)
+			}
+		}
+	}
+	rebase(w.table)
+	rebase(w.chain)
+}
+
+func hash4(b []byte) uint32 {
+	return (binary.LittleEndian.Uint32(b) * 2654435761) >> (32 - _hashLog)
+}
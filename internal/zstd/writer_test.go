@@ -0,0 +1,124 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testInputs returns inputs that exercise raw, RLE, and Huffman coded
+// literals, repeated offsets, and histories longer than the window.
+func testInputs() map[string][]byte {
+	var logs bytes.Buffer
+	for i := 0; logs.Len() < 3*_windowSize; i++ {
+		fmt.Fprintf(&logs, `{"level":"info","ts":%d,"msg":"request served","path":"/v1/items/%d","status":%d}`+"\n", 1500000000+i*7, i%97, 200+i%3)
+	}
+	random := make([]byte, _blockSize+100)
+	rand.New(rand.NewSource(1)).Read(random)
+	return map[string][]byte{
+		"empty":   nil,
+		"byte":    []byte("a"),
+		"short":   []byte("abc"),
+		"run":     bytes.Repeat([]byte("z"), 5000),
+		"text":    []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 300)),
+		"utf8":    []byte(strings.Repeat("日本語のログ、ümlauts, and ASCII; ", 200)),
+		"logs":    logs.Bytes(),
+		"random":  random,
+		"binary":  bytes.Repeat([]byte{0, 1, 2, 3, 255, 254, 200, 128, 129}, 3000),
+		"pattern": bytes.Repeat(append(random[:300:300], random[:50]...), 40),
+	}
+}
+
+// compress writes data in chunks of the given size, flushing after every
+// flushEvery chunks if it's positive.
+func compress(t testing.TB, data []byte, chunk, flushEvery int) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for i := 0; len(data) > 0; i++ {
+		n := chunk
+		if n > len(data) {
+			n = len(data)
+		}
+		written, err := w.Write(data[:n])
+		require.NoError(t, err, "Unexpected error writing.")
+		require.Equal(t, n, written, "Unexpected number of bytes written.")
+		data = data[n:]
+		if flushEvery > 0 && i%flushEvery == flushEvery-1 {
+			require.NoError(t, w.Flush(), "Unexpected error flushing.")
+		}
+	}
+	require.NoError(t, w.Close(), "Unexpected error closing.")
+	return buf.Bytes()
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	zstdPath, _ := exec.LookPath("zstd")
+	for name, data := range testInputs() {
+		for _, tt := range []struct{ chunk, flushEvery int }{
+			{1 << 20, 0},
+			{4096, 5},
+			{7, 100},
+		} {
+			if tt.chunk < 100 && len(data) > 100000 {
+				continue
+			}
+			compressed := compress(t, data, tt.chunk, tt.flushEvery)
+			out, err := decode(compressed)
+			if assert.NoError(t, err, "Unexpected error decoding %s written in chunks of %d.", name, tt.chunk) {
+				assert.True(t, bytes.Equal(data, out), "Unexpected round trip of %s written in chunks of %d.", name, tt.chunk)
+			}
+
+			if zstdPath == "" {
+				continue
+			}
+			cmd := exec.Command(zstdPath, "-q", "-d", "-c")
+			cmd.Stdin = bytes.NewReader(compressed)
+			out, err = cmd.Output()
+			if assert.NoError(t, err, "Unexpected error decompressing %s with zstd.", name) {
+				assert.True(t, bytes.Equal(data, out), "Unexpected output of zstd for %s written in chunks of %d.", name, tt.chunk)
+			}
+		}
+	}
+}
+
+func TestWriterCompresses(t *testing.T) {
+	inputs := testInputs()
+	for _, name := range []string{"run", "text", "logs"} {
+		compressed := compress(t, inputs[name], 1<<20, 0)
+		assert.True(t, len(compressed) < len(inputs[name])/3, "Expected %s to compress to under a third of its size, got %d of %d bytes.", name, len(compressed), len(inputs[name]))
+	}
+	random := inputs["random"]
+	assert.True(t, len(compress(t, random, 1<<20, 0)) < len(random)+32, "Expected incompressible data to be stored with little overhead.")
+}
+
+func TestWriterClosed(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.Close(), "Unexpected error closing.")
+	_, err := w.Write([]byte("late"))
+	assert.Equal(t, errClosed, err, "Expected an error writing after closing.")
+	assert.Equal(t, errClosed, w.Flush(), "Expected an error flushing after closing.")
+	assert.Equal(t, errClosed, w.Close(), "Expected an error closing twice.")
+}
+
+type failingWriter struct{ err error }
+
+func (f failingWriter) Write([]byte) (int, error) { return 0, f.err }
+
+func TestWriterErrors(t *testing.T) {
+	fail := errors.New("fail")
+	w := NewWriter(failingWriter{fail})
+	_, err := w.Write([]byte("buffered"))
+	assert.NoError(t, err, "Expected writes to be buffered.")
+	assert.Equal(t, fail, w.Flush(), "Expected flushing to return the underlying error.")
+	_, err = w.Write([]byte("more"))
+	assert.Equal(t, fail, err, "Expected the error to persist.")
+	assert.Equal(t, fail, w.Close(), "Expected closing to return the error.")
+}
@@ -0,0 +1,105 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	_prime64_1 uint64 = 11400714785074694791
+	_prime64_2 uint64 = 14029467366897019727
+	_prime64_3 uint64 = 1609587929392839161
+	_prime64_4 uint64 = 9650029242287828579
+	_prime64_5 uint64 = 2870177450012600261
+)
+
+// xxhash64 computes the XXH64 hash, with a seed of zero, of the data written
+// to it. Frames end with the low 32 bits of the hash of their content.
+type xxhash64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [32]byte
+	n              int
+}
+
+func (x *xxhash64) reset() {
+	// The primes are copied to variables so that the additions wrap around.
+	p1, p2 := _prime64_1, _prime64_2
+	*x = xxhash64{
+		v1: p1 + p2,
+		v2: p2,
+		v4: -p1,
+	}
+}
+
+func (x *xxhash64) write(p []byte) {
+	x.total += uint64(len(p))
+	if x.n+len(p) < 32 {
+		x.n += copy(x.mem[x.n:], p)
+		return
+	}
+	if x.n > 0 {
+		c := copy(x.mem[x.n:], p)
+		x.stripe(x.mem[:])
+		p = p[c:]
+		x.n = 0
+	}
+	for ; len(p) >= 32; p = p[32:] {
+		x.stripe(p)
+	}
+	x.n = copy(x.mem[:], p)
+}
+
+func (x *xxhash64) stripe(p []byte) {
+	x.v1 = xxhashRound(x.v1, binary.LittleEndian.Uint64(p))
+	x.v2 = xxhashRound(x.v2, binary.LittleEndian.Uint64(p[8:]))
+	x.v3 = xxhashRound(x.v3, binary.LittleEndian.Uint64(p[16:]))
+	x.v4 = xxhashRound(x.v4, binary.LittleEndian.Uint64(p[24:]))
+}
+
+func (x *xxhash64) sum64() uint64 {
+	var h uint64
+	if x.total >= 32 {
+		h = bits.RotateLeft64(x.v1, 1) + bits.RotateLeft64(x.v2, 7) +
+			bits.RotateLeft64(x.v3, 12) + bits.RotateLeft64(x.v4, 18)
+		h = xxhashMerge(h, x.v1)
+		h = xxhashMerge(h, x.v2)
+		h = xxhashMerge(h, x.v3)
+		h = xxhashMerge(h, x.v4)
+	} else {
+		h = _prime64_5
+	}
+	h += x.total
+
+	p := x.mem[:x.n]
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxhashRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*_prime64_1 + _prime64_4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * _prime64_1
+		h = bits.RotateLeft64(h, 23)*_prime64_2 + _prime64_3
+		p = p[4:]
+	}
+	for _, c := range p {
+		h ^= uint64(c) * _prime64_5
+		h = bits.RotateLeft64(h, 11) * _prime64_1
+	}
+
+	h ^= h >> 33
+	h *= _prime64_2
+	h ^= h >> 29
+	h *= _prime64_3
+	h ^= h >> 32
+	return h
+}
+
+func xxhashRound(acc, input uint64) uint64 {
+	acc += input * _prime64_2
+	return bits.RotateLeft64(acc, 31) * _prime64_1
+}
+
+func xxhashMerge(acc, val uint64) uint64 {
+	acc ^= xxhashRound(0, val)
+	return acc*_prime64_1 + _prime64_4
+}
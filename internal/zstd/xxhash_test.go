@@ -0,0 +1,37 @@
+package zstd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func xxhashSum(chunks ...[]byte) uint64 {
+	var x xxhash64
+	x.reset()
+	for _, c := range chunks {
+		x.write(c)
+	}
+	return x.sum64()
+}
+
+func TestXXHash64(t *testing.T) {
+	tests := map[string]uint64{
+		"":    0xEF46DB3751D8E999,
+		"a":   0xD24EC4F1A98C6E5B,
+		"abc": 0x44BC2CF5AD770999,
+	}
+	for in, sum := range tests {
+		assert.Equal(t, sum, xxhashSum([]byte(in)), "Unexpected hash of %q.", in)
+	}
+
+	// The zstd command writes the low 32 bits of the hash after each frame.
+	long := make([]byte, 1000)
+	for i := range long {
+		long[i] = byte(i % 251)
+	}
+	assert.Equal(t, uint32(0xA88B54D3), uint32(xxhashSum(long)), "Unexpected hash of a long input.")
+	for _, split := range []int{1, 31, 32, 33, 64, 999} {
+		assert.Equal(t, xxhashSum(long), xxhashSum(long[:split], long[split:]), "Expected the hash not to depend on how the input is split at %d.", split)
+	}
+}
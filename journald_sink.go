@@ -0,0 +1,117 @@
+
+
+package viper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+)
+
+const (
+	schemeJournald = "journald"
+
+	_journaldDefaultSocket = "/run/systemd/journal/socket"
+)
+
+var errJournaldSinkClosed = errors.New("journald sink is closed")
+
+// JournaldSinkConfig configures a sink that sends entries to systemd-journald.
+type JournaldSinkConfig struct {
+	// Socket is the path of the journal's native protocol socket, which
+	// defaults to /run/systemd/journal/socket.
+	Socket string
+}
+
+type journaldSink struct {
+	cfg JournaldSinkConfig
+
+	mu     sync.Mutex
+	conn   *net.UnixConn
+	closed bool
+}
+
+// NewJournaldSink creates a sink for the "journald" encoder's output, which
+// sends each write to systemd-journald as an entry in the journal's native
+// protocol. Entries too large for a datagram are written to a sealed memfd,
+// or on kernels without memfds to an unlinked file in /dev/shm, and passed to
+// the journal as a file descriptor. The socket is opened on the first write
+// and reopened after errors.
+//
+// Since every write is sent as one entry, the sink should be written to by a
+// single Core, which writes each entry at once.
+//
+// The same sink can be opened with a URL in Config's OutputPaths: journald://
+// uses the default socket, and journald:///path/to/socket the given one.
+func NewJournaldSink(cfg JournaldSinkConfig) (Sink, error) {
+	if cfg.Socket == "" {
+		cfg.Socket = _journaldDefaultSocket
+	}
+	return &journaldSink{cfg: cfg}, nil
+}
+
+func newJournaldSinkFromURL(u *url.URL) (Sink, error) {
+	if u.User != nil {
+		return nil, fmt.Errorf("user and password not allowed with journald URLs: got %v", u)
+	}
+	if u.Host != "" {
+		return nil, fmt.Errorf("hosts not allowed with journald URLs: got %v", u)
+	}
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("fragments not allowed with journald URLs: got %v", u)
+	}
+	if u.RawQuery != "" {
+		return nil, fmt.Errorf("query parameters not allowed with journald URLs: got %v", u)
+	}
+	return NewJournaldSink(JournaldSinkConfig{Socket: u.Path})
+}
+
+func (s *journaldSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, errJournaldSinkClosed
+	}
+	if s.conn == nil {
+		// The socket is autobound rather than connected, since file
+		// descriptors can only be sent with an address.
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+		if err != nil {
+			return 0, fmt.Errorf("can't open socket for journald: %v", err)
+		}
+		s.conn = conn
+	}
+	addr := &net.UnixAddr{Name: s.cfg.Socket, Net: "unixgram"}
+	_, err := s.conn.WriteToUnix(p, addr)
+	if err != nil && isJournaldEntryTooLarge(err) {
+		err = sendJournaldFD(s.conn, addr, p)
+	}
+	if err != nil {
+		// Reopen the socket on the next write.
+		s.conn.Close()
+		s.conn = nil
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *journaldSink) Sync() error {
+	return nil
+}
+
+func (s *journaldSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errJournaldSinkClosed
+	}
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
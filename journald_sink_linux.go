@@ -0,0 +1,92 @@
+
+
+package viper
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	_mfdCloexec      = 0x1
+	_mfdAllowSealing = 0x2
+
+	_fAddSeals   = 1033
+	_fSealSeal   = 0x1
+	_fSealShrink = 0x2
+	_fSealGrow   = 0x4
+	_fSealWrite  = 0x8
+)
+
+// The syscall package doesn't know memfd_create, so its number is listed for
+// the common architectures. Elsewhere, entries go through /dev/shm instead.
+var _memfdCreateSyscalls = map[string]uintptr{
+	"386":     356,
+	"amd64":   319,
+	"arm":     385,
+	"arm64":   279,
+	"ppc64":   360,
+	"ppc64le": 360,
+	"riscv64": 279,
+	"s390x":   350,
+}
+
+func isJournaldEntryTooLarge(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// sendJournaldFD sends an entry that doesn't fit in a datagram by writing it
+// to a file and passing the journal the file's descriptor.
+func sendJournaldFD(conn *net.UnixConn, addr *net.UnixAddr, p []byte) error {
+	f, sealed, err := openJournaldFile()
+	if err != nil {
+		return fmt.Errorf("can't create file for journald entry: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(p); err != nil {
+		return err
+	}
+	if sealed {
+		// The journal only accepts memfds that can't be changed.
+		seals := _fSealSeal | _fSealShrink | _fSealGrow | _fSealWrite
+		if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), _fAddSeals, uintptr(seals)); errno != 0 {
+			return fmt.Errorf("can't seal journald entry: %v", errno)
+		}
+	}
+	_, _, err = conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), addr)
+	return err
+}
+
+// openJournaldFile creates a memfd that can be sealed or, if the kernel
+// doesn't support memfds, an unlinked file in /dev/shm.
+func openJournaldFile() (f *os.File, sealed bool, err error) {
+	if nr, ok := _memfdCreateSyscalls[runtime.GOARCH]; ok {
+		name, err := syscall.BytePtrFromString("viper-journald")
+		if err != nil {
+			return nil, false, err
+		}
+		fd, _, errno := syscall.Syscall(nr, uintptr(unsafe.Pointer(name)), _mfdCloexec|_mfdAllowSealing, 0)
+		if errno == 0 {
+			return os.NewFile(fd, "viper-journald"), true, nil
+		}
+		if errno != syscall.ENOSYS {
+			return nil, false, errno
+		}
+	}
+	f, err = ioutil.TempFile("/dev/shm", "viper-journald-")
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return f, false, nil
+}
@@ -0,0 +1,56 @@
+package viper
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournaldSinkLargeEntry(t *testing.T) {
+	conn, path, cleanup := listenJournald(t)
+	defer cleanup()
+
+	sink, err := NewJournaldSink(JournaldSinkConfig{Socket: path})
+	require.NoError(t, err, "Unexpected error creating journald sink.")
+	defer sink.Close()
+
+	// Far larger than the default socket buffers, so it can't be a datagram.
+	entry := append([]byte("MESSAGE="), bytes.Repeat([]byte("x"), 4<<20)...)
+	entry = append(entry, '\n')
+	n, err := sink.Write(entry)
+	require.NoError(t, err, "Unexpected error writing a large entry.")
+	assert.Equal(t, len(entry), n, "Unexpected number of bytes written.")
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)), "Failed to set read deadline.")
+	buf := make([]byte, 1024)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	require.NoError(t, err, "Failed to read datagram.")
+	assert.Equal(t, 0, n, "Expected an empty datagram.")
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	require.NoError(t, err, "Failed to parse control message.")
+	require.Len(t, msgs, 1, "Expected one control message.")
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	require.NoError(t, err, "Failed to parse file descriptors.")
+	require.Len(t, fds, 1, "Expected one file descriptor.")
+
+	f := os.NewFile(uintptr(fds[0]), "entry")
+	defer f.Close()
+	const fGetSeals = 1034
+	if seals, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), fGetSeals, 0); errno == 0 {
+		assert.Equal(t, uintptr(_fSealSeal|_fSealShrink|_fSealGrow|_fSealWrite), seals, "Expected a sealed memfd.")
+	}
+	// The descriptor shares the sink's offset, which is past the entry.
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err, "Failed to seek in the entry's file.")
+	contents, err := ioutil.ReadAll(f)
+	require.NoError(t, err, "Failed to read the entry's file.")
+	assert.True(t, bytes.Equal(entry, contents), "Unexpected contents of the entry's file.")
+}
@@ -0,0 +1,18 @@
+
+
+// +build !linux
+
+package viper
+
+import (
+	"errors"
+	"net"
+)
+
+func isJournaldEntryTooLarge(err error) bool {
+	return false
+}
+
+func sendJournaldFD(conn *net.UnixConn, addr *net.UnixAddr, p []byte) error {
+	return errors.New("journald entries too large for a datagram are only supported on Linux")
+}
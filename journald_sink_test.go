@@ -0,0 +1,102 @@
+package viper
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/viper/vipercore"
+)
+
+// listenJournald listens on a unixgram socket standing in for the journal.
+func listenJournald(t testing.TB) (*net.UnixConn, string, func()) {
+	dir, err := ioutil.TempDir("", "viper-journald-test")
+	require.NoError(t, err, "Failed to create temp dir.")
+	path := filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("can't listen on a unixgram socket: %v", err)
+	}
+	return conn, path, func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func readJournaldDatagram(t testing.TB, conn *net.UnixConn) string {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)), "Failed to set read deadline.")
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	require.NoError(t, err, "Failed to read datagram.")
+	return string(buf[:n])
+}
+
+func newJournaldTestLogger(sink Sink) *Logger {
+	enc := vipercore.NewJournaldEncoder(NewProductionEncoderConfig())
+	return New(vipercore.NewCore(enc, sink, DebugLevel))
+}
+
+func TestJournaldSink(t *testing.T) {
+	conn, path, cleanup := listenJournald(t)
+	defer cleanup()
+
+	sink, err := newSink("journald://" + path)
+	require.NoError(t, err, "Unexpected error opening journald URL.")
+	defer sink.Close()
+	logger := newJournaldTestLogger(sink).Named("app")
+
+	logger.Info("one", String("user", "u1"))
+	logger.Warn("two")
+	assert.Equal(t, "MESSAGE=one\nPRIORITY=6\nLOGGER=app\nUSER=u1\n", readJournaldDatagram(t, conn), "Unexpected first entry.")
+	assert.Equal(t, "MESSAGE=two\nPRIORITY=4\nLOGGER=app\n", readJournaldDatagram(t, conn), "Unexpected second entry.")
+}
+
+func TestJournaldSinkConfig(t *testing.T) {
+	conn, path, cleanup := listenJournald(t)
+	defer cleanup()
+
+	cfg := NewProductionConfig()
+	cfg.Encoding = "journald"
+	cfg.OutputPaths = []string{"journald://" + path}
+	logger, err := cfg.Build()
+	require.NoError(t, err, "Unexpected error constructing logger.")
+
+	logger.Error("failed")
+	entry := readJournaldDatagram(t, conn)
+	assert.Contains(t, entry, "MESSAGE=failed\nPRIORITY=3\n", "Unexpected entry.")
+	assert.Contains(t, entry, "CODE_FILE=", "Expected the caller's file.")
+	assert.Contains(t, entry, "CODE_FUNC=github.com/gottingen/viper.TestJournaldSinkConfig\n", "Expected the caller's function.")
+	assert.Contains(t, entry, "STACKTRACE\n", "Expected a stacktrace in the binary form.")
+}
+
+func TestJournaldSinkErrors(t *testing.T) {
+	for _, bad := range []string{
+		"journald://host",
+		"journald://user@/path",
+		"journald:///path#frag",
+		"journald:///path?sync=true",
+	} {
+		_, err := newSink(bad)
+		assert.Error(t, err, "Expected an error opening %q.", bad)
+	}
+
+	sink, err := newSink("journald://")
+	require.NoError(t, err, "Unexpected error opening journald URL.")
+	assert.Equal(t, _journaldDefaultSocket, sink.(*journaldSink).cfg.Socket, "Expected the default socket.")
+	assert.NoError(t, sink.Close(), "Unexpected error closing an unused sink.")
+	_, err = sink.Write([]byte("MESSAGE=hi\n"))
+	assert.Equal(t, errJournaldSinkClosed, err, "Expected an error writing to a closed sink.")
+
+	sink, err = NewJournaldSink(JournaldSinkConfig{Socket: filepath.Join(os.TempDir(), "viper-journald-missing")})
+	require.NoError(t, err, "Unexpected error creating journald sink.")
+	defer sink.Close()
+	_, err = sink.Write([]byte("MESSAGE=hi\n"))
+	assert.Error(t, err, "Expected an error writing without a journal.")
+}
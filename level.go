@@ -8,17 +8,26 @@ import (
 )
 
 const (
+	// TraceLevel logs are finer-grained than Debug, and are usually only
+	// enabled while chasing down a specific problem.
+	TraceLevel = vipercore.TraceLevel
 	// DebugLevel logs are typically voluminous, and are usually disabled in
 	// production.
 	DebugLevel = vipercore.DebugLevel
 	// InfoLevel is the default logging priority.
 	InfoLevel = vipercore.InfoLevel
+	// NoticeLevel logs are normal but significant events, more important than
+	// Info but not indicative of a problem.
+	NoticeLevel = vipercore.NoticeLevel
 	// WarnLevel logs are more important than Info, but don't need individual
 	// human review.
 	WarnLevel = vipercore.WarnLevel
 	// ErrorLevel logs are high-priority. If an application is running smoothly,
 	// it shouldn't generate any error-level logs.
 	ErrorLevel = vipercore.ErrorLevel
+	// CriticalLevel logs are errors that need immediate attention, but unlike
+	// DPanic, Panic, and Fatal they never interrupt the program.
+	CriticalLevel = vipercore.CriticalLevel
 	// DPanicLevel logs are particularly important errors. In development the
 	// logger panics after writing the message.
 	DPanicLevel = vipercore.DPanicLevel
@@ -46,17 +55,22 @@ func (f LevelEnablerFunc) Enabled(lvl vipercore.Level) bool { return f(lvl) }
 // The AtomicLevel itself is an http.Handler that serves a JSON endpoint to
 // alter its level.
 //
+// Each AtomicLevel also carries a set of vipercore.LevelRules, which adjust
+// the level for entries with matching fields; see SetRules.
+//
 // AtomicLevels must be created with the NewAtomicLevel constructor to allocate
 // their internal atomic pointer.
 type AtomicLevel struct {
-	l *atomic.Int32
+	l     *atomic.Int32
+	rules *vipercore.LevelRules
 }
 
 // NewAtomicLevel creates an AtomicLevel with InfoLevel and above logging
 // enabled.
 func NewAtomicLevel() AtomicLevel {
 	return AtomicLevel{
-		l: atomic.NewInt32(int32(InfoLevel)),
+		l:     atomic.NewInt32(int32(InfoLevel)),
+		rules: &vipercore.LevelRules{},
 	}
 }
 
@@ -84,18 +98,34 @@ func (lvl AtomicLevel) SetLevel(l vipercore.Level) {
 	lvl.l.Store(int32(l))
 }
 
+// Rules returns the current field-based level rules.
+func (lvl AtomicLevel) Rules() []vipercore.LevelRule {
+	return lvl.rules.Rules()
+}
+
+// SetRules validates and replaces the field-based level rules. The rules only
+// take effect for loggers whose Core is wrapped with
+// vipercore.NewLevelRulesCore, as Config.Build does.
+func (lvl AtomicLevel) SetRules(rules []vipercore.LevelRule) error {
+	return lvl.rules.Set(rules)
+}
+
 // String returns the string representation of the underlying Level.
 func (lvl AtomicLevel) String() string {
 	return lvl.Level().String()
 }
 
 // UnmarshalText unmarshals the text to an AtomicLevel. It uses the same text
-// representations as the static vipercore.Levels ("debug", "info", "warn",
-// "error", "dpanic", "panic", and "fatal").
+// representations as the static vipercore.Levels ("trace", "debug", "info",
+// "notice", "warn", "error", "critical", "dpanic", "panic", and "fatal"), as
+// well as the names of any levels added with vipercore.RegisterLevel.
 func (lvl *AtomicLevel) UnmarshalText(text []byte) error {
 	if lvl.l == nil {
 		lvl.l = &atomic.Int32{}
 	}
+	if lvl.rules == nil {
+		lvl.rules = &vipercore.LevelRules{}
+	}
 
 	var l vipercore.Level
 	if err := l.UnmarshalText(text); err != nil {
@@ -107,8 +137,9 @@ func (lvl *AtomicLevel) UnmarshalText(text []byte) error {
 }
 
 // MarshalText marshals the AtomicLevel to a byte slice. It uses the same
-// text representation as the static vipercore.Levels ("debug", "info", "warn",
-// "error", "dpanic", "panic", and "fatal").
+// text representation as the static vipercore.Levels ("trace", "debug",
+// "info", "notice", "warn", "error", "critical", "dpanic", "panic", and
+// "fatal").
 func (lvl AtomicLevel) MarshalText() (text []byte, err error) {
 	return lvl.Level().MarshalText()
 }
@@ -17,10 +17,13 @@ func TestLevelEnablerFunc(t *testing.T) {
 		level   vipercore.Level
 		enabled bool
 	}{
+		{TraceLevel, false},
 		{DebugLevel, false},
 		{InfoLevel, true},
+		{NoticeLevel, false},
 		{WarnLevel, false},
 		{ErrorLevel, false},
+		{CriticalLevel, false},
 		{DPanicLevel, false},
 		{PanicLevel, false},
 		{FatalLevel, false},
@@ -63,11 +66,14 @@ func TestAtomicLevelText(t *testing.T) {
 		expect vipercore.Level
 		err    bool
 	}{
+		{"trace", TraceLevel, false},
 		{"debug", DebugLevel, false},
 		{"info", InfoLevel, false},
 		{"", InfoLevel, false},
+		{"notice", NoticeLevel, false},
 		{"warn", WarnLevel, false},
 		{"error", ErrorLevel, false},
+		{"critical", CriticalLevel, false},
 		{"dpanic", DPanicLevel, false},
 		{"panic", PanicLevel, false},
 		{"fatal", FatalLevel, false},
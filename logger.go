@@ -8,7 +8,6 @@ import (
 	"os"
 	"runtime"
 	"strings"
-	"time"
 
 	"github.com/gottingen/viper/vipercore"
 )
@@ -31,6 +30,8 @@ type Logger struct {
 	addStack  vipercore.LevelEnabler
 
 	callerSkip int
+
+	clock vipercore.Clock
 }
 
 // New constructs a new Logger from the provided vipercore.Core and Options. If
@@ -51,6 +52,7 @@ func New(core vipercore.Core, options ...Option) *Logger {
 		core:        core,
 		errorOutput: vipercore.Lock(os.Stderr),
 		addStack:    vipercore.FatalLevel + 1,
+		clock:       vipercore.DefaultClock,
 	}
 	return log.WithOptions(options...)
 }
@@ -65,6 +67,7 @@ func NewNop() *Logger {
 		core:        vipercore.NewNopCore(),
 		errorOutput: vipercore.AddSync(ioutil.Discard),
 		addStack:    vipercore.FatalLevel + 1,
+		clock:       vipercore.DefaultClock,
 	}
 }
 
@@ -154,6 +157,14 @@ func (log *Logger) Check(lvl vipercore.Level, msg string) *vipercore.CheckedEntr
 	return log.check(lvl, msg)
 }
 
+// Trace logs a message at TraceLevel. The message includes any fields passed
+// at the log site, as well as any fields accumulated on the logger.
+func (log *Logger) Trace(msg string, fields ...Field) {
+	if ce := log.check(TraceLevel, msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
 // Debug logs a message at DebugLevel. The message includes any fields passed
 // at the log site, as well as any fields accumulated on the logger.
 func (log *Logger) Debug(msg string, fields ...Field) {
@@ -170,6 +181,14 @@ func (log *Logger) Info(msg string, fields ...Field) {
 	}
 }
 
+// Notice logs a message at NoticeLevel. The message includes any fields
+// passed at the log site, as well as any fields accumulated on the logger.
+func (log *Logger) Notice(msg string, fields ...Field) {
+	if ce := log.check(NoticeLevel, msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
 // Warn logs a message at WarnLevel. The message includes any fields passed
 // at the log site, as well as any fields accumulated on the logger.
 func (log *Logger) Warn(msg string, fields ...Field) {
@@ -186,6 +205,14 @@ func (log *Logger) Error(msg string, fields ...Field) {
 	}
 }
 
+// Critical logs a message at CriticalLevel. The message includes any fields
+// passed at the log site, as well as any fields accumulated on the logger.
+func (log *Logger) Critical(msg string, fields ...Field) {
+	if ce := log.check(CriticalLevel, msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
 // DPanic logs a message at DPanicLevel. The message includes any fields
 // passed at the log site, as well as any fields accumulated on the logger.
 //
@@ -244,7 +271,7 @@ func (log *Logger) check(lvl vipercore.Level, msg string) *vipercore.CheckedEntr
 	// log message will actually be written somewhere.
 	ent := vipercore.Entry{
 		LoggerName: log.name,
-		Time:       time.Now(),
+		Time:       log.clock.Now(),
 		Level:      lvl,
 		Message:    msg,
 	}
@@ -275,7 +302,7 @@ func (log *Logger) check(lvl vipercore.Level, msg string) *vipercore.CheckedEntr
 	if log.addCaller {
 		ce.Entry.Caller = vipercore.NewEntryCaller(runtime.Caller(log.callerSkip + callerSkipOffset))
 		if !ce.Entry.Caller.Defined {
-			fmt.Fprintf(log.errorOutput, "%v Logger.check error: failed to get caller\n", time.Now().UTC())
+			fmt.Fprintf(log.errorOutput, "%v Logger.check error: failed to get caller\n", log.clock.Now().UTC())
 			log.errorOutput.Sync()
 		}
 	}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gottingen/viper/internal/exit"
 	"github.com/gottingen/viper/internal/vtest"
@@ -151,15 +152,18 @@ func TestLoggerLogFatal(t *testing.T) {
 }
 
 func TestLoggerLeveledMethods(t *testing.T) {
-	withLogger(t, DebugLevel, nil, func(logger *Logger, logs *observer.ObservedLogs) {
+	withLogger(t, TraceLevel, nil, func(logger *Logger, logs *observer.ObservedLogs) {
 		tests := []struct {
 			method        func(string, ...Field)
 			expectedLevel vipercore.Level
 		}{
+			{logger.Trace, TraceLevel},
 			{logger.Debug, DebugLevel},
 			{logger.Info, InfoLevel},
+			{logger.Notice, NoticeLevel},
 			{logger.Warn, WarnLevel},
 			{logger.Error, ErrorLevel},
+			{logger.Critical, CriticalLevel},
 			{logger.DPanic, DPanicLevel},
 		}
 		for i, tt := range tests {
@@ -383,6 +387,33 @@ func TestLoggerHooks(t *testing.T) {
 	assert.Equal(t, int64(2), seen.Load(), "Hook saw an unexpected number of logs.")
 }
 
+type constantClock time.Time
+
+func (c constantClock) Now() time.Time { return time.Time(c) }
+func (c constantClock) NewTicker(d time.Duration) vipercore.Ticker {
+	return vipercore.DefaultClock.NewTicker(d)
+}
+
+func TestLoggerCustomClock(t *testing.T) {
+	date := time.Date(2077, 1, 23, 10, 15, 13, 441, time.UTC)
+	withLogger(t, DebugLevel, opts(WithClock(constantClock(date))), func(logger *Logger, logs *observer.ObservedLogs) {
+		logger.Info("")
+		logger.With(String("foo", "bar")).Warn("")
+		require.Equal(t, 2, logs.Len(), "Expected two log entries.")
+		for _, entry := range logs.All() {
+			assert.Equal(t, date, entry.Time, "Unexpected entry time.")
+		}
+	})
+}
+
+func TestLoggerNilClock(t *testing.T) {
+	withLogger(t, DebugLevel, opts(WithClock(nil)), func(logger *Logger, logs *observer.ObservedLogs) {
+		assert.NotPanics(t, func() { logger.Info("") }, "Expected a nil clock to fall back to the default.")
+		require.Equal(t, 1, logs.Len(), "Expected one log entry.")
+		assert.False(t, logs.All()[0].Time.IsZero(), "Expected the entry to be timestamped.")
+	})
+}
+
 func TestLoggerConcurrent(t *testing.T) {
 	withLogger(t, DebugLevel, nil, func(logger *Logger, logs *observer.ObservedLogs) {
 		child := logger.With(String("foo", "bar"))
@@ -0,0 +1,111 @@
+
+
+package viper
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gottingen/viper/vipercore"
+)
+
+// _expvarMutex serializes publishing, since expvar.Publish panics if a name is
+// taken between checking for it and publishing it.
+var _expvarMutex sync.Mutex
+
+// PublishExpvarMetrics publishes the snapshots of a MetricsRecorder as an
+// expvar variable with the given name, so that they're served as JSON by
+// expvar's /debug/vars handler. It returns an error if the name is already
+// published.
+func PublishExpvarMetrics(name string, m *vipercore.MetricsRecorder) error {
+	_expvarMutex.Lock()
+	defer _expvarMutex.Unlock()
+
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar variable %q already published", name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+	return nil
+}
+
+// MetricsHandler returns an HTTP handler that serves the snapshots of a
+// MetricsRecorder in the Prometheus text exposition format, so that they can
+// be scraped without depending on the Prometheus client library. It serves
+// these metrics:
+//
+//   viper_entries_written_total{level}   counter
+//   viper_written_bytes_total            counter
+//   viper_write_errors_total{level}      counter
+//   viper_sync_errors_total              counter
+//   viper_entries_dropped_total{level}   counter
+//   viper_sink_write_errors_total        counter
+//   viper_sink_dropped_total             counter
+//   viper_write_latency_seconds          histogram
+func MetricsHandler(m *vipercore.MetricsRecorder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		writePrometheusMetrics(&buf, m.Snapshot())
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+func writePrometheusMetrics(buf *bytes.Buffer, s vipercore.MetricsSnapshot) {
+	writeLevelCounter(buf, "viper_entries_written_total", "Log entries written, by level.", s.EntriesWritten)
+	writeCounter(buf, "viper_written_bytes_total", "Bytes of encoded log entries written.", s.BytesWritten)
+	writeLevelCounter(buf, "viper_write_errors_total", "Log entries that failed to be encoded or written, by level.", s.WriteErrors)
+	writeCounter(buf, "viper_sync_errors_total", "Failures to sync written log entries.", s.SyncErrors)
+	writeLevelCounter(buf, "viper_entries_dropped_total", "Log entries dropped by sampling or full queues, by level.", s.EntriesDropped)
+	writeCounter(buf, "viper_sink_write_errors_total", "Encoded log entries that sinks failed to deliver.", s.SinkWriteErrors)
+	writeCounter(buf, "viper_sink_dropped_total", "Encoded log entries dropped by sinks with full queues.", s.SinkDropped)
+
+	const latency = "viper_write_latency_seconds"
+	writeHeader(buf, latency, "Time taken to encode and write log entries.", "histogram")
+	for _, b := range s.WriteLatency.Buckets {
+		fmt.Fprintf(buf, "%s_bucket{le=\"%s\"} %d\n", latency, formatFloat(b.UpperBound), b.Count)
+	}
+	fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", latency, s.WriteLatency.Count)
+	fmt.Fprintf(buf, "%s_sum %s\n", latency, formatFloat(s.WriteLatency.Sum.Seconds()))
+	fmt.Fprintf(buf, "%s_count %d\n", latency, s.WriteLatency.Count)
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, typ string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeCounter(buf *bytes.Buffer, name, help string, n uint64) {
+	writeHeader(buf, name, help, "counter")
+	fmt.Fprintf(buf, "%s %d\n", name, n)
+}
+
+// writeLevelCounter writes a counter with a sample for each level, in order
+// of severity.
+func writeLevelCounter(buf *bytes.Buffer, name, help string, counts map[vipercore.Level]uint64) {
+	writeHeader(buf, name, help, "counter")
+	lvls := make([]vipercore.Level, 0, len(counts))
+	for lvl := range counts {
+		lvls = append(lvls, lvl)
+	}
+	sort.Slice(lvls, func(i, j int) bool { return !lvls[j].Enabled(lvls[i]) })
+	for _, lvl := range lvls {
+		fmt.Fprintf(buf, "%s{level=\"%s\"} %d\n", name, escapeLabelValue(lvl.String()), counts[lvl])
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var _labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return _labelValueEscaper.Replace(s)
+}
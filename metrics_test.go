@@ -0,0 +1,137 @@
+package viper
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gottingen/atomic"
+	"github.com/gottingen/viper/internal/vtest"
+	"github.com/gottingen/viper/vipercore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	m := vipercore.NewMetricsRecorder()
+	m.EntryWritten(InfoLevel, 100, 3*time.Millisecond)
+	m.EntryWritten(ErrorLevel, 50, time.Millisecond)
+	m.WriteError(ErrorLevel)
+	m.SyncError()
+	m.EntryDropped(DebugLevel)
+	m.SinkWriteError()
+
+	srv := httptest.NewServer(MetricsHandler(m))
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	require.NoError(t, err, "Unexpected error scraping metrics.")
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err, "Unexpected error reading metrics.")
+
+	assert.Equal(t, http.StatusOK, res.StatusCode, "Unexpected status.")
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", res.Header.Get("Content-Type"), "Unexpected content type.")
+	lines := strings.Split(string(body), "\n")
+	for _, want := range []string{
+		"# HELP viper_entries_written_total Log entries written, by level.",
+		"# TYPE viper_entries_written_total counter",
+		`viper_entries_written_total{level="info"} 1`,
+		`viper_entries_written_total{level="error"} 1`,
+		`viper_entries_written_total{level="fatal"} 0`,
+		"viper_written_bytes_total 150",
+		`viper_write_errors_total{level="error"} 1`,
+		"viper_sync_errors_total 1",
+		`viper_entries_dropped_total{level="debug"} 1`,
+		"viper_sink_write_errors_total 1",
+		"viper_sink_dropped_total 0",
+		"# TYPE viper_write_latency_seconds histogram",
+		`viper_write_latency_seconds_bucket{le="0.001"} 1`,
+		`viper_write_latency_seconds_bucket{le="0.005"} 2`,
+		`viper_write_latency_seconds_bucket{le="+Inf"} 2`,
+		"viper_write_latency_seconds_sum 0.004",
+		"viper_write_latency_seconds_count 2",
+	} {
+		assert.Contains(t, lines, want, "Expected a line in the metrics.")
+	}
+
+	var levels []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "viper_entries_written_total{") {
+			levels = append(levels, line[len(`viper_entries_written_total{level="`):strings.Index(line, `"}`)])
+		}
+	}
+	assert.Equal(t, []string{"trace", "debug", "info", "notice", "warn", "error", "critical", "dpanic", "panic", "fatal"}, levels, "Expected samples in order of severity.")
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert.Equal(t, `a\\b\"c\nd`, escapeLabelValue("a\\b\"c\nd"), "Unexpected escaping.")
+}
+
+func TestPublishExpvarMetrics(t *testing.T) {
+	m := vipercore.NewMetricsRecorder()
+	m.SyncError()
+	// expvar names can't be unpublished, so each run needs its own.
+	name := fmt.Sprintf("viper_test_metrics_%d", time.Now().UnixNano())
+
+	var (
+		wg        sync.WaitGroup
+		published atomic.Int32
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if PublishExpvarMetrics(name, m) == nil {
+				published.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), published.Load(), "Expected a name to be published exactly once.")
+	assert.Error(t, PublishExpvarMetrics(name, m), "Expected an error publishing a name twice.")
+
+	var snapshot struct {
+		SyncErrors     uint64            `json:"syncErrors"`
+		EntriesWritten map[string]uint64 `json:"entriesWritten"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &snapshot), "Expected JSON.")
+	assert.Equal(t, uint64(1), snapshot.SyncErrors, "Unexpected sync errors.")
+	assert.Contains(t, snapshot.EntriesWritten, "info", "Expected levels to be keyed by name.")
+}
+
+func TestConfigMetrics(t *testing.T) {
+	m := vipercore.NewMetricsRecorder()
+	sink := &vtest.Discarder{}
+	require.NoError(t, RegisterSink("metricstest", func(*url.URL) (Sink, error) { return nopCloserSink{sink}, nil }))
+	defer resetSinkRegistry()
+
+	cfg := NewProductionConfig()
+	cfg.OutputPaths = []string{"metricstest://"}
+	cfg.Sampling = &SamplingConfig{Initial: 1, Thereafter: 100}
+	cfg.Metrics = m
+	logger, err := cfg.Build()
+	require.NoError(t, err, "Unexpected error building logger.")
+
+	for i := 0; i < 3; i++ {
+		logger.Info("sampled")
+	}
+	logger.Debug("disabled")
+	sink.SetError(errors.New("failed"))
+	logger.Sync()
+
+	s := m.Snapshot()
+	assert.Equal(t, uint64(1), s.EntriesWritten[InfoLevel], "Expected the first entry to be written.")
+	assert.Equal(t, uint64(2), s.EntriesDropped[InfoLevel], "Expected the rest to be dropped by sampling.")
+	assert.Equal(t, uint64(0), s.EntriesDropped[DebugLevel], "Expected disabled entries not to count as dropped.")
+	assert.Equal(t, uint64(1), s.SyncErrors, "Expected the sync error to be counted.")
+}
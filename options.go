@@ -89,3 +89,16 @@ func AddStacktrace(lvl vipercore.LevelEnabler) Option {
 		log.addStack = lvl
 	})
 }
+
+// WithClock specifies the clock used by the logger to timestamp entries.
+// Cores that measure time using entries' timestamps, like the sampler, follow
+// the same clock. Defaults to vipercore.DefaultClock, which uses the system
+// clock; passing nil restores the default.
+func WithClock(clock vipercore.Clock) Option {
+	return optionFunc(func(log *Logger) {
+		if clock == nil {
+			clock = vipercore.DefaultClock
+		}
+		log.clock = clock
+	})
+}
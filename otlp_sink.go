@@ -0,0 +1,212 @@
+
+
+package viper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gottingen/viper/vipercore"
+)
+
+const (
+	schemeOTLPHTTP  = "otlp+http"
+	schemeOTLPHTTPS = "otlp+https"
+
+	_otlpDefaultPath          = "/v1/logs"
+	_otlpDefaultBatchSize     = 512
+	_otlpDefaultFlushInterval = 5 * time.Second
+	_otlpDefaultTimeout       = 10 * time.Second
+	_otlpDefaultQueueSize     = 8
+	_otlpDefaultRetries       = 3
+	_otlpDefaultRetryBackoff  = 500 * time.Millisecond
+)
+
+var errOTLPSinkClosed = errors.New("OTLP sink is closed")
+
+// OTLPSinkConfig configures a sink that exports logs to an OpenTelemetry
+// Collector or another OTLP/HTTP endpoint.
+type OTLPSinkConfig struct {
+	// Endpoint is the URL that batches are posted to, usually ending in
+	// /v1/logs.
+	Endpoint string
+	// BatchSize is the number of records that triggers an export. The default
+	// is 512.
+	BatchSize int
+	// FlushInterval is how often buffered records are exported, even if the
+	// batch isn't full. The default is five seconds; a negative interval only
+	// exports full batches and on Sync and Close.
+	FlushInterval time.Duration
+	// Timeout bounds each export request. The default is ten seconds.
+	Timeout time.Duration
+	// Headers are added to each export request, for example to authenticate.
+	Headers http.Header
+	// QueueSize is the number of full batches that can wait to be exported.
+	// Batches that fill up while the queue is full are dropped. The default
+	// is eight.
+	QueueSize int
+	// MaxRetries is the number of times an export is retried after a network
+	// error or a 429 or 5xx response. The default is three; a negative number
+	// disables retries.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, which doubles with
+	// each further retry. A Retry-After header in the response takes
+	// precedence, up to a minute. The default is 500ms.
+	RetryBackoff time.Duration
+	// Client sends the export requests. The default is a new http.Client.
+	Client *http.Client
+	// ErrorOutput receives a line for each batch that couldn't be exported
+	// and for each run of dropped batches. The default is standard error.
+	ErrorOutput vipercore.WriteSyncer
+	// Metrics, if set, records each record dropped because the queue is full
+	// with SinkDropped and each record that couldn't be exported with
+	// SinkWriteError.
+	Metrics vipercore.Metrics
+}
+
+type otlpSink struct {
+	*batchPoster
+
+	cfg OTLPSinkConfig
+}
+
+// NewOTLPSink creates a sink for the "otlp" encoder's output, which collects
+// the records written to it and posts them in batches to an OTLP/HTTP
+// endpoint as JSON. Like the sink created by NewHTTPSink, it exports from a
+// single goroutine, so writes don't wait for the endpoint: records are
+// exported once the batch is full, every FlushInterval, and when the sink is
+// synced or closed. Exports that fail with a network error or a 429 or 5xx
+// response are retried with exponential backoff, and batches that still fail
+// are reported to the ErrorOutput and dropped. Sync returns an error if
+// anything written before it couldn't be exported.
+//
+// The same sink can be opened with a URL in Config's OutputPaths by
+// replacing the endpoint's scheme with "otlp+http" or "otlp+https", as in
+// otlp+http://localhost:4318?batch=100&interval=1s. The path defaults to
+// /v1/logs, and the batch, interval, timeout, queue, and retries query
+// parameters set the BatchSize, FlushInterval, Timeout, QueueSize, and
+// MaxRetries.
+func NewOTLPSink(cfg OTLPSinkConfig) (Sink, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("OTLP sink requires an endpoint")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = _otlpDefaultBatchSize
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = _otlpDefaultFlushInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = _otlpDefaultTimeout
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = _otlpDefaultQueueSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = _otlpDefaultRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = _otlpDefaultRetryBackoff
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{}
+	}
+	if cfg.ErrorOutput == nil {
+		cfg.ErrorOutput = vipercore.Lock(os.Stderr)
+	}
+	return &otlpSink{
+		batchPoster: newBatchPoster(batchPosterConfig{
+			Name:          "OTLP sink",
+			Noun:          "OTLP log records",
+			ErrClosed:     errOTLPSinkClosed,
+			Endpoint:      cfg.Endpoint,
+			ContentType:   "application/json",
+			Headers:       cfg.Headers,
+			Client:        cfg.Client,
+			Timeout:       cfg.Timeout,
+			BatchSize:     cfg.BatchSize,
+			FlushInterval: cfg.FlushInterval,
+			QueueSize:     cfg.QueueSize,
+			MaxRetries:    cfg.MaxRetries,
+			RetryBackoff:  cfg.RetryBackoff,
+			ErrorOutput:   cfg.ErrorOutput,
+			Metrics:       cfg.Metrics,
+			Split:         splitOTLPLine,
+			Encode:        encodeOTLPRecords,
+		}),
+		cfg: cfg,
+	}, nil
+}
+
+func newOTLPSinkFromURL(u *url.URL) (Sink, error) {
+	if u.Fragment != "" {
+		return nil, fmt.Errorf("fragments not allowed with OTLP URLs: got %v", u)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("OTLP URLs must have a host: got %v", u)
+	}
+	var cfg OTLPSinkConfig
+	for key, vals := range u.Query() {
+		val := vals[len(vals)-1]
+		var err error
+		switch key {
+		case "batch":
+			cfg.BatchSize, err = strconv.Atoi(val)
+		case "interval":
+			cfg.FlushInterval, err = time.ParseDuration(val)
+		case "timeout":
+			cfg.Timeout, err = time.ParseDuration(val)
+		case "queue":
+			cfg.QueueSize, err = strconv.Atoi(val)
+		case "retries":
+			cfg.MaxRetries, err = strconv.Atoi(val)
+		default:
+			return nil, fmt.Errorf("unknown query parameter %q in OTLP URL: got %v", key, u)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in OTLP URL %v: %v", key, u, err)
+		}
+	}
+
+	endpoint := *u
+	endpoint.Scheme = u.Scheme[len("otlp+"):]
+	endpoint.RawQuery = ""
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = _otlpDefaultPath
+	}
+	cfg.Endpoint = endpoint.String()
+	return NewOTLPSink(cfg)
+}
+
+// splitOTLPLine returns the resourceLogs of a line written by the "otlp"
+// encoder.
+func splitOTLPLine(line []byte) ([][]byte, error) {
+	var req struct {
+		ResourceLogs []json.RawMessage `json:"resourceLogs"`
+	}
+	if err := json.Unmarshal(line, &req); err != nil {
+		return nil, fmt.Errorf("can't parse OTLP log record: %v", err)
+	}
+	records := make([][]byte, len(req.ResourceLogs))
+	for i, r := range req.ResourceLogs {
+		records[i] = r
+	}
+	return records, nil
+}
+
+// encodeOTLPRecords wraps resourceLogs in an export request.
+func encodeOTLPRecords(records [][]byte) ([]byte, error) {
+	logs := make([]json.RawMessage, len(records))
+	for i, r := range records {
+		logs[i] = r
+	}
+	return json.Marshal(struct {
+		ResourceLogs []json.RawMessage `json:"resourceLogs"`
+	}{logs})
+}
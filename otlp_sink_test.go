@@ -0,0 +1,253 @@
+package viper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/viper/internal/vtest"
+	"github.com/gottingen/viper/vipercore"
+)
+
+type otlpRequest struct {
+	Header       http.Header
+	Path         string
+	ResourceLogs []json.RawMessage `json:"resourceLogs"`
+}
+
+// withOTLPServer runs f with a server that records the OTLP export requests
+// it receives and responds with the statuses returned by respond, which is
+// passed the number of the request.
+func withOTLPServer(t testing.TB, respond func(int) int, f func(url string, requests func() []otlpRequest)) {
+	var (
+		mu       sync.Mutex
+		requests []otlpRequest
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err, "Unexpected error reading request body.")
+		req := otlpRequest{Header: r.Header, Path: r.URL.Path}
+		assert.NoError(t, json.Unmarshal(body, &req), "Unexpected error unmarshaling request body.")
+		mu.Lock()
+		requests = append(requests, req)
+		status := respond(len(requests))
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	f(srv.URL, func() []otlpRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]otlpRequest(nil), requests...)
+	})
+}
+
+// waitForOTLPRequests waits for the server to have received n requests,
+// since the sink exports in the background.
+func waitForOTLPRequests(requests func() []otlpRequest, n int) []otlpRequest {
+	deadline := time.Now().Add(time.Second)
+	for len(requests()) < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return requests()
+}
+
+func newOTLPTestLogger(sink Sink) *Logger {
+	enc := vipercore.NewOTLPEncoder(NewProductionEncoderConfig())
+	return New(vipercore.NewCore(enc, sink, DebugLevel))
+}
+
+func TestOTLPSinkBatches(t *testing.T) {
+	withOTLPServer(t, alwaysStatus(http.StatusOK), func(url string, requests func() []otlpRequest) {
+		sink, err := NewOTLPSink(OTLPSinkConfig{
+			Endpoint:      url + "/v1/logs",
+			BatchSize:     2,
+			FlushInterval: -1,
+			Headers:       http.Header{"Authorization": {"Bearer token"}},
+		})
+		require.NoError(t, err, "Unexpected error creating OTLP sink.")
+		logger := newOTLPTestLogger(sink)
+
+		logger.Info("one")
+		assert.Empty(t, requests(), "Expected records to be buffered.")
+		logger.Info("two")
+		logger.Info("three")
+		require.Len(t, waitForOTLPRequests(requests, 1), 1, "Expected a full batch to be exported.")
+		assert.NoError(t, logger.Sync(), "Unexpected error syncing.")
+		assert.NoError(t, logger.Sync(), "Unexpected error syncing with nothing buffered.")
+		assert.NoError(t, sink.Close(), "Unexpected error closing sink.")
+
+		reqs := requests()
+		require.Len(t, reqs, 2, "Expected the rest to be exported on Sync.")
+		assert.Len(t, reqs[0].ResourceLogs, 2, "Unexpected size of first batch.")
+		assert.Len(t, reqs[1].ResourceLogs, 1, "Unexpected size of second batch.")
+		assert.Contains(t, string(reqs[1].ResourceLogs[0]), `"body":{"stringValue":"three"}`, "Unexpected record in second batch.")
+		assert.Equal(t, "/v1/logs", reqs[0].Path, "Unexpected request path.")
+		assert.Equal(t, "application/json", reqs[0].Header.Get("Content-Type"), "Unexpected content type.")
+		assert.Equal(t, "Bearer token", reqs[0].Header.Get("Authorization"), "Expected configured headers.")
+
+		_, err = sink.Write([]byte("{}\n"))
+		assert.Equal(t, errOTLPSinkClosed, err, "Expected an error writing to a closed sink.")
+	})
+}
+
+func TestOTLPSinkFlushesPeriodically(t *testing.T) {
+	withOTLPServer(t, alwaysStatus(http.StatusOK), func(url string, requests func() []otlpRequest) {
+		sink, err := NewOTLPSink(OTLPSinkConfig{Endpoint: url, FlushInterval: time.Millisecond})
+		require.NoError(t, err, "Unexpected error creating OTLP sink.")
+		defer sink.Close()
+
+		newOTLPTestLogger(sink).Info("tick")
+		deadline := time.Now().Add(5 * time.Second)
+		for len(requests()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		assert.Len(t, requests(), 1, "Expected a background export.")
+	})
+}
+
+func TestOTLPSinkErrors(t *testing.T) {
+	withOTLPServer(t, alwaysStatus(http.StatusServiceUnavailable), func(url string, requests func() []otlpRequest) {
+		errOut := &vtest.Buffer{}
+		m := vipercore.NewMetricsRecorder()
+		sink, err := NewOTLPSink(OTLPSinkConfig{Endpoint: url, FlushInterval: -1, MaxRetries: 1, RetryBackoff: time.Millisecond, ErrorOutput: errOut, Metrics: m})
+		require.NoError(t, err, "Unexpected error creating OTLP sink.")
+		defer sink.Close()
+
+		_, err = sink.Write([]byte("not json\n"))
+		assert.Error(t, err, "Expected an error writing a malformed record.")
+
+		newOTLPTestLogger(sink).Info("lost")
+		err = sink.Sync()
+		require.Error(t, err, "Expected an error from a failed export.")
+		assert.Contains(t, err.Error(), "503 Service Unavailable", "Unexpected export error.")
+		assert.Len(t, requests(), 2, "Expected the export to be retried once.")
+		assert.Contains(t, errOut.String(), "OTLP sink error: can't post 1 OTLP log records", "Expected the failed export to be reported.")
+		assert.Equal(t, uint64(1), m.Snapshot().SinkWriteErrors, "Expected the failed record to be counted.")
+	})
+
+	_, err := NewOTLPSink(OTLPSinkConfig{})
+	assert.Error(t, err, "Expected an error creating a sink without an endpoint.")
+}
+
+func TestOTLPSinkRetries(t *testing.T) {
+	// Fail the first two exports, then succeed.
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	respond := func(n int) int {
+		if n <= len(statuses) {
+			return statuses[n-1]
+		}
+		return http.StatusOK
+	}
+	withOTLPServer(t, respond, func(url string, requests func() []otlpRequest) {
+		sink, err := NewOTLPSink(OTLPSinkConfig{Endpoint: url, FlushInterval: -1, RetryBackoff: time.Millisecond})
+		require.NoError(t, err, "Unexpected error creating OTLP sink.")
+		defer sink.Close()
+
+		newOTLPTestLogger(sink).Info("retried")
+		assert.NoError(t, sink.Sync(), "Expected the export to succeed after retries.")
+		reqs := requests()
+		require.Len(t, reqs, 3, "Expected two retries.")
+		assert.Equal(t, reqs[0].ResourceLogs, reqs[2].ResourceLogs, "Expected the same batch to be retried.")
+	})
+}
+
+func TestOTLPSinkPartialWrites(t *testing.T) {
+	withOTLPServer(t, alwaysStatus(http.StatusOK), func(url string, requests func() []otlpRequest) {
+		sink, err := NewOTLPSink(OTLPSinkConfig{Endpoint: url, FlushInterval: -1})
+		require.NoError(t, err, "Unexpected error creating OTLP sink.")
+		defer sink.Close()
+
+		for _, part := range []string{`{"resourceLogs":`, `[{}]}`, "\n"} {
+			n, err := sink.Write([]byte(part))
+			assert.NoError(t, err, "Unexpected error writing part of a record.")
+			assert.Equal(t, len(part), n, "Unexpected number of bytes written.")
+		}
+		require.NoError(t, sink.Sync(), "Unexpected error syncing.")
+		reqs := requests()
+		require.Len(t, reqs, 1, "Expected one export.")
+		assert.Equal(t, []json.RawMessage{json.RawMessage(`{}`)}, reqs[0].ResourceLogs, "Unexpected exported records.")
+	})
+}
+
+func TestOTLPSinkURLs(t *testing.T) {
+	tests := []struct {
+		url      string
+		endpoint string
+		cfg      OTLPSinkConfig
+	}{
+		{
+			url:      "otlp+http://localhost:4318",
+			endpoint: "http://localhost:4318/v1/logs",
+		},
+		{
+			url:      "otlp+https://collector/custom/path?batch=10&interval=1s&timeout=3s&queue=2&retries=-1",
+			endpoint: "https://collector/custom/path",
+			cfg:      OTLPSinkConfig{BatchSize: 10, FlushInterval: time.Second, Timeout: 3 * time.Second, QueueSize: 2, MaxRetries: -1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			sink, err := newSink(tt.url)
+			require.NoError(t, err, "Unexpected error opening OTLP URL.")
+			defer sink.Close()
+
+			cfg := sink.(*otlpSink).cfg
+			assert.Equal(t, tt.endpoint, cfg.Endpoint, "Unexpected endpoint.")
+			if tt.cfg.BatchSize != 0 {
+				assert.Equal(t, tt.cfg.BatchSize, cfg.BatchSize, "Unexpected batch size.")
+				assert.Equal(t, tt.cfg.FlushInterval, cfg.FlushInterval, "Unexpected flush interval.")
+				assert.Equal(t, tt.cfg.Timeout, cfg.Timeout, "Unexpected timeout.")
+				assert.Equal(t, tt.cfg.QueueSize, cfg.QueueSize, "Unexpected queue size.")
+				assert.Equal(t, tt.cfg.MaxRetries, cfg.MaxRetries, "Unexpected retries.")
+			}
+		})
+	}
+
+	for _, bad := range []string{
+		"otlp+http://localhost#frag",
+		"otlp+http:///v1/logs",
+		"otlp+http://localhost?batch=many",
+		"otlp+http://localhost?interval=often",
+		"otlp+http://localhost?retries=x",
+		"otlp+http://localhost?compression=gzip",
+	} {
+		_, err := newSink(bad)
+		assert.Error(t, err, "Expected an error opening %q.", bad)
+	}
+}
+
+func TestOTLPConfigResource(t *testing.T) {
+	withOTLPServer(t, alwaysStatus(http.StatusOK), func(srvURL string, requests func() []otlpRequest) {
+		u, err := url.Parse(srvURL)
+		require.NoError(t, err, "Unexpected error parsing server URL.")
+		u.Scheme = "otlp+" + u.Scheme
+		u.RawQuery = "interval=-1s"
+
+		cfg := NewProductionConfig()
+		cfg.Encoding = "otlp"
+		cfg.OutputPaths = []string{u.String()}
+		cfg.InitialFields = map[string]interface{}{"service.name": "api"}
+		logger, err := cfg.Build()
+		require.NoError(t, err, "Unexpected error building logger.")
+
+		logger.Info("hello", String("k", "v"))
+		require.NoError(t, logger.Sync(), "Unexpected error syncing.")
+
+		reqs := requests()
+		require.Len(t, reqs, 1, "Expected one export.")
+		require.Len(t, reqs[0].ResourceLogs, 1, "Expected one record.")
+		rec := string(reqs[0].ResourceLogs[0])
+		assert.Contains(t, rec, `"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]}`, "Expected InitialFields as the resource.")
+		assert.Contains(t, rec, `"attributes":[{"key":"k","value":{"stringValue":"v"}}`, "Expected InitialFields to be omitted from attributes.")
+	})
+}
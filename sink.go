@@ -30,7 +30,13 @@ func resetSinkRegistry() {
 	defer _sinkMutex.Unlock()
 
 	_sinkFactories = map[string]func(*url.URL) (Sink, error){
-		schemeFile: newFileSink,
+		schemeFile:      newFileSink,
+		schemeGELF:      newGELFSinkFromURL,
+		schemeHTTP:      newHTTPSinkFromURL,
+		schemeHTTPS:     newHTTPSinkFromURL,
+		schemeJournald:  newJournaldSinkFromURL,
+		schemeOTLPHTTP:  newOTLPSinkFromURL,
+		schemeOTLPHTTPS: newOTLPSinkFromURL,
 	}
 }
 
@@ -57,8 +63,10 @@ func (e *errSinkNotFound) Error() string {
 //
 // All schemes must be ASCII, valid under section 3.1 of RFC 3986
 // (https://tools.ietf.org/html/rfc3986#section-3.1), and must not already
-// have a factory registered. Viper automatically registers a factory for the
-// "file" scheme.
+// have a factory registered. Viper automatically registers factories for the
+// "file" scheme, the "gelf" scheme of NewGELFSink, the "journald" scheme of
+// NewJournaldSink, and, for NewHTTPSink and NewOTLPSink, the "http", "https",
+// "otlp+http", and "otlp+https" schemes.
 func RegisterSink(scheme string, factory func(*url.URL) (Sink, error)) error {
 	_sinkMutex.Lock()
 	defer _sinkMutex.Unlock()
@@ -102,8 +110,10 @@ func newFileSink(u *url.URL) (Sink, error) {
 	if u.Fragment != "" {
 		return nil, fmt.Errorf("fragments not allowed with file URLs: got %v", u)
 	}
-	if u.RawQuery != "" {
-		return nil, fmt.Errorf("query parameters not allowed with file URLs: got %v", u)
+	query := u.Query()
+	compress := query.Get("compress")
+	if query.Del("compress"); len(query) > 0 {
+		return nil, fmt.Errorf("query parameters not allowed with file URLs, except compress: got %v", u)
 	}
 	// Error messages are better if we check hostname and port separately.
 	if u.Port() != "" {
@@ -113,11 +123,22 @@ func newFileSink(u *url.URL) (Sink, error) {
 		return nil, fmt.Errorf("file URLs must leave host empty or use localhost: got %v", u)
 	}
 	switch u.Path {
-	case "stdout":
-		return nopCloserSink{os.Stdout}, nil
-	case "stderr":
+	case "stdout", "stderr":
+		if compress != "" && compress != _compressionNone {
+			return nil, fmt.Errorf("compression not allowed with %s: got %v", u.Path, u)
+		}
+		if u.Path == "stdout" {
+			return nopCloserSink{os.Stdout}, nil
+		}
 		return nopCloserSink{os.Stderr}, nil
 	}
+	c, err := compressionFor(u.Path, compress)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		return newCompressedFileSink(u.Path, c)
+	}
 	return os.OpenFile(u.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 }
 
@@ -74,6 +74,11 @@ func (s *SugaredLogger) With(args ...interface{}) *SugaredLogger {
 	return &SugaredLogger{base: s.base.With(s.sweetenFields(args)...)}
 }
 
+// Trace uses fmt.Sprint to construct and log a message.
+func (s *SugaredLogger) Trace(args ...interface{}) {
+	s.log(TraceLevel, "", args, nil)
+}
+
 // Debug uses fmt.Sprint to construct and log a message.
 func (s *SugaredLogger) Debug(args ...interface{}) {
 	s.log(DebugLevel, "", args, nil)
@@ -84,6 +89,11 @@ func (s *SugaredLogger) Info(args ...interface{}) {
 	s.log(InfoLevel, "", args, nil)
 }
 
+// Notice uses fmt.Sprint to construct and log a message.
+func (s *SugaredLogger) Notice(args ...interface{}) {
+	s.log(NoticeLevel, "", args, nil)
+}
+
 // Warn uses fmt.Sprint to construct and log a message.
 func (s *SugaredLogger) Warn(args ...interface{}) {
 	s.log(WarnLevel, "", args, nil)
@@ -94,6 +104,11 @@ func (s *SugaredLogger) Error(args ...interface{}) {
 	s.log(ErrorLevel, "", args, nil)
 }
 
+// Critical uses fmt.Sprint to construct and log a message.
+func (s *SugaredLogger) Critical(args ...interface{}) {
+	s.log(CriticalLevel, "", args, nil)
+}
+
 // DPanic uses fmt.Sprint to construct and log a message. In development, the
 // logger then panics. (See DPanicLevel for details.)
 func (s *SugaredLogger) DPanic(args ...interface{}) {
@@ -110,6 +125,11 @@ func (s *SugaredLogger) Fatal(args ...interface{}) {
 	s.log(FatalLevel, "", args, nil)
 }
 
+// Tracef uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) Tracef(template string, args ...interface{}) {
+	s.log(TraceLevel, template, args, nil)
+}
+
 // Debugf uses fmt.Sprintf to log a templated message.
 func (s *SugaredLogger) Debugf(template string, args ...interface{}) {
 	s.log(DebugLevel, template, args, nil)
@@ -120,6 +140,11 @@ func (s *SugaredLogger) Infof(template string, args ...interface{}) {
 	s.log(InfoLevel, template, args, nil)
 }
 
+// Noticef uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) Noticef(template string, args ...interface{}) {
+	s.log(NoticeLevel, template, args, nil)
+}
+
 // Warnf uses fmt.Sprintf to log a templated message.
 func (s *SugaredLogger) Warnf(template string, args ...interface{}) {
 	s.log(WarnLevel, template, args, nil)
@@ -130,6 +155,11 @@ func (s *SugaredLogger) Errorf(template string, args ...interface{}) {
 	s.log(ErrorLevel, template, args, nil)
 }
 
+// Criticalf uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) Criticalf(template string, args ...interface{}) {
+	s.log(CriticalLevel, template, args, nil)
+}
+
 // DPanicf uses fmt.Sprintf to log a templated message. In development, the
 // logger then panics. (See DPanicLevel for details.)
 func (s *SugaredLogger) DPanicf(template string, args ...interface{}) {
@@ -146,6 +176,15 @@ func (s *SugaredLogger) Fatalf(template string, args ...interface{}) {
 	s.log(FatalLevel, template, args, nil)
 }
 
+// Tracew logs a message with some additional context. The variadic key-value
+// pairs are treated as they are in With.
+//
+// When trace-level logging is disabled, this is much faster than
+//  s.With(keysAndValues).Trace(msg)
+func (s *SugaredLogger) Tracew(msg string, keysAndValues ...interface{}) {
+	s.log(TraceLevel, msg, nil, keysAndValues)
+}
+
 // Debugw logs a message with some additional context. The variadic key-value
 // pairs are treated as they are in With.
 //
@@ -161,6 +200,12 @@ func (s *SugaredLogger) Infow(msg string, keysAndValues ...interface{}) {
 	s.log(InfoLevel, msg, nil, keysAndValues)
 }
 
+// Noticew logs a message with some additional context. The variadic key-value
+// pairs are treated as they are in With.
+func (s *SugaredLogger) Noticew(msg string, keysAndValues ...interface{}) {
+	s.log(NoticeLevel, msg, nil, keysAndValues)
+}
+
 // Warnw logs a message with some additional context. The variadic key-value
 // pairs are treated as they are in With.
 func (s *SugaredLogger) Warnw(msg string, keysAndValues ...interface{}) {
@@ -173,6 +218,12 @@ func (s *SugaredLogger) Errorw(msg string, keysAndValues ...interface{}) {
 	s.log(ErrorLevel, msg, nil, keysAndValues)
 }
 
+// Criticalw logs a message with some additional context. The variadic
+// key-value pairs are treated as they are in With.
+func (s *SugaredLogger) Criticalw(msg string, keysAndValues ...interface{}) {
+	s.log(CriticalLevel, msg, nil, keysAndValues)
+}
+
 // DPanicw logs a message with some additional context. In development, the
 // logger then panics. (See DPanicLevel for details.) The variadic key-value
 // pairs are treated as they are in With.
@@ -200,7 +251,7 @@ func (s *SugaredLogger) Sync() error {
 func (s *SugaredLogger) log(lvl vipercore.Level, template string, fmtArgs []interface{}, context []interface{}) {
 	// If logging at this level is completely disabled, skip the overhead of
 	// string formatting.
-	if lvl < DPanicLevel && !s.base.Core().Enabled(lvl) {
+	if !DPanicLevel.Enabled(lvl) && !s.base.Core().Enabled(lvl) {
 		return
 	}
 
@@ -160,15 +160,18 @@ func TestSugarStructuredLogging(t *testing.T) {
 	expectedFields := []Field{String("foo", "bar"), Bool("baz", false)}
 
 	for _, tt := range tests {
-		withSugar(t, DebugLevel, nil, func(logger *SugaredLogger, logs *observer.ObservedLogs) {
+		withSugar(t, TraceLevel, nil, func(logger *SugaredLogger, logs *observer.ObservedLogs) {
+			logger.With(context...).Tracew(tt.msg, extra...)
 			logger.With(context...).Debugw(tt.msg, extra...)
 			logger.With(context...).Infow(tt.msg, extra...)
+			logger.With(context...).Noticew(tt.msg, extra...)
 			logger.With(context...).Warnw(tt.msg, extra...)
 			logger.With(context...).Errorw(tt.msg, extra...)
+			logger.With(context...).Criticalw(tt.msg, extra...)
 			logger.With(context...).DPanicw(tt.msg, extra...)
 
-			expected := make([]observer.LoggedEntry, 5)
-			for i, lvl := range []vipercore.Level{DebugLevel, InfoLevel, WarnLevel, ErrorLevel, DPanicLevel} {
+			expected := make([]observer.LoggedEntry, 8)
+			for i, lvl := range []vipercore.Level{TraceLevel, DebugLevel, InfoLevel, NoticeLevel, WarnLevel, ErrorLevel, CriticalLevel, DPanicLevel} {
 				expected[i] = observer.LoggedEntry{
 					Entry:   vipercore.Entry{Message: tt.expectMsg, Level: lvl},
 					Context: expectedFields,
@@ -192,15 +195,18 @@ func TestSugarConcatenatingLogging(t *testing.T) {
 	expectedFields := []Field{String("foo", "bar")}
 
 	for _, tt := range tests {
-		withSugar(t, DebugLevel, nil, func(logger *SugaredLogger, logs *observer.ObservedLogs) {
+		withSugar(t, TraceLevel, nil, func(logger *SugaredLogger, logs *observer.ObservedLogs) {
+			logger.With(context...).Trace(tt.args...)
 			logger.With(context...).Debug(tt.args...)
 			logger.With(context...).Info(tt.args...)
+			logger.With(context...).Notice(tt.args...)
 			logger.With(context...).Warn(tt.args...)
 			logger.With(context...).Error(tt.args...)
+			logger.With(context...).Critical(tt.args...)
 			logger.With(context...).DPanic(tt.args...)
 
-			expected := make([]observer.LoggedEntry, 5)
-			for i, lvl := range []vipercore.Level{DebugLevel, InfoLevel, WarnLevel, ErrorLevel, DPanicLevel} {
+			expected := make([]observer.LoggedEntry, 8)
+			for i, lvl := range []vipercore.Level{TraceLevel, DebugLevel, InfoLevel, NoticeLevel, WarnLevel, ErrorLevel, CriticalLevel, DPanicLevel} {
 				expected[i] = observer.LoggedEntry{
 					Entry:   vipercore.Entry{Message: tt.expect, Level: lvl},
 					Context: expectedFields,
@@ -228,15 +234,18 @@ func TestSugarTemplatedLogging(t *testing.T) {
 	expectedFields := []Field{String("foo", "bar")}
 
 	for _, tt := range tests {
-		withSugar(t, DebugLevel, nil, func(logger *SugaredLogger, logs *observer.ObservedLogs) {
+		withSugar(t, TraceLevel, nil, func(logger *SugaredLogger, logs *observer.ObservedLogs) {
+			logger.With(context...).Tracef(tt.format, tt.args...)
 			logger.With(context...).Debugf(tt.format, tt.args...)
 			logger.With(context...).Infof(tt.format, tt.args...)
+			logger.With(context...).Noticef(tt.format, tt.args...)
 			logger.With(context...).Warnf(tt.format, tt.args...)
 			logger.With(context...).Errorf(tt.format, tt.args...)
+			logger.With(context...).Criticalf(tt.format, tt.args...)
 			logger.With(context...).DPanicf(tt.format, tt.args...)
 
-			expected := make([]observer.LoggedEntry, 5)
-			for i, lvl := range []vipercore.Level{DebugLevel, InfoLevel, WarnLevel, ErrorLevel, DPanicLevel} {
+			expected := make([]observer.LoggedEntry, 8)
+			for i, lvl := range []vipercore.Level{TraceLevel, DebugLevel, InfoLevel, NoticeLevel, WarnLevel, ErrorLevel, CriticalLevel, DPanicLevel} {
 				expected[i] = observer.LoggedEntry{
 					Entry:   vipercore.Entry{Message: tt.expect, Level: lvl},
 					Context: expectedFields,
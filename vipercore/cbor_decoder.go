@@ -0,0 +1,282 @@
+
+
+package vipercore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"time"
+)
+
+// _cborMaxDepth bounds the nesting of decoded CBOR items, so that malformed
+// input can't exhaust the stack.
+const _cborMaxDepth = 1000
+
+type cborDecoder struct {
+	*EncoderConfig
+}
+
+// NewCBORDecoder creates a Decoder for single entries written by the CBOR
+// encoder with the given EncoderConfig. As with NewJSONDecoder, the keys
+// configured in cfg identify the parts of the entry and all other keys become
+// fields.
+//
+// Since CBOR keeps more type information than JSON, fields are decoded more
+// faithfully: integers become Int64 or Uint64 fields, floats become Float32
+// or Float64 fields, byte strings become Binary fields, and tagged times
+// become Time fields. Bignums are decoded as *big.Int Reflect fields. To
+// decode a stream of entries, use NewCBORStreamDecoder.
+func NewCBORDecoder(cfg EncoderConfig) Decoder {
+	return &cborDecoder{&cfg}
+}
+
+func (dec *cborDecoder) Decode(data []byte) (Entry, []Field, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	ent, fields, err := decodeCBOREntry(dec.EncoderConfig, r)
+	if err != nil {
+		return Entry{}, nil, fmt.Errorf("can't decode CBOR log entry: %v", unexpectedEOF(err))
+	}
+	if _, err := r.Peek(1); err != io.EOF {
+		return Entry{}, nil, errors.New("can't decode CBOR log entry: unexpected data after top-level map")
+	}
+	return ent, fields, nil
+}
+
+// A CBORStreamDecoder reads a stream of entries written back to back by the
+// CBOR encoder.
+type CBORStreamDecoder struct {
+	cfg *EncoderConfig
+	r   *bufio.Reader
+}
+
+// NewCBORStreamDecoder creates a decoder that reads CBOR-encoded entries from
+// r, decoding them as NewCBORDecoder does.
+func NewCBORStreamDecoder(r io.Reader, cfg EncoderConfig) *CBORStreamDecoder {
+	return &CBORStreamDecoder{cfg: &cfg, r: bufio.NewReader(r)}
+}
+
+// Decode reads the next entry from the stream. At the end of the stream, it
+// returns io.EOF; if the stream ends in the middle of an entry, it returns
+// an error wrapping io.ErrUnexpectedEOF.
+func (dec *CBORStreamDecoder) Decode() (Entry, []Field, error) {
+	if _, err := dec.r.Peek(1); err == io.EOF {
+		return Entry{}, nil, io.EOF
+	}
+	ent, fields, err := decodeCBOREntry(dec.cfg, dec.r)
+	if err != nil {
+		return Entry{}, nil, fmt.Errorf("can't decode CBOR log entry: %w", unexpectedEOF(err))
+	}
+	return ent, fields, nil
+}
+
+func decodeCBOREntry(cfg *EncoderConfig, r *bufio.Reader) (Entry, []Field, error) {
+	val, err := readCBORItem(r, 0)
+	if err != nil {
+		return Entry{}, nil, err
+	}
+	obj, ok := val.(decodedObject)
+	if !ok {
+		return Entry{}, nil, errors.New("not a map")
+	}
+	ent, fields := decodeEntry(cfg, obj)
+	return ent, fields, nil
+}
+
+// readCBORItem reads the next data item from r. Maps become decodedObjects
+// and arrays become decodedArrays, so that they can be re-encoded in their
+// original order.
+func readCBORItem(r *bufio.Reader, depth int) (interface{}, error) {
+	if depth > _cborMaxDepth {
+		return nil, errors.New("items nested too deeply")
+	}
+	major, info, n, err := readCBORHead(r)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case _cborUint:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case _cborNegint:
+		if n > math.MaxInt64 {
+			// -1 - n doesn't fit an int64.
+			i := new(big.Int).SetUint64(n)
+			return i.Neg(i).Sub(i, big.NewInt(1)), nil
+		}
+		return ^int64(n), nil
+	case _cborBytes:
+		return readCBORBytes(r, n)
+	case _cborText:
+		b, err := readCBORBytes(r, n)
+		return string(b), err
+	case _cborArray:
+		arr := decodedArray{}
+		for i := uint64(0); i < n; i++ {
+			elem, err := readCBORItem(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, elem)
+		}
+		return arr, nil
+	case _cborMap:
+		obj := decodedObject{}
+		for i := uint64(0); i < n; i++ {
+			key, err := readCBORItem(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported map key %v", key)
+			}
+			val, err := readCBORItem(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, decodedKV{key: s, value: val})
+		}
+		return obj, nil
+	case _cborTag:
+		content, err := readCBORItem(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeCBORTag(n, content)
+	default:
+		return decodeCBORSimple(info, n)
+	}
+}
+
+// readCBORHead reads the initial byte and argument of a data item. For
+// floats, n holds their bits.
+func readCBORHead(r *bufio.Reader) (major, info byte, n uint64, err error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b&0xe0, b&0x1f
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		size = 1 << (info - 24)
+	case info == 31:
+		return 0, 0, 0, errors.New("indefinite-length items aren't supported")
+	default:
+		return 0, 0, 0, fmt.Errorf("malformed initial byte 0x%02x", b)
+	}
+	var arg [8]byte
+	if _, err := io.ReadFull(r, arg[8-size:]); err != nil {
+		return 0, 0, 0, unexpectedEOF(err)
+	}
+	return major, info, binary.BigEndian.Uint64(arg[:]), nil
+}
+
+func readCBORBytes(r *bufio.Reader, n uint64) ([]byte, error) {
+	if n > math.MaxInt64 {
+		return nil, fmt.Errorf("string of %d bytes is too long", n)
+	}
+	// Don't trust the length enough to allocate it up front.
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+func decodeCBORTag(tag uint64, content interface{}) (interface{}, error) {
+	switch tag {
+	case _cborTagStringTime:
+		s, ok := content.(string)
+		if !ok {
+			return nil, fmt.Errorf("tag 0 must enclose a string, not %v", content)
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case _cborTagEpochTime:
+		switch v := content.(type) {
+		case int64:
+			return time.Unix(v, 0), nil
+		case float64:
+			return unixFloat(v, time.Microsecond), nil
+		case float32:
+			return unixFloat(float64(v), time.Microsecond), nil
+		}
+		return nil, fmt.Errorf("tag 1 must enclose a number, not %v", content)
+	case _cborTagPosBignum, _cborTagNegBignum:
+		b, ok := content.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("tag %d must enclose a byte string, not %v", tag, content)
+		}
+		i := new(big.Int).SetBytes(b)
+		if tag == _cborTagNegBignum {
+			i.Neg(i).Sub(i, big.NewInt(1))
+		}
+		return i, nil
+	default:
+		// Ignore unknown tags.
+		return content, nil
+	}
+}
+
+func decodeCBORSimple(info byte, n uint64) (interface{}, error) {
+	switch _cborSimple | info {
+	case _cborFalse:
+		return false, nil
+	case _cborTrue:
+		return true, nil
+	case _cborNull, _cborSimple | 23: // null and undefined
+		return nil, nil
+	case _cborSimple | 25:
+		return float16ToFloat32(uint16(n)), nil
+	case _cborFloat32:
+		return math.Float32frombits(uint32(n)), nil
+	case _cborFloat64:
+		return math.Float64frombits(n), nil
+	}
+	return nil, fmt.Errorf("unsupported simple value %d", n)
+}
+
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch {
+	case exp == 0x1f:
+		// Infinity or NaN.
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	case exp == 0:
+		// Zero or subnormal.
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	default:
+		return math.Float32frombits(sign | (exp+127-15)<<23 | frac<<13)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
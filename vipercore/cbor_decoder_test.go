@@ -0,0 +1,85 @@
+package vipercore_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func TestCBORDecoderValues(t *testing.T) {
+	big64, _ := new(big.Int).SetString("-18446744073709551616", 10)
+	tests := []struct {
+		desc string
+		in   []byte
+		want interface{}
+	}{
+		{"uint beyond int64", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(math.MaxUint64)},
+		{"negint beyond int64", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, big64},
+		{"float16", []byte{0xf9, 0x3e, 0x00}, float32(1.5)},
+		{"negative float16", []byte{0xf9, 0xc4, 0x00}, float32(-4)},
+		{"subnormal float16", []byte{0xf9, 0x00, 0x01}, float32(5.960464477539063e-8)},
+		{"float16 infinity", []byte{0xf9, 0x7c, 0x00}, float32(math.Inf(1))},
+		{"undefined", []byte{0xf7}, nil},
+		{"string time", append([]byte{0xc0, 0x74}, "2013-03-21T20:04:00Z"...), time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"unknown tag", []byte{0xd8, 0x20, 0x61, 'u'}, "u"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			in := append([]byte{0xa1, 0x61, 'k'}, tt.in...)
+			_, fields := decodeCBORFields(t, in)
+			assert.Equal(t, tt.want, fields["k"], "Unexpected decoded value.")
+		})
+	}
+}
+
+func TestCBORDecoderErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		in   []byte
+		err  string
+	}{
+		{"empty", nil, "unexpected EOF"},
+		{"not a map", []byte{0x01}, "not a map"},
+		{"truncated head", []byte{0xa1, 0x61, 'k', 0x19, 0x01}, "unexpected EOF"},
+		{"truncated string", []byte{0xa1, 0x61, 'k', 0x65, 'a'}, "unexpected EOF"},
+		{"truncated map", []byte{0xa2, 0x61, 'k', 0x01}, "unexpected EOF"},
+		{"trailing data", []byte{0xa0, 0x00}, "unexpected data after top-level map"},
+		{"integer key", []byte{0xa1, 0x01, 0x01}, "unsupported map key"},
+		{"indefinite length", []byte{0xbf, 0xff}, "indefinite-length items aren't supported"},
+		{"reserved", []byte{0xa1, 0x61, 'k', 0x1c}, "malformed initial byte 0x1c"},
+		{"bad time", []byte{0xa1, 0x61, 'k', 0xc1, 0x61, 'x'}, "tag 1 must enclose a number"},
+		{"bad bignum", []byte{0xa1, 0x61, 'k', 0xc2, 0x01}, "tag 2 must enclose a byte string"},
+		{"simple value", []byte{0xa1, 0x61, 'k', 0xe0}, "unsupported simple value 0"},
+		{"nested too deeply", []byte(strings.Repeat("\x81", 2000)), "items nested too deeply"},
+	}
+
+	dec := NewCBORDecoder(testEncoderConfig())
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, _, err := dec.Decode(tt.in)
+			require.Error(t, err, "Expected an error.")
+			assert.Contains(t, err.Error(), tt.err, "Unexpected error.")
+		})
+	}
+}
+
+func TestCBORStreamDecoderTruncated(t *testing.T) {
+	dec := NewCBORStreamDecoder(bytes.NewReader([]byte{0xa0, 0xa1, 0x61}), testEncoderConfig())
+	_, fields, err := dec.Decode()
+	require.NoError(t, err, "Unexpected error decoding the first entry.")
+	assert.Empty(t, fields, "Unexpected fields.")
+
+	_, _, err = dec.Decode()
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "Expected io.ErrUnexpectedEOF, got %v.", err)
+}
@@ -0,0 +1,601 @@
+
+
+package vipercore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gottingen/buffer"
+)
+
+// CBOR major types and tags, from RFC 8949.
+const (
+	_cborUint   = 0 << 5
+	_cborNegint = 1 << 5
+	_cborBytes  = 2 << 5
+	_cborText   = 3 << 5
+	_cborArray  = 4 << 5
+	_cborMap    = 5 << 5
+	_cborTag    = 6 << 5
+	_cborSimple = 7 << 5
+
+	_cborFalse   = _cborSimple | 20
+	_cborTrue    = _cborSimple | 21
+	_cborNull    = _cborSimple | 22
+	_cborFloat32 = _cborSimple | 26
+	_cborFloat64 = _cborSimple | 27
+
+	_cborTagStringTime = 0
+	_cborTagEpochTime  = 1
+	_cborTagPosBignum  = 2
+	_cborTagNegBignum  = 3
+)
+
+var _cborPool = sync.Pool{New: func() interface{} {
+	return &cborEncoder{}
+}}
+
+func getCBOREncoder(cfg *EncoderConfig, sortKeys bool) *cborEncoder {
+	enc := _cborPool.Get().(*cborEncoder)
+	enc.EncoderConfig = cfg
+	enc.sortKeys = sortKeys
+	enc.buf = buffer.Get()
+	return enc
+}
+
+func putCBOREncoder(enc *cborEncoder) {
+	enc.EncoderConfig = nil
+	enc.sortKeys = false
+	enc.buf = nil
+	enc.count = 0
+	enc.keys = enc.keys[:0]
+	enc.namespaces = enc.namespaces[:0]
+	_cborPool.Put(enc)
+}
+
+// cborNamespace is a map enclosing an open namespace.
+type cborNamespace struct {
+	key   string
+	buf   *buffer.Buffer
+	count int
+	keys  []int
+}
+
+type cborEncoder struct {
+	*EncoderConfig
+	sortKeys bool
+
+	// buf holds the encoded elements of the innermost open map or array, and
+	// count is the number of key-value pairs or elements in it. For maps, keys
+	// holds the offsets of the pairs in buf, so that they can be sorted.
+	buf   *buffer.Buffer
+	count int
+	keys  []int
+	// namespaces are the maps enclosing the open namespaces, outermost first.
+	namespaces []cborNamespace
+}
+
+// A CBOROption configures a CBOR encoder.
+type CBOROption interface {
+	applyCBOROption(*cborEncoder)
+}
+
+type cborOptionFunc func(*cborEncoder)
+
+func (f cborOptionFunc) applyCBOROption(enc *cborEncoder) {
+	f(enc)
+}
+
+// CBORSortKeys sorts the keys of every map in the bytewise lexicographic
+// order of their encodings, as RFC 8949's core deterministic encoding
+// requires, so that identical entries always encode to identical bytes.
+// Sorting costs some CPU, and duplicate keys are kept.
+func CBORSortKeys() CBOROption {
+	return cborOptionFunc(func(enc *cborEncoder) {
+		enc.sortKeys = true
+	})
+}
+
+// NewCBOREncoder creates an encoder that writes each entry as a CBOR (RFC
+// 8949) map.
+//
+// Values keep their types: integers use the shortest encoding that holds
+// them, AddBinary writes raw byte strings, and times are written without
+// losing precision, regardless of the configured TimeEncoder: whole seconds
+// are written as tag 1 epoch times with integer content, and other times as
+// tag 0 RFC 3339 strings in UTC with nanoseconds. Levels, names, callers,
+// and durations are encoded with the EncoderConfig's encoders.
+// Reflected *big.Int values and integers too large for 64 bits are written as
+// tag 2 or 3 bignums; other reflected values are serialized with
+// encoding/json and converted to the equivalent CBOR. Complex numbers are
+// written as strings, as they are by the JSON encoder.
+//
+// Since CBOR values are self-delimiting, the LineEnding is ignored and entries
+// are written back to back.
+func NewCBOREncoder(cfg EncoderConfig, opts ...CBOROption) Encoder {
+	enc := &cborEncoder{
+		EncoderConfig: &cfg,
+		buf:           buffer.Get(),
+	}
+	for _, opt := range opts {
+		opt.applyCBOROption(enc)
+	}
+	return enc
+}
+
+func (enc *cborEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	enc.addKey(key)
+	return enc.AppendArray(arr)
+}
+
+func (enc *cborEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	enc.addKey(key)
+	return enc.AppendObject(obj)
+}
+
+func (enc *cborEncoder) AddBinary(key string, val []byte) {
+	enc.addKey(key)
+	writeCBORHead(enc.buf, _cborBytes, uint64(len(val)))
+	enc.buf.Write(val)
+	enc.count++
+}
+
+func (enc *cborEncoder) AddByteString(key string, val []byte) {
+	enc.addKey(key)
+	enc.WriteByteString(val)
+}
+
+func (enc *cborEncoder) AddBool(key string, val bool) {
+	enc.addKey(key)
+	enc.WriteBool(val)
+}
+
+func (enc *cborEncoder) AddComplex128(key string, val complex128) {
+	enc.addKey(key)
+	enc.AppendComplex128(val)
+}
+
+func (enc *cborEncoder) AddDuration(key string, val time.Duration) {
+	enc.addKey(key)
+	enc.AppendDuration(val)
+}
+
+func (enc *cborEncoder) AddFloat64(key string, val float64) {
+	enc.addKey(key)
+	enc.WriteFloat64(val)
+}
+
+func (enc *cborEncoder) AddFloat32(key string, val float32) {
+	enc.addKey(key)
+	enc.WriteFloat32(val)
+}
+
+func (enc *cborEncoder) AddInt64(key string, val int64) {
+	enc.addKey(key)
+	enc.WriteInt64(val)
+}
+
+func (enc *cborEncoder) AddReflected(key string, obj interface{}) error {
+	decoded, err := decodeReflectedCBOR(obj)
+	if err != nil {
+		return err
+	}
+	enc.addKey(key)
+	enc.writeDecoded(decoded)
+	enc.count++
+	return nil
+}
+
+func (enc *cborEncoder) OpenNamespace(key string) {
+	enc.namespaces = append(enc.namespaces, cborNamespace{key: key, buf: enc.buf, count: enc.count, keys: enc.keys})
+	enc.buf = buffer.Get()
+	enc.count = 0
+	enc.keys = nil
+}
+
+func (enc *cborEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	enc.WriteString(val)
+}
+
+func (enc *cborEncoder) AddTime(key string, val time.Time) {
+	enc.addKey(key)
+	enc.AppendTime(val)
+}
+
+func (enc *cborEncoder) AddUint64(key string, val uint64) {
+	enc.addKey(key)
+	enc.WriteUint64(val)
+}
+
+func (enc *cborEncoder) AppendArray(arr ArrayMarshaler) error {
+	child := getCBOREncoder(enc.EncoderConfig, enc.sortKeys)
+	err := arr.MarshalLogArray(child)
+	child.closeOpenNamespaces()
+	writeCBORHead(enc.buf, _cborArray, uint64(child.count))
+	enc.buf.Write(child.buf.Bytes())
+	enc.count++
+	buffer.Put(child.buf)
+	putCBOREncoder(child)
+	return err
+}
+
+func (enc *cborEncoder) AppendObject(obj ObjectMarshaler) error {
+	child := getCBOREncoder(enc.EncoderConfig, enc.sortKeys)
+	err := obj.MarshalLogObject(child)
+	child.closeOpenNamespaces()
+	writeCBORMap(enc.buf, enc.sortKeys, child)
+	enc.count++
+	buffer.Put(child.buf)
+	putCBOREncoder(child)
+	return err
+}
+
+func (enc *cborEncoder) WriteBool(val bool) {
+	if val {
+		enc.buf.WriteByte(_cborTrue)
+	} else {
+		enc.buf.WriteByte(_cborFalse)
+	}
+	enc.count++
+}
+
+func (enc *cborEncoder) WriteByteString(val []byte) {
+	writeCBORHead(enc.buf, _cborText, uint64(len(val)))
+	enc.buf.Write(val)
+	enc.count++
+}
+
+func (enc *cborEncoder) AppendComplex128(val complex128) {
+	// Cast to a platform-independent, fixed-size type.
+	r, i := float64(real(val)), float64(imag(val))
+	enc.WriteString(strconv.FormatFloat(r, 'f', -1, 64) + "+" + strconv.FormatFloat(i, 'f', -1, 64) + "i")
+}
+
+func (enc *cborEncoder) AppendDuration(val time.Duration) {
+	cur := enc.count
+	if enc.EncodeDuration != nil {
+		enc.EncodeDuration(val, enc)
+	}
+	if cur == enc.count {
+		// User-supplied EncodeDuration is a no-op. Fall back to nanoseconds.
+		enc.WriteInt64(int64(val))
+	}
+}
+
+func (enc *cborEncoder) WriteFloat64(val float64) {
+	writeCBORFloat64(enc.buf, val)
+	enc.count++
+}
+
+func (enc *cborEncoder) WriteFloat32(val float32) {
+	var b [5]byte
+	b[0] = _cborFloat32
+	binary.BigEndian.PutUint32(b[1:], math.Float32bits(val))
+	enc.buf.Write(b[:])
+	enc.count++
+}
+
+func (enc *cborEncoder) WriteInt64(val int64) {
+	writeCBORInt(enc.buf, val)
+	enc.count++
+}
+
+func (enc *cborEncoder) AppendReflected(val interface{}) error {
+	decoded, err := decodeReflectedCBOR(val)
+	if err != nil {
+		return err
+	}
+	enc.writeDecoded(decoded)
+	enc.count++
+	return nil
+}
+
+func (enc *cborEncoder) WriteString(val string) {
+	writeCBORString(enc.buf, val)
+	enc.count++
+}
+
+// AppendTime writes a time as a tag 1 epoch time if it's a whole second.
+// Since floating-point seconds can't hold nanoseconds for current times, other
+// times are written as tag 0 date/time strings.
+func (enc *cborEncoder) AppendTime(val time.Time) {
+	if val.Nanosecond() == 0 {
+		writeCBORHead(enc.buf, _cborTag, _cborTagEpochTime)
+		enc.WriteInt64(val.Unix())
+		return
+	}
+	writeCBORHead(enc.buf, _cborTag, _cborTagStringTime)
+	enc.WriteString(val.UTC().Format(time.RFC3339Nano))
+}
+
+func (enc *cborEncoder) WriteUint64(val uint64) {
+	writeCBORHead(enc.buf, _cborUint, val)
+	enc.count++
+}
+
+func (enc *cborEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *cborEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *cborEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *cborEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *cborEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *cborEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+func (enc *cborEncoder) AppendComplex64(v complex64)        { enc.AppendComplex128(complex128(v)) }
+func (enc *cborEncoder) WriteInt(v int)                     { enc.WriteInt64(int64(v)) }
+func (enc *cborEncoder) WriteInt32(v int32)                 { enc.WriteInt64(int64(v)) }
+func (enc *cborEncoder) WriteInt16(v int16)                 { enc.WriteInt64(int64(v)) }
+func (enc *cborEncoder) WriteInt8(v int8)                   { enc.WriteInt64(int64(v)) }
+func (enc *cborEncoder) WriteUint(v uint)                   { enc.WriteUint64(uint64(v)) }
+func (enc *cborEncoder) WriteUint32(v uint32)               { enc.WriteUint64(uint64(v)) }
+func (enc *cborEncoder) WriteUint16(v uint16)               { enc.WriteUint64(uint64(v)) }
+func (enc *cborEncoder) WriteUint8(v uint8)                 { enc.WriteUint64(uint64(v)) }
+func (enc *cborEncoder) WriteUintptr(v uintptr)             { enc.WriteUint64(uint64(v)) }
+
+func (enc *cborEncoder) Clone() Encoder {
+	clone := getCBOREncoder(enc.EncoderConfig, enc.sortKeys)
+	clone.buf.Write(enc.buf.Bytes())
+	clone.count = enc.count
+	clone.keys = append(clone.keys, enc.keys...)
+	for _, ns := range enc.namespaces {
+		buf := buffer.Get()
+		buf.Write(ns.buf.Bytes())
+		clone.namespaces = append(clone.namespaces, cborNamespace{
+			key:   ns.key,
+			buf:   buf,
+			count: ns.count,
+			keys:  append([]int(nil), ns.keys...),
+		})
+	}
+	return clone
+}
+
+func (enc *cborEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	// The entry's metadata is written to its own map, since the context's
+	// namespaces may still be open.
+	head := getCBOREncoder(enc.EncoderConfig, enc.sortKeys)
+	if head.LevelKey != "" {
+		head.addKey(head.LevelKey)
+		cur := head.count
+		if head.EncodeLevel != nil {
+			head.EncodeLevel(ent.Level, head)
+		}
+		if cur == head.count {
+			// User-supplied EncodeLevel was a no-op. Fall back to strings to
+			// keep the map valid.
+			head.WriteString(ent.Level.String())
+		}
+	}
+	if head.TimeKey != "" {
+		head.AddTime(head.TimeKey, ent.Time)
+	}
+	if ent.LoggerName != "" && head.NameKey != "" {
+		head.addKey(head.NameKey)
+		cur := head.count
+		nameEncoder := head.EncodeName
+		if nameEncoder == nil {
+			nameEncoder = FullNameEncoder
+		}
+		nameEncoder(ent.LoggerName, head)
+		if cur == head.count {
+			head.WriteString(ent.LoggerName)
+		}
+	}
+	if ent.Caller.Defined && head.CallerKey != "" {
+		head.addKey(head.CallerKey)
+		cur := head.count
+		if head.EncodeCaller != nil {
+			head.EncodeCaller(ent.Caller, head)
+		}
+		if cur == head.count {
+			head.WriteString(ent.Caller.String())
+		}
+	}
+	if head.MessageKey != "" {
+		head.AddString(head.MessageKey, ent.Message)
+	}
+
+	final := enc.Clone().(*cborEncoder)
+	addFields(final, fields)
+	final.closeOpenNamespaces()
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+
+	out := buffer.Get()
+	writeCBORMap(out, enc.sortKeys, head, final)
+
+	buffer.Put(head.buf)
+	putCBOREncoder(head)
+	buffer.Put(final.buf)
+	putCBOREncoder(final)
+	return out, nil
+}
+
+func (enc *cborEncoder) addKey(key string) {
+	enc.keys = append(enc.keys, enc.buf.Len())
+	writeCBORString(enc.buf, key)
+}
+
+// closeOpenNamespaces writes each open namespace as a map into its enclosing
+// map.
+func (enc *cborEncoder) closeOpenNamespaces() {
+	for i := len(enc.namespaces) - 1; i >= 0; i-- {
+		ns := enc.namespaces[i]
+		inner := &cborEncoder{buf: enc.buf, count: enc.count, keys: enc.keys}
+		enc.buf, enc.count, enc.keys = ns.buf, ns.count, ns.keys
+		enc.addKey(ns.key)
+		writeCBORMap(enc.buf, enc.sortKeys, inner)
+		enc.count++
+		buffer.Put(inner.buf)
+	}
+	enc.namespaces = enc.namespaces[:0]
+}
+
+// writeDecoded writes a value decoded from JSON or a bignum.
+func (enc *cborEncoder) writeDecoded(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		enc.buf.WriteByte(_cborNull)
+	case bool:
+		if v {
+			enc.buf.WriteByte(_cborTrue)
+		} else {
+			enc.buf.WriteByte(_cborFalse)
+		}
+	case string:
+		writeCBORString(enc.buf, v)
+	case *big.Int:
+		writeCBORBignum(enc.buf, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeCBORInt(enc.buf, i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			writeCBORHead(enc.buf, _cborUint, u)
+		} else if n, ok := new(big.Int).SetString(string(v), 10); ok {
+			writeCBORBignum(enc.buf, n)
+		} else {
+			f, _ := v.Float64()
+			writeCBORFloat64(enc.buf, f)
+		}
+	case decodedObject:
+		child := getCBOREncoder(enc.EncoderConfig, enc.sortKeys)
+		for _, kv := range v {
+			child.addKey(kv.key)
+			child.writeDecoded(kv.value)
+			child.count++
+		}
+		writeCBORMap(enc.buf, enc.sortKeys, child)
+		buffer.Put(child.buf)
+		putCBOREncoder(child)
+	case decodedArray:
+		writeCBORHead(enc.buf, _cborArray, uint64(len(v)))
+		for _, elem := range v {
+			enc.writeDecoded(elem)
+		}
+	}
+}
+
+// decodeReflectedCBOR returns bignums as they are and serializes all other
+// values with encoding/json, decoding them again while preserving the order
+// of object keys.
+func decodeReflectedCBOR(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case *big.Int:
+		if v == nil {
+			return nil, nil
+		}
+		return v, nil
+	case big.Int:
+		return &v, nil
+	}
+	return decodeReflected(val)
+}
+
+func writeCBORHead(buf *buffer.Buffer, major byte, n uint64) {
+	var b [9]byte
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		b[0], b[1] = major|24, byte(n)
+		buf.Write(b[:2])
+	case n <= math.MaxUint16:
+		b[0] = major | 25
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		buf.Write(b[:3])
+	case n <= math.MaxUint32:
+		b[0] = major | 26
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		buf.Write(b[:5])
+	default:
+		b[0] = major | 27
+		binary.BigEndian.PutUint64(b[1:], n)
+		buf.Write(b[:9])
+	}
+}
+
+func writeCBORInt(buf *buffer.Buffer, val int64) {
+	if val < 0 {
+		// Negative integers encode -1 - val.
+		writeCBORHead(buf, _cborNegint, uint64(^val))
+	} else {
+		writeCBORHead(buf, _cborUint, uint64(val))
+	}
+}
+
+func writeCBORFloat64(buf *buffer.Buffer, val float64) {
+	var b [9]byte
+	b[0] = _cborFloat64
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(val))
+	buf.Write(b[:])
+}
+
+func writeCBORString(buf *buffer.Buffer, s string) {
+	writeCBORHead(buf, _cborText, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func writeCBORBignum(buf *buffer.Buffer, n *big.Int) {
+	if n.Sign() >= 0 {
+		writeCBORHead(buf, _cborTag, _cborTagPosBignum)
+		bs := n.Bytes()
+		writeCBORHead(buf, _cborBytes, uint64(len(bs)))
+		buf.Write(bs)
+		return
+	}
+	// Negative bignums encode -1 - n.
+	m := new(big.Int).Neg(n)
+	m.Sub(m, big.NewInt(1))
+	writeCBORHead(buf, _cborTag, _cborTagNegBignum)
+	bs := m.Bytes()
+	writeCBORHead(buf, _cborBytes, uint64(len(bs)))
+	buf.Write(bs)
+}
+
+// writeCBORMap writes a map holding the key-value pairs of all the parts,
+// sorting them by key if sortKeys is set.
+func writeCBORMap(buf *buffer.Buffer, sortKeys bool, parts ...*cborEncoder) {
+	count := 0
+	for _, p := range parts {
+		count += p.count
+	}
+	writeCBORHead(buf, _cborMap, uint64(count))
+	if !sortKeys {
+		for _, p := range parts {
+			buf.Write(p.buf.Bytes())
+		}
+		return
+	}
+
+	pairs := make([][]byte, 0, count)
+	for _, p := range parts {
+		bs := p.buf.Bytes()
+		for i, start := range p.keys {
+			end := len(bs)
+			if i+1 < len(p.keys) {
+				end = p.keys[i+1]
+			}
+			pairs = append(pairs, bs[start:end])
+		}
+	}
+	// Since encoded keys start with their length, comparing whole pairs
+	// orders them by their keys.
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return bytes.Compare(pairs[i], pairs[j]) < 0
+	})
+	for _, pair := range pairs {
+		buf.Write(pair)
+	}
+}
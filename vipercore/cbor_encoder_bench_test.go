@@ -0,0 +1,42 @@
+package vipercore_test
+
+import (
+	"testing"
+
+	"github.com/gottingen/buffer"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+// BenchmarkViperCBOR encodes the same entry as BenchmarkViperJSON, with and
+// without sorting keys.
+func BenchmarkViperCBOR(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		opts []CBOROption
+	}{
+		{"Unsorted", nil},
+		{"SortKeys", []CBOROption{CBORSortKeys()}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					enc := NewCBOREncoder(testEncoderConfig(), bb.opts...)
+					enc.AddString("str", "foo")
+					enc.AddInt64("int64-1", 1)
+					enc.AddInt64("int64-2", 2)
+					enc.AddFloat64("float64", 1.0)
+					enc.AddString("string1", "\n")
+					enc.AddString("string2", "💩")
+					enc.AddString("string3", "🤔")
+					enc.AddString("string4", "🙊")
+					enc.AddBool("bool", true)
+					buf, _ := enc.EncodeEntry(Entry{
+						Message: "fake",
+						Level:   DebugLevel,
+					}, nil)
+					buffer.Put(buf)
+				}
+			})
+		})
+	}
+}
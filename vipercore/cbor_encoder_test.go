@@ -0,0 +1,317 @@
+package vipercore_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func encodeCBOREntry(t testing.TB, enc Encoder, ent Entry, fields ...Field) []byte {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buffer.Put(buf)
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// decodeCBORFields decodes an entry written by the CBOR encoder and adds its
+// fields to a map.
+func decodeCBORFields(t testing.TB, b []byte) (Entry, map[string]interface{}) {
+	ent, fields, err := NewCBORDecoder(testEncoderConfig()).Decode(b)
+	require.NoError(t, err, "Unexpected error decoding CBOR entry.")
+	m := NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(m)
+	}
+	return ent, m.Fields
+}
+
+func TestCBOREncoderEntry(t *testing.T) {
+	type bar struct {
+		Key string  `json:"key"`
+		Val float64 `json:"val"`
+	}
+
+	enc := NewCBOREncoder(testEncoderConfig())
+	enc.AddString("request", "abc")
+	enc.OpenNamespace("http")
+	enc.AddInt("status", 500)
+
+	ts := time.Unix(1500000000, 123456789).UTC()
+	out := encodeCBOREntry(t, enc, Entry{
+		Level:      WarnLevel,
+		Time:       ts,
+		LoggerName: "bob",
+		Message:    "lob law",
+		Caller:     EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42},
+		Stack:      "fake-stack",
+	},
+		viper.Int("answer", 42),
+		viper.Uint64("big", math.MaxUint64),
+		viper.Float64("pi", 3.14),
+		viper.Float32("e", 2.5),
+		viper.Bool("ok", true),
+		viper.Binary("bin", []byte{0, 1, 2}),
+		viper.ByteString("bs", []byte("bytes")),
+		viper.Duration("elapsed", 1500*time.Millisecond),
+		viper.Time("at", time.Unix(10, 0)),
+		viper.Complex128("c", 1+2i),
+		viper.Strings("tags", []string{"a", "b"}),
+		viper.Reflect("bars", []bar{{Key: "k", Val: 1.5}}),
+		viper.Reflect("nothing", nil),
+		viper.Object("obj", ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+			enc.AddInt8("neg", -100)
+			enc.OpenNamespace("inner")
+			enc.AddString("deep", "yes")
+			return nil
+		})),
+	)
+
+	ent, fields := decodeCBORFields(t, out)
+	assert.Equal(t, Entry{
+		Level:      WarnLevel,
+		Time:       ts,
+		LoggerName: "bob",
+		Message:    "lob law",
+		Caller:     EntryCaller{Defined: true, File: "app/main.go", Line: 42},
+		Stack:      "fake-stack",
+	}, ent, "Unexpected decoded entry.")
+	assert.Equal(t, map[string]interface{}{
+		"request": "abc",
+		"http": map[string]interface{}{
+			"status":  int64(500),
+			"answer":  int64(42),
+			"big":     uint64(math.MaxUint64),
+			"pi":      3.14,
+			"e":       float32(2.5),
+			"ok":      true,
+			"bin":     []byte{0, 1, 2},
+			"bs":      "bytes",
+			"elapsed": 1.5,
+			"at":      time.Unix(10, 0),
+			"c":       "1+2i",
+			"tags":    []interface{}{"a", "b"},
+			"bars":    []interface{}{map[string]interface{}{"key": "k", "val": 1.5}},
+			"nothing": nil,
+			"obj": map[string]interface{}{
+				"neg":   int64(-100),
+				"inner": map[string]interface{}{"deep": "yes"},
+			},
+		},
+	}, fields, "Unexpected decoded fields.")
+
+	// The context must be unaffected by encoding an entry.
+	_, fields = decodeCBORFields(t, encodeCBOREntry(t, enc, Entry{Message: "again"}))
+	assert.Equal(t, map[string]interface{}{
+		"request": "abc",
+		"http":    map[string]interface{}{"status": int64(500)},
+	}, fields, "Unexpected context after encoding.")
+}
+
+func TestCBOREncoderPrimitives(t *testing.T) {
+	tests := []struct {
+		desc string
+		f    func(Encoder)
+		want []byte
+	}{
+		{"small uint", func(e Encoder) { e.AddInt("k", 23) }, []byte{0x17}},
+		{"uint8", func(e Encoder) { e.AddInt("k", 24) }, []byte{0x18, 24}},
+		{"uint16", func(e Encoder) { e.AddUint16("k", 300) }, []byte{0x19, 0x01, 0x2c}},
+		{"uint32", func(e Encoder) { e.AddUint32("k", 70000) }, []byte{0x1a, 0, 1, 0x11, 0x70}},
+		{"uint64", func(e Encoder) { e.AddUint64("k", math.MaxUint64) }, []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"small negint", func(e Encoder) { e.AddInt("k", -1) }, []byte{0x20}},
+		{"negint16", func(e Encoder) { e.AddInt16("k", -300) }, []byte{0x39, 0x01, 0x2b}},
+		{"negint64", func(e Encoder) { e.AddInt64("k", math.MinInt64) }, []byte{0x3b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"false", func(e Encoder) { e.AddBool("k", false) }, []byte{0xf4}},
+		{"true", func(e Encoder) { e.AddBool("k", true) }, []byte{0xf5}},
+		{"float32", func(e Encoder) { e.AddFloat32("k", 1.5) }, []byte{0xfa, 0x3f, 0xc0, 0, 0}},
+		{"float64", func(e Encoder) { e.AddFloat64("k", 1.5) }, []byte{0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"text", func(e Encoder) { e.AddString("k", "hi") }, []byte{0x62, 'h', 'i'}},
+		{"byte string", func(e Encoder) { e.AddByteString("k", []byte("hi")) }, []byte{0x62, 'h', 'i'}},
+		{"binary", func(e Encoder) { e.AddBinary("k", []byte{0, 0xff}) }, []byte{0x42, 0, 0xff}},
+		{"empty binary", func(e Encoder) { e.AddBinary("k", nil) }, []byte{0x40}},
+		{"whole time", func(e Encoder) { e.AddTime("k", time.Unix(1363896240, 0)) }, []byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}},
+		{"fractional time", func(e Encoder) { e.AddTime("k", time.Unix(1363896240, 500000000)) }, append([]byte{0xc0, 0x76}, "2013-03-21T20:04:00.5Z"...)},
+		{"negative time", func(e Encoder) { e.AddTime("k", time.Unix(-1, 0)) }, []byte{0xc1, 0x20}},
+		{"bignum", func(e Encoder) {
+			n, _ := new(big.Int).SetString("18446744073709551616", 10)
+			e.AddReflected("k", n)
+		}, []byte{0xc2, 0x49, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"negative bignum", func(e Encoder) {
+			n, _ := new(big.Int).SetString("-18446744073709551617", 10)
+			e.AddReflected("k", *n)
+		}, []byte{0xc3, 0x49, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"large reflected integer", func(e Encoder) { e.AddReflected("k", json18446744073709551616{}) }, []byte{0xc2, 0x49, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"reflected null", func(e Encoder) { e.AddReflected("k", nil) }, []byte{0xf6}},
+		{"empty array", func(e Encoder) { e.AddArray("k", viper.Ints("", nil).Interface.(ArrayMarshaler)) }, []byte{0x80}},
+		{"array", func(e Encoder) { e.AddArray("k", viper.Ints("", []int{1, -1}).Interface.(ArrayMarshaler)) }, []byte{0x82, 0x01, 0x20}},
+	}
+
+	cfg := testEncoderConfig()
+	cfg.LevelKey = ""
+	cfg.TimeKey = ""
+	cfg.MessageKey = ""
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := NewCBOREncoder(cfg)
+			tt.f(enc)
+			out := encodeCBOREntry(t, enc, Entry{})
+			want := append([]byte{0xa1, 0x61, 'k'}, tt.want...)
+			assert.Equal(t, want, out, "Unexpected encoding.")
+		})
+	}
+}
+
+// json18446744073709551616 marshals to an integer too large for 64 bits.
+type json18446744073709551616 struct{}
+
+func (json18446744073709551616) MarshalJSON() ([]byte, error) {
+	return []byte("18446744073709551616"), nil
+}
+
+func TestCBOREncoderSortKeys(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.LevelKey = ""
+
+	encode := func(opts ...CBOROption) []byte {
+		enc := NewCBOREncoder(cfg, opts...)
+		enc.AddString("zz", "context")
+		enc.OpenNamespace("ns")
+		enc.AddInt("b", 1)
+		enc.AddInt("a", 2)
+		return encodeCBOREntry(t, enc, Entry{Message: "m"},
+			viper.Object("obj", ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+				enc.AddInt("y", 1)
+				enc.AddInt("x", 2)
+				return nil
+			})),
+		)
+	}
+
+	// Unsorted, keys keep their insertion order.
+	assert.Equal(t, []byte{
+		0xa3,
+		0x63, 'm', 's', 'g', 0x61, 'm',
+		0x62, 'z', 'z', 0x67, 'c', 'o', 'n', 't', 'e', 'x', 't',
+		0x62, 'n', 's', 0xa3,
+		0x61, 'b', 0x01,
+		0x61, 'a', 0x02,
+		0x63, 'o', 'b', 'j', 0xa2, 0x61, 'y', 0x01, 0x61, 'x', 0x02,
+	}, encode(), "Unexpected unsorted encoding.")
+
+	// Sorted, shorter keys come first and keys of equal length are ordered
+	// bytewise, including the entry's metadata.
+	assert.Equal(t, []byte{
+		0xa3,
+		0x62, 'n', 's', 0xa3,
+		0x61, 'a', 0x02,
+		0x61, 'b', 0x01,
+		0x63, 'o', 'b', 'j', 0xa2, 0x61, 'x', 0x02, 0x61, 'y', 0x01,
+		0x62, 'z', 'z', 0x67, 'c', 'o', 'n', 't', 'e', 'x', 't',
+		0x63, 'm', 's', 'g', 0x61, 'm',
+	}, encode(CBORSortKeys()), "Unexpected sorted encoding.")
+}
+
+func TestCBOREncoderSortKeysDeterministic(t *testing.T) {
+	enc := NewCBOREncoder(testEncoderConfig(), CBORSortKeys())
+	ent := Entry{Level: InfoLevel, Time: time.Unix(100, 0), Message: "same"}
+	first := encodeCBOREntry(t, enc, ent,
+		viper.Reflect("m", map[string]int{"c": 3, "a": 1, "b": 2}),
+		viper.String("s", "v"),
+		viper.Int("i", 1),
+	)
+	second := encodeCBOREntry(t, enc.Clone(), ent,
+		viper.Int("i", 1),
+		viper.String("s", "v"),
+		viper.Reflect("m", map[string]int{"b": 2, "c": 3, "a": 1}),
+	)
+	assert.Equal(t, first, second, "Expected sorted encodings to be independent of field order.")
+}
+
+func TestCBOREncoderFallbacks(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.EncodeLevel = func(Level, PrimitiveArrayEncoder) {}
+	cfg.EncodeDuration = func(time.Duration, PrimitiveArrayEncoder) {}
+	cfg.EncodeCaller = func(EntryCaller, PrimitiveArrayEncoder) {}
+	cfg.EncodeName = func(string, PrimitiveArrayEncoder) {}
+	enc := NewCBOREncoder(cfg)
+
+	out := encodeCBOREntry(t, enc, Entry{
+		Level:      ErrorLevel,
+		LoggerName: "name",
+		Caller:     EntryCaller{Defined: true, File: "f.go", Line: 1},
+	}, viper.Duration("d", time.Second))
+	ent, fields := decodeCBORFields(t, out)
+	assert.Equal(t, ErrorLevel, ent.Level, "Unexpected level fallback.")
+	assert.Equal(t, "name", ent.LoggerName, "Unexpected name fallback.")
+	assert.Equal(t, EntryCaller{Defined: true, File: "f.go", Line: 1}, ent.Caller, "Unexpected caller fallback.")
+	assert.Equal(t, int64(time.Second), fields["d"], "Unexpected duration fallback.")
+}
+
+func TestCBOREncoderMarshalerErrors(t *testing.T) {
+	enc := NewCBOREncoder(testEncoderConfig())
+	fail := errors.New("fail")
+	assert.Equal(t, fail, enc.AddObject("obj", ObjectMarshalerFunc(func(ObjectEncoder) error { return fail })))
+	assert.Equal(t, fail, enc.AddArray("arr", ArrayMarshalerFunc(func(ArrayEncoder) error { return fail })))
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected an error reflecting a channel.")
+
+	_, fields := decodeCBORFields(t, encodeCBOREntry(t, enc, Entry{}))
+	assert.NotContains(t, fields, "ch", "Expected failed reflected fields to be omitted.")
+	assert.Equal(t, map[string]interface{}{}, fields["obj"], "Unexpected object after a marshaler error.")
+}
+
+func TestCBORTimeRoundTrip(t *testing.T) {
+	times := []time.Time{
+		time.Unix(1363896240, 0),
+		time.Unix(1363896240, 1),
+		time.Unix(1700000000, 999999999),
+		time.Unix(-1, 500),
+		time.Date(2020, time.February, 29, 12, 0, 0, 123456789, time.FixedZone("UTC+8", 8*60*60)),
+	}
+	for _, ts := range times {
+		enc := NewCBOREncoder(testEncoderConfig())
+		enc.AddTime("at", ts)
+		enc.AddArray("times", ArrayMarshalerFunc(func(arr ArrayEncoder) error {
+			arr.AppendTime(ts)
+			return nil
+		}))
+		ent, fields := decodeCBORFields(t, encodeCBOREntry(t, enc, Entry{Message: "times", Time: ts}))
+
+		assert.Equal(t, ts.UnixNano(), ent.Time.UnixNano(), "Expected the entry's time to keep its nanoseconds.")
+		at, ok := fields["at"].(time.Time)
+		require.True(t, ok, "Expected a time field, got %v.", fields["at"])
+		assert.Equal(t, ts.UnixNano(), at.UnixNano(), "Expected the time field to keep its nanoseconds.")
+		arr, ok := fields["times"].([]interface{})
+		require.True(t, ok && len(arr) == 1, "Expected an array of one time, got %v.", fields["times"])
+		assert.Equal(t, ts.UnixNano(), arr[0].(time.Time).UnixNano(), "Expected times in arrays to keep their nanoseconds.")
+	}
+}
+
+func TestCBOREncoderStream(t *testing.T) {
+	enc := NewCBOREncoder(testEncoderConfig())
+	var stream bytes.Buffer
+	for i := 0; i < 3; i++ {
+		stream.Write(encodeCBOREntry(t, enc, Entry{Message: "entry", Time: time.Unix(int64(i), 0)}, viper.Int("i", i)))
+	}
+
+	dec := NewCBORStreamDecoder(&stream, testEncoderConfig())
+	for i := 0; i < 3; i++ {
+		ent, fields, err := dec.Decode()
+		require.NoError(t, err, "Unexpected error decoding entry %d.", i)
+		assert.Equal(t, time.Unix(int64(i), 0), ent.Time, "Unexpected time of entry %d.", i)
+		assert.Equal(t, []Field{viper.Int64("i", int64(i))}, fields, "Unexpected fields of entry %d.", i)
+	}
+	_, _, err := dec.Decode()
+	assert.Equal(t, io.EOF, err, "Expected io.EOF at the end of the stream.")
+}
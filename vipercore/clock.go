@@ -0,0 +1,48 @@
+
+
+package vipercore
+
+import "time"
+
+// Clock is a source of time for logged entries and for Cores that need to
+// measure time. Replacing it lets tests control time without sleeping.
+type Clock interface {
+	// Now returns the current local time.
+	Now() time.Time
+
+	// NewTicker returns a Ticker that delivers the clock's ticks on its
+	// channel at the given interval.
+	NewTicker(time.Duration) Ticker
+}
+
+// Ticker delivers the ticks of a Clock, like a *time.Ticker. Callers must
+// call Stop once they're done with it to release its resources.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker. It doesn't close the channel.
+	Stop()
+}
+
+// DefaultClock is the Clock used by Viper unless another is supplied. It uses
+// the system clock.
+var DefaultClock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -2,7 +2,11 @@
 
 package vipercore
 
-import "github.com/gottingen/buffer"
+import (
+	"time"
+
+	"github.com/gottingen/buffer"
+)
 
 // Core is a minimal, fast logger interface. It's designed for library authors
 // to wrap in a more user-friendly API.
@@ -47,10 +51,23 @@ func NewCore(enc Encoder, ws WriteSyncer, enab LevelEnabler) Core {
 	}
 }
 
+// NewCoreWithMetrics creates a Core that writes logs to a WriteSyncer and
+// reports the entries it writes, its write and sync errors, and its write
+// latency to m.
+func NewCoreWithMetrics(enc Encoder, ws WriteSyncer, enab LevelEnabler, m Metrics) Core {
+	return &ioCore{
+		LevelEnabler: enab,
+		enc:          enc,
+		out:          ws,
+		metrics:      m,
+	}
+}
+
 type ioCore struct {
 	LevelEnabler
-	enc Encoder
-	out WriteSyncer
+	enc     Encoder
+	out     WriteSyncer
+	metrics Metrics
 }
 
 func (c *ioCore) With(fields []Field) Core {
@@ -67,16 +84,31 @@ func (c *ioCore) Check(ent Entry, ce *CheckedEntry) *CheckedEntry {
 }
 
 func (c *ioCore) Write(ent Entry, fields []Field) error {
+	var start time.Time
+	if c.metrics != nil {
+		start = time.Now()
+	}
 	buf, err := c.enc.EncodeEntry(ent, fields)
 	if err != nil {
+		if c.metrics != nil {
+			c.metrics.WriteError(ent.Level)
+		}
 		return err
 	}
+	n := buf.Len()
 	_, err = c.out.Write(buf.Bytes())
 	buffer.Put(buf)
+	if c.metrics != nil {
+		if err != nil {
+			c.metrics.WriteError(ent.Level)
+		} else {
+			c.metrics.EntryWritten(ent.Level, n, time.Since(start))
+		}
+	}
 	if err != nil {
 		return err
 	}
-	if ent.Level > ErrorLevel {
+	if CriticalLevel.Enabled(ent.Level) {
 		// Since we may be crashing the program, sync the output. Ignore Sync
 		// errors, pending a clean solution to issue #370.
 		c.Sync()
@@ -85,7 +117,11 @@ func (c *ioCore) Write(ent Entry, fields []Field) error {
 }
 
 func (c *ioCore) Sync() error {
-	return c.out.Sync()
+	err := c.out.Sync()
+	if err != nil && c.metrics != nil {
+		c.metrics.SyncError()
+	}
+	return err
 }
 
 func (c *ioCore) clone() *ioCore {
@@ -93,5 +129,6 @@ func (c *ioCore) clone() *ioCore {
 		LevelEnabler: c.LevelEnabler,
 		enc:          c.enc.Clone(),
 		out:          c.out,
+		metrics:      c.metrics,
 	}
 }
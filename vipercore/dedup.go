@@ -0,0 +1,253 @@
+
+
+package vipercore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gottingen/gekko/multierr"
+)
+
+// Keys of the fields added to the summary entries logged by a Deduplicator.
+const (
+	DedupCountKey     = "repeat_count"
+	DedupFirstSeenKey = "first_seen"
+	DedupLastSeenKey  = "last_seen"
+)
+
+type dedupRun struct {
+	core        Core
+	ent         Entry
+	fields      []Field
+	count       int64
+	first, last time.Time
+}
+
+func (r *dedupRun) summary() (Core, Entry, []Field) {
+	ent := r.ent
+	ent.Time = r.last
+	fields := make([]Field, len(r.fields), len(r.fields)+3)
+	copy(fields, r.fields)
+	fields = append(fields,
+		Field{Key: DedupCountKey, Type: Int64Type, Integer: r.count},
+		Field{Key: DedupFirstSeenKey, Type: TimeType, Integer: r.first.UnixNano(), Interface: r.first.Location()},
+		Field{Key: DedupLastSeenKey, Type: TimeType, Integer: r.last.UnixNano(), Interface: r.last.Location()},
+	)
+	return r.core, ent, fields
+}
+
+// _dedupTicksPerWindow is how often, per window, a deduplicator checks for
+// runs whose window has closed.
+const _dedupTicksPerWindow = 4
+
+type dedupState struct {
+	sync.Mutex
+
+	window  time.Duration
+	clock   Clock
+	runs    map[string]*dedupRun
+	order   []string // keys of runs, oldest first
+	closing bool     // whether closeWindows is running
+}
+
+// startClosingWindows starts closing windows in the background unless it's
+// already happening. Callers must hold the lock.
+func (s *dedupState) startClosingWindows() {
+	if s.window <= 0 || s.closing {
+		return
+	}
+	tick := s.window / _dedupTicksPerWindow
+	if tick <= 0 {
+		tick = s.window
+	}
+	s.closing = true
+	go s.closeWindows(s.clock.NewTicker(tick))
+}
+
+// closeWindows ends runs as their windows close, so that a burst of
+// duplicates followed by silence is still summarized. It returns once no runs
+// are left, and is restarted by the next entry.
+func (s *dedupState) closeWindows(ticker Ticker) {
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		s.Lock()
+		ended := s.expire(s.clock.Now(), "")
+		done := len(s.order) == 0
+		if done {
+			s.closing = false
+		}
+		s.Unlock()
+
+		// There's no caller to return errors to, so the summaries are
+		// written on a best-effort basis, like the entries of a Logger.
+		writeDedupSummaries(ended)
+		if done {
+			return
+		}
+	}
+}
+
+// expire removes the runs that have ended as of t and returns those that were
+// repeated. Callers must hold the lock.
+func (s *dedupState) expire(t time.Time, key string) []*dedupRun {
+	var ended []*dedupRun
+	for len(s.order) > 0 {
+		oldest := s.order[0]
+		r := s.runs[oldest]
+		if s.window > 0 {
+			if t.Sub(r.first) < s.window {
+				break
+			}
+		} else if oldest == key {
+			// Without a window, a run only ends when a different entry is
+			// logged.
+			break
+		}
+		s.order = s.order[1:]
+		delete(s.runs, oldest)
+		if r.count > 1 {
+			ended = append(ended, r)
+		}
+	}
+	return ended
+}
+
+type deduplicator struct {
+	Core
+
+	state      *dedupState
+	contextKey string
+}
+
+// NewDeduplicator creates a Core that collapses repeated identical entries,
+// which keeps a flapping dependency from flooding the logs without losing
+// track of how often it failed. Entries are identical if they have the same
+// level, message, and fields, including those added with With.
+//
+// The first entry of each run is logged immediately and its duplicates are
+// dropped. Once the run ends, a single copy of the entry is logged with the
+// number of occurrences and the times of the first and last occurrence added
+// under DedupCountKey, DedupFirstSeenKey, and DedupLastSeenKey.
+//
+// If window is positive, a run ends once the window has elapsed since the run
+// began, even if nothing else is logged; otherwise, only consecutive
+// duplicates are collapsed and a run ends as soon as a different entry is
+// logged. Windows are measured using the entries' timestamps, so they should
+// come from the same Clock as the one passed to NewDeduplicatorWithClock,
+// which is DefaultClock here. Calling Sync ends all runs.
+//
+// Like other Cores, the wrapped Core checks each entry and summary before
+// it's written, so its sampling and level filtering apply.
+func NewDeduplicator(core Core, window time.Duration) Core {
+	return NewDeduplicatorWithClock(core, window, DefaultClock)
+}
+
+// NewDeduplicatorWithClock creates a Core that collapses repeated identical
+// entries like NewDeduplicator, using the supplied Clock to close windows.
+// Pass the Clock given to the Logger with WithClock. A nil Clock means
+// DefaultClock.
+func NewDeduplicatorWithClock(core Core, window time.Duration, clock Clock) Core {
+	if clock == nil {
+		clock = DefaultClock
+	}
+	return &deduplicator{
+		Core: core,
+		state: &dedupState{
+			window: window,
+			clock:  clock,
+			runs:   make(map[string]*dedupRun),
+		},
+	}
+}
+
+func (d *deduplicator) With(fields []Field) Core {
+	return &deduplicator{
+		Core:       d.Core.With(fields),
+		state:      d.state,
+		contextKey: d.contextKey + fieldsKey(fields),
+	}
+}
+
+func (d *deduplicator) Check(ent Entry, ce *CheckedEntry) *CheckedEntry {
+	if d.Enabled(ent.Level) {
+		return ce.AddCore(ent, d)
+	}
+	return ce
+}
+
+func (d *deduplicator) Write(ent Entry, fields []Field) error {
+	key := fmt.Sprintf("%d\x00%s\x00%s\x00%s", ent.Level, ent.Message, d.contextKey, fieldsKey(fields))
+
+	d.state.Lock()
+	ended := d.state.expire(ent.Time, key)
+	r, duplicate := d.state.runs[key]
+	if duplicate {
+		r.count++
+		r.last = ent.Time
+	} else {
+		d.state.runs[key] = &dedupRun{
+			core:   d.Core,
+			ent:    ent,
+			fields: append([]Field(nil), fields...),
+			count:  1,
+			first:  ent.Time,
+			last:   ent.Time,
+		}
+		d.state.order = append(d.state.order, key)
+	}
+	d.state.startClosingWindows()
+	d.state.Unlock()
+
+	err := writeDedupSummaries(ended)
+	if !duplicate {
+		err = multierr.Append(err, writeChecked(d.Core, ent, fields))
+	}
+	return err
+}
+
+func (d *deduplicator) Sync() error {
+	d.state.Lock()
+	var ended []*dedupRun
+	for _, key := range d.state.order {
+		if r := d.state.runs[key]; r.count > 1 {
+			ended = append(ended, r)
+		}
+	}
+	d.state.runs = make(map[string]*dedupRun)
+	d.state.order = nil
+	d.state.Unlock()
+
+	err := writeDedupSummaries(ended)
+	return multierr.Append(err, d.Core.Sync())
+}
+
+func writeDedupSummaries(runs []*dedupRun) error {
+	var err error
+	for _, r := range runs {
+		core, ent, fields := r.summary()
+		err = multierr.Append(err, writeChecked(core, ent, fields))
+	}
+	return err
+}
+
+// fieldsKey returns a string identifying a set of fields, regardless of their
+// order. It includes the fields' types, so that fields whose values print the
+// same, like String("id", "1") and Int("id", 1), aren't duplicates.
+func fieldsKey(fields []Field) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	enc := NewMapObjectEncoder()
+	types := make([]string, len(fields))
+	for i := range fields {
+		fields[i].AddTo(enc)
+		types[i] = fmt.Sprintf("%s:%d", fields[i].Key, fields[i].Type)
+	}
+	sort.Strings(types)
+	return fmt.Sprint(enc.Fields) + "\x00" + strings.Join(types, ",")
+}
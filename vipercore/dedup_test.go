@@ -0,0 +1,205 @@
+
+
+package vipercore_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/gottingen/viper/vipercore"
+	"github.com/gottingen/viper/vipertest"
+	"github.com/gottingen/viper/vipertest/observer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeDeduplicator(window time.Duration) (Core, *observer.ObservedLogs) {
+	core, logs := observer.New(DebugLevel)
+	return NewDeduplicator(core, window), logs
+}
+
+func writeAt(core Core, t time.Time, lvl Level, msg string, fields ...Field) {
+	if ce := core.Check(Entry{Level: lvl, Time: t, Message: msg}, nil); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+func assertDedupSummary(t testing.TB, entry observer.LoggedEntry, count int64, first, last time.Time) {
+	require.True(t, len(entry.Context) >= 3, "Expected summary fields.")
+	summary := entry.Context[len(entry.Context)-3:]
+	assert.Equal(t, DedupCountKey, summary[0].Key, "Unexpected count key.")
+	assert.Equal(t, count, summary[0].Integer, "Unexpected repeat count.")
+	assert.Equal(t, DedupFirstSeenKey, summary[1].Key, "Unexpected first-seen key.")
+	assert.Equal(t, first.UnixNano(), summary[1].Integer, "Unexpected first-seen time.")
+	assert.Equal(t, DedupLastSeenKey, summary[2].Key, "Unexpected last-seen key.")
+	assert.Equal(t, last.UnixNano(), summary[2].Integer, "Unexpected last-seen time.")
+	assert.Equal(t, last, entry.Time, "Expected summary to be logged at the last-seen time.")
+}
+
+func TestDeduplicatorConsecutive(t *testing.T) {
+	dedup, logs := fakeDeduplicator(0)
+	start := time.Unix(1000, 0)
+
+	for i := 0; i < 5; i++ {
+		writeAt(dedup, start.Add(time.Duration(i)*time.Second), ErrorLevel, "flapping", makeInt64Field("port", 80))
+	}
+	require.Equal(t, 1, logs.Len(), "Expected duplicates to be suppressed.")
+
+	writeAt(dedup, start.Add(10*time.Second), InfoLevel, "recovered")
+	entries := logs.TakeAll()
+	require.Equal(t, 3, len(entries), "Expected summary and new entry after the run ended.")
+
+	assert.Equal(t, "flapping", entries[0].Message, "Unexpected first entry.")
+	assert.Equal(t, []Field{makeInt64Field("port", 80)}, entries[0].Context, "Unexpected fields on first entry.")
+
+	assert.Equal(t, "flapping", entries[1].Message, "Unexpected summary message.")
+	assert.Equal(t, ErrorLevel, entries[1].Level, "Unexpected summary level.")
+	assert.Equal(t, makeInt64Field("port", 80), entries[1].Context[0], "Expected summary to keep original fields.")
+	assertDedupSummary(t, entries[1], 5, start, start.Add(4*time.Second))
+
+	assert.Equal(t, "recovered", entries[2].Message, "Unexpected entry after the run.")
+
+	// Runs without duplicates don't produce summaries.
+	writeAt(dedup, start.Add(11*time.Second), InfoLevel, "other")
+	assert.Equal(t, []string{"other"}, messages(logs.TakeAll()), "Unexpected summary for a single entry.")
+}
+
+func TestDeduplicatorDistinguishesEntries(t *testing.T) {
+	dedup, logs := fakeDeduplicator(time.Minute)
+	now := time.Unix(1000, 0)
+
+	writeAt(dedup, now, ErrorLevel, "msg", makeInt64Field("a", 1), makeInt64Field("b", 2))
+	writeAt(dedup, now, ErrorLevel, "msg", makeInt64Field("b", 2), makeInt64Field("a", 1))
+	require.Equal(t, 1, logs.Len(), "Expected field order not to matter.")
+
+	writeAt(dedup, now, WarnLevel, "msg", makeInt64Field("a", 1), makeInt64Field("b", 2))
+	writeAt(dedup, now, ErrorLevel, "other", makeInt64Field("a", 1), makeInt64Field("b", 2))
+	writeAt(dedup, now, ErrorLevel, "msg", makeInt64Field("a", 2), makeInt64Field("b", 2))
+	writeAt(dedup.With([]Field{makeInt64Field("c", 3)}), now, ErrorLevel, "msg", makeInt64Field("a", 1), makeInt64Field("b", 2))
+	assert.Equal(t, 5, logs.Len(), "Expected entries differing in level, message or fields to be logged.")
+}
+
+func TestDeduplicatorWindow(t *testing.T) {
+	dedup, logs := fakeDeduplicator(time.Minute)
+	start := time.Unix(1000, 0)
+
+	// Interleaved duplicates are collapsed within the window.
+	for i := 0; i < 3; i++ {
+		writeAt(dedup, start.Add(time.Duration(i)*time.Second), ErrorLevel, "a")
+		writeAt(dedup, start.Add(time.Duration(i)*time.Second), ErrorLevel, "b")
+	}
+	assert.Equal(t, []string{"a", "b"}, messages(logs.TakeAll()), "Expected duplicates within the window to be suppressed.")
+
+	// Once the window closes, the next entry flushes the runs and starts a
+	// new one.
+	writeAt(dedup, start.Add(time.Minute), ErrorLevel, "a")
+	entries := logs.TakeAll()
+	require.Equal(t, []string{"a", "b", "a"}, messages(entries), "Expected summaries followed by the new entry.")
+	assertDedupSummary(t, entries[0], 3, start, start.Add(2*time.Second))
+	assertDedupSummary(t, entries[1], 3, start, start.Add(2*time.Second))
+	assert.Empty(t, entries[2].Context, "Expected new run to start with an unannotated entry.")
+}
+
+func TestDeduplicatorSync(t *testing.T) {
+	dedup, logs := fakeDeduplicator(time.Hour)
+	start := time.Unix(1000, 0)
+	child := dedup.With([]Field{makeInt64Field("ctx", 1)})
+
+	writeAt(child, start, ErrorLevel, "msg")
+	writeAt(child, start.Add(time.Second), ErrorLevel, "msg")
+	require.NoError(t, dedup.Sync(), "Unexpected error syncing.")
+
+	entries := logs.TakeAll()
+	require.Equal(t, 2, len(entries), "Expected Sync to flush the pending run.")
+	assert.Equal(t, makeInt64Field("ctx", 1), entries[1].Context[0], "Expected summary to be written with the child's context.")
+	assertDedupSummary(t, entries[1], 2, start, start.Add(time.Second))
+
+	require.NoError(t, dedup.Sync(), "Unexpected error syncing.")
+	writeAt(child, start.Add(2*time.Second), ErrorLevel, "msg")
+	assert.Equal(t, 1, logs.Len(), "Expected Sync to end all runs.")
+}
+
+func TestDeduplicatorDisabledLevels(t *testing.T) {
+	core, logs := observer.New(InfoLevel)
+	dedup := NewDeduplicator(core, 0)
+	assert.Nil(t, dedup.Check(Entry{Level: DebugLevel}, nil), "Expected disabled levels to fail Check.")
+	writeAt(dedup, time.Now(), InfoLevel, "msg")
+	assert.Equal(t, 1, logs.Len(), "Expected enabled levels to be logged.")
+}
+
+func TestDeduplicatorConcurrent(t *testing.T) {
+	const (
+		numGoroutines = 10
+		numWrites     = 100
+	)
+	cc := &countingCore{}
+	dedup := NewDeduplicator(cc, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numWrites; j++ {
+				writeAt(dedup, time.Now(), ErrorLevel, "msg")
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, dedup.Sync(), "Unexpected error syncing.")
+	assert.Equal(t, uint32(2), cc.logs.Load(), "Expected one entry and one summary.")
+}
+
+func TestDeduplicatorClosesWindows(t *testing.T) {
+	clock := vipertest.NewMockClock()
+	core, logs := observer.New(DebugLevel)
+	dedup := NewDeduplicatorWithClock(core, time.Minute, clock)
+
+	start := clock.Now()
+	for i := 0; i < 3; i++ {
+		writeAt(dedup, clock.Now(), ErrorLevel, "burst")
+		clock.Add(time.Second)
+	}
+	assert.Equal(t, 1, logs.Len(), "Expected duplicates within the window to be suppressed.")
+
+	// Nothing else is logged, but the run still ends with the window.
+	clock.Add(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for logs.Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	entries := logs.TakeAll()
+	require.Equal(t, 2, len(entries), "Expected a summary once the window closed.")
+	assertDedupSummary(t, entries[1], 3, start, start.Add(2*time.Second))
+}
+
+func TestDeduplicatorFieldTypes(t *testing.T) {
+	dedup, logs := fakeDeduplicator(time.Hour)
+	now := time.Unix(1000, 0)
+	writeAt(dedup, now, ErrorLevel, "msg", makeStringField("id", "1"))
+	writeAt(dedup, now, ErrorLevel, "msg", makeInt64Field("id", 1))
+	assert.Equal(t, 2, logs.Len(), "Expected fields of different types not to be duplicates.")
+}
+
+func TestDeduplicatorChecksWrappedCore(t *testing.T) {
+	all, allLogs := observer.New(DebugLevel)
+	errorsOnly, errorLogs := observer.New(ErrorLevel)
+	dedup := NewDeduplicator(NewTee(all, errorsOnly), time.Hour)
+	now := time.Unix(1000, 0)
+
+	writeAt(dedup, now, InfoLevel, "info")
+	writeAt(dedup, now, InfoLevel, "info")
+	require.NoError(t, dedup.Sync(), "Unexpected error syncing.")
+	assert.Equal(t, 2, allLogs.Len(), "Expected the entry and its summary.")
+	assert.Equal(t, 0, errorLogs.Len(), "Expected the wrapped core's levels to apply.")
+}
+
+func messages(entries []observer.LoggedEntry) []string {
+	msgs := make([]string, len(entries))
+	for i, e := range entries {
+		msgs[i] = e.Message
+	}
+	return msgs
+}
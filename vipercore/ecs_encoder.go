@@ -0,0 +1,159 @@
+
+
+package vipercore
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/gottingen/buffer"
+)
+
+// ECSVersion is the version of the Elastic Common Schema that the ECS encoder
+// conforms to. It's written as the ecs.version of every entry.
+const ECSVersion = "1.6.0"
+
+// _ecsErrorStackKey is where ECS expects the stacktrace of an error.
+const _ecsErrorStackKey = "error.stack_trace"
+
+type ecsEncoder struct {
+	*jsonEncoder
+
+	// errorStack records whether an error's own stacktrace has been written
+	// as error.stack_trace, which takes precedence over the entry's.
+	errorStack bool
+}
+
+// NewECSEncoder creates an encoder whose output conforms to the Elastic
+// Common Schema (ECS), so that Elasticsearch can index it without ingest
+// pipelines. It writes JSON like the JSON encoder, except that the time,
+// level, and message come first and are followed by ecs.version, and that the
+// caller is written as an object holding the file.name, file.line, and
+// function that ECS expects under log.origin. Error fields are written as
+// objects holding the error's message, its type, and the verbose stack_trace
+// of rich errors, so that the field added by viper.Error becomes
+// error.message, error.type, and error.stack_trace.
+//
+// ECS fixes the names of these fields, so the encoder should be used with an
+// EncoderConfig that uses them, like the one returned by
+// viper.NewECSEncoderConfig. If an Error field has written error.stack_trace,
+// the entry's own stacktrace is omitted rather than duplicated there.
+func NewECSEncoder(cfg EncoderConfig) Encoder {
+	return &ecsEncoder{jsonEncoder: newJSONEncoder(cfg, false)}
+}
+
+func (c *ecsEncoder) Clone() Encoder {
+	return &ecsEncoder{
+		jsonEncoder: c.jsonEncoder.Clone().(*jsonEncoder),
+		errorStack:  c.errorStack,
+	}
+}
+
+// addError implements errorEncoder.
+func (c *ecsEncoder) addError(key string, err error) {
+	ecsErr := ecsError{err: err, message: err.Error()}
+	if f, ok := err.(fmt.Formatter); ok {
+		if verbose := fmt.Sprintf("%+v", f); verbose != ecsErr.message {
+			ecsErr.stackTrace = verbose
+		}
+	}
+	c.AddObject(key, ecsErr)
+	if key == "error" && ecsErr.stackTrace != "" && c.openNamespaces == 0 {
+		c.errorStack = true
+	}
+}
+
+func (c *ecsEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	final := &ecsEncoder{jsonEncoder: c.jsonEncoder.clone(), errorStack: c.errorStack}
+	final.buf.WriteByte('{')
+
+	if final.TimeKey != "" {
+		final.AddTime(final.TimeKey, ent.Time)
+	}
+	if final.LevelKey != "" {
+		final.addKey(final.LevelKey)
+		cur := final.buf.Len()
+		final.EncodeLevel(ent.Level, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeLevel was a no-op. Fall back to strings to keep
+			// output JSON valid.
+			final.WriteString(ent.Level.String())
+		}
+	}
+	if final.MessageKey != "" {
+		final.addKey(final.MessageKey)
+		final.WriteString(ent.Message)
+	}
+	final.AddString("ecs.version", ECSVersion)
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.addKey(final.NameKey)
+		cur := final.buf.Len()
+		nameEncoder := final.EncodeName
+		if nameEncoder == nil {
+			nameEncoder = FullNameEncoder
+		}
+		nameEncoder(ent.LoggerName, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeName was a no-op. Fall back to strings to
+			// keep output JSON valid.
+			final.WriteString(ent.LoggerName)
+		}
+	}
+	if ent.Caller.Defined && final.CallerKey != "" {
+		final.AddObject(final.CallerKey, ecsOrigin(ent.Caller))
+	}
+	if c.buf.Len() > 0 {
+		final.addElementSeparator()
+		final.buf.Write(c.buf.Bytes())
+	}
+	addFields(final, fields)
+	final.closeOpenNamespaces()
+	if ent.Stack != "" && final.StacktraceKey != "" &&
+		!(final.errorStack && final.StacktraceKey == _ecsErrorStackKey) {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+	final.buf.WriteByte('}')
+	if final.LineEnding != "" {
+		final.buf.WriteString(final.LineEnding)
+	} else {
+		final.buf.WriteString(DefaultLineEnding)
+	}
+
+	ret := final.buf
+	putJSONEncoder(final.jsonEncoder)
+	return ret, nil
+}
+
+// ecsOrigin writes a caller as the fields of ECS's log.origin.
+type ecsOrigin EntryCaller
+
+func (o ecsOrigin) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddObject("file", ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+		enc.AddString("name", o.File)
+		enc.AddInt("line", o.Line)
+		return nil
+	}))
+	if o.PC == 0 {
+		return nil
+	}
+	if fn := runtime.FuncForPC(o.PC); fn != nil {
+		enc.AddString("function", fn.Name())
+	}
+	return nil
+}
+
+// ecsError writes an error as the fields of ECS's error.
+type ecsError struct {
+	err        error
+	message    string
+	stackTrace string
+}
+
+func (e ecsError) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddString("message", e.message)
+	enc.AddString("type", fmt.Sprintf("%T", e.err))
+	if e.stackTrace != "" {
+		enc.AddString("stack_trace", e.stackTrace)
+	}
+	return nil
+}
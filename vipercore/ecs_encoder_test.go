@@ -0,0 +1,123 @@
+package vipercore_test
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+// richError is formatted with a stacktrace by %+v, like the errors of
+// github.com/pkg/errors.
+type richError string
+
+func (e richError) Error() string { return string(e) }
+
+func (e richError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprintf(s, "%s\nmain.handler\n\t/src/app/main.go:10", string(e))
+		return
+	}
+	fmt.Fprint(s, string(e))
+}
+
+func ecsTestEncoderConfig() EncoderConfig {
+	return EncoderConfig{
+		TimeKey:        "@timestamp",
+		LevelKey:       "log.level",
+		NameKey:        "log.logger",
+		CallerKey:      "log.origin",
+		MessageKey:     "message",
+		StacktraceKey:  "error.stack_trace",
+		EncodeLevel:    LowercaseLevelEncoder,
+		EncodeTime:     RFC3339NanoTimeEncoder,
+		EncodeDuration: NanosDurationEncoder,
+		EncodeCaller:   FullCallerEncoder,
+	}
+}
+
+func encodeECS(t testing.TB, enc Encoder, ent Entry, fields ...Field) string {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buffer.Put(buf)
+	return buf.String()
+}
+
+func TestECSEncoderEntry(t *testing.T) {
+	enc := NewECSEncoder(ecsTestEncoderConfig())
+	enc.AddString("service.name", "api")
+
+	pc, _, _, ok := runtime.Caller(0)
+	require.True(t, ok, "Couldn't get the caller.")
+	out := encodeECS(t, enc, Entry{
+		Level:      WarnLevel,
+		Time:       time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC),
+		LoggerName: "http",
+		Message:    "slow request",
+		Caller:     EntryCaller{Defined: true, PC: pc, File: "/src/app/main.go", Line: 42},
+	}, viper.Duration("event.duration", 1500*time.Millisecond))
+
+	assert.Equal(t, `{"@timestamp":"2020-01-02T03:04:05.123456789Z","log.level":"warn","message":"slow request",`+
+		`"ecs.version":"1.6.0","log.logger":"http",`+
+		`"log.origin":{"file":{"name":"/src/app/main.go","line":42},"function":"github.com/gottingen/viper/vipercore_test.TestECSEncoderEntry"},`+
+		`"service.name":"api","event.duration":1500000000}`+"\n", out, "Unexpected ECS JSON.")
+}
+
+func TestECSEncoderErrors(t *testing.T) {
+	cfg := ecsTestEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.LevelKey = ""
+	cfg.MessageKey = ""
+	enc := NewECSEncoder(cfg)
+
+	tests := []struct {
+		desc   string
+		fields []Field
+		stack  string
+		want   string
+	}{
+		{
+			desc:   "plain error",
+			fields: []Field{viper.Error(errors.New("boom"))},
+			stack:  "entry-stack",
+			want: `{"ecs.version":"1.6.0","error":{"message":"boom","type":"*errors.errorString"},` +
+				`"error.stack_trace":"entry-stack"}`,
+		},
+		{
+			desc:   "rich error",
+			fields: []Field{viper.Error(richError("boom"))},
+			stack:  "entry-stack",
+			want: `{"ecs.version":"1.6.0","error":{"message":"boom","type":"vipercore_test.richError",` +
+				`"stack_trace":"boom\nmain.handler\n\t/src/app/main.go:10"}}`,
+		},
+		{
+			desc:   "named error",
+			fields: []Field{viper.NamedError("cause", richError("boom"))},
+			stack:  "entry-stack",
+			want: `{"ecs.version":"1.6.0","cause":{"message":"boom","type":"vipercore_test.richError",` +
+				`"stack_trace":"boom\nmain.handler\n\t/src/app/main.go:10"},"error.stack_trace":"entry-stack"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			assert.Equal(t, tt.want+"\n", encodeECS(t, enc, Entry{Stack: tt.stack}, tt.fields...), "Unexpected ECS JSON.")
+		})
+	}
+
+	// Errors added to the context are written the same way.
+	withErr := enc.Clone()
+	viper.Error(richError("boom")).AddTo(withErr)
+	assert.Equal(t, `{"ecs.version":"1.6.0","error":{"message":"boom","type":"vipercore_test.richError",`+
+		`"stack_trace":"boom\nmain.handler\n\t/src/app/main.go:10"}}`+"\n",
+		encodeECS(t, withErr, Entry{Stack: "entry-stack"}), "Unexpected ECS JSON with context error.")
+	assert.Equal(t, `{"ecs.version":"1.6.0","error.stack_trace":"entry-stack"}`+"\n",
+		encodeECS(t, enc, Entry{Stack: "entry-stack"}), "Expected the original encoder to be unaffected.")
+}
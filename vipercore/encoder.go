@@ -30,7 +30,11 @@ func LowercaseLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
 func LowercaseColorLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
 	s, ok := _levelToLowercaseColorString[l]
 	if !ok {
-		s = _unknownLevelColor.Add(l.String())
+		if cl, custom := lookupCustomLevel(l); custom {
+			s = cl.lowerColor
+		} else {
+			s = _unknownLevelColor.Add(l.String())
+		}
 	}
 	enc.WriteString(s)
 }
@@ -46,17 +50,23 @@ func CapitalLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
 func CapitalColorLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
 	s, ok := _levelToCapitalColorString[l]
 	if !ok {
-		s = _unknownLevelColor.Add(l.CapitalString())
+		if cl, custom := lookupCustomLevel(l); custom {
+			s = cl.capitalColor
+		} else {
+			s = _unknownLevelColor.Add(l.CapitalString())
+		}
 	}
 	enc.WriteString(s)
 }
 
 // UnmarshalText unmarshals text to a LevelEncoder. "capital" is unmarshaled to
 // CapitalLevelEncoder, "coloredCapital" is unmarshaled to CapitalColorLevelEncoder,
-// "colored" is unmarshaled to LowercaseColorLevelEncoder, and anything else
-// is unmarshaled to LowercaseLevelEncoder.
+// "colored" is unmarshaled to LowercaseColorLevelEncoder, "gcp" is unmarshaled
+// to GCPLevelEncoder, and anything else is unmarshaled to LowercaseLevelEncoder.
 func (e *LevelEncoder) UnmarshalText(text []byte) error {
 	switch string(text) {
+	case "gcp":
+		*e = GCPLevelEncoder
 	case "capital":
 		*e = CapitalLevelEncoder
 	case "capitalColor":
@@ -117,6 +127,7 @@ func RFC3339NanoTimeEncoder(t time.Time, enc PrimitiveArrayEncoder) {
 // "iso8601" and "ISO8601" are unmarshaled to ISO8601TimeEncoder.
 // "millis" is unmarshaled to EpochMillisTimeEncoder.
 // "nanos" is unmarshaled to EpochNanosEncoder.
+// "gcp" is unmarshaled to GCPTimeEncoder.
 // Anything else is unmarshaled to EpochTimeEncoder.
 func (e *TimeEncoder) UnmarshalText(text []byte) error {
 	switch string(text) {
@@ -130,6 +141,8 @@ func (e *TimeEncoder) UnmarshalText(text []byte) error {
 		*e = EpochMillisTimeEncoder
 	case "nanos":
 		*e = EpochNanosTimeEncoder
+	case "gcp":
+		*e = GCPTimeEncoder
 	default:
 		*e = EpochTimeEncoder
 	}
@@ -189,11 +202,14 @@ func ShortCallerEncoder(caller EntryCaller, enc PrimitiveArrayEncoder) {
 }
 
 // UnmarshalText unmarshals text to a CallerEncoder. "full" is unmarshaled to
-// FullCallerEncoder and anything else is unmarshaled to ShortCallerEncoder.
+// FullCallerEncoder, "gcp" is unmarshaled to GCPCallerEncoder, and anything
+// else is unmarshaled to ShortCallerEncoder.
 func (e *CallerEncoder) UnmarshalText(text []byte) error {
 	switch string(text) {
 	case "full":
 		*e = FullCallerEncoder
+	case "gcp":
+		*e = GCPCallerEncoder
 	default:
 		*e = ShortCallerEncoder
 	}
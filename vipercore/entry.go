@@ -215,6 +215,23 @@ func (ce *CheckedEntry) Write(fields ...Field) {
 	}
 }
 
+// writeChecked writes an entry to the Cores that agree to log it when core's
+// Check is called. Cores that decide whether to log an entry only once its
+// fields are known use it to honor the sampling and level filtering of the
+// Cores they wrap.
+func writeChecked(core Core, ent Entry, fields []Field) error {
+	ce := core.Check(ent, nil)
+	if ce == nil {
+		return nil
+	}
+	var err error
+	for i := range ce.cores {
+		err = multierr.Append(err, ce.cores[i].Write(ce.Entry, fields))
+	}
+	putCheckedEntry(ce)
+	return err
+}
+
 // AddCore adds a Core that has agreed to log this CheckedEntry. It's intended to be
 // used by Core.Check implementations, and is safe to call on nil CheckedEntry
 // references.
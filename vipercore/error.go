@@ -42,7 +42,15 @@ import (
 //      ...
 //    ],
 //  }
+//
+// Encoders that implement errorEncoder write the error in their own format
+// instead.
 func encodeError(key string, err error, enc ObjectEncoder) error {
+	if ee, ok := enc.(errorEncoder); ok {
+		ee.addError(key, err)
+		return nil
+	}
+
 	basic := err.Error()
 	enc.AddString(key, basic)
 
@@ -60,6 +68,12 @@ func encodeError(key string, err error, enc ObjectEncoder) error {
 	return nil
 }
 
+// An errorEncoder is an ObjectEncoder that writes errors in a format of its
+// own, like the objects the ECS encoder writes, rather than as strings.
+type errorEncoder interface {
+	addError(key string, err error)
+}
+
 type errorGroup interface {
 	// Provides read-only access to the underlying list of errors, preferably
 	// without causing any allocs.
@@ -0,0 +1,206 @@
+package vipercore
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+)
+
+// snapshotFields returns a copy of fields that doesn't refer to anything the
+// caller can change later. Fields whose values are only encoded when they're
+// written, like ObjectMarshalers and Stringers, are encoded into memory right
+// away and replaced by fields holding the result.
+func snapshotFields(fields []Field) []Field {
+	snapshot := make([]Field, 0, len(fields))
+	for _, f := range fields {
+		switch f.Type {
+		case BinaryType, ByteStringType:
+			b, _ := f.Interface.([]byte)
+			f.Interface = append([]byte(nil), b...)
+			snapshot = append(snapshot, f)
+		case ReflectType:
+			snapshot = append(snapshot, snapshotField(f.Key, freeze(f.Interface)))
+		case ArrayMarshalerType, ObjectMarshalerType, StringerType, ErrorType:
+			// These may add more than one key, like an error's verbose form,
+			// so keep everything they add.
+			enc := NewMapObjectEncoder()
+			f.AddTo(enc)
+			keys := make([]string, 0, len(enc.Fields))
+			for k := range enc.Fields {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				snapshot = append(snapshot, snapshotField(k, freeze(enc.Fields[k])))
+			}
+		default:
+			snapshot = append(snapshot, f)
+		}
+	}
+	return snapshot
+}
+
+// freeze copies a value encoded by a MapObjectEncoder, so that the copy
+// doesn't share anything with the caller: maps, slices, and byte slices are
+// copied, and reflected values of other types are encoded to JSON.
+func freeze(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			m[k] = freeze(elem)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, elem := range v {
+			s[i] = freeze(elem)
+		}
+		return s
+	case []byte:
+		return append([]byte(nil), v...)
+	case json.RawMessage:
+		return append(json.RawMessage(nil), v...)
+	case nil, bool, string, complex128, complex64, float64, float32,
+		int, int64, int32, int16, int8, uint, uint64, uint32, uint16, uint8, uintptr,
+		time.Duration, time.Time:
+		return v
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err.Error()
+		}
+		return json.RawMessage(b)
+	}
+}
+
+// snapshotField creates a field for a frozen value.
+func snapshotField(key string, v interface{}) Field {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return Field{Key: key, Type: ObjectMarshalerType, Interface: frozenObject(v)}
+	case []interface{}:
+		return Field{Key: key, Type: ArrayMarshalerType, Interface: frozenArray(v)}
+	case []byte:
+		return Field{Key: key, Type: BinaryType, Interface: v}
+	case bool:
+		var i int64
+		if v {
+			i = 1
+		}
+		return Field{Key: key, Type: BoolType, Integer: i}
+	case string:
+		return Field{Key: key, Type: StringType, String: v}
+	case complex128:
+		return Field{Key: key, Type: Complex128Type, Interface: v}
+	case complex64:
+		return Field{Key: key, Type: Complex64Type, Interface: v}
+	case float64:
+		return Field{Key: key, Type: Float64Type, Integer: int64(math.Float64bits(v))}
+	case float32:
+		return Field{Key: key, Type: Float32Type, Integer: int64(math.Float32bits(v))}
+	case int:
+		return Field{Key: key, Type: Int64Type, Integer: int64(v)}
+	case int64:
+		return Field{Key: key, Type: Int64Type, Integer: v}
+	case int32:
+		return Field{Key: key, Type: Int32Type, Integer: int64(v)}
+	case int16:
+		return Field{Key: key, Type: Int16Type, Integer: int64(v)}
+	case int8:
+		return Field{Key: key, Type: Int8Type, Integer: int64(v)}
+	case uint:
+		return Field{Key: key, Type: Uint64Type, Integer: int64(v)}
+	case uint64:
+		return Field{Key: key, Type: Uint64Type, Integer: int64(v)}
+	case uint32:
+		return Field{Key: key, Type: Uint32Type, Integer: int64(v)}
+	case uint16:
+		return Field{Key: key, Type: Uint16Type, Integer: int64(v)}
+	case uint8:
+		return Field{Key: key, Type: Uint8Type, Integer: int64(v)}
+	case uintptr:
+		return Field{Key: key, Type: UintptrType, Integer: int64(v)}
+	case time.Duration:
+		return Field{Key: key, Type: DurationType, Integer: int64(v)}
+	case time.Time:
+		return Field{Key: key, Type: TimeType, Integer: v.UnixNano(), Interface: v.Location()}
+	default:
+		return Field{Key: key, Type: ReflectType, Interface: v}
+	}
+}
+
+// frozenObject replays a frozen object, in the order of its keys.
+type frozenObject map[string]interface{}
+
+func (o frozenObject) MarshalLogObject(enc ObjectEncoder) error {
+	keys := make([]string, 0, len(o))
+	for k := range o {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		snapshotField(k, o[k]).AddTo(enc)
+	}
+	return nil
+}
+
+// frozenArray replays a frozen array.
+type frozenArray []interface{}
+
+func (a frozenArray) MarshalLogArray(enc ArrayEncoder) error {
+	var err error
+	for _, v := range a {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			err = enc.AppendObject(frozenObject(v))
+		case []interface{}:
+			err = enc.AppendArray(frozenArray(v))
+		case bool:
+			enc.WriteBool(v)
+		case string:
+			enc.WriteString(v)
+		case complex128:
+			enc.AppendComplex128(v)
+		case complex64:
+			enc.AppendComplex64(v)
+		case float64:
+			enc.WriteFloat64(v)
+		case float32:
+			enc.WriteFloat32(v)
+		case int:
+			enc.WriteInt(v)
+		case int64:
+			enc.WriteInt64(v)
+		case int32:
+			enc.WriteInt32(v)
+		case int16:
+			enc.WriteInt16(v)
+		case int8:
+			enc.WriteInt8(v)
+		case uint:
+			enc.WriteUint(v)
+		case uint64:
+			enc.WriteUint64(v)
+		case uint32:
+			enc.WriteUint32(v)
+		case uint16:
+			enc.WriteUint16(v)
+		case uint8:
+			enc.WriteUint8(v)
+		case uintptr:
+			enc.WriteUintptr(v)
+		case time.Duration:
+			enc.AppendDuration(v)
+		case time.Time:
+			enc.AppendTime(v)
+		default:
+			err = enc.AppendReflected(v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
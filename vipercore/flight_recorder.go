@@ -0,0 +1,157 @@
+
+
+package vipercore
+
+import (
+	"sync"
+
+	"github.com/gottingen/gekko/multierr"
+)
+
+// BackfilledKey is the key of the boolean field added to entries that a
+// flight recorder writes after the fact.
+const BackfilledKey = "backfilled"
+
+type recordedEntry struct {
+	ent    Entry
+	fields []Field
+}
+
+// ringBuffer holds the most recent entries written to a flight recorder.
+type ringBuffer struct {
+	sync.Mutex
+
+	entries []recordedEntry
+	next    int
+	full    bool
+}
+
+func (r *ringBuffer) add(size int, ent Entry, fields []Field) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.entries == nil {
+		// Allocate lazily, since most loggers created with With never record
+		// anything.
+		r.entries = make([]recordedEntry, size)
+	}
+	r.entries[r.next] = recordedEntry{ent: ent, fields: snapshotFields(fields)}
+	r.next = (r.next + 1) % size
+	r.full = r.full || r.next == 0
+}
+
+// drain removes and returns the buffered entries, oldest first.
+func (r *ringBuffer) drain() []recordedEntry {
+	r.Lock()
+	defer r.Unlock()
+
+	var drained []recordedEntry
+	if r.full {
+		drained = append(drained, r.entries[r.next:]...)
+	}
+	drained = append(drained, r.entries[:r.next]...)
+	for i := range r.entries {
+		r.entries[i] = recordedEntry{}
+	}
+	r.next = 0
+	r.full = false
+	return drained
+}
+
+type flightRecorder struct {
+	Core
+
+	size            int
+	record, trigger LevelEnabler
+	buf             *ringBuffer
+}
+
+// NewFlightRecorder creates a Core that keeps the last size entries that the
+// wrapped Core doesn't enable but record does, typically debug logs in
+// production. When an entry enabled by trigger is logged, the buffered entries
+// are written to the wrapped Core first, each with an added BackfilledKey
+// field so that they can be told apart from entries logged as they happened.
+//
+// Each Core created with With gets its own buffer, so a logger scoped to a
+// single request only back-fills that request's history. Buffered fields are
+// snapshotted when they're recorded: values that are otherwise encoded lazily,
+// like ObjectMarshalers and Stringers, are encoded into memory right away, so
+// back-filled entries show them as they were when logged and the buffer
+// doesn't keep them alive.
+func NewFlightRecorder(core Core, size int, record, trigger LevelEnabler) Core {
+	if size < 1 {
+		size = 1
+	}
+	return &flightRecorder{
+		Core:    core,
+		size:    size,
+		record:  record,
+		trigger: trigger,
+		buf:     &ringBuffer{},
+	}
+}
+
+func (f *flightRecorder) Enabled(lvl Level) bool {
+	return f.Core.Enabled(lvl) || f.record.Enabled(lvl)
+}
+
+func (f *flightRecorder) With(fields []Field) Core {
+	return &flightRecorder{
+		Core:    f.Core.With(fields),
+		size:    f.size,
+		record:  f.record,
+		trigger: f.trigger,
+		buf:     &ringBuffer{},
+	}
+}
+
+func (f *flightRecorder) Check(ent Entry, ce *CheckedEntry) *CheckedEntry {
+	if f.Core.Enabled(ent.Level) {
+		if f.trigger.Enabled(ent.Level) {
+			return ce.AddCore(ent, f)
+		}
+		return f.Core.Check(ent, ce)
+	}
+	if f.record.Enabled(ent.Level) {
+		return ce.AddCore(ent, f)
+	}
+	return ce
+}
+
+func (f *flightRecorder) Write(ent Entry, fields []Field) error {
+	if !f.Core.Enabled(ent.Level) {
+		f.buf.add(f.size, ent, fields)
+		return nil
+	}
+
+	var err error
+	if f.trigger.Enabled(ent.Level) {
+		backfilled := Field{Key: BackfilledKey, Type: BoolType, Integer: 1}
+		for _, r := range f.buf.drain() {
+			err = multierr.Append(err, writeBackfilled(f.Core, ent.Level, r.ent, append(r.fields, backfilled)))
+		}
+	}
+	return multierr.Append(err, writeChecked(f.Core, ent, fields))
+}
+
+// writeBackfilled writes a buffered entry to the cores that core's Check
+// selects for it, so that samplers and the level filters of teed cores still
+// apply. The wrapped Core doesn't enable the entry's own level, so it's
+// checked at the level of the entry that triggered the back-fill instead, but
+// written with its own.
+func writeBackfilled(core Core, lvl Level, ent Entry, fields []Field) error {
+	checked := ent
+	checked.Level = lvl
+	ce := core.Check(checked, nil)
+	if ce == nil {
+		return nil
+	}
+	written := ce.Entry
+	written.Level = ent.Level
+	var err error
+	for i := range ce.cores {
+		err = multierr.Append(err, ce.cores[i].Write(written, fields))
+	}
+	putCheckedEntry(ce)
+	return err
+}
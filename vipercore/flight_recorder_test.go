@@ -0,0 +1,161 @@
+package vipercore_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/gottingen/viper/vipercore"
+	"github.com/gottingen/viper/vipertest/observer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var backfilled = Field{Key: BackfilledKey, Type: BoolType, Integer: 1}
+
+func fakeFlightRecorder(size int) (Core, *observer.ObservedLogs) {
+	core, logs := observer.New(InfoLevel)
+	return NewFlightRecorder(core, size, DebugLevel, ErrorLevel), logs
+}
+
+func TestFlightRecorderBackfills(t *testing.T) {
+	recorder, logs := fakeFlightRecorder(3)
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 5; i++ {
+		writeAt(recorder, now, DebugLevel, fmt.Sprintf("debug-%d", i), makeInt64Field("i", i))
+	}
+	writeAt(recorder, now, TraceLevel, "trace")
+	writeAt(recorder, now, InfoLevel, "info")
+	assert.Equal(t, []string{"info"}, messages(logs.TakeAll()), "Expected debug entries to be buffered.")
+
+	writeAt(recorder, now, ErrorLevel, "error")
+	entries := logs.TakeAll()
+	require.Equal(
+		t,
+		[]string{"debug-2", "debug-3", "debug-4", "error"},
+		messages(entries),
+		"Expected the last entries to be back-filled before the trigger.",
+	)
+	for i, e := range entries[:3] {
+		assert.Equal(t, DebugLevel, e.Level, "Expected back-filled entries to keep their level.")
+		assert.Equal(t, []Field{makeInt64Field("i", i+2), backfilled}, e.Context, "Expected back-filled entries to be tagged.")
+	}
+	assert.Empty(t, entries[3].Context, "Expected the trigger entry not to be tagged.")
+
+	writeAt(recorder, now, ErrorLevel, "error")
+	assert.Equal(t, []string{"error"}, messages(logs.TakeAll()), "Expected the buffer to be emptied by the trigger.")
+}
+
+func TestFlightRecorderPartialBuffer(t *testing.T) {
+	recorder, logs := fakeFlightRecorder(10)
+	writeAt(recorder, time.Now(), DebugLevel, "one")
+	writeAt(recorder, time.Now(), DebugLevel, "two")
+	writeAt(recorder, time.Now(), CriticalLevel, "critical")
+	assert.Equal(t, []string{"one", "two", "critical"}, messages(logs.TakeAll()), "Unexpected entries.")
+}
+
+func TestFlightRecorderWith(t *testing.T) {
+	recorder, logs := fakeFlightRecorder(10)
+	req1 := recorder.With([]Field{makeInt64Field("request", 1)})
+	req2 := recorder.With([]Field{makeInt64Field("request", 2)})
+
+	writeAt(recorder, time.Now(), DebugLevel, "root")
+	writeAt(req1, time.Now(), DebugLevel, "req1")
+	writeAt(req2, time.Now(), DebugLevel, "req2")
+	writeAt(req1, time.Now(), ErrorLevel, "failed")
+
+	entries := logs.TakeAll()
+	require.Equal(t, []string{"req1", "failed"}, messages(entries), "Expected only the request's history to be back-filled.")
+	assert.Equal(t, []Field{makeInt64Field("request", 1), backfilled}, entries[0].Context, "Unexpected back-filled context.")
+}
+
+func TestFlightRecorderCopiesFields(t *testing.T) {
+	recorder, logs := fakeFlightRecorder(10)
+	fields := []Field{makeInt64Field("k", 1)}
+	writeAt(recorder, time.Now(), DebugLevel, "debug", fields...)
+	fields[0] = makeInt64Field("k", 2)
+	writeAt(recorder, time.Now(), ErrorLevel, "error")
+
+	entries := logs.TakeAll()
+	require.Equal(t, 2, len(entries), "Unexpected number of entries.")
+	assert.Equal(t, makeInt64Field("k", 1), entries[0].Context[0], "Expected buffered fields to be copied.")
+}
+
+func TestFlightRecorderEnabled(t *testing.T) {
+	recorder, _ := fakeFlightRecorder(10)
+	assert.False(t, recorder.Enabled(TraceLevel), "Expected trace to be disabled.")
+	assert.True(t, recorder.Enabled(DebugLevel), "Expected recorded levels to be enabled.")
+	assert.True(t, recorder.Enabled(InfoLevel), "Expected the wrapped Core's levels to be enabled.")
+	assert.Nil(t, recorder.Check(Entry{Level: TraceLevel}, nil), "Expected trace entries to fail Check.")
+}
+
+func TestFlightRecorderChecksWrappedCore(t *testing.T) {
+	infoCore, infoLogs := observer.New(InfoLevel)
+	errorCore, errorLogs := observer.New(ErrorLevel)
+	sampled := NewSampler(NewTee(infoCore, errorCore), time.Minute, 1, 100)
+	recorder := NewFlightRecorder(sampled, 10, DebugLevel, WarnLevel)
+	now := time.Now()
+
+	writeAt(recorder, now, DebugLevel, "debug")
+	writeAt(recorder, now, DebugLevel, "debug")
+	writeAt(recorder, now, DebugLevel, "other")
+	writeAt(recorder, now, WarnLevel, "warn")
+	writeAt(recorder, now, WarnLevel, "warn")
+
+	entries := infoLogs.TakeAll()
+	require.Equal(
+		t,
+		[]string{"debug", "other", "warn"},
+		messages(entries),
+		"Expected the sampler to apply to back-filled and trigger entries.",
+	)
+	assert.Equal(t, DebugLevel, entries[0].Level, "Expected back-filled entries to keep their level.")
+	assert.Equal(t, 0, errorLogs.Len(), "Expected the error-level core not to get warnings or their history.")
+
+	writeAt(recorder, now, ErrorLevel, "error")
+	assert.Equal(t, []string{"error"}, messages(infoLogs.TakeAll()), "Unexpected info-level entries.")
+	assert.Equal(t, []string{"error"}, messages(errorLogs.TakeAll()), "Unexpected error-level entries.")
+}
+
+type mutableUser struct {
+	name string
+	tags []string
+}
+
+func (u *mutableUser) MarshalLogObject(enc ObjectEncoder) error {
+	enc.AddString("name", u.name)
+	return enc.AddArray("tags", ArrayMarshalerFunc(func(arr ArrayEncoder) error {
+		for _, t := range u.tags {
+			arr.WriteString(t)
+		}
+		return nil
+	}))
+}
+
+func TestFlightRecorderSnapshotsFields(t *testing.T) {
+	recorder, logs := fakeFlightRecorder(10)
+	u := &mutableUser{name: "jane", tags: []string{"admin"}}
+	bin := []byte("abc")
+	writeAt(
+		recorder, time.Now(), DebugLevel, "debug",
+		Field{Key: "user", Type: ObjectMarshalerType, Interface: u},
+		Field{Key: "bin", Type: BinaryType, Interface: bin},
+		Field{Key: "err", Type: ErrorType, Interface: errors.New("failed")},
+	)
+	u.name = "joe"
+	u.tags[0] = "guest"
+	bin[0] = 'x'
+	writeAt(recorder, time.Now(), ErrorLevel, "error")
+
+	entries := logs.TakeAll()
+	require.Equal(t, []string{"debug", "error"}, messages(entries), "Unexpected entries.")
+	assert.Equal(t, map[string]interface{}{
+		"user":        map[string]interface{}{"name": "jane", "tags": []interface{}{"admin"}},
+		"bin":         []byte("abc"),
+		"err":         "failed",
+		BackfilledKey: true,
+	}, entries[0].ContextMap(), "Expected fields to be snapshotted when recorded.")
+}
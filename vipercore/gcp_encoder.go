@@ -0,0 +1,114 @@
+
+
+package vipercore
+
+import (
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/gottingen/buffer"
+)
+
+// GCPErrorEventType is the @type that marks an entry as an error event for
+// Google Cloud Error Reporting.
+const GCPErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// Google Cloud Logging's names for severities.
+var _levelToGCPSeverity = map[Level]string{
+	TraceLevel:    "DEBUG",
+	DebugLevel:    "DEBUG",
+	InfoLevel:     "INFO",
+	NoticeLevel:   "NOTICE",
+	WarnLevel:     "WARNING",
+	ErrorLevel:    "ERROR",
+	CriticalLevel: "CRITICAL",
+	DPanicLevel:   "CRITICAL",
+	PanicLevel:    "ALERT",
+	FatalLevel:    "EMERGENCY",
+}
+
+// GCPLevelEncoder serializes a Level to the name of a Google Cloud Logging
+// severity. Custom levels beyond the built-in ones become DEBUG or EMERGENCY.
+func GCPLevelEncoder(l Level, enc PrimitiveArrayEncoder) {
+	s, ok := _levelToGCPSeverity[l]
+	switch {
+	case ok:
+	case l < TraceLevel:
+		s = "DEBUG"
+	default:
+		s = "EMERGENCY"
+	}
+	enc.WriteString(s)
+}
+
+// GCPTimeEncoder serializes a time.Time to an object holding the seconds and
+// nanos of a google.protobuf.Timestamp, as Google Cloud Logging expects for
+// the timestamp of structured logs. If the encoder can't append objects, the
+// time is serialized as RFC3339 with nanoseconds instead.
+func GCPTimeEncoder(t time.Time, enc PrimitiveArrayEncoder) {
+	arr, ok := enc.(ArrayEncoder)
+	if !ok {
+		RFC3339NanoTimeEncoder(t, enc)
+		return
+	}
+	arr.AppendObject(ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+		enc.AddInt64("seconds", t.Unix())
+		enc.AddInt("nanos", t.Nanosecond())
+		return nil
+	}))
+}
+
+// GCPCallerEncoder serializes a caller to the object Google Cloud Logging
+// expects as logging.googleapis.com/sourceLocation, holding the file, the
+// line, and, if known, the function. As in the protobuf JSON mapping, the line
+// is written as a string. If the encoder can't append objects, the caller is
+// serialized as FullCallerEncoder does instead.
+func GCPCallerEncoder(caller EntryCaller, enc PrimitiveArrayEncoder) {
+	arr, ok := enc.(ArrayEncoder)
+	if !ok {
+		FullCallerEncoder(caller, enc)
+		return
+	}
+	arr.AppendObject(ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+		enc.AddString("file", caller.File)
+		enc.AddString("line", strconv.Itoa(caller.Line))
+		if caller.PC == 0 {
+			return nil
+		}
+		if fn := runtime.FuncForPC(caller.PC); fn != nil {
+			enc.AddString("function", fn.Name())
+		}
+		return nil
+	}))
+}
+
+type gcpEncoder struct {
+	Encoder
+}
+
+// NewGCPEncoder creates a JSON encoder for Google Cloud Logging, which adds
+// GCPErrorEventType as the @type of entries at ErrorLevel and above that have
+// stacktraces, so that Error Reporting groups them. The rest of the format is
+// controlled by the EncoderConfig, which should be the one returned by
+// viper.NewGCPEncoderConfig. Like other fields, the @type is written inside
+// any namespace left open by the logger's context.
+func NewGCPEncoder(cfg EncoderConfig) Encoder {
+	return gcpEncoder{NewJSONEncoder(cfg)}
+}
+
+func (c gcpEncoder) Clone() Encoder {
+	return gcpEncoder{c.Encoder.Clone()}
+}
+
+func (c gcpEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	if ErrorLevel.Enabled(ent.Level) && ent.Stack != "" {
+		// Don't append to the caller's slice.
+		fields = append(fields[:len(fields):len(fields)], Field{
+			Key:    "@type",
+			Type:   StringType,
+			String: GCPErrorEventType,
+		})
+	}
+	return c.Encoder.EncodeEntry(ent, fields)
+}
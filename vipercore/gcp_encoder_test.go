@@ -0,0 +1,124 @@
+package vipercore_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func gcpTestEncoderConfig() EncoderConfig {
+	return EncoderConfig{
+		TimeKey:        "timestamp",
+		LevelKey:       "severity",
+		CallerKey:      "logging.googleapis.com/sourceLocation",
+		MessageKey:     "message",
+		StacktraceKey:  "stack_trace",
+		EncodeLevel:    GCPLevelEncoder,
+		EncodeTime:     GCPTimeEncoder,
+		EncodeDuration: StringDurationEncoder,
+		EncodeCaller:   GCPCallerEncoder,
+	}
+}
+
+func encodeGCP(t testing.TB, enc Encoder, ent Entry, fields ...Field) string {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buffer.Put(buf)
+	return buf.String()
+}
+
+func TestGCPEncoderConfigWithJSON(t *testing.T) {
+	pc, _, _, ok := runtime.Caller(0)
+	require.True(t, ok, "Couldn't get the caller.")
+	out := encodeGCP(t, NewJSONEncoder(gcpTestEncoderConfig()), Entry{
+		Level:   WarnLevel,
+		Time:    time.Unix(1500000000, 123456789),
+		Message: "slow",
+		Caller:  EntryCaller{Defined: true, PC: pc, File: "/src/app/main.go", Line: 42},
+	})
+	assert.Equal(t, `{"severity":"WARNING","timestamp":{"seconds":1500000000,"nanos":123456789},`+
+		`"logging.googleapis.com/sourceLocation":{"file":"/src/app/main.go","line":"42",`+
+		`"function":"github.com/gottingen/viper/vipercore_test.TestGCPEncoderConfigWithJSON"},"message":"slow"}`+"\n",
+		out, "Unexpected JSON.")
+}
+
+func TestGCPLevelEncoder(t *testing.T) {
+	tests := map[Level]string{
+		TraceLevel:    "DEBUG",
+		DebugLevel:    "DEBUG",
+		InfoLevel:     "INFO",
+		NoticeLevel:   "NOTICE",
+		WarnLevel:     "WARNING",
+		ErrorLevel:    "ERROR",
+		CriticalLevel: "CRITICAL",
+		DPanicLevel:   "CRITICAL",
+		PanicLevel:    "ALERT",
+		FatalLevel:    "EMERGENCY",
+		Level(-10):    "DEBUG",
+		Level(20):     "EMERGENCY",
+	}
+	for lvl, want := range tests {
+		assertAppended(t, want, func(arr ArrayEncoder) { GCPLevelEncoder(lvl, arr) }, "Unexpected severity for %v.", lvl)
+	}
+
+	var enc LevelEncoder
+	require.NoError(t, enc.UnmarshalText([]byte("gcp")), "Unexpected error unmarshaling level encoder.")
+	assertAppended(t, "WARNING", func(arr ArrayEncoder) { enc(WarnLevel, arr) }, "Expected GCPLevelEncoder.")
+}
+
+// primitiveOnlyEncoder records the strings written to it. It can't append
+// objects, and its other methods are left unimplemented.
+type primitiveOnlyEncoder struct {
+	PrimitiveArrayEncoder
+
+	strings []string
+}
+
+func (e *primitiveOnlyEncoder) WriteString(s string) {
+	e.strings = append(e.strings, s)
+}
+
+func TestGCPEncodersFallBack(t *testing.T) {
+	// Primitive-only encoders can't hold objects, so the GCP encoders fall
+	// back to strings.
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	caller := EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42}
+	var arr primitiveOnlyEncoder
+	GCPTimeEncoder(ts, &arr)
+	GCPCallerEncoder(caller, &arr)
+	assert.Equal(t, []string{"2020-01-02T03:04:05.000000006Z", "/src/app/main.go:42"}, arr.strings, "Unexpected fallbacks.")
+
+	var timeEnc TimeEncoder
+	require.NoError(t, timeEnc.UnmarshalText([]byte("gcp")), "Unexpected error unmarshaling time encoder.")
+	assertAppended(t, map[string]interface{}{"seconds": int64(1577934245), "nanos": 6},
+		func(arr ArrayEncoder) { timeEnc(ts, arr) }, "Expected GCPTimeEncoder.")
+	var callerEnc CallerEncoder
+	require.NoError(t, callerEnc.UnmarshalText([]byte("gcp")), "Unexpected error unmarshaling caller encoder.")
+	assertAppended(t, map[string]interface{}{"file": "/src/app/main.go", "line": "42"},
+		func(arr ArrayEncoder) { callerEnc(caller, arr) }, "Expected GCPCallerEncoder.")
+}
+
+func TestGCPEncoderErrorReporting(t *testing.T) {
+	cfg := gcpTestEncoderConfig()
+	cfg.TimeKey = ""
+	enc := NewGCPEncoder(cfg)
+
+	fields := make([]Field, 1, 2)
+	fields[0] = viper.String("k", "v")
+	out := encodeGCP(t, enc.Clone(), Entry{Level: ErrorLevel, Message: "failed", Stack: "stack"}, fields...)
+	assert.Equal(t, `{"severity":"ERROR","message":"failed","k":"v","@type":"`+GCPErrorEventType+`","stack_trace":"stack"}`+"\n",
+		out, "Expected an error event.")
+	assert.Equal(t, Field{}, fields[:cap(fields)][1], "Expected the caller's fields to be unmodified.")
+
+	assert.Equal(t, `{"severity":"ERROR","message":"no stack"}`+"\n",
+		encodeGCP(t, enc, Entry{Level: ErrorLevel, Message: "no stack"}), "Expected no error event without a stack.")
+	assert.Equal(t, `{"severity":"WARNING","message":"warn","stack_trace":"stack"}`+"\n",
+		encodeGCP(t, enc, Entry{Level: WarnLevel, Message: "warn", Stack: "stack"}), "Expected no error event below ErrorLevel.")
+}
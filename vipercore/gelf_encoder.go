@@ -0,0 +1,194 @@
+
+
+package vipercore
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gottingen/buffer"
+)
+
+// GELFVersion is the version of the Graylog Extended Log Format that the GELF
+// encoder writes.
+const GELFVersion = "1.1"
+
+// Syslog severities, which GELF uses as levels.
+const (
+	_syslogEmergency = 0
+	_syslogAlert     = 1
+	_syslogCritical  = 2
+	_syslogError     = 3
+	_syslogWarning   = 4
+	_syslogNotice    = 5
+	_syslogInfo      = 6
+	_syslogDebug     = 7
+)
+
+var _levelToSyslogSeverity = map[Level]int{
+	TraceLevel:    _syslogDebug,
+	DebugLevel:    _syslogDebug,
+	InfoLevel:     _syslogInfo,
+	NoticeLevel:   _syslogNotice,
+	WarnLevel:     _syslogWarning,
+	ErrorLevel:    _syslogError,
+	CriticalLevel: _syslogCritical,
+	DPanicLevel:   _syslogCritical,
+	PanicLevel:    _syslogAlert,
+	FatalLevel:    _syslogEmergency,
+}
+
+// A GELFOption configures a GELF encoder.
+type GELFOption interface {
+	applyGELFOption(*gelfEncoder)
+}
+
+type gelfOptionFunc func(*gelfEncoder)
+
+func (f gelfOptionFunc) applyGELFOption(enc *gelfEncoder) {
+	f(enc)
+}
+
+// GELFHost sets the host written with every message, which defaults to the
+// name reported by os.Hostname.
+func GELFHost(host string) GELFOption {
+	return gelfOptionFunc(func(enc *gelfEncoder) {
+		enc.host = host
+	})
+}
+
+type gelfEncoder struct {
+	*treeEncoder
+
+	host string
+}
+
+// NewGELFEncoder creates an encoder that writes each entry as a line holding a
+// Graylog Extended Log Format (GELF) 1.1 message. The message becomes the
+// short_message, the stacktrace the full_message, and the level the syslog
+// severity that GELF expects; the time is written in seconds with millisecond
+// precision, and the logger name and caller as the _logger and _caller
+// additional fields. GELF fixes the names of these parts, so the
+// EncoderConfig's keys only control whether they're written. The short_message is required and always written.
+//
+// Fields become additional fields, prefixed with an underscore. Since GELF
+// values can only be strings and numbers, nested objects and namespaces are
+// flattened, joining their keys with underscores; arrays are written as JSON
+// strings, booleans as the strings "true" and "false", and null values are
+// omitted. Characters GELF doesn't allow in keys are replaced with
+// underscores, and a field named "id", which GELF reserves, is written as
+// "_id_".
+func NewGELFEncoder(cfg EncoderConfig, opts ...GELFOption) Encoder {
+	enc := &gelfEncoder{treeEncoder: newTreeEncoder(&cfg)}
+	for _, opt := range opts {
+		opt.applyGELFOption(enc)
+	}
+	if enc.host == "" {
+		enc.host, _ = os.Hostname()
+	}
+	if enc.host == "" {
+		enc.host = "localhost"
+	}
+	return enc
+}
+
+func (c *gelfEncoder) Clone() Encoder {
+	return &gelfEncoder{
+		treeEncoder: c.treeEncoder.clone(),
+		host:        c.host,
+	}
+}
+
+func (c *gelfEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	obj := c.withFields(fields)
+
+	out := newJSONEncoder(*c.EncoderConfig, false)
+	out.buf.WriteByte('{')
+	out.AddString("version", GELFVersion)
+	out.AddString("host", c.host)
+	out.AddString("short_message", ent.Message)
+	if ent.Stack != "" && c.StacktraceKey != "" {
+		out.AddString("full_message", ent.Stack)
+	}
+	if c.TimeKey != "" && !ent.Time.IsZero() {
+		// GELF timestamps are seconds with millisecond precision.
+		out.AddFloat64("timestamp", float64(ent.Time.UnixNano()/int64(time.Millisecond))/1e3)
+	}
+	if c.LevelKey != "" {
+		out.AddInt("level", syslogSeverity(ent.Level))
+	}
+	if ent.LoggerName != "" && c.NameKey != "" {
+		out.AddString("_logger", ent.LoggerName)
+	}
+	if ent.Caller.Defined && c.CallerKey != "" {
+		out.AddString("_caller", ent.Caller.String())
+	}
+	writeGELFFields(out, "", obj)
+	out.buf.WriteByte('}')
+	if c.LineEnding != "" {
+		out.buf.WriteString(c.LineEnding)
+	} else {
+		out.buf.WriteString(DefaultLineEnding)
+	}
+	return out.buf, nil
+}
+
+// syslogSeverity maps a level to a syslog severity. Custom levels beyond the
+// built-in ones get the lowest or highest severity.
+func syslogSeverity(l Level) int {
+	if s, ok := _levelToSyslogSeverity[l]; ok {
+		return s
+	}
+	if l < TraceLevel {
+		return _syslogDebug
+	}
+	return _syslogEmergency
+}
+
+// writeGELFFields writes decoded fields as additional fields, flattening
+// nested objects.
+func writeGELFFields(out *jsonEncoder, prefix string, obj decodedObject) {
+	for _, kv := range obj {
+		key := prefix + "_" + gelfKey(kv.key)
+		switch v := kv.value.(type) {
+		case string:
+			out.AddString(gelfReservedKey(key), v)
+		case bool:
+			out.AddString(gelfReservedKey(key), strconv.FormatBool(v))
+		case json.Number:
+			out.addKey(gelfReservedKey(key))
+			out.buf.WriteString(string(v))
+		case decodedObject:
+			writeGELFFields(out, key, v)
+		case decodedArray:
+			// GELF values can't be arrays, so write their JSON as a string.
+			arr := newJSONEncoder(*out.EncoderConfig, false)
+			arr.AppendArray(v)
+			out.AddString(gelfReservedKey(key), arr.buf.String())
+			buffer.Put(arr.buf)
+		}
+	}
+}
+
+// gelfKey replaces the characters that GELF doesn't allow in keys.
+func gelfKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		case r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+func gelfReservedKey(key string) string {
+	if key == "_id" {
+		return "_id_"
+	}
+	return key
+}
@@ -0,0 +1,97 @@
+package vipercore_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func encodeGELF(t testing.TB, enc Encoder, ent Entry, fields ...Field) string {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buffer.Put(buf)
+	require.True(t, json.Valid(buf.Bytes()), "Encoded entry isn't valid JSON: %s", buf.String())
+	return buf.String()
+}
+
+func TestGELFEncoderEntry(t *testing.T) {
+	enc := NewGELFEncoder(testEncoderConfig(), GELFHost("web-1"))
+	enc.AddString("request", "abc")
+	enc.OpenNamespace("http")
+	enc.AddInt("status", 500)
+
+	out := encodeGELF(t, enc, Entry{
+		Level:      ErrorLevel,
+		Time:       time.Unix(1500000000, 250000000),
+		LoggerName: "bob",
+		Message:    "lob law",
+		Caller:     EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42},
+		Stack:      "fake-stack",
+	},
+		viper.Float64("pi", 3.14),
+		viper.Bool("ok", true),
+		viper.Strings("tags", []string{"a", "b"}),
+		viper.Reflect("nothing", nil),
+		viper.String("user id", "u1"),
+	)
+	assert.Equal(t, `{"version":"1.1","host":"web-1","short_message":"lob law","full_message":"fake-stack",`+
+		`"timestamp":1500000000.25,"level":3,"_logger":"bob","_caller":"/src/app/main.go:42",`+
+		`"_request":"abc","_http_status":500,"_http_pi":3.14,"_http_ok":"true",`+
+		`"_http_tags":"[\"a\",\"b\"]","_http_user_id":"u1"}`+"\n", out, "Unexpected GELF JSON.")
+}
+
+func TestGELFEncoderLevels(t *testing.T) {
+	enc := NewGELFEncoder(testEncoderConfig(), GELFHost("h"))
+	tests := []struct {
+		level    Level
+		severity int
+	}{
+		{TraceLevel, 7},
+		{DebugLevel, 7},
+		{InfoLevel, 6},
+		{NoticeLevel, 5},
+		{WarnLevel, 4},
+		{ErrorLevel, 3},
+		{CriticalLevel, 2},
+		{DPanicLevel, 2},
+		{PanicLevel, 1},
+		{FatalLevel, 0},
+		{Level(-10), 7},
+		{Level(20), 0},
+	}
+	for _, tt := range tests {
+		var msg struct {
+			Level int `json:"level"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(encodeGELF(t, enc, Entry{Level: tt.level})), &msg), "Unexpected error unmarshaling GELF JSON.")
+		assert.Equal(t, tt.severity, msg.Level, "Unexpected severity for %v.", tt.level)
+	}
+}
+
+func TestGELFEncoderKeys(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.LevelKey = ""
+	cfg.TimeKey = ""
+	cfg.NameKey = ""
+	cfg.CallerKey = ""
+	cfg.StacktraceKey = ""
+	enc := NewGELFEncoder(cfg, GELFHost("h"))
+
+	out := encodeGELF(t, enc, Entry{
+		Level:      ErrorLevel,
+		Time:       time.Unix(1, 0),
+		LoggerName: "name",
+		Message:    "msg",
+		Caller:     EntryCaller{Defined: true, File: "f.go", Line: 1},
+		Stack:      "stack",
+	}, viper.String("id", "reserved"), viper.String("ünïcode/key", "v"))
+	assert.Equal(t, `{"version":"1.1","host":"h","short_message":"msg","_id_":"reserved","__n_code_key":"v"}`+"\n",
+		out, "Unexpected GELF JSON.")
+}
@@ -0,0 +1,167 @@
+
+
+package vipercore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/gottingen/buffer"
+)
+
+// The journal allows field names of at most 64 characters.
+const _journaldMaxKeyLength = 64
+
+type journaldEncoder struct {
+	*treeEncoder
+
+	// The journal field names of the logger name and stacktrace, which fields
+	// mustn't take.
+	nameKey  string
+	stackKey string
+}
+
+// NewJournaldEncoder creates an encoder that writes each entry in the native
+// protocol of systemd-journald, which keeps every field of the entry as a
+// separate journal field. It's meant to be used with the "journald" sink,
+// which sends each entry to the journal as a datagram.
+//
+// The message becomes MESSAGE, the level the syslog severity in PRIORITY, and
+// the caller CODE_FILE, CODE_LINE, and, if known, CODE_FUNC; the journal
+// records the time itself. The EncoderConfig's keys control whether these are
+// written, and the logger name and stacktrace are written under their keys.
+// Fields are flattened like the GELF encoder's, joining the keys of nested
+// objects and namespaces with underscores, and arrays are written as JSON.
+// Since journal field names may only hold uppercase letters, digits, and
+// underscores, keys are uppercased, other characters are replaced with
+// underscores, and the leading underscores that mark fields trusted by the
+// journal are dropped. Fields whose names would collide with the entry's own,
+// like a "message" field, are prefixed with X_, as in X_MESSAGE.
+func NewJournaldEncoder(cfg EncoderConfig) Encoder {
+	c := journaldEncoder{treeEncoder: newTreeEncoder(&cfg)}
+	if cfg.NameKey != "" {
+		c.nameKey = journaldKey(cfg.NameKey)
+	}
+	if cfg.StacktraceKey != "" {
+		c.stackKey = journaldKey(cfg.StacktraceKey)
+	}
+	return c
+}
+
+func (c journaldEncoder) Clone() Encoder {
+	return journaldEncoder{
+		treeEncoder: c.treeEncoder.clone(),
+		nameKey:     c.nameKey,
+		stackKey:    c.stackKey,
+	}
+}
+
+func (c journaldEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	obj := c.withFields(fields)
+
+	buf := buffer.Get()
+	if c.MessageKey != "" {
+		writeJournaldField(buf, "MESSAGE", ent.Message)
+	}
+	if c.LevelKey != "" {
+		writeJournaldField(buf, "PRIORITY", strconv.Itoa(syslogSeverity(ent.Level)))
+	}
+	if ent.LoggerName != "" && c.NameKey != "" {
+		writeJournaldField(buf, c.nameKey, ent.LoggerName)
+	}
+	if ent.Caller.Defined && c.CallerKey != "" {
+		writeJournaldField(buf, "CODE_FILE", ent.Caller.File)
+		writeJournaldField(buf, "CODE_LINE", strconv.Itoa(ent.Caller.Line))
+		if ent.Caller.PC != 0 {
+			if fn := runtime.FuncForPC(ent.Caller.PC); fn != nil {
+				writeJournaldField(buf, "CODE_FUNC", fn.Name())
+			}
+		}
+	}
+	if ent.Stack != "" && c.StacktraceKey != "" {
+		writeJournaldField(buf, c.stackKey, ent.Stack)
+	}
+	c.writeFields(buf, "", obj)
+	return buf, nil
+}
+
+// writeFields writes decoded fields, flattening nested objects.
+func (c journaldEncoder) writeFields(buf *buffer.Buffer, prefix string, obj decodedObject) {
+	for _, kv := range obj {
+		key := prefix + "_" + kv.key
+		switch v := kv.value.(type) {
+		case string:
+			writeJournaldField(buf, c.fieldKey(key), v)
+		case bool:
+			writeJournaldField(buf, c.fieldKey(key), strconv.FormatBool(v))
+		case json.Number:
+			writeJournaldField(buf, c.fieldKey(key), string(v))
+		case decodedObject:
+			c.writeFields(buf, key, v)
+		case decodedArray:
+			arr := newJSONEncoder(*c.EncoderConfig, false)
+			arr.AppendArray(v)
+			writeJournaldField(buf, c.fieldKey(key), arr.buf.String())
+			buffer.Put(arr.buf)
+		}
+	}
+}
+
+// fieldKey turns a field's key into a journal field name, prefixing the
+// names of the entry's own fields so that they can't be overwritten.
+func (c journaldEncoder) fieldKey(key string) string {
+	key = journaldKey(key)
+	switch key {
+	case "MESSAGE", "PRIORITY", "CODE_FILE", "CODE_LINE", "CODE_FUNC", c.nameKey, c.stackKey:
+		key = "X_" + key
+		if len(key) > _journaldMaxKeyLength {
+			key = key[:_journaldMaxKeyLength]
+		}
+	}
+	return key
+}
+
+// writeJournaldField writes a field as KEY=value and a newline. Values that
+// hold newlines are written in the protocol's binary form instead: the key,
+// a newline, the value's length as a little-endian 64-bit integer, the value,
+// and a newline.
+func writeJournaldField(buf *buffer.Buffer, key, value string) {
+	buf.WriteString(key)
+	if strings.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.WriteByte('\n')
+	buf.Write(size[:])
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journaldKey turns a key into a valid journal field name.
+func journaldKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		switch {
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, key)
+	key = strings.TrimLeft(key, "_")
+	if key == "" || ('0' <= key[0] && key[0] <= '9') {
+		// Field names can't be empty or start with a digit.
+		key = "X_" + key
+	}
+	if len(key) > _journaldMaxKeyLength {
+		key = key[:_journaldMaxKeyLength]
+	}
+	return key
+}
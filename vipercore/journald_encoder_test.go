@@ -0,0 +1,106 @@
+package vipercore_test
+
+import (
+	"encoding/binary"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func encodeJournald(t testing.TB, enc Encoder, ent Entry, fields ...Field) string {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buffer.Put(buf)
+	return buf.String()
+}
+
+func TestJournaldEncoderEntry(t *testing.T) {
+	pc, _, _, ok := runtime.Caller(0)
+	require.True(t, ok, "Couldn't get the caller.")
+	enc := NewJournaldEncoder(testEncoderConfig())
+	enc.AddString("request", "abc")
+	enc.OpenNamespace("http")
+	enc.AddInt("status", 500)
+
+	out := encodeJournald(t, enc, Entry{
+		Level:      WarnLevel,
+		LoggerName: "bob",
+		Message:    "lob law",
+		Caller:     EntryCaller{Defined: true, PC: pc, File: "/src/app/main.go", Line: 42},
+	},
+		viper.Bool("ok", true),
+		viper.Strings("tags", []string{"a", "b"}),
+		viper.Reflect("nothing", nil),
+		viper.String("user-id", "u1"),
+	)
+	assert.Equal(t, "MESSAGE=lob law\nPRIORITY=4\nNAME=bob\n"+
+		"CODE_FILE=/src/app/main.go\nCODE_LINE=42\n"+
+		"CODE_FUNC=github.com/gottingen/viper/vipercore_test.TestJournaldEncoderEntry\n"+
+		"REQUEST=abc\nHTTP_STATUS=500\nHTTP_OK=true\n"+
+		`HTTP_TAGS=["a","b"]`+"\nHTTP_USER_ID=u1\n", out, "Unexpected journal fields.")
+}
+
+func TestJournaldEncoderMultilineValues(t *testing.T) {
+	enc := NewJournaldEncoder(testEncoderConfig())
+	out := encodeJournald(t, enc, Entry{Level: ErrorLevel, Message: "one\ntwo", Stack: "stack"})
+
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, 7)
+	assert.Equal(t, "MESSAGE\n"+string(size)+"one\ntwo\nPRIORITY=3\nSTACKTRACE=stack\n", out, "Unexpected binary field.")
+}
+
+func TestJournaldEncoderKeys(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.MessageKey = ""
+	cfg.LevelKey = ""
+	cfg.NameKey = ""
+	cfg.CallerKey = ""
+	cfg.StacktraceKey = ""
+	enc := NewJournaldEncoder(cfg)
+
+	out := encodeJournald(t, enc, Entry{
+		Level:      ErrorLevel,
+		LoggerName: "name",
+		Message:    "msg",
+		Caller:     EntryCaller{Defined: true, File: "f.go", Line: 1},
+		Stack:      "stack",
+	},
+		viper.String("_trusted", "a"),
+		viper.String("1st", "b"),
+		viper.String("ünïcode.key", "c"),
+		viper.String("", "d"),
+		viper.String(strings.Repeat("k", 70), "e"),
+	)
+	assert.Equal(t, "TRUSTED=a\nX_1ST=b\nN_CODE_KEY=c\nX_=d\n"+strings.Repeat("K", 64)+"=e\n", out, "Unexpected journal fields.")
+}
+
+func TestJournaldEncoderReservedKeys(t *testing.T) {
+	enc := NewJournaldEncoder(testEncoderConfig())
+	out := encodeJournald(t, enc, Entry{
+		Level:      InfoLevel,
+		LoggerName: "bob",
+		Message:    "lob law",
+		Caller:     EntryCaller{Defined: true, File: "main.go", Line: 42},
+	},
+		viper.String("message", "a"),
+		viper.String("priority", "b"),
+		viper.Int("code_line", 7),
+		viper.String("Name", "c"),
+		viper.String("stacktrace", "d"),
+		viper.String("messages", "e"),
+	)
+	assert.Equal(t, "MESSAGE=lob law\nPRIORITY=6\nNAME=bob\nCODE_FILE=main.go\nCODE_LINE=42\n"+
+		"X_MESSAGE=a\nX_PRIORITY=b\nX_CODE_LINE=7\nX_NAME=c\nX_STACKTRACE=d\nMESSAGES=e\n", out, "Expected colliding fields to be prefixed.")
+
+	cfg := testEncoderConfig()
+	cfg.NameKey = ""
+	out = encodeJournald(t, NewJournaldEncoder(cfg), Entry{Message: "msg"}, viper.String("name", "a"))
+	assert.Equal(t, "MESSAGE=msg\nPRIORITY=6\nNAME=a\n", out, "Expected the name to be free without a NameKey.")
+}
@@ -0,0 +1,356 @@
+
+
+package vipercore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Decoder parses a single encoded log entry back into an Entry and its
+// fields. It's the inverse of an Encoder, which lets tools replay, re-encode,
+// and make assertions about logs that have already been written.
+type Decoder interface {
+	Decode(line []byte) (Entry, []Field, error)
+}
+
+type jsonDecoder struct {
+	*EncoderConfig
+}
+
+// NewJSONDecoder creates a Decoder for lines written by the JSON encoder with
+// the given EncoderConfig. The configured keys identify the entry's level,
+// time, logger name, caller, message, and stacktrace; all other keys become
+// fields.
+//
+// Since JSON doesn't record Go types, fields are decoded on a best-effort
+// basis: strings, booleans, and numbers become String, Bool, and Int64 or
+// Float64 fields, nulls become nil Reflect fields, and objects and arrays
+// become marshalers that re-encode their contents in their original order.
+// Times are decoded according to cfg.EncodeTime; durations are left as
+// numbers or strings.
+func NewJSONDecoder(cfg EncoderConfig) Decoder {
+	return &jsonDecoder{&cfg}
+}
+
+func (dec *jsonDecoder) Decode(line []byte) (Entry, []Field, error) {
+	var ent Entry
+
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.UseNumber()
+	val, err := decodeJSONValue(d)
+	if err != nil {
+		return ent, nil, fmt.Errorf("can't decode JSON log entry: %v", err)
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return ent, nil, errors.New("can't decode JSON log entry: unexpected data after top-level object")
+	}
+	obj, ok := val.(decodedObject)
+	if !ok {
+		return ent, nil, errors.New("can't decode JSON log entry: not an object")
+	}
+
+	ent, fields := decodeEntry(dec.EncoderConfig, obj)
+	return ent, fields, nil
+}
+
+// decodeEntry splits the decoded key-value pairs of a log line into an Entry
+// and its fields.
+func decodeEntry(cfg *EncoderConfig, obj decodedObject) (Entry, []Field) {
+	var (
+		ent    Entry
+		fields []Field
+		seen   = make(map[string]bool, 6)
+	)
+	for _, kv := range obj {
+		if kv.key != "" && !seen[kv.key] && decodeEntryKey(cfg, &ent, kv) {
+			seen[kv.key] = true
+			continue
+		}
+		fields = append(fields, decodedField(kv.key, kv.value))
+	}
+	return ent, fields
+}
+
+// decodeEntryKey sets the part of the Entry identified by the key, if any.
+// Values that can't be decoded are left as fields.
+func decodeEntryKey(cfg *EncoderConfig, ent *Entry, kv decodedKV) bool {
+	switch kv.key {
+	case cfg.LevelKey:
+		s, ok := kv.value.(string)
+		if !ok {
+			return false
+		}
+		lvl, err := decodeLevel(s)
+		if err != nil {
+			return false
+		}
+		ent.Level = lvl
+	case cfg.TimeKey:
+		t, err := decodeTime(cfg, kv.value)
+		if err != nil {
+			return false
+		}
+		ent.Time = t
+	case cfg.NameKey:
+		s, ok := kv.value.(string)
+		if !ok {
+			return false
+		}
+		ent.LoggerName = s
+	case cfg.CallerKey:
+		s, ok := kv.value.(string)
+		if !ok {
+			return false
+		}
+		ent.Caller = decodeCaller(s)
+	case cfg.MessageKey:
+		s, ok := kv.value.(string)
+		if !ok {
+			return false
+		}
+		ent.Message = s
+	case cfg.StacktraceKey:
+		s, ok := kv.value.(string)
+		if !ok {
+			return false
+		}
+		ent.Stack = s
+	default:
+		return false
+	}
+	return true
+}
+
+var (
+	_ansiEscapes  = regexp.MustCompile("\x1b\\[[0-9;]*m")
+	_unknownLevel = regexp.MustCompile(`^(?i:level)\((-?\d+)\)$`)
+)
+
+func decodeLevel(s string) (Level, error) {
+	s = _ansiEscapes.ReplaceAllString(s, "")
+	var lvl Level
+	if lvl.unmarshalText([]byte(s)) || lvl.unmarshalText([]byte(strings.ToLower(s))) {
+		return lvl, nil
+	}
+	if m := _unknownLevel.FindStringSubmatch(s); m != nil {
+		if n, err := strconv.ParseInt(m[1], 10, 8); err == nil {
+			return Level(n), nil
+		}
+	}
+	return lvl, fmt.Errorf("can't decode level %q", s)
+}
+
+func decodeCaller(s string) EntryCaller {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return EntryCaller{Defined: true, File: s}
+	}
+	line, err := strconv.Atoi(s[i+1:])
+	if err != nil {
+		return EntryCaller{Defined: true, File: s}
+	}
+	return EntryCaller{Defined: true, File: s[:i], Line: line}
+}
+
+func sameFunc(a, b interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+func decodeTime(cfg *EncoderConfig, v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("can't decode time %q: %v", v, err)
+		}
+		switch {
+		case cfg.EncodeTime != nil && sameFunc(cfg.EncodeTime, EpochNanosTimeEncoder):
+			n, err := v.Int64()
+			if err != nil {
+				return time.Time{}, fmt.Errorf("can't decode time %q: %v", v, err)
+			}
+			return time.Unix(0, n), nil
+		case cfg.EncodeTime != nil && sameFunc(cfg.EncodeTime, EpochMillisTimeEncoder):
+			return unixFloat(f/1e3, time.Millisecond), nil
+		default:
+			return unixFloat(f, time.Microsecond), nil
+		}
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z0700"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("can't decode time %q", v)
+	case time.Time:
+		return v, nil
+	default:
+		return time.Time{}, fmt.Errorf("can't decode time from %v", v)
+	}
+}
+
+// unixFloat converts floating-point seconds since the epoch to a time,
+// rounding away the noise below the float's precision.
+func unixFloat(sec float64, precision time.Duration) time.Time {
+	whole := math.Floor(sec)
+	nanos := time.Duration(math.Round((sec - whole) * 1e9)).Round(precision)
+	return time.Unix(int64(whole), int64(nanos))
+}
+
+// decodedKV is a key-value pair of a JSON object.
+type decodedKV struct {
+	key   string
+	value interface{}
+}
+
+// decodedObject is a JSON object that remembers the order of its keys.
+type decodedObject []decodedKV
+
+func (o decodedObject) MarshalLogObject(enc ObjectEncoder) error {
+	for _, kv := range o {
+		decodedField(kv.key, kv.value).AddTo(enc)
+	}
+	return nil
+}
+
+// decodedArray is a JSON array.
+type decodedArray []interface{}
+
+func (a decodedArray) MarshalLogArray(enc ArrayEncoder) error {
+	for _, v := range a {
+		switch v := v.(type) {
+		case string:
+			enc.WriteString(v)
+		case bool:
+			enc.WriteBool(v)
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				enc.WriteInt64(i)
+			} else {
+				f, _ := v.Float64()
+				enc.WriteFloat64(f)
+			}
+		case int64:
+			enc.WriteInt64(v)
+		case uint64:
+			enc.WriteUint64(v)
+		case float64:
+			enc.WriteFloat64(v)
+		case float32:
+			enc.WriteFloat32(v)
+		case time.Time:
+			enc.AppendTime(v)
+		case time.Duration:
+			enc.AppendDuration(v)
+		case complex128:
+			enc.AppendComplex128(v)
+		case decodedObject:
+			if err := enc.AppendObject(v); err != nil {
+				return err
+			}
+		case decodedArray:
+			if err := enc.AppendArray(v); err != nil {
+				return err
+			}
+		default:
+			if err := enc.AppendReflected(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodedField(key string, v interface{}) Field {
+	switch v := v.(type) {
+	case string:
+		return Field{Key: key, Type: StringType, String: v}
+	case bool:
+		var i int64
+		if v {
+			i = 1
+		}
+		return Field{Key: key, Type: BoolType, Integer: i}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return Field{Key: key, Type: Int64Type, Integer: i}
+		}
+		f, _ := v.Float64()
+		return Field{Key: key, Type: Float64Type, Integer: int64(math.Float64bits(f))}
+	case int64:
+		return Field{Key: key, Type: Int64Type, Integer: v}
+	case uint64:
+		return Field{Key: key, Type: Uint64Type, Integer: int64(v)}
+	case float64:
+		return Field{Key: key, Type: Float64Type, Integer: int64(math.Float64bits(v))}
+	case float32:
+		return Field{Key: key, Type: Float32Type, Integer: int64(math.Float32bits(v))}
+	case []byte:
+		return Field{Key: key, Type: BinaryType, Interface: v}
+	case time.Time:
+		return Field{Key: key, Type: TimeType, Integer: v.UnixNano(), Interface: v.Location()}
+	case time.Duration:
+		return Field{Key: key, Type: DurationType, Integer: int64(v)}
+	case complex128:
+		return Field{Key: key, Type: Complex128Type, Interface: v}
+	case decodedObject:
+		return Field{Key: key, Type: ObjectMarshalerType, Interface: v}
+	case decodedArray:
+		return Field{Key: key, Type: ArrayMarshalerType, Interface: v}
+	default:
+		return Field{Key: key, Type: ReflectType, Interface: v}
+	}
+}
+
+// decodeJSONValue reads the next value from d, preserving the order of object
+// keys.
+func decodeJSONValue(d *json.Decoder) (interface{}, error) {
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := decodedObject{}
+		for d.More() {
+			keyTok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			val, err := decodeJSONValue(d)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, decodedKV{key: keyTok.(string), value: val})
+		}
+		if _, err := d.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case json.Delim('['):
+		arr := decodedArray{}
+		for d.More() {
+			val, err := decodeJSONValue(d)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+		if _, err := d.Token(); err != nil {
+			return nil, err
+		}
+		return arr, nil
+	default:
+		return tok, nil
+	}
+}
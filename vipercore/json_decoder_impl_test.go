@@ -0,0 +1,42 @@
+
+
+package vipercore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONDecoderLevels(t *testing.T) {
+	const verboseLevel = Level(-10)
+	require.NoError(t, RegisterLevel(verboseLevel, "verbose"), "Unexpected error registering level.")
+	defer func() {
+		_customLevelsMu.Lock()
+		delete(_customLevels, verboseLevel)
+		delete(_customLevelByName, "verbose")
+		_customLevelsMu.Unlock()
+	}()
+
+	tests := []struct {
+		encoded string
+		want    Level
+	}{
+		{"info", InfoLevel},
+		{"CRITICAL", CriticalLevel},
+		{`\u001b[31mERROR\u001b[0m`, ErrorLevel},
+		{"verbose", verboseLevel},
+		{"VERBOSE", verboseLevel},
+		{"Level(42)", Level(42)},
+		{"LEVEL(-42)", Level(-42)},
+	}
+
+	dec := NewJSONDecoder(EncoderConfig{LevelKey: "level"})
+	for _, tt := range tests {
+		ent, fields, err := dec.Decode([]byte(`{"level":"` + tt.encoded + `"}`))
+		require.NoError(t, err, "Unexpected error decoding level %q.", tt.encoded)
+		assert.Equal(t, tt.want, ent.Level, "Unexpected level decoded from %q.", tt.encoded)
+		assert.Empty(t, fields, "Unexpected fields decoding level %q.", tt.encoded)
+	}
+}
@@ -0,0 +1,129 @@
+
+
+package vipercore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func TestJSONDecoderRoundTrip(t *testing.T) {
+	type bar struct {
+		Key string  `json:"key"`
+		Val float64 `json:"val"`
+	}
+
+	cfg := testEncoderConfig()
+	cfg.EncodeCaller = FullCallerEncoder
+	ent := Entry{
+		Level:      WarnLevel,
+		Time:       time.Unix(1500000000, 123000000),
+		LoggerName: "bob",
+		Message:    "lob law",
+		Caller:     EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42},
+		Stack:      "fake-stack",
+	}
+	fields := []Field{
+		viper.String("so", "passes"),
+		viper.Int("answer", 42),
+		viper.Float64("pi", 3.14),
+		viper.Bool("ok", true),
+		viper.Strings("tags", []string{"a", "b"}),
+		viper.Reflect("bars", []bar{{Key: "k", Val: 1.5}}),
+		viper.Reflect("nothing", nil),
+		viper.Namespace("ns"),
+		viper.Int("nested", 1),
+	}
+
+	enc := NewJSONEncoder(cfg)
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	encoded := buf.String()
+
+	decodedEnt, decodedFields, err := NewJSONDecoder(cfg).Decode(buf.Bytes())
+	require.NoError(t, err, "Unexpected error decoding entry.")
+
+	assert.Equal(t, ent.Level, decodedEnt.Level, "Unexpected level.")
+	assert.True(t, ent.Time.Equal(decodedEnt.Time), "Unexpected time %v.", decodedEnt.Time)
+	assert.Equal(t, ent.LoggerName, decodedEnt.LoggerName, "Unexpected logger name.")
+	assert.Equal(t, ent.Message, decodedEnt.Message, "Unexpected message.")
+	assert.Equal(t, ent.Caller, decodedEnt.Caller, "Unexpected caller.")
+	assert.Equal(t, ent.Stack, decodedEnt.Stack, "Unexpected stack.")
+
+	require.Equal(t, 8, len(decodedFields), "Unexpected number of fields.")
+	assert.Equal(t, viper.String("so", "passes"), decodedFields[0], "Unexpected string field.")
+	assert.Equal(t, viper.Int64("answer", 42), decodedFields[1], "Unexpected integer field.")
+	assert.Equal(t, viper.Float64("pi", 3.14), decodedFields[2], "Unexpected float field.")
+	assert.Equal(t, viper.Bool("ok", true), decodedFields[3], "Unexpected bool field.")
+	assert.Equal(t, ArrayMarshalerType, decodedFields[4].Type, "Unexpected array field type.")
+	assert.Equal(t, ArrayMarshalerType, decodedFields[5].Type, "Unexpected array field type.")
+	assert.Equal(t, viper.Reflect("nothing", nil), decodedFields[6], "Unexpected null field.")
+	assert.Equal(t, ObjectMarshalerType, decodedFields[7].Type, "Expected namespaces to be decoded as objects.")
+
+	buffer.Put(buf)
+	buf, err = enc.EncodeEntry(decodedEnt, decodedFields)
+	require.NoError(t, err, "Unexpected error re-encoding entry.")
+	assert.Equal(t, encoded, buf.String(), "Expected re-encoded entry to match the original.")
+}
+
+func TestJSONDecoderTimes(t *testing.T) {
+	ts := time.Date(2020, 6, 15, 12, 30, 45, 123000000, time.UTC)
+	tests := []struct {
+		name string
+		enc  TimeEncoder
+	}{
+		{"epoch", EpochTimeEncoder},
+		{"millis", EpochMillisTimeEncoder},
+		{"nanos", EpochNanosTimeEncoder},
+		{"ISO8601", ISO8601TimeEncoder},
+		{"RFC3339Nano", RFC3339NanoTimeEncoder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testEncoderConfig()
+			cfg.EncodeTime = tt.enc
+			buf, err := NewJSONEncoder(cfg).EncodeEntry(Entry{Time: ts}, nil)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+
+			ent, fields, err := NewJSONDecoder(cfg).Decode(buf.Bytes())
+			require.NoError(t, err, "Unexpected error decoding entry.")
+			assert.True(t, ts.Equal(ent.Time), "Expected %v, got %v.", ts, ent.Time)
+			assert.Empty(t, fields, "Unexpected fields.")
+		})
+	}
+
+	cfg := testEncoderConfig()
+	cfg.EncodeTime = RFC3339TimeEncoder
+	ent, _, err := NewJSONDecoder(cfg).Decode([]byte(`{"ts":"2020-06-15T12:30:45Z"}`))
+	require.NoError(t, err, "Unexpected error decoding entry.")
+	assert.True(t, ts.Truncate(time.Second).Equal(ent.Time), "Unexpected RFC3339 time %v.", ent.Time)
+}
+
+func TestJSONDecoderUndecodableEntryKeys(t *testing.T) {
+	dec := NewJSONDecoder(testEncoderConfig())
+	ent, fields, err := dec.Decode([]byte(`{"level":"nope","ts":"yesterday","msg":1,"msg":"second"}`))
+	require.NoError(t, err, "Unexpected error decoding entry.")
+	assert.Equal(t, InfoLevel, ent.Level, "Expected the default level.")
+	assert.Equal(t, "second", ent.Message, "Expected the first decodable message.")
+	assert.Equal(t, []Field{
+		viper.String("level", "nope"),
+		viper.String("ts", "yesterday"),
+		viper.Int64("msg", 1),
+	}, fields, "Expected undecodable entry keys to be kept as fields.")
+}
+
+func TestJSONDecoderErrors(t *testing.T) {
+	dec := NewJSONDecoder(testEncoderConfig())
+	for _, line := range []string{``, `{`, `[1, 2]`, `"msg"`, `{"msg":"a"} {"msg":"b"}`} {
+		_, _, err := dec.Decode([]byte(line))
+		assert.Error(t, err, "Expected an error decoding %q.", line)
+	}
+}
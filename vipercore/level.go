@@ -6,11 +6,34 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gottingen/viper/internal/color"
 )
 
 var errUnmarshalNilLevel = errors.New("can't unmarshal a nil *Level")
 
-// A Level is a logging priority. Higher levels are more important.
+var (
+	_customLevelsMu    sync.RWMutex
+	_customLevels      = make(map[Level]customLevel)
+	_customLevelByName = make(map[string]Level)
+)
+
+// customLevel holds the precomputed representations of a Level registered
+// with RegisterLevel.
+type customLevel struct {
+	lower, capital           string
+	lowerColor, capitalColor string
+}
+
+// A Level is a logging priority.
+//
+// TraceLevel, NoticeLevel, and CriticalLevel were added after the others, and
+// are numbered below DebugLevel so that existing levels keep their values and
+// FatalLevel+1 stays above every built-in level. Levels are ordered by
+// importance rather than by value, so compare them with Enabled instead of <
+// and >.
 type Level int8
 
 const (
@@ -32,22 +55,67 @@ const (
 	PanicLevel
 	// FatalLevel logs a message, then calls os.Exit(1).
 	FatalLevel
+)
+
+const (
+	// TraceLevel logs are finer-grained than Debug, and are usually only
+	// enabled while chasing down a specific problem.
+	TraceLevel Level = -2
+	// NoticeLevel logs are normal but significant events, more important than
+	// Info but not indicative of a problem.
+	NoticeLevel Level = -3
+	// CriticalLevel logs are errors that need immediate attention, but unlike
+	// DPanic, Panic, and Fatal they never interrupt the program.
+	CriticalLevel Level = -4
 
-	_minLevel = DebugLevel
+	_minLevel = CriticalLevel
 	_maxLevel = FatalLevel
 )
 
+// _levelSeverities ranks the built-in levels by importance, indexed by value.
+// Custom levels are ranked by their value times _severityScale, so that those
+// below the built-in values are less important than every built-in level and
+// those above are more important.
+var _levelSeverities = [_numLevels]int{
+	TraceLevel - _minLevel:    -8,
+	DebugLevel - _minLevel:    -4,
+	InfoLevel - _minLevel:     0,
+	NoticeLevel - _minLevel:   2,
+	WarnLevel - _minLevel:     4,
+	ErrorLevel - _minLevel:    8,
+	CriticalLevel - _minLevel: 10,
+	DPanicLevel - _minLevel:   12,
+	PanicLevel - _minLevel:    16,
+	FatalLevel - _minLevel:    20,
+}
+
+const _severityScale = 4
+
+// severity returns the level's rank by importance.
+func (l Level) severity() int {
+	if l >= _minLevel && l <= _maxLevel {
+		return _levelSeverities[l-_minLevel]
+	}
+	return int(l) * _severityScale
+}
+
 // String returns a lower-case ASCII representation of the log level.
 func (l Level) String() string {
 	switch l {
+	case TraceLevel:
+		return "trace"
 	case DebugLevel:
 		return "debug"
 	case InfoLevel:
 		return "info"
+	case NoticeLevel:
+		return "notice"
 	case WarnLevel:
 		return "warn"
 	case ErrorLevel:
 		return "error"
+	case CriticalLevel:
+		return "critical"
 	case DPanicLevel:
 		return "dpanic"
 	case PanicLevel:
@@ -55,6 +123,9 @@ func (l Level) String() string {
 	case FatalLevel:
 		return "fatal"
 	default:
+		if cl, ok := lookupCustomLevel(l); ok {
+			return cl.lower
+		}
 		return fmt.Sprintf("Level(%d)", l)
 	}
 }
@@ -64,14 +135,20 @@ func (l Level) CapitalString() string {
 	// Printing levels in all-caps is common enough that we should export this
 	// functionality.
 	switch l {
+	case TraceLevel:
+		return "TRACE"
 	case DebugLevel:
 		return "DEBUG"
 	case InfoLevel:
 		return "INFO"
+	case NoticeLevel:
+		return "NOTICE"
 	case WarnLevel:
 		return "WARN"
 	case ErrorLevel:
 		return "ERROR"
+	case CriticalLevel:
+		return "CRITICAL"
 	case DPanicLevel:
 		return "DPANIC"
 	case PanicLevel:
@@ -79,6 +156,9 @@ func (l Level) CapitalString() string {
 	case FatalLevel:
 		return "FATAL"
 	default:
+		if cl, ok := lookupCustomLevel(l); ok {
+			return cl.capital
+		}
 		return fmt.Sprintf("LEVEL(%d)", l)
 	}
 }
@@ -107,14 +187,20 @@ func (l *Level) UnmarshalText(text []byte) error {
 
 func (l *Level) unmarshalText(text []byte) bool {
 	switch string(text) {
+	case "trace", "TRACE":
+		*l = TraceLevel
 	case "debug", "DEBUG":
 		*l = DebugLevel
 	case "info", "INFO", "": // make the zero value useful
 		*l = InfoLevel
+	case "notice", "NOTICE":
+		*l = NoticeLevel
 	case "warn", "WARN":
 		*l = WarnLevel
 	case "error", "ERROR":
 		*l = ErrorLevel
+	case "critical", "CRITICAL":
+		*l = CriticalLevel
 	case "dpanic", "DPANIC":
 		*l = DPanicLevel
 	case "panic", "PANIC":
@@ -122,7 +208,13 @@ func (l *Level) unmarshalText(text []byte) bool {
 	case "fatal", "FATAL":
 		*l = FatalLevel
 	default:
-		return false
+		_customLevelsMu.RLock()
+		lvl, ok := _customLevelByName[string(text)]
+		_customLevelsMu.RUnlock()
+		if !ok {
+			return false
+		}
+		*l = lvl
 	}
 	return true
 }
@@ -139,7 +231,7 @@ func (l *Level) Get() interface{} {
 
 // Enabled returns true if the given level is at or above this level.
 func (l Level) Enabled(lvl Level) bool {
-	return lvl >= l
+	return lvl.severity() >= l.severity()
 }
 
 // LevelEnabler decides whether a given logging level is enabled when logging a
@@ -150,8 +242,70 @@ func (l Level) Enabled(lvl Level) bool {
 //
 // Each concrete Level value implements a static LevelEnabler which returns
 // true for itself and all higher logging levels. For example WarnLevel.Enabled()
-// will return true for WarnLevel, ErrorLevel, DPanicLevel, PanicLevel, and
-// FatalLevel, but return false for InfoLevel and DebugLevel.
+// will return true for WarnLevel, ErrorLevel, CriticalLevel, DPanicLevel,
+// PanicLevel, and FatalLevel, but return false for NoticeLevel, InfoLevel,
+// DebugLevel, and TraceLevel.
 type LevelEnabler interface {
 	Enabled(Level) bool
 }
+
+// RegisterLevel registers a custom, named Level. Once registered, the level
+// is supported by String, CapitalString, MarshalText, UnmarshalText, and the
+// colored level encoders; log at it with Logger.Check.
+//
+// Names are case-insensitive and must not collide with a built-in or
+// previously-registered level, and the Level's value must not already be in
+// use. Since the built-in levels use values -4 through 5, custom levels below
+// -4 are less important than TraceLevel and are colored like it, and those
+// above 5 are more important than FatalLevel and are colored like it. Like
+// RegisterEncoder, RegisterLevel is intended to be called during program
+// initialization.
+func RegisterLevel(lvl Level, name string) error {
+	if name == "" {
+		return errors.New("can't register a level with an empty name")
+	}
+	lower := strings.ToLower(name)
+	if lvl >= _minLevel && lvl <= _maxLevel {
+		return fmt.Errorf("level %d is already used by %q", lvl, lvl.String())
+	}
+	for builtin := _minLevel; builtin <= _maxLevel; builtin++ {
+		if builtin.String() == lower {
+			return fmt.Errorf("level name %q is already used by a built-in level", lower)
+		}
+	}
+
+	_customLevelsMu.Lock()
+	defer _customLevelsMu.Unlock()
+	if cl, ok := _customLevels[lvl]; ok {
+		return fmt.Errorf("level %d is already registered as %q", lvl, cl.lower)
+	}
+	if _, ok := _customLevelByName[lower]; ok {
+		return fmt.Errorf("level name %q is already registered", lower)
+	}
+	capital := strings.ToUpper(name)
+	c := customLevelColor(lvl)
+	_customLevels[lvl] = customLevel{
+		lower:        lower,
+		capital:      capital,
+		lowerColor:   c.Add(lower),
+		capitalColor: c.Add(capital),
+	}
+	_customLevelByName[lower] = lvl
+	return nil
+}
+
+func lookupCustomLevel(l Level) (customLevel, bool) {
+	_customLevelsMu.RLock()
+	cl, ok := _customLevels[l]
+	_customLevelsMu.RUnlock()
+	return cl, ok
+}
+
+// customLevelColor picks the color of the closest built-in level: FatalLevel
+// for custom levels above the built-in ones, and TraceLevel for those below.
+func customLevelColor(lvl Level) color.Color {
+	if lvl > _maxLevel {
+		return _levelToColor[FatalLevel]
+	}
+	return _levelToColor[TraceLevel]
+}
@@ -0,0 +1,263 @@
+
+
+package vipercore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A LevelRule overrides the minimum enabled level for entries carrying a
+// field that matches it. Exactly one of Equals, Prefix, and In must be set;
+// field values are compared using their string representations.
+//
+// For example, the rule
+//   {"key": "user_id", "equals": "42", "level": "debug"}
+// enables debug logging for a single user, while
+//   {"key": "component", "prefix": "cache.", "level": "error"}
+// silences everything but errors from a noisy subsystem.
+type LevelRule struct {
+	Key    string   `json:"key" yaml:"key"`
+	Equals string   `json:"equals,omitempty" yaml:"equals,omitempty"`
+	Prefix string   `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	In     []string `json:"in,omitempty" yaml:"in,omitempty"`
+	Level  Level    `json:"level" yaml:"level"`
+}
+
+// Validate reports whether the rule is well-formed.
+func (r LevelRule) Validate() error {
+	if r.Key == "" {
+		return errors.New("level rules must specify a field key")
+	}
+	n := 0
+	if r.Equals != "" {
+		n++
+	}
+	if r.Prefix != "" {
+		n++
+	}
+	if len(r.In) > 0 {
+		n++
+	}
+	if n != 1 {
+		return fmt.Errorf("level rule for key %q must set exactly one of equals, prefix, and in", r.Key)
+	}
+	return nil
+}
+
+func (r LevelRule) matches(fields []Field) bool {
+	for i := range fields {
+		if fields[i].Key != r.Key {
+			continue
+		}
+		val, ok := fieldValueString(fields[i])
+		if !ok {
+			continue
+		}
+		switch {
+		case r.Equals != "":
+			if val == r.Equals {
+				return true
+			}
+		case r.Prefix != "":
+			if strings.HasPrefix(val, r.Prefix) {
+				return true
+			}
+		default:
+			for _, candidate := range r.In {
+				if val == candidate {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// LevelRules is a concurrency-safe, ordered collection of LevelRules that can
+// be replaced at runtime. The zero value holds no rules and is ready to use.
+type LevelRules struct {
+	v atomic.Value // []LevelRule
+}
+
+// NewLevelRules creates a LevelRules holding the supplied rules.
+func NewLevelRules(rules ...LevelRule) (*LevelRules, error) {
+	lr := &LevelRules{}
+	if err := lr.Set(rules); err != nil {
+		return nil, err
+	}
+	return lr, nil
+}
+
+// Rules returns a copy of the current rules.
+func (lr *LevelRules) Rules() []LevelRule {
+	return append([]LevelRule(nil), lr.load()...)
+}
+
+// Set validates and atomically replaces the current rules. Passing no rules
+// removes all overrides.
+func (lr *LevelRules) Set(rules []LevelRule) error {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	lr.v.Store(append([]LevelRule(nil), rules...))
+	return nil
+}
+
+// Enabled reports whether any rule enables the level, regardless of the
+// fields it applies to.
+func (lr *LevelRules) Enabled(lvl Level) bool {
+	for _, r := range lr.load() {
+		if r.Level.Enabled(lvl) {
+			return true
+		}
+	}
+	return false
+}
+
+func (lr *LevelRules) load() []LevelRule {
+	if lr == nil {
+		return nil
+	}
+	rules, _ := lr.v.Load().([]LevelRule)
+	return rules
+}
+
+type ruledCore struct {
+	Core
+	enab    LevelEnabler
+	rules   *LevelRules
+	context []Field
+}
+
+// NewLevelRulesCore creates a Core that decides which entries to log using
+// both a LevelEnabler and a set of LevelRules evaluated against the fields
+// accumulated with With and the fields supplied at the log site. Rules are
+// tried in order and the first match determines the entry's minimum enabled
+// level; entries matching no rule fall back to enab.
+//
+// Entries are still checked by the wrapped Core, so its sampling and level
+// filtering apply. Since a rule may lower the level below enab, the wrapped
+// Core should be constructed with a LevelEnabler at least as permissive as
+// any rule, like the one returned by NewLevelRulesEnabler.
+func NewLevelRulesCore(core Core, enab LevelEnabler, rules *LevelRules) Core {
+	return &ruledCore{
+		Core:  core,
+		enab:  enab,
+		rules: rules,
+	}
+}
+
+// NewLevelRulesEnabler creates a LevelEnabler that enables the levels enab
+// enables, as well as any level enabled by one of the rules. It's suited to
+// the Core wrapped by NewLevelRulesCore.
+func NewLevelRulesEnabler(enab LevelEnabler, rules *LevelRules) LevelEnabler {
+	return rulesEnabler{enab, rules}
+}
+
+type rulesEnabler struct {
+	enab  LevelEnabler
+	rules *LevelRules
+}
+
+func (e rulesEnabler) Enabled(lvl Level) bool {
+	return e.enab.Enabled(lvl) || e.rules.Enabled(lvl)
+}
+
+func (c *ruledCore) Enabled(lvl Level) bool {
+	return c.enab.Enabled(lvl) || c.rules.Enabled(lvl)
+}
+
+func (c *ruledCore) With(fields []Field) Core {
+	return &ruledCore{
+		Core:    c.Core.With(fields),
+		enab:    c.enab,
+		rules:   c.rules,
+		context: append(c.context[:len(c.context):len(c.context)], fields...),
+	}
+}
+
+func (c *ruledCore) Check(ent Entry, ce *CheckedEntry) *CheckedEntry {
+	rules := c.rules.load()
+	if len(rules) == 0 {
+		if c.enab.Enabled(ent.Level) {
+			return c.Core.Check(ent, ce)
+		}
+		return ce
+	}
+
+	// Fields supplied at the log site aren't available yet, so admit the
+	// entry if any rule might enable it and make the final decision, along
+	// with the wrapped Core's check, in Write. A rule matching the
+	// accumulated context is certain to apply unless an earlier rule matches
+	// the log site's fields.
+	possible := false
+	for _, r := range rules {
+		possible = possible || r.Level.Enabled(ent.Level)
+		if r.matches(c.context) {
+			break
+		}
+	}
+	if possible || c.enab.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *ruledCore) Write(ent Entry, fields []Field) error {
+	if !c.enabledFor(ent.Level, fields) {
+		return nil
+	}
+	return writeChecked(c.Core, ent, fields)
+}
+
+func (c *ruledCore) enabledFor(lvl Level, fields []Field) bool {
+	for _, r := range c.rules.load() {
+		if r.matches(fields) || r.matches(c.context) {
+			return r.Level.Enabled(lvl)
+		}
+	}
+	return c.enab.Enabled(lvl)
+}
+
+// fieldValueString returns the string used to compare a field's value against
+// LevelRules. Fields that can't be represented as a simple string, like
+// marshalers and reflected values, never match.
+func fieldValueString(f Field) (val string, ok bool) {
+	switch f.Type {
+	case StringType:
+		return f.String, true
+	case ByteStringType:
+		return string(f.Interface.([]byte)), true
+	case BoolType:
+		return strconv.FormatBool(f.Integer == 1), true
+	case Int64Type, Int32Type, Int16Type, Int8Type:
+		return strconv.FormatInt(f.Integer, 10), true
+	case Uint64Type, Uint32Type, Uint16Type, Uint8Type, UintptrType:
+		return strconv.FormatUint(uint64(f.Integer), 10), true
+	case Float64Type:
+		return strconv.FormatFloat(math.Float64frombits(uint64(f.Integer)), 'g', -1, 64), true
+	case Float32Type:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(f.Integer))), 'g', -1, 32), true
+	case DurationType:
+		return time.Duration(f.Integer).String(), true
+	case ErrorType:
+		return f.Interface.(error).Error(), true
+	case StringerType:
+		defer func() {
+			if recover() != nil {
+				val, ok = "", false
+			}
+		}()
+		return f.Interface.(fmt.Stringer).String(), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,249 @@
+
+
+package vipercore_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/gottingen/viper/vipercore"
+	"github.com/gottingen/viper/vipertest/observer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeStringField(key, val string) Field {
+	return Field{Type: StringType, String: val, Key: key}
+}
+
+func withLevelRulesCore(t testing.TB, rules []LevelRule, f func(Core, *LevelRules, *observer.ObservedLogs)) {
+	lr, err := NewLevelRules(rules...)
+	require.NoError(t, err, "Unexpected error constructing level rules.")
+	obs, logs := observer.New(TraceLevel)
+	f(NewLevelRulesCore(obs, InfoLevel, lr), lr, logs)
+}
+
+func checkAndWrite(core Core, lvl Level, msg string, fields ...Field) {
+	if ce := core.Check(Entry{Level: lvl, Message: msg}, nil); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+func TestLevelRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule LevelRule
+		ok   bool
+	}{
+		{LevelRule{Key: "user_id", Equals: "42"}, true},
+		{LevelRule{Key: "component", Prefix: "cache."}, true},
+		{LevelRule{Key: "region", In: []string{"us", "eu"}}, true},
+		{LevelRule{Equals: "42"}, false},
+		{LevelRule{Key: "user_id"}, false},
+		{LevelRule{Key: "user_id", Equals: "42", Prefix: "4"}, false},
+		{LevelRule{Key: "user_id", Prefix: "4", In: []string{"42"}}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.rule.Validate()
+		if tt.ok {
+			assert.NoError(t, err, "Unexpected error validating %+v.", tt.rule)
+		} else {
+			assert.Error(t, err, "Expected an error validating %+v.", tt.rule)
+		}
+	}
+
+	_, err := NewLevelRules(LevelRule{Key: "user_id"})
+	assert.Error(t, err, "Expected NewLevelRules to reject invalid rules.")
+}
+
+func TestLevelRulesZeroValue(t *testing.T) {
+	var lr LevelRules
+	assert.Empty(t, lr.Rules(), "Expected zero-value LevelRules to have no rules.")
+
+	rules := []LevelRule{{Key: "user_id", Equals: "42", Level: DebugLevel}}
+	require.NoError(t, lr.Set(rules), "Unexpected error setting rules.")
+	rules[0].Equals = "43"
+	assert.Equal(t, "42", lr.Rules()[0].Equals, "Expected Set to copy its input.")
+
+	require.NoError(t, lr.Set(nil), "Unexpected error clearing rules.")
+	assert.Empty(t, lr.Rules(), "Expected rules to be cleared.")
+}
+
+func TestLevelRulesCoreMatching(t *testing.T) {
+	rules := []LevelRule{
+		{Key: "user_id", Equals: "42", Level: DebugLevel},
+		{Key: "component", Prefix: "cache.", Level: ErrorLevel},
+		{Key: "region", In: []string{"us-east", "eu-west"}, Level: TraceLevel},
+	}
+
+	tests := []struct {
+		desc    string
+		lvl     Level
+		context []Field
+		fields  []Field
+		want    bool
+	}{
+		{"no fields, info", InfoLevel, nil, nil, true},
+		{"no fields, debug", DebugLevel, nil, nil, false},
+		{"equality in call fields", DebugLevel, nil, []Field{makeStringField("user_id", "42")}, true},
+		{"equality in context", DebugLevel, []Field{makeStringField("user_id", "42")}, nil, true},
+		{"equality on integers", DebugLevel, nil, []Field{makeInt64Field("user_id", 42)}, true},
+		{"equality mismatch", DebugLevel, nil, []Field{makeStringField("user_id", "7")}, false},
+		{"below rule level", TraceLevel, nil, []Field{makeStringField("user_id", "42")}, false},
+		{"prefix raises level", WarnLevel, []Field{makeStringField("component", "cache.lru")}, nil, false},
+		{"prefix allows errors", ErrorLevel, []Field{makeStringField("component", "cache.lru")}, nil, true},
+		{"prefix mismatch", WarnLevel, []Field{makeStringField("component", "db")}, nil, true},
+		{"membership", TraceLevel, nil, []Field{makeStringField("region", "eu-west")}, true},
+		{"membership mismatch", TraceLevel, nil, []Field{makeStringField("region", "ap-south")}, false},
+		{
+			"first match wins",
+			DebugLevel,
+			[]Field{makeStringField("component", "cache.lru")},
+			[]Field{makeStringField("user_id", "42")},
+			true,
+		},
+		{
+			"call fields don't shadow earlier context matches",
+			WarnLevel,
+			[]Field{makeStringField("user_id", "42")},
+			[]Field{makeStringField("component", "cache.lru")},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			withLevelRulesCore(t, rules, func(core Core, _ *LevelRules, logs *observer.ObservedLogs) {
+				if len(tt.context) > 0 {
+					core = core.With(tt.context)
+				}
+				checkAndWrite(core, tt.lvl, "msg", tt.fields...)
+				if tt.want {
+					assert.Equal(t, 1, logs.Len(), "Expected entry to be logged.")
+				} else {
+					assert.Equal(t, 0, logs.Len(), "Expected entry to be dropped.")
+				}
+			})
+		})
+	}
+}
+
+func TestLevelRulesCoreEnabled(t *testing.T) {
+	withLevelRulesCore(t, nil, func(core Core, lr *LevelRules, _ *observer.ObservedLogs) {
+		assert.False(t, core.Enabled(DebugLevel), "Expected debug to be disabled without rules.")
+		assert.True(t, core.Enabled(InfoLevel), "Expected info to be enabled without rules.")
+
+		require.NoError(t, lr.Set([]LevelRule{{Key: "user_id", Equals: "42", Level: DebugLevel}}))
+		assert.True(t, core.Enabled(DebugLevel), "Expected a debug rule to enable debug.")
+		assert.False(t, core.Enabled(TraceLevel), "Expected trace to remain disabled.")
+	})
+}
+
+func TestLevelRulesCoreCheckUsesContext(t *testing.T) {
+	rules := []LevelRule{{Key: "user_id", Equals: "42", Level: DebugLevel}}
+	withLevelRulesCore(t, rules, func(core Core, _ *LevelRules, _ *observer.ObservedLogs) {
+		other := core.With([]Field{makeStringField("user_id", "42")})
+		assert.NotNil(t, other.Check(Entry{Level: DebugLevel}, nil), "Expected matching context to pass Check.")
+		assert.Nil(t, other.Check(Entry{Level: TraceLevel}, nil), "Expected trace entries to fail Check.")
+		assert.NotNil(t, core.Check(Entry{Level: DebugLevel}, nil), "Expected possible call-site matches to pass Check.")
+	})
+
+	rules = []LevelRule{{Key: "component", Prefix: "cache.", Level: ErrorLevel}}
+	withLevelRulesCore(t, rules, func(core Core, _ *LevelRules, _ *observer.ObservedLogs) {
+		quiet := core.With([]Field{makeStringField("component", "cache.lru")})
+		assert.NotNil(t, quiet.Check(Entry{Level: InfoLevel}, nil), "Expected unsilenced levels to pass Check.")
+		assert.Nil(t, core.Check(Entry{Level: DebugLevel}, nil), "Expected debug to fail Check.")
+	})
+}
+
+func TestLevelRulesCoreRuntimeUpdates(t *testing.T) {
+	withLevelRulesCore(t, nil, func(core Core, lr *LevelRules, logs *observer.ObservedLogs) {
+		userCore := core.With([]Field{makeStringField("user_id", "42")})
+		checkAndWrite(userCore, DebugLevel, "before")
+
+		require.NoError(t, lr.Set([]LevelRule{{Key: "user_id", Equals: "42", Level: DebugLevel}}))
+		checkAndWrite(userCore, DebugLevel, "during")
+
+		require.NoError(t, lr.Set(nil))
+		checkAndWrite(userCore, DebugLevel, "after")
+
+		require.Equal(t, 1, logs.Len(), "Expected only one entry to be logged.")
+		entry := logs.AllUntimed()[0]
+		assert.Equal(t, "during", entry.Message, "Unexpected message.")
+		assert.Equal(t, []Field{makeStringField("user_id", "42")}, entry.Context, "Unexpected context.")
+	})
+}
+
+func TestLevelRulesCoreChecksWrappedCore(t *testing.T) {
+	for _, rules := range [][]LevelRule{
+		nil,
+		{{Key: "user_id", Equals: "42", Level: DebugLevel}},
+	} {
+		lr, err := NewLevelRules(rules...)
+		require.NoError(t, err, "Unexpected error constructing level rules.")
+		sampled, sampledLogs := observer.New(TraceLevel)
+		errorsOnly, errorLogs := observer.New(ErrorLevel)
+		inner := NewTee(NewSampler(sampled, time.Minute, 1, 100), errorsOnly)
+		core := NewLevelRulesCore(inner, InfoLevel, lr)
+
+		for i := 0; i < 3; i++ {
+			checkAndWrite(core, InfoLevel, "repeated")
+		}
+		checkAndWrite(core, DebugLevel, "debug", makeStringField("user_id", "42"))
+		assert.Equal(t, 0, errorLogs.Len(), "Expected the wrapped core's level to apply with rules %v.", rules)
+		checkAndWrite(core, ErrorLevel, "failed")
+
+		expected := []string{"repeated", "failed"}
+		if len(rules) > 0 {
+			expected = []string{"repeated", "debug", "failed"}
+		}
+		var messages []string
+		for _, e := range sampledLogs.AllUntimed() {
+			messages = append(messages, e.Message)
+		}
+		assert.Equal(t, expected, messages, "Expected the wrapped sampler to apply with rules %v.", rules)
+		assert.Equal(t, 1, errorLogs.Len(), "Expected errors to reach the error-only core with rules %v.", rules)
+	}
+}
+
+type panickingStringer struct{}
+
+func (panickingStringer) String() string { panic("oh no") }
+
+type fixedStringer string
+
+func (s fixedStringer) String() string { return string(s) }
+
+func TestLevelRulesCoreFieldTypes(t *testing.T) {
+	tests := []struct {
+		field  Field
+		equals string
+	}{
+		{Field{Key: "k", Type: ByteStringType, Interface: []byte("foo")}, "foo"},
+		{Field{Key: "k", Type: BoolType, Integer: 1}, "true"},
+		{Field{Key: "k", Type: Uint32Type, Integer: 7}, "7"},
+		{Field{Key: "k", Type: Float64Type, Integer: 4609434218613702656}, "1.5"},
+		{Field{Key: "k", Type: DurationType, Integer: int64(time.Second)}, "1s"},
+		{Field{Key: "k", Type: ErrorType, Interface: errors.New("boom")}, "boom"},
+		{Field{Key: "k", Type: StringerType, Interface: fixedStringer("bar")}, "bar"},
+	}
+
+	for _, tt := range tests {
+		rules := []LevelRule{{Key: "k", Equals: tt.equals, Level: DebugLevel}}
+		withLevelRulesCore(t, rules, func(core Core, _ *LevelRules, logs *observer.ObservedLogs) {
+			checkAndWrite(core, DebugLevel, "msg", tt.field)
+			assert.Equal(t, 1, logs.Len(), "Expected field of type %v to match %q.", tt.field.Type, tt.equals)
+		})
+	}
+
+	rules := []LevelRule{{Key: "k", Equals: "x", Level: DebugLevel}}
+	withLevelRulesCore(t, rules, func(core Core, _ *LevelRules, logs *observer.ObservedLogs) {
+		assert.NotPanics(t, func() {
+			checkAndWrite(core, DebugLevel, "msg", Field{Key: "k", Type: StringerType, Interface: panickingStringer{}})
+		}, "Unexpected panic from a misbehaving Stringer.")
+		checkAndWrite(core, DebugLevel, "msg", Field{Key: "k", Type: ReflectType, Interface: "x"})
+		assert.Equal(t, 0, logs.Len(), "Expected unmatchable fields to be dropped.")
+	})
+}
@@ -6,13 +6,16 @@ import "github.com/gottingen/viper/internal/color"
 
 var (
 	_levelToColor = map[Level]color.Color{
-		DebugLevel:  color.Magenta,
-		InfoLevel:   color.Blue,
-		WarnLevel:   color.Yellow,
-		ErrorLevel:  color.Red,
-		DPanicLevel: color.Red,
-		PanicLevel:  color.Red,
-		FatalLevel:  color.Red,
+		TraceLevel:    color.Magenta,
+		DebugLevel:    color.Magenta,
+		InfoLevel:     color.Blue,
+		NoticeLevel:   color.Cyan,
+		WarnLevel:     color.Yellow,
+		ErrorLevel:    color.Red,
+		CriticalLevel: color.Red,
+		DPanicLevel:   color.Red,
+		PanicLevel:    color.Red,
+		FatalLevel:    color.Red,
 	}
 	_unknownLevelColor = color.Red
 
@@ -8,19 +8,23 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/gottingen/buffer"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestLevelString(t *testing.T) {
 	tests := map[Level]string{
-		DebugLevel:  "debug",
-		InfoLevel:   "info",
-		WarnLevel:   "warn",
-		ErrorLevel:  "error",
-		DPanicLevel: "dpanic",
-		PanicLevel:  "panic",
-		FatalLevel:  "fatal",
-		Level(-42):  "Level(-42)",
+		TraceLevel:    "trace",
+		DebugLevel:    "debug",
+		InfoLevel:     "info",
+		NoticeLevel:   "notice",
+		WarnLevel:     "warn",
+		ErrorLevel:    "error",
+		CriticalLevel: "critical",
+		DPanicLevel:   "dpanic",
+		PanicLevel:    "panic",
+		FatalLevel:    "fatal",
+		Level(-42):    "Level(-42)",
 	}
 
 	for lvl, stringLevel := range tests {
@@ -29,16 +33,57 @@ func TestLevelString(t *testing.T) {
 	}
 }
 
+func TestLevelValues(t *testing.T) {
+	// The levels that predate Trace, Notice, and Critical keep their values,
+	// since they may be persisted or sent numerically.
+	for lvl, value := range map[Level]int8{
+		DebugLevel:  -1,
+		InfoLevel:   0,
+		WarnLevel:   1,
+		ErrorLevel:  2,
+		DPanicLevel: 3,
+		PanicLevel:  4,
+		FatalLevel:  5,
+	} {
+		assert.Equal(t, value, int8(lvl), "Unexpected value for %v.", lvl)
+	}
+}
+
+func TestLevelEnabledOrdersByImportance(t *testing.T) {
+	ordered := []Level{
+		Level(-10), // custom, below the built-in values
+		TraceLevel,
+		DebugLevel,
+		InfoLevel,
+		NoticeLevel,
+		WarnLevel,
+		ErrorLevel,
+		CriticalLevel,
+		DPanicLevel,
+		PanicLevel,
+		FatalLevel,
+		FatalLevel + 1, // above every built-in level
+	}
+	for i, lvl := range ordered {
+		for j, other := range ordered {
+			assert.Equal(t, j >= i, lvl.Enabled(other), "Unexpected result for %v.Enabled(%v).", lvl, other)
+		}
+	}
+}
+
 func TestLevelText(t *testing.T) {
 	tests := []struct {
 		text  string
 		level Level
 	}{
+		{"trace", TraceLevel},
 		{"debug", DebugLevel},
 		{"info", InfoLevel},
 		{"", InfoLevel}, // make the zero value useful
+		{"notice", NoticeLevel},
 		{"warn", WarnLevel},
 		{"error", ErrorLevel},
+		{"critical", CriticalLevel},
 		{"dpanic", DPanicLevel},
 		{"panic", PanicLevel},
 		{"fatal", FatalLevel},
@@ -63,10 +108,13 @@ func TestCapitalLevelsParse(t *testing.T) {
 		text  string
 		level Level
 	}{
+		{"TRACE", TraceLevel},
 		{"DEBUG", DebugLevel},
 		{"INFO", InfoLevel},
+		{"NOTICE", NoticeLevel},
 		{"WARN", WarnLevel},
 		{"ERROR", ErrorLevel},
+		{"CRITICAL", CriticalLevel},
 		{"DPANIC", DPanicLevel},
 		{"PANIC", PanicLevel},
 		{"FATAL", FatalLevel},
@@ -141,7 +189,7 @@ func TestLevelAsFlagValue(t *testing.T) {
 	fs.SetOutput(&buf)
 	fs.Var(&lvl, "level", "log level")
 
-	for _, expected := range []Level{DebugLevel, InfoLevel, WarnLevel, ErrorLevel, DPanicLevel, PanicLevel, FatalLevel} {
+	for _, expected := range []Level{TraceLevel, DebugLevel, InfoLevel, NoticeLevel, WarnLevel, ErrorLevel, CriticalLevel, DPanicLevel, PanicLevel, FatalLevel} {
 		assert.NoError(t, fs.Parse([]string{"-level", expected.String()}))
 		assert.Equal(t, expected, lvl, "Unexpected level after parsing flag.")
 		assert.Equal(t, expected, lvl.Get(), "Unexpected output using flag.Getter API.")
@@ -157,3 +205,52 @@ func TestLevelAsFlagValue(t *testing.T) {
 		"Unexpected error output from invalid flag input.",
 	)
 }
+
+func TestRegisterLevel(t *testing.T) {
+	const verboseLevel = Level(-10)
+	defer func() {
+		_customLevelsMu.Lock()
+		delete(_customLevels, verboseLevel)
+		delete(_customLevelByName, "verbose")
+		_customLevelsMu.Unlock()
+	}()
+
+	assert.NoError(t, RegisterLevel(verboseLevel, "Verbose"), "Unexpected error registering a custom level.")
+	assert.Equal(t, "verbose", verboseLevel.String(), "Unexpected lowercase custom level string.")
+	assert.Equal(t, "VERBOSE", verboseLevel.CapitalString(), "Unexpected all-caps custom level string.")
+	assert.True(t, TraceLevel.Enabled(InfoLevel) && !TraceLevel.Enabled(verboseLevel), "Custom level should sort below TraceLevel.")
+
+	marshaled, err := verboseLevel.MarshalText()
+	assert.NoError(t, err, "Unexpected error marshaling custom level.")
+	assert.Equal(t, "verbose", string(marshaled), "Unexpected marshaled custom level.")
+
+	for _, text := range []string{"verbose", "VERBOSE", "VerBose"} {
+		var l Level
+		assert.NoError(t, l.UnmarshalText([]byte(text)), "Unexpected error unmarshaling %q.", text)
+		assert.Equal(t, verboseLevel, l, "Text %q unmarshaled to an unexpected level.", text)
+	}
+
+	pretty := NewPrettyConsoleEncoder(EncoderConfig{LevelKey: "L", EncodeLevel: CapitalLevelEncoder, MessageKey: "M"}, true)
+	buf, err := pretty.EncodeEntry(Entry{Level: verboseLevel, Message: "hi"}, nil)
+	assert.NoError(t, err, "Unexpected error encoding an entry at a custom level.")
+	assert.Contains(t, buf.String(), _levelToColor[TraceLevel].Add("VERBOSE"), "Expected the pretty encoder to color custom levels.")
+	buffer.Put(buf)
+
+	tests := []struct {
+		lvl  Level
+		name string
+		err  string
+	}{
+		{Level(-11), "", "empty name"},
+		{WarnLevel, "caution", `already used by "warn"`},
+		{Level(-11), "Debug", "built-in level"},
+		{verboseLevel, "chatty", `already registered as "verbose"`},
+		{Level(-11), "VERBOSE", "already registered"},
+	}
+	for _, tt := range tests {
+		err := RegisterLevel(tt.lvl, tt.name)
+		if assert.Error(t, err, "Expected registering level %d as %q to fail.", tt.lvl, tt.name) {
+			assert.Contains(t, err.Error(), tt.err, "Unexpected error registering level %d as %q.", tt.lvl, tt.name)
+		}
+	}
+}
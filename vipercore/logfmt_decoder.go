@@ -0,0 +1,130 @@
+
+
+package vipercore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type logfmtDecoder struct {
+	*EncoderConfig
+}
+
+// NewLogfmtDecoder creates a Decoder for logfmt lines, which are sequences of
+// space-separated key=value pairs. Values containing spaces, quotes, or equals
+// signs must be double-quoted using Go's escaping rules. As with
+// NewJSONDecoder, the keys configured in cfg identify the parts of the entry
+// and all other keys become fields.
+//
+// Quoted values are always decoded as strings. Unquoted values are decoded as
+// booleans or numbers when they look like one and as strings otherwise, and a
+// key without a value is decoded as true. Lines without any key=value pairs
+// aren't considered logfmt and can't be decoded.
+func NewLogfmtDecoder(cfg EncoderConfig) Decoder {
+	return &logfmtDecoder{&cfg}
+}
+
+func (dec *logfmtDecoder) Decode(line []byte) (Entry, []Field, error) {
+	obj, err := parseLogfmt(string(line))
+	if err != nil {
+		return Entry{}, nil, fmt.Errorf("can't decode logfmt log entry: %v", err)
+	}
+	ent, fields := decodeEntry(dec.EncoderConfig, obj)
+	return ent, fields, nil
+}
+
+func parseLogfmt(s string) (decodedObject, error) {
+	var (
+		obj      decodedObject
+		hasValue bool
+	)
+	i := 0
+	for {
+		for i < len(s) && isLogfmtSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			break
+		}
+
+		start := i
+		for i < len(s) && !isLogfmtSpace(s[i]) && s[i] != '=' {
+			if s[i] == '"' {
+				return nil, fmt.Errorf("unexpected quote in key at offset %d", i)
+			}
+			i++
+		}
+		key := s[start:i]
+		if key == "" {
+			return nil, fmt.Errorf("missing key at offset %d", i)
+		}
+		if i == len(s) || s[i] != '=' {
+			obj = append(obj, decodedKV{key: key, value: true})
+			continue
+		}
+		i++ // skip '='
+		hasValue = true
+
+		if i < len(s) && s[i] == '"' {
+			end, err := closingQuote(s, i)
+			if err != nil {
+				return nil, err
+			}
+			val, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value for key %q: %v", key, err)
+			}
+			obj = append(obj, decodedKV{key: key, value: val})
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(s) && !isLogfmtSpace(s[i]) {
+			i++
+		}
+		obj = append(obj, decodedKV{key: key, value: logfmtValue(s[start:i])})
+	}
+	if !hasValue {
+		return nil, errors.New("no key=value pairs")
+	}
+	return obj, nil
+}
+
+func isLogfmtSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// closingQuote returns the index of the quote that ends the quoted string
+// beginning at s[start].
+func closingQuote(s string, start int) (int, error) {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated quoted value at offset %d", start)
+}
+
+// logfmtValue infers the type of an unquoted value.
+func logfmtValue(raw string) interface{} {
+	switch raw {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	digits := strings.TrimLeft(raw, "+-")
+	if len(digits) > 0 && digits[0] >= '0' && digits[0] <= '9' {
+		if _, err := strconv.ParseFloat(raw, 64); err == nil {
+			return json.Number(raw)
+		}
+	}
+	return raw
+}
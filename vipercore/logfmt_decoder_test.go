@@ -0,0 +1,55 @@
+
+
+package vipercore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func TestLogfmtDecoder(t *testing.T) {
+	line := `ts=2017-07-14T02:40:00Z level=WARN name=payments caller=app/pay.go:12 msg="card declined" ` +
+		`user=alice attempts=3 ratio=0.5 retry=false note="say \"hi\"\tnow" empty= debug`
+	ent, fields, err := NewLogfmtDecoder(testEncoderConfig()).Decode([]byte(line))
+	require.NoError(t, err, "Unexpected error decoding logfmt.")
+
+	assert.Equal(t, WarnLevel, ent.Level, "Unexpected level.")
+	assert.True(t, time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC).Equal(ent.Time), "Unexpected time %v.", ent.Time)
+	assert.Equal(t, "payments", ent.LoggerName, "Unexpected logger name.")
+	assert.Equal(t, EntryCaller{Defined: true, File: "app/pay.go", Line: 12}, ent.Caller, "Unexpected caller.")
+	assert.Equal(t, "card declined", ent.Message, "Unexpected message.")
+
+	enc := NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	assert.Equal(t, map[string]interface{}{
+		"user":     "alice",
+		"attempts": int64(3),
+		"ratio":    0.5,
+		"retry":    false,
+		"note":     "say \"hi\"\tnow",
+		"empty":    "",
+		"debug":    true,
+	}, enc.Fields, "Unexpected fields.")
+}
+
+func TestLogfmtDecoderErrors(t *testing.T) {
+	dec := NewLogfmtDecoder(testEncoderConfig())
+	for _, line := range []string{
+		"",
+		"just some text",
+		`msg="unterminated`,
+		`msg="bad \q escape"`,
+		`=value`,
+		`k"ey=value`,
+	} {
+		_, _, err := dec.Decode([]byte(line))
+		assert.Error(t, err, "Expected an error decoding %q.", line)
+	}
+}
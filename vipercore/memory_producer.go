@@ -0,0 +1,56 @@
+
+
+package vipercore
+
+import "sync"
+
+// MemoryProducer is a Producer that keeps the messages it's given in memory,
+// which is useful for testing code that logs through a producer Core. The
+// zero value is ready to use.
+type MemoryProducer struct {
+	mu      sync.Mutex
+	batches [][]Message
+	err     error
+}
+
+// Produce records a batch of messages, unless an error was set with
+// SetError.
+func (p *MemoryProducer) Produce(msgs []Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.batches = append(p.batches, append([]Message(nil), msgs...))
+	return nil
+}
+
+// SetError makes Produce fail with the given error, until it's called again
+// with nil.
+func (p *MemoryProducer) SetError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
+
+// Batches returns the batches produced so far, oldest first.
+func (p *MemoryProducer) Batches() [][]Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([][]Message(nil), p.batches...)
+}
+
+// Messages returns the messages produced so far, oldest first.
+func (p *MemoryProducer) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var msgs []Message
+	for _, batch := range p.batches {
+		msgs = append(msgs, batch...)
+	}
+	return msgs
+}
@@ -0,0 +1,189 @@
+
+
+package vipercore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gottingen/atomic"
+)
+
+// WriteLatencyBuckets are the upper bounds, in seconds, of the buckets of a
+// MetricsRecorder's write latency histogram.
+var WriteLatencyBuckets = []float64{
+	.000001, .000005, .00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1,
+}
+
+// Metrics receives measurements from Cores and sinks, so that failures to log
+// can be monitored and alerted on. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	// EntryWritten records an entry written to its destination, with the size
+	// of its encoding and how long encoding and writing it took.
+	EntryWritten(lvl Level, bytes int, latency time.Duration)
+	// WriteError records an entry that couldn't be encoded or written.
+	WriteError(lvl Level)
+	// SyncError records a failure to sync buffered entries.
+	SyncError()
+	// EntryDropped records an entry dropped on purpose, for example by a
+	// sampler or because a producer Core's queue is full.
+	EntryDropped(lvl Level)
+	// SinkWriteError records an encoded entry that a sink couldn't deliver.
+	// Sinks only see encoded entries, so they can't tell their levels.
+	SinkWriteError()
+	// SinkDropped records an encoded entry that a sink dropped, for example
+	// because its queue is full.
+	SinkDropped()
+}
+
+// levelCounters counts events for each level.
+type levelCounters struct {
+	builtin [_numLevels]atomic.Uint64
+	custom  sync.Map // Level to *atomic.Uint64
+}
+
+func (c *levelCounters) inc(lvl Level) {
+	if lvl >= _minLevel && lvl <= _maxLevel {
+		c.builtin[lvl-_minLevel].Inc()
+		return
+	}
+	n, _ := c.custom.LoadOrStore(lvl, &atomic.Uint64{})
+	n.(*atomic.Uint64).Inc()
+}
+
+// snapshot returns the counts of the built-in levels, and those of custom
+// levels that have been counted.
+func (c *levelCounters) snapshot() map[Level]uint64 {
+	counts := make(map[Level]uint64, _numLevels)
+	for i := range c.builtin {
+		counts[_minLevel+Level(i)] = c.builtin[i].Load()
+	}
+	c.custom.Range(func(lvl, n interface{}) bool {
+		counts[lvl.(Level)] = n.(*atomic.Uint64).Load()
+		return true
+	})
+	return counts
+}
+
+// MetricsRecorder is a Metrics that keeps counters and a latency histogram in
+// memory. Its Snapshot can be exported, for example with viper.MetricsHandler.
+type MetricsRecorder struct {
+	written     levelCounters
+	writeErrors levelCounters
+	dropped     levelCounters
+	bytes       atomic.Uint64
+	syncErrors  atomic.Uint64
+
+	sinkWriteErrors atomic.Uint64
+	sinkDropped     atomic.Uint64
+
+	// The latency buckets aren't cumulative; the last one counts latencies
+	// beyond the largest bound.
+	latencyBuckets []atomic.Uint64
+	latencySum     atomic.Int64
+	latencyCount   atomic.Uint64
+}
+
+// NewMetricsRecorder creates a MetricsRecorder.
+func NewMetricsRecorder() *MetricsRecorder {
+	return &MetricsRecorder{
+		latencyBuckets: make([]atomic.Uint64, len(WriteLatencyBuckets)+1),
+	}
+}
+
+// EntryWritten implements Metrics.
+func (m *MetricsRecorder) EntryWritten(lvl Level, bytes int, latency time.Duration) {
+	m.written.inc(lvl)
+	m.bytes.Add(uint64(bytes))
+	i := sort.SearchFloat64s(WriteLatencyBuckets, latency.Seconds())
+	m.latencyBuckets[i].Inc()
+	m.latencySum.Add(int64(latency))
+	m.latencyCount.Inc()
+}
+
+// WriteError implements Metrics.
+func (m *MetricsRecorder) WriteError(lvl Level) {
+	m.writeErrors.inc(lvl)
+}
+
+// SyncError implements Metrics.
+func (m *MetricsRecorder) SyncError() {
+	m.syncErrors.Inc()
+}
+
+// EntryDropped implements Metrics.
+func (m *MetricsRecorder) EntryDropped(lvl Level) {
+	m.dropped.inc(lvl)
+}
+
+// SinkWriteError implements Metrics.
+func (m *MetricsRecorder) SinkWriteError() {
+	m.sinkWriteErrors.Inc()
+}
+
+// SinkDropped implements Metrics.
+func (m *MetricsRecorder) SinkDropped() {
+	m.sinkDropped.Inc()
+}
+
+// MetricsSnapshot holds the values of a MetricsRecorder at a point in time.
+// The counts by level include every built-in level.
+type MetricsSnapshot struct {
+	EntriesWritten map[Level]uint64 `json:"entriesWritten"`
+	BytesWritten   uint64           `json:"bytesWritten"`
+	WriteErrors    map[Level]uint64 `json:"writeErrors"`
+	SyncErrors     uint64           `json:"syncErrors"`
+	EntriesDropped map[Level]uint64 `json:"entriesDropped"`
+	WriteLatency   LatencySnapshot  `json:"writeLatency"`
+
+	// Sinks count the encoded entries they couldn't deliver or dropped
+	// separately, since they can't tell their levels.
+	SinkWriteErrors uint64 `json:"sinkWriteErrors"`
+	SinkDropped     uint64 `json:"sinkDropped"`
+}
+
+// LatencySnapshot is a histogram of write latencies.
+type LatencySnapshot struct {
+	// Buckets count the latencies up to each of the WriteLatencyBuckets. As
+	// in Prometheus, the counts are cumulative.
+	Buckets []LatencyBucket `json:"buckets"`
+	// Sum is the total of all latencies.
+	Sum time.Duration `json:"sum"`
+	// Count is the number of latencies.
+	Count uint64 `json:"count"`
+}
+
+// LatencyBucket is a bucket of a LatencySnapshot.
+type LatencyBucket struct {
+	// UpperBound is the bucket's largest latency, in seconds.
+	UpperBound float64 `json:"upperBound"`
+	// Count is the number of latencies up to the upper bound.
+	Count uint64 `json:"count"`
+}
+
+// Snapshot returns the recorder's current values. Since they're read one at a
+// time, writes that happen while taking the snapshot may be partly included.
+func (m *MetricsRecorder) Snapshot() MetricsSnapshot {
+	buckets := make([]LatencyBucket, len(WriteLatencyBuckets))
+	var cumulative uint64
+	for i, bound := range WriteLatencyBuckets {
+		cumulative += m.latencyBuckets[i].Load()
+		buckets[i] = LatencyBucket{UpperBound: bound, Count: cumulative}
+	}
+	return MetricsSnapshot{
+		EntriesWritten: m.written.snapshot(),
+		BytesWritten:   m.bytes.Load(),
+		WriteErrors:    m.writeErrors.snapshot(),
+		SyncErrors:     m.syncErrors.Load(),
+		EntriesDropped: m.dropped.snapshot(),
+		WriteLatency: LatencySnapshot{
+			Buckets: buckets,
+			Sum:     time.Duration(m.latencySum.Load()),
+			Count:   m.latencyCount.Load(),
+		},
+		SinkWriteErrors: m.sinkWriteErrors.Load(),
+		SinkDropped:     m.sinkDropped.Load(),
+	}
+}
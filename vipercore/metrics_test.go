@@ -0,0 +1,94 @@
+package vipercore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gottingen/viper/internal/vtest"
+	. "github.com/gottingen/viper/vipercore"
+	"github.com/gottingen/viper/vipertest/observer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsRecorder(t *testing.T) {
+	m := NewMetricsRecorder()
+	m.EntryWritten(InfoLevel, 10, 2*time.Microsecond)
+	m.EntryWritten(InfoLevel, 20, 2*time.Millisecond)
+	m.EntryWritten(ErrorLevel, 5, 2*time.Second)
+	m.WriteError(WarnLevel)
+	m.SyncError()
+	m.EntryDropped(DebugLevel)
+	m.EntryDropped(Level(20))
+	m.SinkWriteError()
+	m.SinkDropped()
+	m.SinkDropped()
+
+	s := m.Snapshot()
+	assert.Equal(t, uint64(2), s.EntriesWritten[InfoLevel], "Unexpected info entries written.")
+	assert.Equal(t, uint64(1), s.EntriesWritten[ErrorLevel], "Unexpected error entries written.")
+	assert.Equal(t, uint64(0), s.EntriesWritten[FatalLevel], "Expected a count for every built-in level.")
+	assert.Equal(t, uint64(35), s.BytesWritten, "Unexpected bytes written.")
+	assert.Equal(t, uint64(1), s.WriteErrors[WarnLevel], "Unexpected write errors.")
+	assert.Equal(t, uint64(1), s.SyncErrors, "Unexpected sync errors.")
+	assert.Equal(t, uint64(1), s.EntriesDropped[DebugLevel], "Unexpected dropped entries.")
+	assert.Equal(t, uint64(1), s.EntriesDropped[Level(20)], "Expected custom levels to be counted.")
+	_, ok := s.EntriesWritten[Level(20)]
+	assert.False(t, ok, "Expected uncounted custom levels to be left out.")
+	assert.Equal(t, uint64(1), s.SinkWriteErrors, "Unexpected sink write errors.")
+	assert.Equal(t, uint64(2), s.SinkDropped, "Unexpected sink drops.")
+
+	lat := s.WriteLatency
+	assert.Equal(t, uint64(3), lat.Count, "Unexpected latency count.")
+	assert.Equal(t, 2*time.Second+2*time.Millisecond+2*time.Microsecond, lat.Sum, "Unexpected latency sum.")
+	require.Len(t, lat.Buckets, len(WriteLatencyBuckets), "Unexpected number of buckets.")
+	counts := make(map[float64]uint64, len(lat.Buckets))
+	for _, b := range lat.Buckets {
+		counts[b.UpperBound] = b.Count
+	}
+	assert.Equal(t, uint64(0), counts[.000001], "Unexpected count of the smallest bucket.")
+	assert.Equal(t, uint64(1), counts[.000005], "Expected microseconds to be counted.")
+	assert.Equal(t, uint64(2), counts[.005], "Expected buckets to be cumulative.")
+	assert.Equal(t, uint64(2), counts[1], "Expected latencies beyond the largest bound to be left out.")
+
+	_, err := json.Marshal(s)
+	assert.NoError(t, err, "Expected snapshots to marshal to JSON.")
+}
+
+func TestIOCoreMetrics(t *testing.T) {
+	m := NewMetricsRecorder()
+	sink := &vtest.Buffer{}
+	core := NewCoreWithMetrics(NewJSONEncoder(testEncoderConfig()), sink, DebugLevel, m)
+	core = core.With([]Field{makeInt64Field("k", 1)})
+
+	require.NoError(t, core.Write(Entry{Level: InfoLevel, Message: "hello"}, nil), "Unexpected write error.")
+	s := m.Snapshot()
+	assert.Equal(t, uint64(1), s.EntriesWritten[InfoLevel], "Expected the entry to be counted.")
+	assert.Equal(t, uint64(sink.Len()), s.BytesWritten, "Unexpected bytes written.")
+	assert.Equal(t, uint64(1), s.WriteLatency.Count, "Expected the write's latency to be recorded.")
+
+	err := errors.New("failed")
+	sink.SetError(err)
+	assert.Equal(t, err, core.Sync(), "Expected sync errors to be returned.")
+	assert.Equal(t, uint64(1), m.Snapshot().SyncErrors, "Expected the sync error to be counted.")
+
+	failing := NewCoreWithMetrics(NewJSONEncoder(testEncoderConfig()), Lock(&vtest.FailWriter{}), DebugLevel, m)
+	assert.Error(t, failing.Write(Entry{Level: WarnLevel}, nil), "Expected writing Entry to fail.")
+	s = m.Snapshot()
+	assert.Equal(t, uint64(1), s.WriteErrors[WarnLevel], "Expected the write error to be counted.")
+	assert.Equal(t, uint64(0), s.EntriesWritten[WarnLevel], "Expected failed writes not to be counted as written.")
+}
+
+func TestSamplerMetrics(t *testing.T) {
+	m := NewMetricsRecorder()
+	core, logs := observer.New(DebugLevel)
+	sampler := NewSamplerWithMetrics(core, time.Minute, 2, 3, m)
+	for i := 1; i < 10; i++ {
+		writeSequence(sampler, i, InfoLevel)
+	}
+	assertSequence(t, logs.TakeAll(), InfoLevel, 1, 2, 5, 8)
+	assert.Equal(t, uint64(5), m.Snapshot().EntriesDropped[InfoLevel], "Expected sampled-out entries to be counted.")
+}
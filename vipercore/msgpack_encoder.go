@@ -0,0 +1,616 @@
+package vipercore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gottingen/buffer"
+)
+
+// MessagePack type markers, from https://github.com/msgpack/msgpack/blob/master/spec.md.
+const (
+	_mpNil      = 0xc0
+	_mpFalse    = 0xc2
+	_mpTrue     = 0xc3
+	_mpBin8     = 0xc4
+	_mpBin16    = 0xc5
+	_mpBin32    = 0xc6
+	_mpExt8     = 0xc7
+	_mpFloat32  = 0xca
+	_mpFloat64  = 0xcb
+	_mpUint8    = 0xcc
+	_mpUint16   = 0xcd
+	_mpUint32   = 0xce
+	_mpUint64   = 0xcf
+	_mpInt8     = 0xd0
+	_mpInt16    = 0xd1
+	_mpInt32    = 0xd2
+	_mpInt64    = 0xd3
+	_mpFixExt4  = 0xd6
+	_mpFixExt8  = 0xd7
+	_mpStr8     = 0xd9
+	_mpStr16    = 0xda
+	_mpStr32    = 0xdb
+	_mpArray16  = 0xdc
+	_mpArray32  = 0xdd
+	_mpMap16    = 0xde
+	_mpMap32    = 0xdf
+	_mpFixStr   = 0xa0
+	_mpFixArray = 0x90
+	_mpFixMap   = 0x80
+
+	// _mpTimestampExt is the extension type of timestamps.
+	_mpTimestampExt = 0xff // -1
+)
+
+var _msgpackPool = sync.Pool{New: func() interface{} {
+	return &msgpackEncoder{}
+}}
+
+func getMsgpackEncoder(cfg *EncoderConfig) *msgpackEncoder {
+	enc := _msgpackPool.Get().(*msgpackEncoder)
+	enc.EncoderConfig = cfg
+	enc.buf = buffer.Get()
+	return enc
+}
+
+func putMsgpackEncoder(enc *msgpackEncoder) {
+	enc.EncoderConfig = nil
+	enc.lengthPrefix = false
+	enc.buf = nil
+	enc.count = 0
+	enc.namespaces = enc.namespaces[:0]
+	_msgpackPool.Put(enc)
+}
+
+// msgpackNamespace is a map enclosing an open namespace.
+type msgpackNamespace struct {
+	key   string
+	buf   *buffer.Buffer
+	count int
+}
+
+type msgpackEncoder struct {
+	*EncoderConfig
+	lengthPrefix bool
+
+	// buf holds the encoded elements of the innermost open map or array, and
+	// count is the number of key-value pairs or elements in it.
+	buf   *buffer.Buffer
+	count int
+	// namespaces are the maps enclosing the open namespaces, outermost first.
+	namespaces []msgpackNamespace
+}
+
+// A MessagePackOption configures a MessagePack encoder.
+type MessagePackOption interface {
+	applyMessagePackOption(*msgpackEncoder)
+}
+
+type msgpackOptionFunc func(*msgpackEncoder)
+
+func (f msgpackOptionFunc) applyMessagePackOption(enc *msgpackEncoder) {
+	f(enc)
+}
+
+// MessagePackLengthPrefix prefixes each encoded entry with its length as a
+// 4-byte big-endian integer, for transports that need explicit framing.
+func MessagePackLengthPrefix() MessagePackOption {
+	return msgpackOptionFunc(func(enc *msgpackEncoder) {
+		enc.lengthPrefix = true
+	})
+}
+
+// NewMessagePackEncoder creates an encoder that writes each entry as a
+// MessagePack map, which is more compact and cheaper to produce than JSON.
+//
+// Values keep their types: integers, floats, booleans, and strings use the
+// smallest MessagePack representation that holds them, AddBinary writes
+// binary data, and times are written with the timestamp extension type,
+// regardless of the configured TimeEncoder. Levels, names, callers, and
+// durations are encoded with the EncoderConfig's encoders. Complex numbers are
+// written as strings, as they are by the JSON encoder, and reflected values are
+// serialized with encoding/json and converted to the equivalent MessagePack.
+//
+// Since MessagePack values are self-delimiting, the LineEnding is ignored and
+// entries are written back to back unless MessagePackLengthPrefix is used.
+func NewMessagePackEncoder(cfg EncoderConfig, opts ...MessagePackOption) Encoder {
+	enc := &msgpackEncoder{
+		EncoderConfig: &cfg,
+		buf:           buffer.Get(),
+	}
+	for _, opt := range opts {
+		opt.applyMessagePackOption(enc)
+	}
+	return enc
+}
+
+func (enc *msgpackEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	enc.addKey(key)
+	return enc.AppendArray(arr)
+}
+
+func (enc *msgpackEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	enc.addKey(key)
+	return enc.AppendObject(obj)
+}
+
+func (enc *msgpackEncoder) AddBinary(key string, val []byte) {
+	enc.addKey(key)
+	enc.appendBinary(val)
+}
+
+func (enc *msgpackEncoder) AddByteString(key string, val []byte) {
+	enc.addKey(key)
+	enc.WriteByteString(val)
+}
+
+func (enc *msgpackEncoder) AddBool(key string, val bool) {
+	enc.addKey(key)
+	enc.WriteBool(val)
+}
+
+func (enc *msgpackEncoder) AddComplex128(key string, val complex128) {
+	enc.addKey(key)
+	enc.AppendComplex128(val)
+}
+
+func (enc *msgpackEncoder) AddDuration(key string, val time.Duration) {
+	enc.addKey(key)
+	enc.AppendDuration(val)
+}
+
+func (enc *msgpackEncoder) AddFloat64(key string, val float64) {
+	enc.addKey(key)
+	enc.WriteFloat64(val)
+}
+
+func (enc *msgpackEncoder) AddFloat32(key string, val float32) {
+	enc.addKey(key)
+	enc.WriteFloat32(val)
+}
+
+func (enc *msgpackEncoder) AddInt64(key string, val int64) {
+	enc.addKey(key)
+	enc.WriteInt64(val)
+}
+
+func (enc *msgpackEncoder) AddReflected(key string, obj interface{}) error {
+	decoded, err := decodeReflected(obj)
+	if err != nil {
+		return err
+	}
+	enc.addKey(key)
+	writeMsgpackDecoded(enc.buf, decoded)
+	enc.count++
+	return nil
+}
+
+func (enc *msgpackEncoder) OpenNamespace(key string) {
+	enc.namespaces = append(enc.namespaces, msgpackNamespace{key: key, buf: enc.buf, count: enc.count})
+	enc.buf = buffer.Get()
+	enc.count = 0
+}
+
+func (enc *msgpackEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	enc.WriteString(val)
+}
+
+func (enc *msgpackEncoder) AddTime(key string, val time.Time) {
+	enc.addKey(key)
+	enc.AppendTime(val)
+}
+
+func (enc *msgpackEncoder) AddUint64(key string, val uint64) {
+	enc.addKey(key)
+	enc.WriteUint64(val)
+}
+
+func (enc *msgpackEncoder) AppendArray(arr ArrayMarshaler) error {
+	child := getMsgpackEncoder(enc.EncoderConfig)
+	err := arr.MarshalLogArray(child)
+	child.closeOpenNamespaces()
+	writeMsgpackArrayHeader(enc.buf, child.count)
+	enc.buf.Write(child.buf.Bytes())
+	enc.count++
+	buffer.Put(child.buf)
+	putMsgpackEncoder(child)
+	return err
+}
+
+func (enc *msgpackEncoder) AppendObject(obj ObjectMarshaler) error {
+	child := getMsgpackEncoder(enc.EncoderConfig)
+	err := obj.MarshalLogObject(child)
+	child.closeOpenNamespaces()
+	writeMsgpackMapHeader(enc.buf, child.count)
+	enc.buf.Write(child.buf.Bytes())
+	enc.count++
+	buffer.Put(child.buf)
+	putMsgpackEncoder(child)
+	return err
+}
+
+func (enc *msgpackEncoder) WriteBool(val bool) {
+	if val {
+		enc.buf.WriteByte(_mpTrue)
+	} else {
+		enc.buf.WriteByte(_mpFalse)
+	}
+	enc.count++
+}
+
+func (enc *msgpackEncoder) WriteByteString(val []byte) {
+	writeMsgpackStringHeader(enc.buf, len(val))
+	enc.buf.Write(val)
+	enc.count++
+}
+
+func (enc *msgpackEncoder) appendBinary(val []byte) {
+	var b [5]byte
+	switch n := len(val); {
+	case n <= math.MaxUint8:
+		b[0], b[1] = _mpBin8, byte(n)
+		enc.buf.Write(b[:2])
+	case n <= math.MaxUint16:
+		b[0] = _mpBin16
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		enc.buf.Write(b[:3])
+	default:
+		b[0] = _mpBin32
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		enc.buf.Write(b[:5])
+	}
+	enc.buf.Write(val)
+	enc.count++
+}
+
+func (enc *msgpackEncoder) AppendComplex128(val complex128) {
+	// Cast to a platform-independent, fixed-size type.
+	r, i := float64(real(val)), float64(imag(val))
+	s := strconv.FormatFloat(r, 'f', -1, 64) + "+" + strconv.FormatFloat(i, 'f', -1, 64) + "i"
+	enc.WriteString(s)
+}
+
+func (enc *msgpackEncoder) AppendDuration(val time.Duration) {
+	cur := enc.count
+	if enc.EncodeDuration != nil {
+		enc.EncodeDuration(val, enc)
+	}
+	if cur == enc.count {
+		// User-supplied EncodeDuration is a no-op. Fall back to nanoseconds.
+		enc.WriteInt64(int64(val))
+	}
+}
+
+func (enc *msgpackEncoder) WriteFloat64(val float64) {
+	var b [9]byte
+	b[0] = _mpFloat64
+	binary.BigEndian.PutUint64(b[1:], math.Float64bits(val))
+	enc.buf.Write(b[:])
+	enc.count++
+}
+
+func (enc *msgpackEncoder) WriteFloat32(val float32) {
+	var b [5]byte
+	b[0] = _mpFloat32
+	binary.BigEndian.PutUint32(b[1:], math.Float32bits(val))
+	enc.buf.Write(b[:])
+	enc.count++
+}
+
+func (enc *msgpackEncoder) WriteInt64(val int64) {
+	if val >= 0 {
+		enc.WriteUint64(uint64(val))
+		return
+	}
+	var b [9]byte
+	switch {
+	case val >= -32:
+		enc.buf.WriteByte(byte(int8(val))) // negative fixint
+	case val >= math.MinInt8:
+		b[0], b[1] = _mpInt8, byte(int8(val))
+		enc.buf.Write(b[:2])
+	case val >= math.MinInt16:
+		b[0] = _mpInt16
+		binary.BigEndian.PutUint16(b[1:], uint16(int16(val)))
+		enc.buf.Write(b[:3])
+	case val >= math.MinInt32:
+		b[0] = _mpInt32
+		binary.BigEndian.PutUint32(b[1:], uint32(int32(val)))
+		enc.buf.Write(b[:5])
+	default:
+		b[0] = _mpInt64
+		binary.BigEndian.PutUint64(b[1:], uint64(val))
+		enc.buf.Write(b[:9])
+	}
+	enc.count++
+}
+
+func (enc *msgpackEncoder) AppendReflected(val interface{}) error {
+	decoded, err := decodeReflected(val)
+	if err != nil {
+		return err
+	}
+	writeMsgpackDecoded(enc.buf, decoded)
+	enc.count++
+	return nil
+}
+
+// decodeReflected serializes a value with encoding/json and decodes it again,
+// preserving the order of object keys.
+func decodeReflected(val interface{}) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+	js, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(js))
+	d.UseNumber()
+	return decodeJSONValue(d)
+}
+
+func (enc *msgpackEncoder) WriteString(val string) {
+	writeMsgpackString(enc.buf, val)
+	enc.count++
+}
+
+// AppendTime writes a time with the timestamp extension type, using the
+// smallest of its three formats that can represent the time.
+func (enc *msgpackEncoder) AppendTime(val time.Time) {
+	sec, nsec := val.Unix(), int64(val.Nanosecond())
+	var b [15]byte
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		b[0], b[1] = _mpFixExt4, _mpTimestampExt
+		binary.BigEndian.PutUint32(b[2:], uint32(sec))
+		enc.buf.Write(b[:6])
+	case sec>>34 == 0:
+		b[0], b[1] = _mpFixExt8, _mpTimestampExt
+		binary.BigEndian.PutUint64(b[2:], uint64(nsec)<<34|uint64(sec))
+		enc.buf.Write(b[:10])
+	default:
+		b[0], b[1], b[2] = _mpExt8, 12, _mpTimestampExt
+		binary.BigEndian.PutUint32(b[3:], uint32(nsec))
+		binary.BigEndian.PutUint64(b[7:], uint64(sec))
+		enc.buf.Write(b[:15])
+	}
+	enc.count++
+}
+
+func (enc *msgpackEncoder) WriteUint64(val uint64) {
+	var b [9]byte
+	switch {
+	case val <= 127:
+		enc.buf.WriteByte(byte(val)) // positive fixint
+	case val <= math.MaxUint8:
+		b[0], b[1] = _mpUint8, byte(val)
+		enc.buf.Write(b[:2])
+	case val <= math.MaxUint16:
+		b[0] = _mpUint16
+		binary.BigEndian.PutUint16(b[1:], uint16(val))
+		enc.buf.Write(b[:3])
+	case val <= math.MaxUint32:
+		b[0] = _mpUint32
+		binary.BigEndian.PutUint32(b[1:], uint32(val))
+		enc.buf.Write(b[:5])
+	default:
+		b[0] = _mpUint64
+		binary.BigEndian.PutUint64(b[1:], val)
+		enc.buf.Write(b[:9])
+	}
+	enc.count++
+}
+
+func (enc *msgpackEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *msgpackEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *msgpackEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *msgpackEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *msgpackEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *msgpackEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+func (enc *msgpackEncoder) AppendComplex64(v complex64)        { enc.AppendComplex128(complex128(v)) }
+func (enc *msgpackEncoder) WriteInt(v int)                     { enc.WriteInt64(int64(v)) }
+func (enc *msgpackEncoder) WriteInt32(v int32)                 { enc.WriteInt64(int64(v)) }
+func (enc *msgpackEncoder) WriteInt16(v int16)                 { enc.WriteInt64(int64(v)) }
+func (enc *msgpackEncoder) WriteInt8(v int8)                   { enc.WriteInt64(int64(v)) }
+func (enc *msgpackEncoder) WriteUint(v uint)                   { enc.WriteUint64(uint64(v)) }
+func (enc *msgpackEncoder) WriteUint32(v uint32)               { enc.WriteUint64(uint64(v)) }
+func (enc *msgpackEncoder) WriteUint16(v uint16)               { enc.WriteUint64(uint64(v)) }
+func (enc *msgpackEncoder) WriteUint8(v uint8)                 { enc.WriteUint64(uint64(v)) }
+func (enc *msgpackEncoder) WriteUintptr(v uintptr)             { enc.WriteUint64(uint64(v)) }
+
+func (enc *msgpackEncoder) Clone() Encoder {
+	clone := getMsgpackEncoder(enc.EncoderConfig)
+	clone.lengthPrefix = enc.lengthPrefix
+	clone.buf.Write(enc.buf.Bytes())
+	clone.count = enc.count
+	for _, ns := range enc.namespaces {
+		buf := buffer.Get()
+		buf.Write(ns.buf.Bytes())
+		clone.namespaces = append(clone.namespaces, msgpackNamespace{key: ns.key, buf: buf, count: ns.count})
+	}
+	return clone
+}
+
+func (enc *msgpackEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	// The entry's metadata is written to its own map, since the context's
+	// namespaces may still be open.
+	head := getMsgpackEncoder(enc.EncoderConfig)
+	if head.LevelKey != "" {
+		head.addKey(head.LevelKey)
+		cur := head.count
+		if head.EncodeLevel != nil {
+			head.EncodeLevel(ent.Level, head)
+		}
+		if cur == head.count {
+			// User-supplied EncodeLevel was a no-op. Fall back to strings to
+			// keep the map valid.
+			head.WriteString(ent.Level.String())
+		}
+	}
+	if head.TimeKey != "" {
+		head.AddTime(head.TimeKey, ent.Time)
+	}
+	if ent.LoggerName != "" && head.NameKey != "" {
+		head.addKey(head.NameKey)
+		cur := head.count
+		nameEncoder := head.EncodeName
+		if nameEncoder == nil {
+			nameEncoder = FullNameEncoder
+		}
+		nameEncoder(ent.LoggerName, head)
+		if cur == head.count {
+			head.WriteString(ent.LoggerName)
+		}
+	}
+	if ent.Caller.Defined && head.CallerKey != "" {
+		head.addKey(head.CallerKey)
+		cur := head.count
+		if head.EncodeCaller != nil {
+			head.EncodeCaller(ent.Caller, head)
+		}
+		if cur == head.count {
+			head.WriteString(ent.Caller.String())
+		}
+	}
+	if head.MessageKey != "" {
+		head.AddString(head.MessageKey, ent.Message)
+	}
+
+	final := enc.Clone().(*msgpackEncoder)
+	addFields(final, fields)
+	final.closeOpenNamespaces()
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+
+	out := buffer.Get()
+	if enc.lengthPrefix {
+		out.Write([]byte{0, 0, 0, 0})
+	}
+	writeMsgpackMapHeader(out, head.count+final.count)
+	out.Write(head.buf.Bytes())
+	out.Write(final.buf.Bytes())
+	if enc.lengthPrefix {
+		bs := out.Bytes()
+		binary.BigEndian.PutUint32(bs, uint32(len(bs)-4))
+	}
+
+	buffer.Put(head.buf)
+	putMsgpackEncoder(head)
+	buffer.Put(final.buf)
+	putMsgpackEncoder(final)
+	return out, nil
+}
+
+func (enc *msgpackEncoder) addKey(key string) {
+	writeMsgpackString(enc.buf, key)
+}
+
+// closeOpenNamespaces writes each open namespace as a map into its enclosing
+// map.
+func (enc *msgpackEncoder) closeOpenNamespaces() {
+	for i := len(enc.namespaces) - 1; i >= 0; i-- {
+		ns := enc.namespaces[i]
+		inner, count := enc.buf, enc.count
+		enc.buf, enc.count = ns.buf, ns.count
+		writeMsgpackString(enc.buf, ns.key)
+		writeMsgpackMapHeader(enc.buf, count)
+		enc.buf.Write(inner.Bytes())
+		enc.count++
+		buffer.Put(inner)
+	}
+	enc.namespaces = enc.namespaces[:0]
+}
+
+func writeMsgpackString(buf *buffer.Buffer, s string) {
+	writeMsgpackStringHeader(buf, len(s))
+	buf.WriteString(s)
+}
+
+func writeMsgpackStringHeader(buf *buffer.Buffer, n int) {
+	var b [5]byte
+	switch {
+	case n < 32:
+		buf.WriteByte(_mpFixStr | byte(n))
+	case n <= math.MaxUint8:
+		b[0], b[1] = _mpStr8, byte(n)
+		buf.Write(b[:2])
+	case n <= math.MaxUint16:
+		b[0] = _mpStr16
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		buf.Write(b[:3])
+	default:
+		b[0] = _mpStr32
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		buf.Write(b[:5])
+	}
+}
+
+func writeMsgpackArrayHeader(buf *buffer.Buffer, n int) {
+	writeMsgpackCollectionHeader(buf, n, _mpFixArray, _mpArray16, _mpArray32)
+}
+
+func writeMsgpackMapHeader(buf *buffer.Buffer, n int) {
+	writeMsgpackCollectionHeader(buf, n, _mpFixMap, _mpMap16, _mpMap32)
+}
+
+func writeMsgpackCollectionHeader(buf *buffer.Buffer, n int, fix, marker16, marker32 byte) {
+	var b [5]byte
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		b[0] = marker16
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		buf.Write(b[:3])
+	default:
+		b[0] = marker32
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		buf.Write(b[:5])
+	}
+}
+
+// writeMsgpackDecoded writes a value decoded from JSON.
+func writeMsgpackDecoded(buf *buffer.Buffer, v interface{}) {
+	enc := &msgpackEncoder{buf: buf}
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(_mpNil)
+	case bool:
+		enc.WriteBool(v)
+	case string:
+		writeMsgpackString(buf, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			enc.WriteInt64(i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			enc.WriteUint64(u)
+		} else {
+			f, _ := v.Float64()
+			enc.WriteFloat64(f)
+		}
+	case decodedObject:
+		writeMsgpackMapHeader(buf, len(v))
+		for _, kv := range v {
+			writeMsgpackString(buf, kv.key)
+			writeMsgpackDecoded(buf, kv.value)
+		}
+	case decodedArray:
+		writeMsgpackArrayHeader(buf, len(v))
+		for _, elem := range v {
+			writeMsgpackDecoded(buf, elem)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+
+
+package vipercore_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+// msgpackReader is a minimal MessagePack decoder for checking the encoder's
+// output.
+type msgpackReader struct {
+	b []byte
+}
+
+func (r *msgpackReader) next(n int) []byte {
+	if n > len(r.b) {
+		panic(fmt.Sprintf("msgpack: need %d bytes, have %d", n, len(r.b)))
+	}
+	bs := r.b[:n]
+	r.b = r.b[n:]
+	return bs
+}
+
+func (r *msgpackReader) uint(n int) uint64 {
+	bs := r.next(n)
+	var u uint64
+	for _, b := range bs {
+		u = u<<8 | uint64(b)
+	}
+	return u
+}
+
+func (r *msgpackReader) value() interface{} {
+	c := r.next(1)[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c)
+	case c >= 0xe0:
+		return int64(int8(c))
+	case c&0xe0 == 0xa0:
+		return string(r.next(int(c & 0x1f)))
+	case c&0xf0 == 0x90:
+		return r.array(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return r.object(int(c & 0x0f))
+	}
+	switch c {
+	case 0xc0:
+		return nil
+	case 0xc2:
+		return false
+	case 0xc3:
+		return true
+	case 0xc4, 0xc5, 0xc6:
+		return append([]byte(nil), r.next(int(r.uint(1<<(c-0xc4))))...)
+	case 0xc7:
+		n := int(r.uint(1))
+		return r.ext(int8(r.next(1)[0]), n)
+	case 0xd6:
+		return r.ext(int8(r.next(1)[0]), 4)
+	case 0xd7:
+		return r.ext(int8(r.next(1)[0]), 8)
+	case 0xca:
+		return math.Float32frombits(uint32(r.uint(4)))
+	case 0xcb:
+		return math.Float64frombits(r.uint(8))
+	case 0xcc, 0xcd, 0xce:
+		return int64(r.uint(1 << (c - 0xcc)))
+	case 0xcf:
+		return r.uint(8)
+	case 0xd0:
+		return int64(int8(r.uint(1)))
+	case 0xd1:
+		return int64(int16(r.uint(2)))
+	case 0xd2:
+		return int64(int32(r.uint(4)))
+	case 0xd3:
+		return int64(r.uint(8))
+	case 0xd9, 0xda, 0xdb:
+		return string(r.next(int(r.uint(1 << (c - 0xd9)))))
+	case 0xdc, 0xdd:
+		return r.array(int(r.uint(2 << (c - 0xdc))))
+	case 0xde, 0xdf:
+		return r.object(int(r.uint(2 << (c - 0xde))))
+	}
+	panic(fmt.Sprintf("msgpack: unexpected type 0x%x", c))
+}
+
+func (r *msgpackReader) ext(typ int8, n int) interface{} {
+	if typ != -1 {
+		panic(fmt.Sprintf("msgpack: unexpected extension type %d", typ))
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(r.uint(4)), 0)
+	case 8:
+		u := r.uint(8)
+		return time.Unix(int64(u&(1<<34-1)), int64(u>>34))
+	default:
+		nsec := r.uint(4)
+		return time.Unix(int64(r.uint(8)), int64(nsec))
+	}
+}
+
+func (r *msgpackReader) array(n int) []interface{} {
+	arr := make([]interface{}, n)
+	for i := range arr {
+		arr[i] = r.value()
+	}
+	return arr
+}
+
+func (r *msgpackReader) object(n int) map[string]interface{} {
+	obj := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		obj[r.value().(string)] = r.value()
+	}
+	return obj
+}
+
+func decodeMsgpack(t testing.TB, b []byte) interface{} {
+	r := &msgpackReader{b: b}
+	v := r.value()
+	require.Empty(t, r.b, "Unexpected trailing bytes.")
+	return v
+}
+
+func encodeMsgpackEntry(t testing.TB, enc Encoder, ent Entry, fields ...Field) []byte {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buffer.Put(buf)
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+func TestMessagePackEncoderEntry(t *testing.T) {
+	type bar struct {
+		Key string  `json:"key"`
+		Val float64 `json:"val"`
+	}
+
+	enc := NewMessagePackEncoder(testEncoderConfig())
+	enc.AddString("request", "abc")
+	enc.OpenNamespace("http")
+	enc.AddInt("status", 500)
+
+	ts := time.Unix(1500000000, 123000000)
+	out := encodeMsgpackEntry(t, enc, Entry{
+		Level:      WarnLevel,
+		Time:       ts,
+		LoggerName: "bob",
+		Message:    "lob law",
+		Caller:     EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42},
+		Stack:      "fake-stack",
+	},
+		viper.Int("answer", 42),
+		viper.Uint64("big", math.MaxUint64),
+		viper.Float64("pi", 3.14),
+		viper.Float32("e", 2.5),
+		viper.Bool("ok", true),
+		viper.Binary("bin", []byte{0, 1, 2}),
+		viper.ByteString("bs", []byte("bytes")),
+		viper.Duration("elapsed", 1500*time.Millisecond),
+		viper.Time("at", time.Unix(10, 0)),
+		viper.Complex128("c", 1+2i),
+		viper.Strings("tags", []string{"a", "b"}),
+		viper.Reflect("bars", []bar{{Key: "k", Val: 1.5}}),
+		viper.Reflect("nothing", nil),
+		viper.Object("obj", ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+			enc.AddInt8("neg", -100)
+			enc.OpenNamespace("inner")
+			enc.AddString("deep", "yes")
+			return nil
+		})),
+	)
+
+	decoded := decodeMsgpack(t, out)
+	assert.Equal(t, map[string]interface{}{
+		"level":   "warn",
+		"ts":      ts,
+		"name":    "bob",
+		"caller":  "app/main.go:42",
+		"msg":     "lob law",
+		"request": "abc",
+		"http": map[string]interface{}{
+			"status":  int64(500),
+			"answer":  int64(42),
+			"big":     uint64(math.MaxUint64),
+			"pi":      3.14,
+			"e":       float32(2.5),
+			"ok":      true,
+			"bin":     []byte{0, 1, 2},
+			"bs":      "bytes",
+			"elapsed": 1.5,
+			"at":      time.Unix(10, 0),
+			"c":       "1+2i",
+			"tags":    []interface{}{"a", "b"},
+			"bars":    []interface{}{map[string]interface{}{"key": "k", "val": 1.5}},
+			"nothing": nil,
+			"obj": map[string]interface{}{
+				"neg":   int64(-100),
+				"inner": map[string]interface{}{"deep": "yes"},
+			},
+		},
+		"stacktrace": "fake-stack",
+	}, decoded, "Unexpected decoded entry.")
+
+	// The context must be unaffected by encoding an entry.
+	out = encodeMsgpackEntry(t, enc, Entry{Message: "again"})
+	decoded = decodeMsgpack(t, out)
+	assert.Equal(t, "abc", decoded.(map[string]interface{})["request"], "Unexpected context after encoding.")
+	assert.Equal(t, map[string]interface{}{"status": int64(500)}, decoded.(map[string]interface{})["http"], "Unexpected context after encoding.")
+}
+
+func TestMessagePackEncoderPrimitives(t *testing.T) {
+	tests := []struct {
+		desc string
+		f    func(Encoder)
+		want []byte
+	}{
+		{"positive fixint", func(e Encoder) { e.AddInt("k", 127) }, []byte{0x7f}},
+		{"negative fixint", func(e Encoder) { e.AddInt("k", -32) }, []byte{0xe0}},
+		{"uint8", func(e Encoder) { e.AddInt("k", 200) }, []byte{0xcc, 200}},
+		{"int8", func(e Encoder) { e.AddInt("k", -33) }, []byte{0xd0, 0xdf}},
+		{"uint16", func(e Encoder) { e.AddUint16("k", 300) }, []byte{0xcd, 0x01, 0x2c}},
+		{"int16", func(e Encoder) { e.AddInt16("k", -300) }, []byte{0xd1, 0xfe, 0xd4}},
+		{"uint32", func(e Encoder) { e.AddUint32("k", 70000) }, []byte{0xce, 0, 1, 0x11, 0x70}},
+		{"int32", func(e Encoder) { e.AddInt32("k", -70000) }, []byte{0xd2, 0xff, 0xfe, 0xee, 0x90}},
+		{"int64", func(e Encoder) { e.AddInt64("k", math.MinInt64) }, []byte{0xd3, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{"false", func(e Encoder) { e.AddBool("k", false) }, []byte{0xc2}},
+		{"fixstr", func(e Encoder) { e.AddString("k", "hi") }, []byte{0xa2, 'h', 'i'}},
+		{"empty binary", func(e Encoder) { e.AddBinary("k", nil) }, []byte{0xc4, 0}},
+		{"timestamp32", func(e Encoder) { e.AddTime("k", time.Unix(1, 0)) }, []byte{0xd6, 0xff, 0, 0, 0, 1}},
+		{"timestamp64", func(e Encoder) { e.AddTime("k", time.Unix(1, 1)) }, []byte{0xd7, 0xff, 0, 0, 0, 0x04, 0, 0, 0, 1}},
+		{"timestamp96", func(e Encoder) { e.AddTime("k", time.Unix(-1, 0)) }, []byte{0xc7, 12, 0xff, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"empty array", func(e Encoder) { e.AddArray("k", viper.Ints("", nil).Interface.(ArrayMarshaler)) }, []byte{0x90}},
+	}
+
+	cfg := testEncoderConfig()
+	cfg.LevelKey = ""
+	cfg.TimeKey = ""
+	cfg.MessageKey = ""
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc := NewMessagePackEncoder(cfg)
+			tt.f(enc)
+			out := encodeMsgpackEntry(t, enc, Entry{})
+			want := append([]byte{0x81, 0xa1, 'k'}, tt.want...)
+			assert.Equal(t, want, out, "Unexpected encoding.")
+		})
+	}
+}
+
+func TestMessagePackEncoderLargeCollections(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.LevelKey = ""
+	cfg.TimeKey = ""
+	cfg.MessageKey = ""
+	enc := NewMessagePackEncoder(cfg)
+
+	long := strings.Repeat("x", 70000)
+	ints := make([]int, 20)
+	fields := []Field{viper.String("long", long), viper.Ints("ints", ints), viper.Binary("bin", make([]byte, 300))}
+	for i := 0; i < 20; i++ {
+		fields = append(fields, viper.Int(fmt.Sprintf("f%d", i), i))
+	}
+	decoded := decodeMsgpack(t, encodeMsgpackEntry(t, enc, Entry{}, fields...)).(map[string]interface{})
+	assert.Len(t, decoded, 23, "Unexpected number of keys.")
+	assert.Equal(t, long, decoded["long"], "Unexpected long string.")
+	assert.Len(t, decoded["ints"], 20, "Unexpected array length.")
+	assert.Len(t, decoded["bin"], 300, "Unexpected binary length.")
+}
+
+func TestMessagePackEncoderLengthPrefix(t *testing.T) {
+	enc := NewMessagePackEncoder(testEncoderConfig(), MessagePackLengthPrefix())
+	out := encodeMsgpackEntry(t, enc.Clone(), Entry{Message: "framed"})
+	require.True(t, len(out) > 4, "Expected a length prefix.")
+	assert.Equal(t, uint32(len(out)-4), binary.BigEndian.Uint32(out), "Unexpected length prefix.")
+	assert.Equal(t, "framed", decodeMsgpack(t, out[4:]).(map[string]interface{})["msg"], "Unexpected framed entry.")
+}
+
+func TestMessagePackEncoderFallbacks(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.EncodeLevel = func(Level, PrimitiveArrayEncoder) {}
+	cfg.EncodeDuration = func(time.Duration, PrimitiveArrayEncoder) {}
+	cfg.EncodeCaller = func(EntryCaller, PrimitiveArrayEncoder) {}
+	cfg.EncodeName = func(string, PrimitiveArrayEncoder) {}
+	enc := NewMessagePackEncoder(cfg)
+
+	out := encodeMsgpackEntry(t, enc, Entry{
+		Level:      ErrorLevel,
+		LoggerName: "name",
+		Caller:     EntryCaller{Defined: true, File: "f.go", Line: 1},
+	}, viper.Duration("d", time.Second))
+	decoded := decodeMsgpack(t, out).(map[string]interface{})
+	assert.Equal(t, "error", decoded["level"], "Unexpected level fallback.")
+	assert.Equal(t, "name", decoded["name"], "Unexpected name fallback.")
+	assert.Equal(t, "f.go:1", decoded["caller"], "Unexpected caller fallback.")
+	assert.Equal(t, int64(time.Second), decoded["d"], "Unexpected duration fallback.")
+}
+
+func TestMessagePackEncoderMarshalerErrors(t *testing.T) {
+	enc := NewMessagePackEncoder(testEncoderConfig())
+	fail := errors.New("fail")
+	assert.Equal(t, fail, enc.AddObject("obj", ObjectMarshalerFunc(func(ObjectEncoder) error { return fail })))
+	assert.Equal(t, fail, enc.AddArray("arr", ArrayMarshalerFunc(func(ArrayEncoder) error { return fail })))
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected an error reflecting a channel.")
+
+	decoded := decodeMsgpack(t, encodeMsgpackEntry(t, enc, Entry{})).(map[string]interface{})
+	assert.NotContains(t, decoded, "ch", "Expected failed reflected fields to be omitted.")
+	assert.Equal(t, map[string]interface{}{}, decoded["obj"], "Unexpected object after a marshaler error.")
+}
@@ -0,0 +1,281 @@
+
+
+package vipercore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+
+	"github.com/gottingen/buffer"
+)
+
+// OpenTelemetry severity numbers, from the log data model.
+const (
+	_otlpSeverityTrace  = 1
+	_otlpSeverityDebug  = 5
+	_otlpSeverityInfo   = 9
+	_otlpSeverityInfo2  = 10
+	_otlpSeverityWarn   = 13
+	_otlpSeverityError  = 17
+	_otlpSeverityError2 = 18
+	_otlpSeverityError3 = 19
+	_otlpSeverityError4 = 20
+	_otlpSeverityFatal  = 21
+	_otlpSeverityFatal4 = 24
+)
+
+var _levelToOTLPSeverity = map[Level]int{
+	TraceLevel:    _otlpSeverityTrace,
+	DebugLevel:    _otlpSeverityDebug,
+	InfoLevel:     _otlpSeverityInfo,
+	NoticeLevel:   _otlpSeverityInfo2,
+	WarnLevel:     _otlpSeverityWarn,
+	ErrorLevel:    _otlpSeverityError,
+	CriticalLevel: _otlpSeverityError2,
+	DPanicLevel:   _otlpSeverityError3,
+	PanicLevel:    _otlpSeverityError4,
+	FatalLevel:    _otlpSeverityFatal,
+}
+
+// Default keys of the fields that carry trace context.
+const (
+	DefaultOTLPTraceIDKey = "trace_id"
+	DefaultOTLPSpanIDKey  = "span_id"
+)
+
+// A ResourceEncoder is an Encoder for formats that describe the source of the
+// logs separately from each entry, like OpenTelemetry's resources. Config
+// passes its InitialFields to such encoders rather than adding them to every
+// entry.
+type ResourceEncoder interface {
+	Encoder
+
+	// WithResource returns a copy of the encoder that writes the fields as
+	// the resource of every entry.
+	WithResource([]Field) Encoder
+}
+
+// An OTLPOption configures an OTLP encoder.
+type OTLPOption interface {
+	applyOTLPOption(*otlpEncoder)
+}
+
+type otlpOptionFunc func(*otlpEncoder)
+
+func (f otlpOptionFunc) applyOTLPOption(enc *otlpEncoder) {
+	f(enc)
+}
+
+// OTLPTraceKeys sets the keys of the string fields that carry the hex-encoded
+// trace and span IDs, which default to DefaultOTLPTraceIDKey and
+// DefaultOTLPSpanIDKey.
+func OTLPTraceKeys(traceIDKey, spanIDKey string) OTLPOption {
+	return otlpOptionFunc(func(enc *otlpEncoder) {
+		enc.traceIDKey = traceIDKey
+		enc.spanIDKey = spanIDKey
+	})
+}
+
+type otlpEncoder struct {
+	*treeEncoder
+
+	traceIDKey, spanIDKey string
+	resource              decodedObject
+}
+
+// NewOTLPEncoder creates an encoder that writes each entry as a line of
+// OpenTelemetry Protocol (OTLP) JSON, holding an ExportLogsServiceRequest with
+// a single LogRecord. Each line is a complete OTLP/HTTP request body, so
+// files can be read by an OpenTelemetry Collector without parsing rules and
+// lines can be batched by concatenating their resourceLogs.
+//
+// Levels are mapped to severity numbers and texts, the time is written in
+// nanoseconds, the message becomes the body, and the logger name becomes the
+// name of the instrumentation scope. Callers and stacktraces are written as
+// the code.filepath, code.lineno, and exception.stacktrace attributes. As
+// with other encoders, each of these is omitted if its key is empty.
+//
+// Fields become attributes. Nested objects and namespaces are written as
+// kvlistValues and arrays as arrayValues. Since fields are formatted as the
+// JSON encoder formats them, their types are inferred from those encodings,
+// and durations and times are encoded with the EncoderConfig's encoders. Top-level string fields
+// holding valid hex-encoded trace and span IDs are written to the record's
+// traceId and spanId instead; see OTLPTraceKeys.
+func NewOTLPEncoder(cfg EncoderConfig, opts ...OTLPOption) Encoder {
+	enc := &otlpEncoder{
+		treeEncoder: newTreeEncoder(&cfg),
+		traceIDKey:  DefaultOTLPTraceIDKey,
+		spanIDKey:   DefaultOTLPSpanIDKey,
+	}
+	for _, opt := range opts {
+		opt.applyOTLPOption(enc)
+	}
+	return enc
+}
+
+func (c *otlpEncoder) Clone() Encoder {
+	return c.clone()
+}
+
+func (c *otlpEncoder) clone() *otlpEncoder {
+	return &otlpEncoder{
+		treeEncoder: c.treeEncoder.clone(),
+		traceIDKey:  c.traceIDKey,
+		spanIDKey:   c.spanIDKey,
+		resource:    c.resource,
+	}
+}
+
+// WithResource returns a copy of the encoder that writes the fields as the
+// attributes of the resource.
+func (c *otlpEncoder) WithResource(fields []Field) Encoder {
+	clone := c.clone()
+	if len(fields) > 0 {
+		resource := newTreeEncoder(c.EncoderConfig).withFields(fields)
+		clone.resource = append(append(decodedObject{}, c.resource...), resource...)
+	}
+	return clone
+}
+
+func (c *otlpEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	obj := c.withFields(fields)
+	var traceID, spanID string
+	attrs := make(decodedObject, 0, len(obj)+3)
+	for _, kv := range obj {
+		if s, ok := kv.value.(string); ok {
+			switch {
+			case kv.key == c.traceIDKey && isOTLPID(s, 16):
+				traceID = s
+				continue
+			case kv.key == c.spanIDKey && isOTLPID(s, 8):
+				spanID = s
+				continue
+			}
+		}
+		attrs = append(attrs, kv)
+	}
+	if ent.Caller.Defined && c.CallerKey != "" {
+		attrs = append(attrs,
+			decodedKV{key: "code.filepath", value: ent.Caller.File},
+			decodedKV{key: "code.lineno", value: json.Number(strconv.Itoa(ent.Caller.Line))},
+		)
+	}
+	if ent.Stack != "" && c.StacktraceKey != "" {
+		attrs = append(attrs, decodedKV{key: "exception.stacktrace", value: ent.Stack})
+	}
+
+	out := newJSONEncoder(*c.EncoderConfig, false)
+	out.buf.WriteString(`{"resourceLogs":[{"resource":{`)
+	if len(c.resource) > 0 {
+		out.addKey("attributes")
+		writeOTLPKeyValues(out, c.resource)
+	}
+	out.buf.WriteString(`},"scopeLogs":[{"scope":{`)
+	if ent.LoggerName != "" && c.NameKey != "" {
+		out.AddString("name", ent.LoggerName)
+	}
+	out.buf.WriteString(`},"logRecords":[{`)
+	if c.TimeKey != "" && !ent.Time.IsZero() {
+		out.AddString("timeUnixNano", strconv.FormatInt(ent.Time.UnixNano(), 10))
+	}
+	if c.LevelKey != "" {
+		out.AddInt64("severityNumber", int64(otlpSeverity(ent.Level)))
+		out.AddString("severityText", ent.Level.CapitalString())
+	}
+	if c.MessageKey != "" {
+		out.addKey("body")
+		writeOTLPAnyValue(out, ent.Message)
+	}
+	if len(attrs) > 0 {
+		out.addKey("attributes")
+		writeOTLPKeyValues(out, attrs)
+	}
+	if traceID != "" {
+		out.AddString("traceId", traceID)
+	}
+	if spanID != "" {
+		out.AddString("spanId", spanID)
+	}
+	out.buf.WriteString(`}]}]}]}`)
+	if c.LineEnding != "" {
+		out.buf.WriteString(c.LineEnding)
+	} else {
+		out.buf.WriteString(DefaultLineEnding)
+	}
+	return out.buf, nil
+}
+
+// otlpSeverity maps a level to a severity number. Custom levels beyond the
+// built-in ones get the lowest or highest severity.
+func otlpSeverity(l Level) int {
+	if n, ok := _levelToOTLPSeverity[l]; ok {
+		return n
+	}
+	if l < TraceLevel {
+		return _otlpSeverityTrace
+	}
+	return _otlpSeverityFatal4
+}
+
+// isOTLPID reports whether s is a hex-encoded, non-zero ID of n bytes.
+func isOTLPID(s string, n int) bool {
+	if len(s) != 2*n {
+		return false
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeOTLPKeyValues(out *jsonEncoder, obj decodedObject) {
+	out.buf.WriteByte('[')
+	for _, kv := range obj {
+		out.addElementSeparator()
+		out.buf.WriteByte('{')
+		out.AddString("key", kv.key)
+		out.addKey("value")
+		writeOTLPAnyValue(out, kv.value)
+		out.buf.WriteByte('}')
+	}
+	out.buf.WriteByte(']')
+}
+
+// writeOTLPAnyValue writes a decoded JSON value as an AnyValue. As in the
+// protobuf JSON mapping, 64-bit integers are written as strings.
+func writeOTLPAnyValue(out *jsonEncoder, v interface{}) {
+	out.addElementSeparator()
+	out.buf.WriteByte('{')
+	switch v := v.(type) {
+	case string:
+		out.AddString("stringValue", v)
+	case bool:
+		out.AddBool("boolValue", v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			out.AddString("intValue", strconv.FormatInt(i, 10))
+		} else {
+			out.addKey("doubleValue")
+			out.buf.WriteString(string(v))
+		}
+	case decodedObject:
+		out.buf.WriteString(`"kvlistValue":{"values":`)
+		writeOTLPKeyValues(out, v)
+		out.buf.WriteByte('}')
+	case decodedArray:
+		out.buf.WriteString(`"arrayValue":{"values":[`)
+		for _, elem := range v {
+			writeOTLPAnyValue(out, elem)
+		}
+		out.buf.WriteString(`]}`)
+	}
+	// Nulls are written as empty AnyValues.
+	out.buf.WriteByte('}')
+}
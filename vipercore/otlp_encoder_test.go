@@ -0,0 +1,191 @@
+package vipercore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func encodeOTLPEntry(t testing.TB, enc Encoder, ent Entry, fields ...Field) string {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buffer.Put(buf)
+	require.True(t, json.Valid(buf.Bytes()), "Encoded entry isn't valid JSON: %s", buf.String())
+	return buf.String()
+}
+
+func TestOTLPEncoderEntry(t *testing.T) {
+	enc := NewOTLPEncoder(testEncoderConfig())
+	enc.AddString("request", "abc")
+	enc.OpenNamespace("http")
+	enc.AddInt("status", 500)
+
+	out := encodeOTLPEntry(t, enc, Entry{
+		Level:      WarnLevel,
+		Time:       time.Unix(1500000000, 123456789),
+		LoggerName: "bob",
+		Message:    "lob law",
+		Caller:     EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42},
+		Stack:      "fake-stack",
+	},
+		viper.Uint64("big", math.MaxUint64),
+		viper.Float64("pi", 3.14),
+		viper.Bool("ok", true),
+		viper.Strings("tags", []string{"a"}),
+		viper.Reflect("nothing", nil),
+	)
+	assert.Equal(t, `{"resourceLogs":[{"resource":{},"scopeLogs":[{"scope":{"name":"bob"},"logRecords":[{`+
+		`"timeUnixNano":"1500000000123456789","severityNumber":13,"severityText":"WARN",`+
+		`"body":{"stringValue":"lob law"},"attributes":[`+
+		`{"key":"request","value":{"stringValue":"abc"}},`+
+		`{"key":"http","value":{"kvlistValue":{"values":[`+
+		`{"key":"status","value":{"intValue":"500"}},`+
+		`{"key":"big","value":{"doubleValue":18446744073709551615}},`+
+		`{"key":"pi","value":{"doubleValue":3.14}},`+
+		`{"key":"ok","value":{"boolValue":true}},`+
+		`{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"}]}}},`+
+		`{"key":"nothing","value":{}}]}}},`+
+		`{"key":"code.filepath","value":{"stringValue":"/src/app/main.go"}},`+
+		`{"key":"code.lineno","value":{"intValue":"42"}},`+
+		`{"key":"exception.stacktrace","value":{"stringValue":"fake-stack"}}]`+
+		`}]}]}]}`+"\n", out, "Unexpected OTLP JSON.")
+}
+
+func TestOTLPEncoderSeverity(t *testing.T) {
+	enc := NewOTLPEncoder(testEncoderConfig())
+	tests := []struct {
+		level Level
+		num   int
+		text  string
+	}{
+		{TraceLevel, 1, "TRACE"},
+		{DebugLevel, 5, "DEBUG"},
+		{InfoLevel, 9, "INFO"},
+		{NoticeLevel, 10, "NOTICE"},
+		{WarnLevel, 13, "WARN"},
+		{ErrorLevel, 17, "ERROR"},
+		{CriticalLevel, 18, "CRITICAL"},
+		{DPanicLevel, 19, "DPANIC"},
+		{PanicLevel, 20, "PANIC"},
+		{FatalLevel, 21, "FATAL"},
+		{Level(-10), 1, "LEVEL(-10)"},
+		{Level(20), 24, "LEVEL(20)"},
+	}
+	for _, tt := range tests {
+		var req struct {
+			ResourceLogs []struct {
+				ScopeLogs []struct {
+					LogRecords []struct {
+						SeverityNumber int    `json:"severityNumber"`
+						SeverityText   string `json:"severityText"`
+					} `json:"logRecords"`
+				} `json:"scopeLogs"`
+			} `json:"resourceLogs"`
+		}
+		out := encodeOTLPEntry(t, enc, Entry{Level: tt.level})
+		require.NoError(t, json.Unmarshal([]byte(out), &req), "Unexpected error unmarshaling OTLP JSON.")
+		rec := req.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+		assert.Equal(t, tt.num, rec.SeverityNumber, "Unexpected severity number for %v.", tt.level)
+		assert.Equal(t, tt.text, rec.SeverityText, "Unexpected severity text for %v.", tt.level)
+	}
+}
+
+func TestOTLPEncoderTraceContext(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	cfg := testEncoderConfig()
+	cfg.LevelKey = ""
+	cfg.MessageKey = ""
+
+	out := encodeOTLPEntry(t, NewOTLPEncoder(cfg), Entry{},
+		viper.String("trace_id", traceID),
+		viper.String("span_id", spanID),
+	)
+	assert.Contains(t, out, `"logRecords":[{"traceId":"`+traceID+`","spanId":"`+spanID+`"}]`, "Expected trace context.")
+
+	out = encodeOTLPEntry(t, NewOTLPEncoder(cfg), Entry{},
+		viper.String("trace_id", "not-hex"),
+		viper.String("span_id", "0000000000000000"),
+	)
+	assert.Contains(t, out, `"logRecords":[{"attributes":[`+
+		`{"key":"trace_id","value":{"stringValue":"not-hex"}},`+
+		`{"key":"span_id","value":{"stringValue":"0000000000000000"}}]}]`,
+		"Expected invalid IDs to remain attributes.")
+
+	out = encodeOTLPEntry(t, NewOTLPEncoder(cfg, OTLPTraceKeys("dd.trace_id", "dd.span_id")), Entry{},
+		viper.String("dd.trace_id", traceID),
+		viper.String("trace_id", traceID),
+	)
+	assert.Contains(t, out, `"logRecords":[{"attributes":[`+
+		`{"key":"trace_id","value":{"stringValue":"`+traceID+`"}}],"traceId":"`+traceID+`"}]`,
+		"Expected custom trace keys.")
+}
+
+func TestOTLPEncoderResource(t *testing.T) {
+	enc := NewOTLPEncoder(testEncoderConfig())
+	re, ok := enc.(ResourceEncoder)
+	require.True(t, ok, "Expected the OTLP encoder to be a ResourceEncoder.")
+	withResource := re.WithResource([]Field{
+		viper.String("service.name", "api"),
+		viper.Int("shard", 3),
+	})
+	withResource.AddString("k", "v")
+
+	ent := Entry{}
+	assert.Equal(t, `{"resourceLogs":[{"resource":{"attributes":[`+
+		`{"key":"service.name","value":{"stringValue":"api"}},`+
+		`{"key":"shard","value":{"intValue":"3"}}]},`+
+		`"scopeLogs":[{"scope":{},"logRecords":[{"severityNumber":9,"severityText":"INFO","body":{"stringValue":""},`+
+		`"attributes":[{"key":"k","value":{"stringValue":"v"}}]}]}]}]}`+"\n",
+		encodeOTLPEntry(t, withResource, ent), "Unexpected resource.")
+	assert.Contains(t, encodeOTLPEntry(t, enc, ent), `"resource":{}`, "Expected the original encoder to be unaffected.")
+	assert.Contains(t, encodeOTLPEntry(t, withResource.Clone(), ent), `"key":"service.name"`, "Expected clones to keep the resource.")
+}
+
+func TestOTLPEncoderOmitsEmptyKeys(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.LevelKey = ""
+	cfg.TimeKey = ""
+	cfg.NameKey = ""
+	cfg.CallerKey = ""
+	cfg.MessageKey = ""
+	cfg.StacktraceKey = ""
+
+	out := encodeOTLPEntry(t, NewOTLPEncoder(cfg), Entry{
+		Level:      ErrorLevel,
+		Time:       time.Unix(1, 0),
+		LoggerName: "name",
+		Message:    "msg",
+		Caller:     EntryCaller{Defined: true, File: "f.go", Line: 1},
+		Stack:      "stack",
+	})
+	assert.Equal(t, `{"resourceLogs":[{"resource":{},"scopeLogs":[{"scope":{},"logRecords":[{}]}]}]}`+"\n", out, "Expected an empty record.")
+}
+
+func TestOTLPEncoderMarshalerErrors(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.LevelKey = ""
+	cfg.MessageKey = ""
+	enc := NewOTLPEncoder(cfg)
+	fail := errors.New("fail")
+	out := encodeOTLPEntry(t, enc, Entry{},
+		viper.Object("obj", ObjectMarshalerFunc(func(ObjectEncoder) error { return fail })),
+		viper.String("after", "errors"),
+	)
+	assert.Contains(t, out, `"attributes":[`+
+		`{"key":"obj","value":{"kvlistValue":{"values":[]}}},`+
+		`{"key":"objError","value":{"stringValue":"fail"}},`+
+		`{"key":"after","value":{"stringValue":"errors"}}]`,
+		"Unexpected attributes after marshaler errors.")
+}
@@ -0,0 +1,404 @@
+
+
+package vipercore
+
+import (
+	"encoding/json"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gottingen/atomic"
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper/internal/color"
+)
+
+const (
+	// Columns are padded to align with earlier entries, up to these widths.
+	// Levels start out wide enough for the common level names.
+	_prettyMinLevelWidth  = 5
+	_prettyMaxLevelWidth  = 8
+	_prettyMaxNameWidth   = 24
+	_prettyMaxCallerWidth = 40
+	// Messages followed by fields are padded to this width.
+	_prettyMessageWidth = 40
+
+	_prettyFieldIndent = "    "
+)
+
+// _mainModule is the path of the main module, used to tell application
+// frames in stacktraces apart from library frames.
+var _mainModule = func() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Path
+	}
+	return ""
+}()
+
+// prettyWidths are the current widths of the aligned columns, shared by an
+// encoder and its clones.
+type prettyWidths struct {
+	level, name, caller atomic.Int32
+}
+
+// fitWidth grows a column to fit n runes, up to max, and returns the width to
+// pad the column to.
+func fitWidth(w *atomic.Int32, n, max int) int {
+	for {
+		cur := int(w.Load())
+		if n <= cur || cur >= max {
+			return cur
+		}
+		next := n
+		if next > max {
+			next = max
+		}
+		if w.CAS(int32(cur), int32(next)) {
+			return next
+		}
+	}
+}
+
+type prettyConsoleEncoder struct {
+	*treeEncoder
+
+	color  bool
+	widths *prettyWidths
+}
+
+// NewPrettyConsoleEncoder creates an encoder for reading logs during local
+// development. Like the console encoder, it writes the entry's metadata as
+// plain text, but it aligns the columns with earlier entries, writes fields
+// as key=value pairs after the message, and pretty-prints nested objects and
+// arrays on the following lines.
+//
+// If color is true, levels are colored, timestamps and callers are dimmed,
+// field keys are highlighted, and the application's frames are emphasized in
+// stacktraces. Callers are responsible for disabling color when the output
+// isn't a terminal.
+//
+// Levels are always written as capitalized names, regardless of the
+// configured LevelEncoder. As with the console encoder, any element whose key
+// is set to the empty string is omitted.
+func NewPrettyConsoleEncoder(cfg EncoderConfig, color bool) Encoder {
+	widths := &prettyWidths{}
+	widths.level.Store(_prettyMinLevelWidth)
+	return prettyConsoleEncoder{
+		treeEncoder: newTreeEncoder(&cfg),
+		color:       color,
+		widths:      widths,
+	}
+}
+
+func (c prettyConsoleEncoder) Clone() Encoder {
+	return prettyConsoleEncoder{
+		treeEncoder: c.treeEncoder.clone(),
+		color:       c.color,
+		widths:      c.widths,
+	}
+}
+
+func (c prettyConsoleEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	var inline, nested decodedObject
+	for _, kv := range c.withFields(fields) {
+		if isNestedValue(kv.value) {
+			nested = append(nested, kv)
+		} else {
+			inline = append(inline, kv)
+		}
+	}
+
+	line := &prettyLine{buf: buffer.Get(), color: c.color}
+	if c.TimeKey != "" && c.EncodeTime != nil {
+		line.column(encodePrimitives(func(arr PrimitiveArrayEncoder) {
+			c.EncodeTime(ent.Time, arr)
+		}), 0, color.Faint)
+	}
+	if c.LevelKey != "" && c.EncodeLevel != nil {
+		lvl := ent.Level.CapitalString()
+		style, ok := _levelToColor[ent.Level]
+		if !ok {
+			if _, custom := lookupCustomLevel(ent.Level); custom {
+				style = customLevelColor(ent.Level)
+			} else {
+				style = _unknownLevelColor
+			}
+		}
+		line.column(lvl, fitWidth(&c.widths.level, utf8.RuneCountInString(lvl), _prettyMaxLevelWidth), style)
+	}
+	if c.NameKey != "" {
+		var name string
+		if ent.LoggerName != "" {
+			nameEncoder := c.EncodeName
+			if nameEncoder == nil {
+				nameEncoder = FullNameEncoder
+			}
+			name = encodePrimitives(func(arr PrimitiveArrayEncoder) {
+				nameEncoder(ent.LoggerName, arr)
+			})
+		}
+		line.column(name, fitWidth(&c.widths.name, utf8.RuneCountInString(name), _prettyMaxNameWidth), color.Bold)
+	}
+	if c.CallerKey != "" && c.EncodeCaller != nil {
+		var caller string
+		if ent.Caller.Defined {
+			caller = encodePrimitives(func(arr PrimitiveArrayEncoder) {
+				c.EncodeCaller(ent.Caller, arr)
+			})
+		}
+		line.column(caller, fitWidth(&c.widths.caller, utf8.RuneCountInString(caller), _prettyMaxCallerWidth), color.Faint)
+	}
+	if c.MessageKey != "" {
+		width := 0
+		if len(inline) > 0 {
+			width = _prettyMessageWidth
+		}
+		line.column(ent.Message, width, 0)
+	}
+
+	for i, kv := range inline {
+		if i == 0 {
+			line.gap()
+		} else {
+			line.buf.WriteByte(' ')
+		}
+		line.styled(kv.key, color.Cyan)
+		line.buf.WriteByte('=')
+		line.inlineValue(kv.value)
+	}
+	for _, kv := range nested {
+		line.buf.WriteByte('\n')
+		line.buf.WriteString(_prettyFieldIndent)
+		line.styled(kv.key, color.Cyan)
+		line.buf.WriteByte('=')
+		line.nestedValue(kv.value, _prettyFieldIndent)
+	}
+
+	// If there's no stacktrace key, honor that; this allows users to force
+	// single-line output.
+	if ent.Stack != "" && c.StacktraceKey != "" {
+		line.stacktrace(ent.Stack)
+	}
+
+	if c.LineEnding != "" {
+		line.buf.WriteString(c.LineEnding)
+	} else {
+		line.buf.WriteString(DefaultLineEnding)
+	}
+	return line.buf, nil
+}
+
+func encodePrimitives(encode func(PrimitiveArrayEncoder)) string {
+	arr := getSliceEncoder()
+	defer putSliceEncoder(arr)
+
+	encode(arr)
+	var sb strings.Builder
+	for i, elem := range arr.elems {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(stringOf(elem))
+	}
+	return sb.String()
+}
+
+func stringOf(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "?"
+		}
+		return string(b)
+	}
+}
+
+// isNestedValue reports whether a value is printed on its own lines: objects
+// and arrays that contain objects or arrays.
+func isNestedValue(v interface{}) bool {
+	switch v := v.(type) {
+	case decodedObject:
+		return len(v) > 0
+	case decodedArray:
+		for _, elem := range v {
+			switch elem.(type) {
+			case decodedObject, decodedArray:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prettyLine writes the columns of a pretty-printed entry, padding each
+// column only once the next one is written.
+type prettyLine struct {
+	buf   *buffer.Buffer
+	color bool
+	pad   int
+}
+
+func (l *prettyLine) column(s string, width int, style color.Color) {
+	if s == "" && width == 0 {
+		return
+	}
+	l.gap()
+	l.styled(s, style)
+	l.pad = width - utf8.RuneCountInString(s)
+	if l.pad < 0 {
+		l.pad = 0
+	}
+}
+
+// gap separates the next column from the previous one.
+func (l *prettyLine) gap() {
+	if l.buf.Len() > 0 {
+		l.buf.WriteString(strings.Repeat(" ", l.pad+2))
+	}
+	l.pad = 0
+}
+
+func (l *prettyLine) styled(s string, style color.Color) {
+	if l.color && style != 0 && s != "" {
+		l.buf.WriteString(style.Add(s))
+		return
+	}
+	l.buf.WriteString(s)
+}
+
+func (l *prettyLine) inlineValue(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		if needsQuotes(v) {
+			v = strconv.Quote(v)
+		}
+		l.buf.WriteString(v)
+	case decodedObject:
+		l.buf.WriteString("{}")
+	case decodedArray:
+		l.buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				l.buf.WriteString(", ")
+			}
+			l.scalar(elem)
+		}
+		l.buf.WriteByte(']')
+	default:
+		l.buf.WriteString(stringOf(v))
+	}
+}
+
+// nestedValue writes an indented JSON rendering of v, keeping the order of
+// object keys.
+func (l *prettyLine) nestedValue(v interface{}, indent string) {
+	const step = "  "
+	switch v := v.(type) {
+	case decodedObject:
+		if len(v) == 0 {
+			l.buf.WriteString("{}")
+			return
+		}
+		l.buf.WriteString("{\n")
+		for i, kv := range v {
+			l.buf.WriteString(indent + step)
+			l.styled(strconv.Quote(kv.key), color.Cyan)
+			l.buf.WriteString(": ")
+			l.nestedValue(kv.value, indent+step)
+			if i < len(v)-1 {
+				l.buf.WriteByte(',')
+			}
+			l.buf.WriteByte('\n')
+		}
+		l.buf.WriteString(indent + "}")
+	case decodedArray:
+		if len(v) == 0 {
+			l.buf.WriteString("[]")
+			return
+		}
+		l.buf.WriteString("[\n")
+		for i, elem := range v {
+			l.buf.WriteString(indent + step)
+			l.nestedValue(elem, indent+step)
+			if i < len(v)-1 {
+				l.buf.WriteByte(',')
+			}
+			l.buf.WriteByte('\n')
+		}
+		l.buf.WriteString(indent + "]")
+	default:
+		l.scalar(v)
+	}
+}
+
+func (l *prettyLine) scalar(v interface{}) {
+	if s, ok := v.(string); ok {
+		l.buf.WriteString(strconv.Quote(s))
+		return
+	}
+	l.buf.WriteString(stringOf(v))
+}
+
+// stacktrace writes a stacktrace on the following lines. With color, the
+// application's frames are emphasized and all others are dimmed.
+func (l *prettyLine) stacktrace(stack string) {
+	app := false
+	for _, s := range strings.Split(stack, "\n") {
+		l.buf.WriteByte('\n')
+		isFunc := !strings.HasPrefix(s, "\t")
+		if isFunc {
+			app = isAppFrame(s)
+		}
+		switch {
+		case !app:
+			l.styled(s, color.Faint)
+		case isFunc:
+			l.styled(s, color.Bold)
+		default:
+			l.buf.WriteString(s)
+		}
+	}
+}
+
+// isAppFrame reports whether the function of a stacktrace frame belongs to
+// the application. Without module information, every package outside the
+// standard library and viper itself is considered part of the application.
+func isAppFrame(fn string) bool {
+	if strings.HasPrefix(fn, "main.") {
+		return true
+	}
+	if _mainModule != "" {
+		return strings.HasPrefix(fn, _mainModule+"/") || strings.HasPrefix(fn, _mainModule+".")
+	}
+	first := fn
+	if i := strings.IndexByte(fn, '/'); i >= 0 {
+		first = fn[:i]
+	}
+	if !strings.Contains(first, ".") {
+		return false
+	}
+	return !strings.HasPrefix(fn, "github.com/gottingen/viper/") && !strings.HasPrefix(fn, "github.com/gottingen/viper.")
+}
+
+func needsQuotes(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r == '"' || r == '=' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
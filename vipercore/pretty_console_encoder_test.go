@@ -0,0 +1,128 @@
+
+
+package vipercore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+var (
+	_prettyTime   = time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	_prettyCaller = EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42}
+)
+
+func prettyEncoderConfig() EncoderConfig {
+	cfg := testEncoderConfig()
+	cfg.EncodeTime = ISO8601TimeEncoder
+	return cfg
+}
+
+func encodePretty(t testing.TB, enc Encoder, ent Entry, fields ...Field) string {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buffer.Put(buf)
+	return buf.String()
+}
+
+func TestPrettyConsoleEncoderAlignment(t *testing.T) {
+	enc := NewPrettyConsoleEncoder(prettyEncoderConfig(), false)
+
+	out := encodePretty(t, enc, Entry{Level: InfoLevel, Time: _prettyTime, LoggerName: "db", Caller: _prettyCaller, Message: "connected"},
+		viper.String("host", "localhost"), viper.Int("port", 5432))
+	out += encodePretty(t, enc, Entry{Level: WarnLevel, Time: _prettyTime, LoggerName: "payments", Message: "slow"},
+		viper.Duration("latency", 1500*time.Millisecond), viper.String("query", "SELECT 1"), viper.String("empty", ""))
+	out += encodePretty(t, enc, Entry{Level: ErrorLevel, Time: _prettyTime, LoggerName: "db", Caller: _prettyCaller, Message: "no fields"})
+	out += encodePretty(t, enc.Clone(), Entry{Level: DPanicLevel, Time: _prettyTime, Message: "clone"},
+		viper.Strings("tags", []string{"a", "b c"}), viper.Reflect("none", nil))
+
+	assert.Equal(
+		t,
+		"2017-07-14T02:40:00.000Z  INFO   db  app/main.go:42  connected                                 host=localhost port=5432\n"+
+			"2017-07-14T02:40:00.000Z  WARN   payments                  slow                                      latency=1.5 query=\"SELECT 1\" empty=\"\"\n"+
+			"2017-07-14T02:40:00.000Z  ERROR  db        app/main.go:42  no fields\n"+
+			"2017-07-14T02:40:00.000Z  DPANIC                            clone                                     tags=[\"a\", \"b c\"] none=null\n",
+		out,
+		"Unexpected aligned output.",
+	)
+}
+
+func TestPrettyConsoleEncoderNested(t *testing.T) {
+	type user struct {
+		Name  string   `json:"name"`
+		Roles []string `json:"roles"`
+	}
+
+	enc := NewPrettyConsoleEncoder(prettyEncoderConfig(), false)
+	enc.AddString("request", "abc")
+	enc.OpenNamespace("http")
+	enc.AddInt("status", 500)
+
+	out := encodePretty(t, enc, Entry{Level: ErrorLevel, Time: _prettyTime, Message: "failed"},
+		viper.Reflect("user", user{Name: "alice", Roles: []string{"admin"}}),
+		viper.Reflect("empty", struct{}{}),
+		viper.Reflect("batches", [][]int{{1, 2}, {}}),
+	)
+	assert.Equal(
+		t,
+		"2017-07-14T02:40:00.000Z  ERROR  failed                                    request=abc\n"+
+			"    http={\n"+
+			"      \"status\": 500,\n"+
+			"      \"user\": {\n"+
+			"        \"name\": \"alice\",\n"+
+			"        \"roles\": [\n"+
+			"          \"admin\"\n"+
+			"        ]\n"+
+			"      },\n"+
+			"      \"empty\": {},\n"+
+			"      \"batches\": [\n"+
+			"        [\n"+
+			"          1,\n"+
+			"          2\n"+
+			"        ],\n"+
+			"        []\n"+
+			"      ]\n"+
+			"    }\n",
+		out,
+		"Unexpected nested output.",
+	)
+}
+
+func TestPrettyConsoleEncoderOmittedKeys(t *testing.T) {
+	cfg := prettyEncoderConfig()
+	cfg.TimeKey = ""
+	cfg.NameKey = ""
+	cfg.CallerKey = ""
+	cfg.StacktraceKey = ""
+	cfg.LineEnding = "\r\n"
+	enc := NewPrettyConsoleEncoder(cfg, false)
+
+	out := encodePretty(t, enc, Entry{Level: InfoLevel, LoggerName: "hidden", Caller: _prettyCaller, Message: "hi", Stack: "hidden"})
+	assert.Equal(t, "INFO   hi\r\n", out, "Unexpected output with omitted keys.")
+}
+
+func TestPrettyConsoleEncoderColor(t *testing.T) {
+	enc := NewPrettyConsoleEncoder(prettyEncoderConfig(), true)
+	stack := "main.handle\n\t/src/app/main.go:42\nruntime.goexit\n\t/usr/local/go/src/runtime/asm_amd64.s:1357"
+
+	out := encodePretty(t, enc, Entry{Level: WarnLevel, Time: _prettyTime, LoggerName: "db", Caller: _prettyCaller, Message: "oops", Stack: stack},
+		viper.Int("n", 1))
+	assert.Equal(
+		t,
+		"\x1b[2m2017-07-14T02:40:00.000Z\x1b[0m  \x1b[33mWARN\x1b[0m   \x1b[1mdb\x1b[0m  \x1b[2mapp/main.go:42\x1b[0m  "+
+			"oops                                      \x1b[36mn\x1b[0m=1\n"+
+			"\x1b[1mmain.handle\x1b[0m\n"+
+			"\t/src/app/main.go:42\n"+
+			"\x1b[2mruntime.goexit\x1b[0m\n"+
+			"\x1b[2m\t/usr/local/go/src/runtime/asm_amd64.s:1357\x1b[0m\n",
+		out,
+		"Unexpected colored output.",
+	)
+}
@@ -0,0 +1,394 @@
+
+
+package vipercore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/gekko/multierr"
+)
+
+const (
+	_producerDefaultBatchSize     = 100
+	_producerDefaultFlushInterval = time.Second
+	_producerDefaultRetries       = 3
+	_producerDefaultRetryBackoff  = 100 * time.Millisecond
+)
+
+var (
+	errNoProducer         = errors.New("producer Core requires a Producer")
+	errProducerCoreClosed = errors.New("producer Core is closed")
+	errProducerQueueFull  = errors.New("producer Core's queue is full, dropped entry")
+)
+
+// A Message is an encoded entry handed to a Producer.
+type Message struct {
+	// Key is the value of the entry's partition key field, or nil if the entry
+	// doesn't have one.
+	Key []byte
+	// Value is the encoded entry.
+	Value []byte
+}
+
+// A Producer publishes messages to a message queue, like a Kafka topic. A
+// producer Core never calls Produce concurrently.
+type Producer interface {
+	// Produce publishes a batch of messages, in order. If it returns an
+	// error, the Core retries the whole batch.
+	Produce([]Message) error
+}
+
+// ProducerConfig configures a Core that sends entries to a Producer.
+type ProducerConfig struct {
+	// Producer publishes the batches of messages.
+	Producer Producer
+	// KeyField is the key of the field whose value becomes the messages'
+	// partition key, whether it's added with With or at the log site. If
+	// it's empty or an entry doesn't have the field, the key is nil.
+	KeyField string
+	// BatchSize is the number of messages that triggers a batch. The default
+	// is 100.
+	BatchSize int
+	// FlushInterval is how often queued messages are produced, even if the
+	// batch isn't full. The default is one second; a negative interval only
+	// produces full batches and on Sync and close.
+	FlushInterval time.Duration
+	// QueueSize is the number of messages queued for the Producer before
+	// writes block, which keeps a slow queue from using unbounded memory. The
+	// default is ten batches.
+	QueueSize int
+	// DropWhenFull makes writes drop their entries and return an error when
+	// the queue is full, rather than block until there's room.
+	DropWhenFull bool
+	// MaxRetries is the number of times a failed batch is retried. The
+	// default is three; a negative number disables retries.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, which doubles with
+	// each further retry. The default is 100ms.
+	RetryBackoff time.Duration
+	// ErrorOutput receives a line for each batch that couldn't be delivered
+	// and for each run of dropped entries. The default is standard error.
+	ErrorOutput WriteSyncer
+	// Clock drives the flush interval and retry backoff, and timestamps the
+	// lines written to the ErrorOutput. The default is DefaultClock.
+	Clock Clock
+	// Metrics, if set, records the entries dropped because the queue is full
+	// and the entries that couldn't be delivered as write errors.
+	Metrics Metrics
+}
+
+// producerQueue is the state shared by a producer Core and its clones.
+type producerQueue struct {
+	cfg ProducerConfig
+
+	mu      sync.RWMutex
+	closed  bool
+	msgs    chan queuedMessage
+	flushes chan chan error
+	done    chan struct{}
+
+	dropMu  sync.Mutex
+	dropped int
+}
+
+// queuedMessage is a message waiting for the Producer, along with its entry's
+// level for the Metrics.
+type queuedMessage struct {
+	Message
+
+	level Level
+}
+
+type producerCore struct {
+	LevelEnabler
+
+	enc   Encoder
+	key   []byte
+	queue *producerQueue
+}
+
+// NewProducerCore creates a Core that encodes entries and sends them in
+// batches to a Producer, which lets entries be logged to a message queue
+// without making callers wait for it. Batches are produced from a single
+// goroutine once they're full, every FlushInterval, and when the Core is
+// synced or closed. Failed batches are retried with exponential backoff, and
+// batches that still fail are reported to the ErrorOutput and dropped.
+//
+// When the queue of messages waiting for the Producer is full, writes block
+// until there's room or, with DropWhenFull, fail. Sync waits for all the
+// messages queued before it to be produced and returns an error if any of
+// them couldn't be.
+//
+// The returned function closes the Core, producing the queued messages; it
+// doesn't close the Producer. Writing to a closed Core returns an error.
+func NewProducerCore(enc Encoder, enab LevelEnabler, cfg ProducerConfig) (Core, func(), error) {
+	if cfg.Producer == nil {
+		return nil, nil, errNoProducer
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = _producerDefaultBatchSize
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = _producerDefaultFlushInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10 * cfg.BatchSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = _producerDefaultRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = _producerDefaultRetryBackoff
+	}
+	if cfg.ErrorOutput == nil {
+		cfg.ErrorOutput = Lock(os.Stderr)
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = DefaultClock
+	}
+
+	q := &producerQueue{
+		cfg:     cfg,
+		msgs:    make(chan queuedMessage, cfg.QueueSize),
+		flushes: make(chan chan error),
+		done:    make(chan struct{}),
+	}
+	// Start the ticker before returning, so that it sees every tick of the
+	// Clock from now on.
+	var ticker Ticker
+	if cfg.FlushInterval > 0 {
+		ticker = cfg.Clock.NewTicker(cfg.FlushInterval)
+	}
+	go q.run(ticker)
+	core := &producerCore{
+		LevelEnabler: enab,
+		enc:          enc,
+		queue:        q,
+	}
+	return core, q.close, nil
+}
+
+func (c *producerCore) With(fields []Field) Core {
+	clone := &producerCore{
+		LevelEnabler: c.LevelEnabler,
+		enc:          c.enc.Clone(),
+		key:          c.key,
+		queue:        c.queue,
+	}
+	addFields(clone.enc, fields)
+	if key, ok := c.queue.partitionKey(fields); ok {
+		clone.key = key
+	}
+	return clone
+}
+
+func (c *producerCore) Check(ent Entry, ce *CheckedEntry) *CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *producerCore) Write(ent Entry, fields []Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	msg := Message{
+		Key:   c.key,
+		Value: append([]byte(nil), buf.Bytes()...),
+	}
+	buffer.Put(buf)
+	if key, ok := c.queue.partitionKey(fields); ok {
+		msg.Key = key
+	}
+	if err := c.queue.send(queuedMessage{msg, ent.Level}); err != nil {
+		return err
+	}
+	if CriticalLevel.Enabled(ent.Level) {
+		// Since we may be crashing the program, deliver the entry now.
+		c.Sync()
+	}
+	return nil
+}
+
+func (c *producerCore) Sync() error {
+	return c.queue.flush()
+}
+
+// partitionKey returns the value of the key field, if it's among the fields.
+func (q *producerQueue) partitionKey(fields []Field) ([]byte, bool) {
+	if q.cfg.KeyField == "" {
+		return nil, false
+	}
+	for i := len(fields) - 1; i >= 0; i-- {
+		f := fields[i]
+		if f.Key != q.cfg.KeyField {
+			continue
+		}
+		switch f.Type {
+		case StringType:
+			return []byte(f.String), true
+		case ByteStringType, BinaryType:
+			return append([]byte(nil), f.Interface.([]byte)...), true
+		}
+		enc := NewMapObjectEncoder()
+		f.AddTo(enc)
+		return []byte(fmt.Sprint(enc.Fields[f.Key])), true
+	}
+	return nil, false
+}
+
+func (q *producerQueue) send(msg queuedMessage) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errProducerCoreClosed
+	}
+	if !q.cfg.DropWhenFull {
+		q.msgs <- msg
+		return nil
+	}
+	select {
+	case q.msgs <- msg:
+		return nil
+	default:
+		q.dropMu.Lock()
+		q.dropped++
+		q.dropMu.Unlock()
+		if q.cfg.Metrics != nil {
+			q.cfg.Metrics.EntryDropped(msg.level)
+		}
+		return errProducerQueueFull
+	}
+}
+
+func (q *producerQueue) flush() error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errProducerCoreClosed
+	}
+	done := make(chan error, 1)
+	q.flushes <- done
+	return <-done
+}
+
+func (q *producerQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.msgs)
+	q.mu.Unlock()
+	<-q.done
+}
+
+// run collects queued messages into batches and produces them until the
+// queue is closed. The ticker, if any, triggers periodic flushes.
+func (q *producerQueue) run(ticker Ticker) {
+	defer close(q.done)
+
+	var tick <-chan time.Time
+	if ticker != nil {
+		defer ticker.Stop()
+		tick = ticker.C()
+	}
+
+	batch := make([]queuedMessage, 0, q.cfg.BatchSize)
+	for {
+		select {
+		case msg, ok := <-q.msgs:
+			if !ok {
+				q.produce(batch)
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) >= q.cfg.BatchSize {
+				q.produce(batch)
+				batch = batch[:0]
+			}
+		case <-tick:
+			q.produceQueued(batch)
+			batch = batch[:0]
+		case done := <-q.flushes:
+			done <- q.produceQueued(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// produceQueued produces the batch along with the messages queued so far, so
+// that a flush includes every message written before it.
+func (q *producerQueue) produceQueued(batch []queuedMessage) error {
+	var err error
+	for n := len(q.msgs); n > 0; n-- {
+		batch = append(batch, <-q.msgs)
+		if len(batch) >= q.cfg.BatchSize {
+			err = multierr.Append(err, q.produce(batch))
+			batch = batch[:0]
+		}
+	}
+	return multierr.Append(err, q.produce(batch))
+}
+
+// produce hands a batch to the Producer, retrying if it fails, and reports
+// batches that can't be delivered and entries that were dropped.
+func (q *producerQueue) produce(batch []queuedMessage) error {
+	q.reportDropped()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// The Producer may keep the slice, so give it a copy.
+	msgs := make([]Message, len(batch))
+	for i, msg := range batch {
+		msgs[i] = msg.Message
+	}
+	err := q.cfg.Producer.Produce(msgs)
+	backoff := q.cfg.RetryBackoff
+	for retry := 0; err != nil && retry < q.cfg.MaxRetries; retry++ {
+		q.sleep(backoff)
+		backoff *= 2
+		err = q.cfg.Producer.Produce(msgs)
+	}
+	if err == nil {
+		return nil
+	}
+	if q.cfg.Metrics != nil {
+		for _, msg := range batch {
+			q.cfg.Metrics.WriteError(msg.level)
+		}
+	}
+	err = fmt.Errorf("failed to deliver %d log messages: %v", len(msgs), err)
+	fmt.Fprintf(q.cfg.ErrorOutput, "%v producer error: %v\n", q.cfg.Clock.Now(), err)
+	q.cfg.ErrorOutput.Sync()
+	return err
+}
+
+func (q *producerQueue) reportDropped() {
+	q.dropMu.Lock()
+	dropped := q.dropped
+	q.dropped = 0
+	q.dropMu.Unlock()
+	if dropped > 0 {
+		fmt.Fprintf(q.cfg.ErrorOutput, "%v producer error: dropped %d log messages, queue is full\n", q.cfg.Clock.Now(), dropped)
+		q.cfg.ErrorOutput.Sync()
+	}
+}
+
+// sleep waits for the Clock to advance by d.
+func (q *producerQueue) sleep(d time.Duration) {
+	ticker := q.cfg.Clock.NewTicker(d)
+	defer ticker.Stop()
+	<-ticker.C()
+}
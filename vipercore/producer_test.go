@@ -0,0 +1,306 @@
+package vipercore_test
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/viper/internal/vtest"
+	. "github.com/gottingen/viper/vipercore"
+	"github.com/gottingen/viper/vipertest"
+)
+
+func newProducerTestCore(t testing.TB, cfg ProducerConfig) (Core, func()) {
+	cfg.FlushInterval = -1
+	enc := NewJSONEncoder(EncoderConfig{MessageKey: "msg"})
+	core, stop, err := NewProducerCore(enc, DebugLevel, cfg)
+	require.NoError(t, err, "Unexpected error creating producer Core.")
+	return core, stop
+}
+
+func writeProducerEntry(t testing.TB, core Core, msg string, fields ...Field) error {
+	ce := core.Check(Entry{Level: InfoLevel, Message: msg}, nil)
+	require.NotNil(t, ce, "Expected the entry to be enabled.")
+	return core.Write(ce.Entry, fields)
+}
+
+func messageValues(msgs []Message) []string {
+	values := make([]string, len(msgs))
+	for i, msg := range msgs {
+		values[i] = strings.TrimSpace(string(msg.Value))
+	}
+	return values
+}
+
+func TestProducerCoreBatches(t *testing.T) {
+	producer := &MemoryProducer{}
+	core, stop := newProducerTestCore(t, ProducerConfig{Producer: producer, BatchSize: 2})
+	defer stop()
+
+	for _, msg := range []string{"one", "two", "three"} {
+		require.NoError(t, writeProducerEntry(t, core, msg), "Unexpected error writing entry.")
+	}
+	require.NoError(t, core.Sync(), "Unexpected error syncing.")
+
+	batches := producer.Batches()
+	require.Len(t, batches, 2, "Expected a full batch and a synced one.")
+	assert.Equal(t, []string{`{"msg":"one"}`, `{"msg":"two"}`}, messageValues(batches[0]), "Unexpected first batch.")
+	assert.Equal(t, []string{`{"msg":"three"}`}, messageValues(batches[1]), "Unexpected second batch.")
+}
+
+func TestProducerCorePartitionKey(t *testing.T) {
+	producer := &MemoryProducer{}
+	core, stop := newProducerTestCore(t, ProducerConfig{Producer: producer, KeyField: "user"})
+	defer stop()
+
+	require.NoError(t, writeProducerEntry(t, core, "none"), "Unexpected error writing entry.")
+	user := core.With([]Field{{Key: "user", Type: StringType, String: "alice"}})
+	require.NoError(t, writeProducerEntry(t, user, "context"), "Unexpected error writing entry.")
+	require.NoError(t, writeProducerEntry(t, user, "override", Field{Key: "user", Type: Int64Type, Integer: 42}), "Unexpected error writing entry.")
+	stop()
+
+	msgs := producer.Messages()
+	require.Len(t, msgs, 3, "Expected all messages to be produced on close.")
+	assert.Nil(t, msgs[0].Key, "Expected no key without the key field.")
+	assert.Equal(t, "alice", string(msgs[1].Key), "Expected the key from the context.")
+	assert.Equal(t, "42", string(msgs[2].Key), "Expected the key from the log site.")
+	assert.Equal(t, `{"msg":"context","user":"alice"}`, strings.TrimSpace(string(msgs[1].Value)), "Unexpected encoded entry.")
+
+	assert.EqualError(t, writeProducerEntry(t, core, "late"), "producer Core is closed", "Expected an error writing to a closed Core.")
+	assert.Error(t, core.Sync(), "Expected an error syncing a closed Core.")
+}
+
+func TestProducerCoreFlushInterval(t *testing.T) {
+	clock := vipertest.NewMockClock()
+	producer := &notifyingProducer{produced: make(chan []Message, 1)}
+	core, stop, err := NewProducerCore(NewJSONEncoder(EncoderConfig{MessageKey: "msg"}), DebugLevel, ProducerConfig{
+		Producer:      producer,
+		FlushInterval: time.Minute,
+		Clock:         clock,
+	})
+	require.NoError(t, err, "Unexpected error creating producer Core.")
+	defer stop()
+
+	require.NoError(t, writeProducerEntry(t, core, "tick"), "Unexpected error writing entry.")
+	clock.Add(time.Minute)
+	select {
+	case batch := <-producer.produced:
+		assert.Equal(t, []string{`{"msg":"tick"}`}, messageValues(batch), "Expected the entry to be produced in the background.")
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the entry to be produced when the flush interval elapsed.")
+	}
+}
+
+func TestProducerCoreRetries(t *testing.T) {
+	var (
+		errOutput vtest.Buffer
+		producer  = &failingProducer{failures: 2}
+	)
+	core, stop := newProducerTestCore(t, ProducerConfig{
+		Producer:     producer,
+		RetryBackoff: time.Millisecond,
+		ErrorOutput:  &errOutput,
+	})
+	defer stop()
+
+	require.NoError(t, writeProducerEntry(t, core, "retried"), "Unexpected error writing entry.")
+	require.NoError(t, core.Sync(), "Expected the batch to succeed after retries.")
+	assert.Equal(t, 3, producer.calls, "Expected two retries.")
+	assert.Len(t, producer.Messages(), 1, "Expected the batch to be produced.")
+	assert.Empty(t, errOutput.String(), "Expected no delivery failures.")
+
+	producer.failures = 10
+	require.NoError(t, writeProducerEntry(t, core, "lost"), "Unexpected error writing entry.")
+	err := core.Sync()
+	require.Error(t, err, "Expected an error once retries run out.")
+	assert.Contains(t, err.Error(), "failed to deliver 1 log messages: unavailable", "Unexpected error.")
+	assert.Equal(t, 7, producer.calls, "Expected three retries by default.")
+	assert.Contains(t, errOutput.String(), "producer error: failed to deliver 1 log messages", "Expected the failure to be reported.")
+}
+
+func TestProducerCoreClock(t *testing.T) {
+	var (
+		errOutput vtest.Buffer
+		clock     = vipertest.NewMockClock()
+		m         = NewMetricsRecorder()
+		producer  = &failingProducer{failures: 10}
+	)
+	core, stop := newProducerTestCore(t, ProducerConfig{
+		Producer:     producer,
+		MaxRetries:   2,
+		RetryBackoff: time.Hour,
+		ErrorOutput:  &errOutput,
+		Clock:        clock,
+		Metrics:      m,
+	})
+	defer stop()
+
+	require.NoError(t, writeProducerEntry(t, core, "retried"), "Unexpected error writing entry.")
+	synced := make(chan error, 1)
+	go func() { synced <- core.Sync() }()
+
+	// Retries wait for the clock, so advance it until they've all been made.
+	var err error
+	for done := false; !done; {
+		select {
+		case err = <-synced:
+			done = true
+		default:
+			clock.Add(time.Hour)
+			runtime.Gosched()
+		}
+	}
+	assert.Error(t, err, "Expected an error once retries run out.")
+	assert.Equal(t, 3, producer.calls, "Expected two retries.")
+	assert.True(t, strings.HasPrefix(errOutput.String(), clock.Now().String()+" producer error: "), "Expected the failure to be timestamped by the clock.")
+	assert.Equal(t, uint64(1), m.Snapshot().WriteErrors[InfoLevel], "Expected the lost entry to be counted at its level.")
+}
+
+func TestProducerCoreBackpressure(t *testing.T) {
+	var errOutput vtest.Buffer
+	m := NewMetricsRecorder()
+	producer := &blockingProducer{release: make(chan struct{})}
+	core, stop := newProducerTestCore(t, ProducerConfig{
+		Producer:     producer,
+		BatchSize:    1,
+		QueueSize:    1,
+		DropWhenFull: true,
+		ErrorOutput:  &errOutput,
+		Metrics:      m,
+	})
+	defer stop()
+
+	// The first entry is being produced and the second is queued, so the
+	// queue is full.
+	require.NoError(t, writeProducerEntry(t, core, "producing"), "Unexpected error writing entry.")
+	<-producer.started()
+	require.NoError(t, writeProducerEntry(t, core, "queued"), "Unexpected error writing entry.")
+	assert.Error(t, writeProducerEntry(t, core, "dropped"), "Expected an error with a full queue.")
+
+	close(producer.release)
+	require.NoError(t, core.Sync(), "Unexpected error syncing.")
+	assert.Equal(t, []string{`{"msg":"producing"}`, `{"msg":"queued"}`}, messageValues(producer.Messages()), "Unexpected messages.")
+	assert.Contains(t, errOutput.String(), "dropped 1 log messages, queue is full", "Expected the drop to be reported.")
+	assert.Equal(t, uint64(1), m.Snapshot().EntriesDropped[InfoLevel], "Expected the drop to be counted at the entry's level.")
+}
+
+func TestProducerCoreBlocksWhenFull(t *testing.T) {
+	producer := &blockingProducer{release: make(chan struct{})}
+	core, stop := newProducerTestCore(t, ProducerConfig{Producer: producer, BatchSize: 1, QueueSize: 1})
+	defer stop()
+
+	require.NoError(t, writeProducerEntry(t, core, "producing"), "Unexpected error writing entry.")
+	<-producer.started()
+	require.NoError(t, writeProducerEntry(t, core, "queued"), "Unexpected error writing entry.")
+
+	// The write can only finish once the producer is released and the queue
+	// has room again.
+	written := make(chan bool, 1)
+	go func() {
+		assert.NoError(t, writeProducerEntry(t, core, "blocked"), "Unexpected error writing entry.")
+		select {
+		case <-producer.release:
+			written <- true
+		default:
+			written <- false
+		}
+	}()
+	close(producer.release)
+	assert.True(t, <-written, "Expected the write to block while the queue is full.")
+	require.NoError(t, core.Sync(), "Unexpected error syncing.")
+	assert.Len(t, producer.Messages(), 3, "Expected all entries to be produced.")
+}
+
+func TestProducerCoreConcurrentWrites(t *testing.T) {
+	producer := &MemoryProducer{}
+	core, stop := newProducerTestCore(t, ProducerConfig{Producer: producer, BatchSize: 7, QueueSize: 3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.NoError(t, writeProducerEntry(t, core, "concurrent"), "Unexpected error writing entry.")
+			}
+		}()
+	}
+	wg.Wait()
+	stop()
+	stop()
+	assert.Len(t, producer.Messages(), 1000, "Expected every entry to be produced.")
+}
+
+func TestProducerCoreRequiresProducer(t *testing.T) {
+	_, _, err := NewProducerCore(NewJSONEncoder(EncoderConfig{}), DebugLevel, ProducerConfig{})
+	assert.Error(t, err, "Expected an error without a Producer.")
+}
+
+func TestMemoryProducerError(t *testing.T) {
+	var producer MemoryProducer
+	producer.SetError(errors.New("fail"))
+	assert.Error(t, producer.Produce([]Message{{Value: []byte("a")}}), "Expected the set error.")
+	producer.SetError(nil)
+	assert.NoError(t, producer.Produce([]Message{{Value: []byte("b")}}), "Unexpected error.")
+	assert.Equal(t, []Message{{Value: []byte("b")}}, producer.Messages(), "Expected only the successful batch.")
+}
+
+// failingProducer fails a number of times before producing.
+type failingProducer struct {
+	MemoryProducer
+
+	failures int
+	calls    int
+}
+
+func (p *failingProducer) Produce(msgs []Message) error {
+	p.calls++
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("unavailable")
+	}
+	return p.MemoryProducer.Produce(msgs)
+}
+
+// notifyingProducer sends each batch it produces on a channel.
+type notifyingProducer struct {
+	MemoryProducer
+
+	produced chan []Message
+}
+
+func (p *notifyingProducer) Produce(msgs []Message) error {
+	p.produced <- msgs
+	return p.MemoryProducer.Produce(msgs)
+}
+
+// blockingProducer blocks until it's released.
+type blockingProducer struct {
+	MemoryProducer
+
+	once    sync.Once
+	start   chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProducer) started() chan struct{} {
+	p.once.Do(func() { p.start = make(chan struct{}) })
+	return p.start
+}
+
+func (p *blockingProducer) Produce(msgs []Message) error {
+	start := p.started()
+	select {
+	case <-start:
+	default:
+		close(start)
+	}
+	<-p.release
+	return p.MemoryProducer.Produce(msgs)
+}
@@ -0,0 +1,321 @@
+
+
+package vipercore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+const (
+	// _protoMaxRecordSize bounds the length prefixes the stream decoder
+	// accepts, so that corrupt input can't make it allocate arbitrarily large
+	// records.
+	_protoMaxRecordSize = 64 << 20
+	// _protoMaxDepth bounds the nesting of decoded values.
+	_protoMaxDepth = 1000
+)
+
+type protobufDecoder struct{}
+
+// NewProtobufDecoder creates a Decoder for single length-prefixed records
+// written by the Protocol Buffers encoder. Since the parts of the entry are
+// typed fields of the record, no EncoderConfig is needed to identify them.
+//
+// Attributes are decoded into fields of the types they were written with,
+// except that all signed integers become Int64 fields, all unsigned integers
+// become Uint64 fields, ByteStrings become Strings, and Reflect fields hold
+// their JSON as a json.RawMessage. Objects and arrays become marshalers that
+// re-encode their contents in their original order. To decode a stream of
+// records, use NewProtobufStreamDecoder.
+func NewProtobufDecoder() Decoder {
+	return protobufDecoder{}
+}
+
+func (protobufDecoder) Decode(data []byte) (Entry, []Field, error) {
+	size, n := binary.Uvarint(data)
+	if n <= 0 {
+		return Entry{}, nil, errors.New("can't decode protobuf log record: invalid length prefix")
+	}
+	if uint64(len(data)-n) != size {
+		return Entry{}, nil, fmt.Errorf("can't decode protobuf log record: length prefix is %d, but record has %d bytes", size, len(data)-n)
+	}
+	ent, fields, err := decodeProtoRecord(data[n:])
+	if err != nil {
+		return Entry{}, nil, fmt.Errorf("can't decode protobuf log record: %v", err)
+	}
+	return ent, fields, nil
+}
+
+// A ProtobufStreamDecoder reads a stream of length-prefixed records written
+// by the Protocol Buffers encoder.
+type ProtobufStreamDecoder struct {
+	r *bufio.Reader
+}
+
+// NewProtobufStreamDecoder creates a decoder that reads records from r,
+// decoding them as NewProtobufDecoder does.
+func NewProtobufStreamDecoder(r io.Reader) *ProtobufStreamDecoder {
+	return &ProtobufStreamDecoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next record from the stream. At the end of the stream, it
+// returns io.EOF; if the stream ends in the middle of a record, it returns an
+// error wrapping io.ErrUnexpectedEOF.
+func (dec *ProtobufStreamDecoder) Decode() (Entry, []Field, error) {
+	size, err := binary.ReadUvarint(dec.r)
+	if err == io.EOF {
+		return Entry{}, nil, io.EOF
+	} else if err != nil {
+		return Entry{}, nil, fmt.Errorf("can't decode protobuf log record: %w", unexpectedEOF(err))
+	}
+	if size > _protoMaxRecordSize {
+		return Entry{}, nil, fmt.Errorf("can't decode protobuf log record: record of %d bytes is too large", size)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(dec.r, int64(size)))
+	if err == nil && uint64(len(data)) < size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return Entry{}, nil, fmt.Errorf("can't decode protobuf log record: %w", err)
+	}
+	ent, fields, err := decodeProtoRecord(data)
+	if err != nil {
+		return Entry{}, nil, fmt.Errorf("can't decode protobuf log record: %v", err)
+	}
+	return ent, fields, nil
+}
+
+func decodeProtoRecord(data []byte) (Entry, []Field, error) {
+	var (
+		ent    Entry
+		fields []Field
+	)
+	err := readProtoFields(data, func(num int, f protoField) error {
+		var err error
+		switch num {
+		case _protoRecordLevel:
+			// An unset level decodes as the zero Level, like other missing
+			// parts of the entry.
+			if f.varint > uint64(len(_protoLevels)) {
+				return fmt.Errorf("invalid level %d", f.varint)
+			}
+			if f.varint > 0 {
+				ent.Level = _protoLevels[f.varint-1]
+			}
+		case _protoRecordCustomLevel:
+			lvl := int64(f.varint)
+			if lvl < math.MinInt8 || lvl > math.MaxInt8 {
+				return fmt.Errorf("invalid custom level %d", lvl)
+			}
+			ent.Level = Level(lvl)
+		case _protoRecordTime:
+			ent.Time, err = decodeProtoTime(f.bytes)
+		case _protoRecordLoggerName:
+			ent.LoggerName = string(f.bytes)
+		case _protoRecordCaller:
+			ent.Caller, err = decodeProtoCaller(f.bytes)
+		case _protoRecordMessage:
+			ent.Message = string(f.bytes)
+		case _protoRecordStacktrace:
+			ent.Stack = string(f.bytes)
+		case _protoRecordAttributes:
+			var kv decodedKV
+			kv, err = decodeProtoEntry(f.bytes, 0)
+			fields = append(fields, decodedField(kv.key, kv.value))
+		}
+		return err
+	})
+	if err != nil {
+		return Entry{}, nil, err
+	}
+	return ent, fields, nil
+}
+
+func decodeProtoCaller(data []byte) (EntryCaller, error) {
+	caller := EntryCaller{Defined: true}
+	err := readProtoFields(data, func(num int, f protoField) error {
+		switch num {
+		case 1:
+			caller.File = string(f.bytes)
+		case 2:
+			caller.Line = int(f.varint)
+		}
+		return nil
+	})
+	return caller, err
+}
+
+// decodeProtoEntry decodes a map entry of an attribute map.
+func decodeProtoEntry(data []byte, depth int) (decodedKV, error) {
+	var kv decodedKV
+	err := readProtoFields(data, func(num int, f protoField) error {
+		var err error
+		switch num {
+		case 1:
+			kv.key = string(f.bytes)
+		case 2:
+			kv.value, err = decodeProtoValue(f.bytes, depth)
+		}
+		return err
+	})
+	return kv, err
+}
+
+// decodeProtoValue decodes a Value message into the same representation as
+// the other decoders use, adding the Go types that JSON can't express.
+func decodeProtoValue(data []byte, depth int) (interface{}, error) {
+	if depth > _protoMaxDepth {
+		return nil, errors.New("values nested too deeply")
+	}
+	var v interface{}
+	err := readProtoFields(data, func(num int, f protoField) error {
+		var err error
+		switch num {
+		case _protoValueBool:
+			v = f.varint != 0
+		case _protoValueInt:
+			v = int64(f.varint)
+		case _protoValueUint:
+			v = f.varint
+		case _protoValueDouble:
+			v = math.Float64frombits(f.varint)
+		case _protoValueFloat:
+			v = math.Float32frombits(uint32(f.varint))
+		case _protoValueString:
+			v = string(f.bytes)
+		case _protoValueBytes:
+			v = append([]byte{}, f.bytes...)
+		case _protoValueComplex:
+			var r, i float64
+			err = readProtoFields(f.bytes, func(num int, f protoField) error {
+				switch num {
+				case 1:
+					r = math.Float64frombits(f.varint)
+				case 2:
+					i = math.Float64frombits(f.varint)
+				}
+				return nil
+			})
+			v = complex(r, i)
+		case _protoValueDuration:
+			var sec, nsec int64
+			sec, nsec, err = decodeProtoSecondsNanos(f.bytes)
+			v = time.Duration(sec)*time.Second + time.Duration(nsec)
+		case _protoValueTime:
+			v, err = decodeProtoTime(f.bytes)
+		case _protoValueObject:
+			obj := decodedObject{}
+			err = readProtoFields(f.bytes, func(num int, f protoField) error {
+				if num != 1 {
+					return nil
+				}
+				kv, err := decodeProtoEntry(f.bytes, depth+1)
+				obj = append(obj, kv)
+				return err
+			})
+			v = obj
+		case _protoValueArray:
+			arr := decodedArray{}
+			err = readProtoFields(f.bytes, func(num int, f protoField) error {
+				if num != 1 {
+					return nil
+				}
+				elem, err := decodeProtoValue(f.bytes, depth+1)
+				arr = append(arr, elem)
+				return err
+			})
+			v = arr
+		case _protoValueJSON:
+			v = json.RawMessage(append([]byte{}, f.bytes...))
+		}
+		return err
+	})
+	return v, err
+}
+
+func decodeProtoTime(data []byte) (time.Time, error) {
+	sec, nsec, err := decodeProtoSecondsNanos(data)
+	return time.Unix(sec, nsec), err
+}
+
+func decodeProtoSecondsNanos(data []byte) (sec, nsec int64, err error) {
+	err = readProtoFields(data, func(num int, f protoField) error {
+		switch num {
+		case 1:
+			sec = int64(f.varint)
+		case 2:
+			nsec = int64(int32(f.varint))
+		}
+		return nil
+	})
+	return sec, nsec, err
+}
+
+// protoField is the value of a field of a protobuf message. Varints and
+// fixed-size values are stored in varint and length-delimited ones in bytes.
+type protoField struct {
+	varint uint64
+	bytes  []byte
+}
+
+// readProtoFields calls fn with each field of a protobuf message, in order.
+func readProtoFields(data []byte, fn func(num int, f protoField) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("malformed field tag")
+		}
+		data = data[n:]
+		num, wireType := int(tag>>3), int(tag&7)
+		if num == 0 {
+			return errors.New("invalid field number 0")
+		}
+
+		var f protoField
+		switch wireType {
+		case _protoVarint:
+			f.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("malformed varint in field %d", num)
+			}
+			data = data[n:]
+		case _protoFixed64:
+			if len(data) < 8 {
+				return io.ErrUnexpectedEOF
+			}
+			f.varint = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case _protoFixed32:
+			if len(data) < 4 {
+				return io.ErrUnexpectedEOF
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case _protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("malformed length in field %d", num)
+			}
+			data = data[n:]
+			if size > uint64(len(data)) {
+				return io.ErrUnexpectedEOF
+			}
+			f.bytes = data[:size]
+			data = data[size:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", wireType, num)
+		}
+		if err := fn(num, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package vipercore_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func TestProtobufDecoderSkipsUnknownFields(t *testing.T) {
+	// A record with an unknown varint field, an unknown fixed32 field, and a
+	// level, as a newer writer might produce.
+	rec := []byte{13, 0x78, 5, 0x85, 0x01, 1, 2, 3, 4, 0x08, 6, 0x2a, 1, 'm'}
+	ent, fields, err := NewProtobufDecoder().Decode(rec)
+	require.NoError(t, err, "Unexpected error decoding record.")
+	assert.Equal(t, Entry{Level: ErrorLevel, Message: "m"}, ent, "Unexpected decoded entry.")
+	assert.Empty(t, fields, "Unexpected decoded fields.")
+}
+
+func TestProtobufDecoderErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		in   []byte
+		err  string
+	}{
+		{"empty", nil, "invalid length prefix"},
+		{"short record", []byte{3, 0x08, 2}, "length prefix is 3, but record has 2 bytes"},
+		{"trailing data", []byte{0, 0}, "length prefix is 0, but record has 1 bytes"},
+		{"field number zero", []byte{2, 0x00, 1}, "invalid field number 0"},
+		{"truncated varint", []byte{2, 0x08, 0x80}, "malformed varint in field 1"},
+		{"truncated bytes", []byte{3, 0x2a, 5, 'm'}, "unexpected EOF"},
+		{"truncated fixed64", []byte{3, 0x79, 0, 0}, "unexpected EOF"},
+		{"group", []byte{1, 0x0b}, "unsupported wire type 3 in field 1"},
+		{"invalid level", []byte{2, 0x08, 11}, "invalid level 11"},
+		{"invalid custom level", []byte{3, 0x40, 0x80, 0x02}, "invalid custom level 256"},
+		{"malformed attribute", []byte{4, 0x3a, 2, 0x12, 5}, "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, _, err := NewProtobufDecoder().Decode(tt.in)
+			require.Error(t, err, "Expected an error.")
+			assert.Contains(t, err.Error(), tt.err, "Unexpected error.")
+		})
+	}
+}
+
+func TestProtobufStreamDecoderErrors(t *testing.T) {
+	dec := NewProtobufStreamDecoder(bytes.NewReader([]byte{0, 5, 0x08}))
+	_, _, err := dec.Decode()
+	require.NoError(t, err, "Unexpected error decoding the first record.")
+	_, _, err = dec.Decode()
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "Expected io.ErrUnexpectedEOF, got %v.", err)
+
+	dec = NewProtobufStreamDecoder(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}))
+	_, _, err = dec.Decode()
+	assert.Error(t, err, "Expected an error decoding a huge record.")
+	assert.Contains(t, err.Error(), "too large", "Unexpected error.")
+}
@@ -0,0 +1,512 @@
+
+
+package vipercore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gottingen/buffer"
+)
+
+// Protocol Buffers wire types.
+const (
+	_protoVarint  = 0
+	_protoFixed64 = 1
+	_protoBytes   = 2
+	_protoFixed32 = 5
+)
+
+// Field numbers of the LogRecord message in protobuf_record.proto.
+const (
+	_protoRecordLevel = iota + 1
+	_protoRecordTime
+	_protoRecordLoggerName
+	_protoRecordCaller
+	_protoRecordMessage
+	_protoRecordStacktrace
+	_protoRecordAttributes
+	_protoRecordCustomLevel
+	_protoRecordLevelName
+)
+
+// Field numbers of the Value message in protobuf_record.proto.
+const (
+	_protoValueBool = iota + 1
+	_protoValueInt
+	_protoValueUint
+	_protoValueDouble
+	_protoValueFloat
+	_protoValueString
+	_protoValueBytes
+	_protoValueComplex
+	_protoValueDuration
+	_protoValueTime
+	_protoValueObject
+	_protoValueArray
+	_protoValueJSON
+)
+
+// _protoLevels lists the built-in levels in the order of the Level enum in
+// protobuf_record.proto, which ranks them by importance. Each level's enum
+// value is its index plus one, keeping the zero value for unset levels.
+var _protoLevels = [...]Level{
+	TraceLevel,
+	DebugLevel,
+	InfoLevel,
+	NoticeLevel,
+	WarnLevel,
+	ErrorLevel,
+	CriticalLevel,
+	DPanicLevel,
+	PanicLevel,
+	FatalLevel,
+}
+
+// _protoLevelEnums maps the built-in levels, indexed by value, to their enum
+// values.
+var _protoLevelEnums = func() [_numLevels]uint64 {
+	var enums [_numLevels]uint64
+	for i, lvl := range _protoLevels {
+		enums[lvl-_minLevel] = uint64(i + 1)
+	}
+	return enums
+}()
+
+var _protobufPool = sync.Pool{New: func() interface{} {
+	return &protobufEncoder{}
+}}
+
+func getProtobufEncoder(cfg *EncoderConfig) *protobufEncoder {
+	enc := _protobufPool.Get().(*protobufEncoder)
+	enc.EncoderConfig = cfg
+	enc.buf = buffer.Get()
+	enc.field = 1
+	return enc
+}
+
+func putProtobufEncoder(enc *protobufEncoder) {
+	enc.EncoderConfig = nil
+	enc.buf = nil
+	enc.field = 0
+	enc.key = ""
+	enc.keyed = false
+	enc.namespaces = enc.namespaces[:0]
+	_protobufPool.Put(enc)
+}
+
+// protobufNamespace is an object enclosing an open namespace.
+type protobufNamespace struct {
+	key   string
+	buf   *buffer.Buffer
+	field int
+}
+
+type protobufEncoder struct {
+	*EncoderConfig
+
+	// buf holds the encoded map entries of the innermost open object, or the
+	// encoded elements of an array, each tagged with field.
+	buf   *buffer.Buffer
+	field int
+	// key is the key of the next value, if keyed is set.
+	key   string
+	keyed bool
+	// namespaces are the objects enclosing the open namespaces, outermost
+	// first.
+	namespaces []protobufNamespace
+	// scratch and entry are reused to build values and map entries.
+	scratch, entry []byte
+}
+
+// NewProtobufEncoder creates an encoder that writes each entry as a
+// LogRecord Protocol Buffers message, whose schema is defined in
+// protobuf_record.proto, preceded by its length as a varint. Streams of
+// records can be read with NewProtobufStreamDecoder or with any protobuf
+// library's length-delimited reader.
+//
+// The entry's level, time, logger name, caller, message, and stacktrace are
+// written to typed fields of the record, regardless of the EncoderConfig's
+// encoders, and are omitted if their key is empty. Built-in levels are written
+// to the Level enum, which ranks them by importance; custom levels are
+// written as their numeric value and name instead. Fields are written to the
+// record's attribute map as typed values: integers, floats, strings, binary
+// blobs, complex numbers, durations, and times keep their types, objects and
+// arrays are nested, and reflected values are serialized with encoding/json.
+// Since the length prefix delimits records, the LineEnding is ignored.
+func NewProtobufEncoder(cfg EncoderConfig) Encoder {
+	return &protobufEncoder{
+		EncoderConfig: &cfg,
+		buf:           buffer.Get(),
+		field:         _protoRecordAttributes,
+	}
+}
+
+func (enc *protobufEncoder) AddArray(key string, arr ArrayMarshaler) error {
+	enc.addKey(key)
+	return enc.AppendArray(arr)
+}
+
+func (enc *protobufEncoder) AddObject(key string, obj ObjectMarshaler) error {
+	enc.addKey(key)
+	return enc.AppendObject(obj)
+}
+
+func (enc *protobufEncoder) AddBinary(key string, val []byte) {
+	enc.addKey(key)
+	enc.writeValue(appendProtoBytes(enc.scratch[:0], _protoValueBytes, val))
+}
+
+func (enc *protobufEncoder) AddByteString(key string, val []byte) {
+	enc.addKey(key)
+	enc.WriteByteString(val)
+}
+
+func (enc *protobufEncoder) AddBool(key string, val bool) {
+	enc.addKey(key)
+	enc.WriteBool(val)
+}
+
+func (enc *protobufEncoder) AddComplex128(key string, val complex128) {
+	enc.addKey(key)
+	enc.AppendComplex128(val)
+}
+
+func (enc *protobufEncoder) AddDuration(key string, val time.Duration) {
+	enc.addKey(key)
+	enc.AppendDuration(val)
+}
+
+func (enc *protobufEncoder) AddFloat64(key string, val float64) {
+	enc.addKey(key)
+	enc.WriteFloat64(val)
+}
+
+func (enc *protobufEncoder) AddFloat32(key string, val float32) {
+	enc.addKey(key)
+	enc.WriteFloat32(val)
+}
+
+func (enc *protobufEncoder) AddInt64(key string, val int64) {
+	enc.addKey(key)
+	enc.WriteInt64(val)
+}
+
+func (enc *protobufEncoder) AddReflected(key string, obj interface{}) error {
+	val, err := protoReflectedValue(obj)
+	if err != nil {
+		return err
+	}
+	enc.addKey(key)
+	enc.writeValue(val)
+	return nil
+}
+
+func (enc *protobufEncoder) OpenNamespace(key string) {
+	enc.namespaces = append(enc.namespaces, protobufNamespace{key: key, buf: enc.buf, field: enc.field})
+	enc.buf = buffer.Get()
+	enc.field = 1
+}
+
+func (enc *protobufEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	enc.WriteString(val)
+}
+
+func (enc *protobufEncoder) AddTime(key string, val time.Time) {
+	enc.addKey(key)
+	enc.AppendTime(val)
+}
+
+func (enc *protobufEncoder) AddUint64(key string, val uint64) {
+	enc.addKey(key)
+	enc.WriteUint64(val)
+}
+
+func (enc *protobufEncoder) AppendArray(arr ArrayMarshaler) error {
+	child := getProtobufEncoder(enc.EncoderConfig)
+	err := arr.MarshalLogArray(child)
+	child.closeOpenNamespaces()
+	enc.writeValue(appendProtoBytes(enc.scratch[:0], _protoValueArray, child.buf.Bytes()))
+	buffer.Put(child.buf)
+	putProtobufEncoder(child)
+	return err
+}
+
+func (enc *protobufEncoder) AppendObject(obj ObjectMarshaler) error {
+	child := getProtobufEncoder(enc.EncoderConfig)
+	err := obj.MarshalLogObject(child)
+	child.closeOpenNamespaces()
+	enc.writeValue(appendProtoBytes(enc.scratch[:0], _protoValueObject, child.buf.Bytes()))
+	buffer.Put(child.buf)
+	putProtobufEncoder(child)
+	return err
+}
+
+func (enc *protobufEncoder) WriteBool(val bool) {
+	var v uint64
+	if val {
+		v = 1
+	}
+	enc.writeValue(appendProtoVarint(enc.scratch[:0], _protoValueBool, v))
+}
+
+func (enc *protobufEncoder) WriteByteString(val []byte) {
+	enc.writeValue(appendProtoBytes(enc.scratch[:0], _protoValueString, val))
+}
+
+func (enc *protobufEncoder) AppendComplex128(val complex128) {
+	// Cast to a platform-independent, fixed-size type.
+	r, i := float64(real(val)), float64(imag(val))
+	var c []byte
+	if r != 0 {
+		c = appendProtoFixed64(c, 1, math.Float64bits(r))
+	}
+	if i != 0 {
+		c = appendProtoFixed64(c, 2, math.Float64bits(i))
+	}
+	enc.writeValue(appendProtoBytes(enc.scratch[:0], _protoValueComplex, c))
+}
+
+// AppendDuration writes a google.protobuf.Duration, regardless of the
+// configured DurationEncoder.
+func (enc *protobufEncoder) AppendDuration(val time.Duration) {
+	enc.writeValue(appendProtoBytes(enc.scratch[:0], _protoValueDuration, protoSecondsNanos(int64(val/time.Second), int32(val%time.Second))))
+}
+
+func (enc *protobufEncoder) WriteFloat64(val float64) {
+	enc.writeValue(appendProtoFixed64(enc.scratch[:0], _protoValueDouble, math.Float64bits(val)))
+}
+
+func (enc *protobufEncoder) WriteFloat32(val float32) {
+	b := appendProtoTag(enc.scratch[:0], _protoValueFloat, _protoFixed32)
+	b = append(b, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b[len(b)-4:], math.Float32bits(val))
+	enc.writeValue(b)
+}
+
+func (enc *protobufEncoder) WriteInt64(val int64) {
+	enc.writeValue(appendProtoVarint(enc.scratch[:0], _protoValueInt, uint64(val)))
+}
+
+func (enc *protobufEncoder) AppendReflected(val interface{}) error {
+	v, err := protoReflectedValue(val)
+	if err != nil {
+		return err
+	}
+	enc.writeValue(v)
+	return nil
+}
+
+func (enc *protobufEncoder) WriteString(val string) {
+	b := appendProtoTag(enc.scratch[:0], _protoValueString, _protoBytes)
+	b = appendVarint(b, uint64(len(val)))
+	enc.writeValue(append(b, val...))
+}
+
+// AppendTime writes a google.protobuf.Timestamp, regardless of the
+// configured TimeEncoder.
+func (enc *protobufEncoder) AppendTime(val time.Time) {
+	enc.writeValue(appendProtoBytes(enc.scratch[:0], _protoValueTime, protoSecondsNanos(val.Unix(), int32(val.Nanosecond()))))
+}
+
+func (enc *protobufEncoder) WriteUint64(val uint64) {
+	enc.writeValue(appendProtoVarint(enc.scratch[:0], _protoValueUint, val))
+}
+
+func (enc *protobufEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *protobufEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *protobufEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *protobufEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *protobufEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *protobufEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *protobufEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *protobufEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *protobufEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *protobufEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+func (enc *protobufEncoder) AppendComplex64(v complex64)        { enc.AppendComplex128(complex128(v)) }
+func (enc *protobufEncoder) WriteInt(v int)                     { enc.WriteInt64(int64(v)) }
+func (enc *protobufEncoder) WriteInt32(v int32)                 { enc.WriteInt64(int64(v)) }
+func (enc *protobufEncoder) WriteInt16(v int16)                 { enc.WriteInt64(int64(v)) }
+func (enc *protobufEncoder) WriteInt8(v int8)                   { enc.WriteInt64(int64(v)) }
+func (enc *protobufEncoder) WriteUint(v uint)                   { enc.WriteUint64(uint64(v)) }
+func (enc *protobufEncoder) WriteUint32(v uint32)               { enc.WriteUint64(uint64(v)) }
+func (enc *protobufEncoder) WriteUint16(v uint16)               { enc.WriteUint64(uint64(v)) }
+func (enc *protobufEncoder) WriteUint8(v uint8)                 { enc.WriteUint64(uint64(v)) }
+func (enc *protobufEncoder) WriteUintptr(v uintptr)             { enc.WriteUint64(uint64(v)) }
+
+func (enc *protobufEncoder) Clone() Encoder {
+	clone := getProtobufEncoder(enc.EncoderConfig)
+	clone.buf.Write(enc.buf.Bytes())
+	clone.field = enc.field
+	for _, ns := range enc.namespaces {
+		buf := buffer.Get()
+		buf.Write(ns.buf.Bytes())
+		clone.namespaces = append(clone.namespaces, protobufNamespace{key: ns.key, buf: buf, field: ns.field})
+	}
+	return clone
+}
+
+func (enc *protobufEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	final := enc.Clone().(*protobufEncoder)
+	addFields(final, fields)
+	final.closeOpenNamespaces()
+
+	var rec []byte
+	if final.LevelKey != "" {
+		if ent.Level >= _minLevel && ent.Level <= _maxLevel {
+			rec = appendProtoVarint(rec, _protoRecordLevel, _protoLevelEnums[ent.Level-_minLevel])
+		} else {
+			rec = appendProtoVarint(rec, _protoRecordCustomLevel, uint64(int64(ent.Level)))
+			rec = appendProtoString(rec, _protoRecordLevelName, ent.Level.String())
+		}
+	}
+	if final.TimeKey != "" && !ent.Time.IsZero() {
+		rec = appendProtoBytes(rec, _protoRecordTime, protoSecondsNanos(ent.Time.Unix(), int32(ent.Time.Nanosecond())))
+	}
+	if final.NameKey != "" && ent.LoggerName != "" {
+		rec = appendProtoString(rec, _protoRecordLoggerName, ent.LoggerName)
+	}
+	if final.CallerKey != "" && ent.Caller.Defined {
+		var caller []byte
+		if ent.Caller.File != "" {
+			caller = appendProtoString(caller, 1, ent.Caller.File)
+		}
+		if ent.Caller.Line != 0 {
+			caller = appendProtoVarint(caller, 2, uint64(ent.Caller.Line))
+		}
+		rec = appendProtoBytes(rec, _protoRecordCaller, caller)
+	}
+	if final.MessageKey != "" && ent.Message != "" {
+		rec = appendProtoString(rec, _protoRecordMessage, ent.Message)
+	}
+	if final.StacktraceKey != "" && ent.Stack != "" {
+		rec = appendProtoString(rec, _protoRecordStacktrace, ent.Stack)
+	}
+
+	out := buffer.Get()
+	out.Write(appendVarint(final.scratch[:0], uint64(len(rec)+final.buf.Len())))
+	out.Write(rec)
+	out.Write(final.buf.Bytes())
+
+	buffer.Put(final.buf)
+	putProtobufEncoder(final)
+	return out, nil
+}
+
+func (enc *protobufEncoder) addKey(key string) {
+	enc.key = key
+	enc.keyed = true
+}
+
+// writeValue writes an encoded Value message, either as a map entry under the
+// pending key or as an array element.
+func (enc *protobufEncoder) writeValue(val []byte) {
+	b := enc.entry[:0]
+	if enc.keyed {
+		enc.keyed = false
+		b = appendProtoTag(b, enc.field, _protoBytes)
+		b = appendVarint(b, uint64(protoBytesSize(1, len(enc.key))+protoBytesSize(2, len(val))))
+		b = appendProtoString(b, 1, enc.key)
+		b = appendProtoBytes(b, 2, val)
+	} else {
+		b = appendProtoBytes(b, enc.field, val)
+	}
+	enc.buf.Write(b)
+	// Keep the grown slices for the next value.
+	enc.scratch, enc.entry = val[:0], b[:0]
+}
+
+// closeOpenNamespaces writes each open namespace as an object into its
+// enclosing object.
+func (enc *protobufEncoder) closeOpenNamespaces() {
+	for i := len(enc.namespaces) - 1; i >= 0; i-- {
+		ns := enc.namespaces[i]
+		inner := enc.buf
+		enc.buf, enc.field = ns.buf, ns.field
+		enc.addKey(ns.key)
+		enc.writeValue(appendProtoBytes(enc.scratch[:0], _protoValueObject, inner.Bytes()))
+		buffer.Put(inner)
+	}
+	enc.namespaces = enc.namespaces[:0]
+}
+
+// protoReflectedValue serializes a value with encoding/json and returns it as
+// an encoded Value, which is empty for nil.
+func protoReflectedValue(val interface{}) ([]byte, error) {
+	if val == nil {
+		return nil, nil
+	}
+	js, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	if string(js) == "null" {
+		return nil, nil
+	}
+	return appendProtoBytes(nil, _protoValueJSON, js), nil
+}
+
+// protoSecondsNanos encodes a google.protobuf.Timestamp or Duration.
+func protoSecondsNanos(sec int64, nsec int32) []byte {
+	var b []byte
+	if sec != 0 {
+		b = appendProtoVarint(b, 1, uint64(sec))
+	}
+	if nsec != 0 {
+		// Negative int32s are sign-extended to 64 bits.
+		b = appendProtoVarint(b, 2, uint64(int64(nsec)))
+	}
+	return b
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func varintSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func appendProtoTag(b []byte, num int, wireType int) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(wireType))
+}
+
+func appendProtoVarint(b []byte, num int, v uint64) []byte {
+	return appendVarint(appendProtoTag(b, num, _protoVarint), v)
+}
+
+func appendProtoFixed64(b []byte, num int, v uint64) []byte {
+	b = appendProtoTag(b, num, _protoFixed64)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(b[len(b)-8:], v)
+	return b
+}
+
+func appendProtoBytes(b []byte, num int, v []byte) []byte {
+	b = appendVarint(appendProtoTag(b, num, _protoBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendProtoString(b []byte, num int, v string) []byte {
+	b = appendVarint(appendProtoTag(b, num, _protoBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// protoBytesSize is the encoded size of a length-delimited field with a
+// small field number.
+func protoBytesSize(num int, n int) int {
+	return varintSize(uint64(num)<<3) + varintSize(uint64(n)) + n
+}
@@ -0,0 +1,233 @@
+package vipercore_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func encodeProtobufEntry(t testing.TB, enc Encoder, ent Entry, fields ...Field) []byte {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buffer.Put(buf)
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// decodeProtobufFields decodes a record written by the protobuf encoder and
+// adds its fields to a map.
+func decodeProtobufFields(t testing.TB, b []byte) (Entry, map[string]interface{}) {
+	ent, fields, err := NewProtobufDecoder().Decode(b)
+	require.NoError(t, err, "Unexpected error decoding protobuf record.")
+	m := NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(m)
+	}
+	return ent, m.Fields
+}
+
+func TestProtobufEncoderWireFormat(t *testing.T) {
+	enc := NewProtobufEncoder(testEncoderConfig())
+	out := encodeProtobufEntry(t, enc, Entry{Level: InfoLevel, Message: "hi"},
+		viper.Int("n", 1),
+		viper.Ints("a", []int{1}),
+	)
+	assert.Equal(t, []byte{
+		// Length prefix.
+		28,
+		// Level: LEVEL_INFO.
+		0x08, 3,
+		// Message.
+		0x2a, 2, 'h', 'i',
+		// Attribute with an int_value.
+		0x3a, 7, 0x0a, 1, 'n', 0x12, 2, 0x10, 1,
+		// Attribute with an array_value holding an int_value.
+		0x3a, 11, 0x0a, 1, 'a', 0x12, 6, 0x62, 4, 0x0a, 2, 0x10, 1,
+	}, out, "Unexpected wire format.")
+}
+
+func TestProtobufEncoderLevels(t *testing.T) {
+	enc := NewProtobufEncoder(testEncoderConfig())
+	ordered := []Level{
+		TraceLevel,
+		DebugLevel,
+		InfoLevel,
+		NoticeLevel,
+		WarnLevel,
+		ErrorLevel,
+		CriticalLevel,
+		DPanicLevel,
+		PanicLevel,
+		FatalLevel,
+	}
+	for i, lvl := range ordered {
+		out := encodeProtobufEntry(t, enc, Entry{Level: lvl})
+		assert.Equal(t, []byte{2, 0x08, byte(i + 1)}, out, "Expected %v to be written to the Level enum in order of importance.", lvl)
+		ent, _ := decodeProtobufFields(t, out)
+		assert.Equal(t, lvl, ent.Level, "Unexpected decoded level.")
+	}
+
+	for _, lvl := range []Level{Level(-5), Level(25)} {
+		out := encodeProtobufEntry(t, enc, Entry{Level: lvl})
+		name := lvl.String()
+		assert.Equal(t, 0x40, int(out[1]), "Expected %v to be written as a custom level.", lvl)
+		assert.True(t, bytes.HasSuffix(out, append([]byte{0x4a, byte(len(name))}, name...)), "Expected the name of %v to be written.", lvl)
+		ent, _ := decodeProtobufFields(t, out)
+		assert.Equal(t, lvl, ent.Level, "Unexpected decoded custom level.")
+	}
+}
+
+func TestProtobufEncoderEntry(t *testing.T) {
+	type bar struct {
+		Key string  `json:"key"`
+		Val float64 `json:"val"`
+	}
+
+	enc := NewProtobufEncoder(testEncoderConfig())
+	enc.AddString("request", "abc")
+	enc.OpenNamespace("http")
+	enc.AddInt("status", 500)
+
+	ts := time.Unix(1500000000, 123456789)
+	ent := Entry{
+		Level:      WarnLevel,
+		Time:       ts,
+		LoggerName: "bob",
+		Message:    "lob law",
+		Caller:     EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42},
+		Stack:      "fake-stack",
+	}
+	out := encodeProtobufEntry(t, enc, ent,
+		viper.Int("answer", 42),
+		viper.Int8("neg", -100),
+		viper.Uint64("big", math.MaxUint64),
+		viper.Float64("pi", 3.14),
+		viper.Float32("e", 2.5),
+		viper.Bool("ok", true),
+		viper.Bool("notok", false),
+		viper.Binary("bin", []byte{0, 1, 2}),
+		viper.ByteString("bs", []byte("bytes")),
+		viper.Duration("elapsed", 1500*time.Millisecond),
+		viper.Duration("negative", -1500*time.Millisecond),
+		viper.Time("at", time.Unix(-10, 5)),
+		viper.Complex128("c", 1+2i),
+		viper.Complex64("c64", 3i),
+		viper.Strings("tags", []string{"a", "b"}),
+		viper.Reflect("bars", []bar{{Key: "k", Val: 1.5}}),
+		viper.Reflect("nothing", nil),
+		viper.Error(errors.New("failed")),
+		viper.Object("obj", ObjectMarshalerFunc(func(enc ObjectEncoder) error {
+			enc.AddString("shallow", "no")
+			enc.OpenNamespace("inner")
+			enc.AddString("deep", "yes")
+			return nil
+		})),
+	)
+
+	decoded, fields := decodeProtobufFields(t, out)
+	assert.Equal(t, ent, decoded, "Unexpected decoded entry.")
+	assert.Equal(t, map[string]interface{}{
+		"request": "abc",
+		"http": map[string]interface{}{
+			"status":   int64(500),
+			"answer":   int64(42),
+			"neg":      int64(-100),
+			"big":      uint64(math.MaxUint64),
+			"pi":       3.14,
+			"e":        float32(2.5),
+			"ok":       true,
+			"notok":    false,
+			"bin":      []byte{0, 1, 2},
+			"bs":       "bytes",
+			"elapsed":  1500 * time.Millisecond,
+			"negative": -1500 * time.Millisecond,
+			"at":       time.Unix(-10, 5),
+			"c":        1 + 2i,
+			"c64":      3i,
+			"tags":     []interface{}{"a", "b"},
+			"bars":     json.RawMessage(`[{"key":"k","val":1.5}]`),
+			"nothing":  nil,
+			"error":    "failed",
+			"obj": map[string]interface{}{
+				"shallow": "no",
+				"inner":   map[string]interface{}{"deep": "yes"},
+			},
+		},
+	}, fields, "Unexpected decoded fields.")
+
+	// The context must be unaffected by encoding an entry.
+	_, fields = decodeProtobufFields(t, encodeProtobufEntry(t, enc, Entry{Message: "again"}))
+	assert.Equal(t, map[string]interface{}{
+		"request": "abc",
+		"http":    map[string]interface{}{"status": int64(500)},
+	}, fields, "Unexpected context after encoding.")
+}
+
+func TestProtobufEncoderOmitsEmptyKeys(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.LevelKey = ""
+	cfg.TimeKey = ""
+	cfg.NameKey = ""
+	cfg.CallerKey = ""
+	cfg.MessageKey = ""
+	cfg.StacktraceKey = ""
+	enc := NewProtobufEncoder(cfg)
+
+	out := encodeProtobufEntry(t, enc, Entry{
+		Level:      ErrorLevel,
+		Time:       time.Unix(1, 0),
+		LoggerName: "name",
+		Message:    "msg",
+		Caller:     EntryCaller{Defined: true, File: "f.go", Line: 1},
+		Stack:      "stack",
+	})
+	assert.Equal(t, []byte{0}, out, "Expected an empty record.")
+
+	ent, fields := decodeProtobufFields(t, out)
+	assert.Equal(t, Entry{}, ent, "Unexpected decoded entry.")
+	assert.Empty(t, fields, "Unexpected decoded fields.")
+}
+
+func TestProtobufEncoderMarshalerErrors(t *testing.T) {
+	enc := NewProtobufEncoder(testEncoderConfig())
+	fail := errors.New("fail")
+	assert.Equal(t, fail, enc.AddObject("obj", ObjectMarshalerFunc(func(ObjectEncoder) error { return fail })))
+	assert.Equal(t, fail, enc.AddArray("arr", ArrayMarshalerFunc(func(ArrayEncoder) error { return fail })))
+	assert.Error(t, enc.AddReflected("ch", make(chan int)), "Expected an error reflecting a channel.")
+	enc.AddString("after", "errors")
+
+	_, fields := decodeProtobufFields(t, encodeProtobufEntry(t, enc, Entry{}))
+	assert.Equal(t, map[string]interface{}{
+		"obj":   map[string]interface{}{},
+		"arr":   []interface{}{},
+		"after": "errors",
+	}, fields, "Unexpected fields after marshaler errors.")
+}
+
+func TestProtobufEncoderStream(t *testing.T) {
+	enc := NewProtobufEncoder(testEncoderConfig())
+	var stream bytes.Buffer
+	for i := 0; i < 3; i++ {
+		stream.Write(encodeProtobufEntry(t, enc, Entry{Message: "entry", Time: time.Unix(int64(i+1), 0)}, viper.Int("i", i)))
+	}
+
+	dec := NewProtobufStreamDecoder(&stream)
+	for i := 0; i < 3; i++ {
+		ent, fields, err := dec.Decode()
+		require.NoError(t, err, "Unexpected error decoding record %d.", i)
+		assert.Equal(t, time.Unix(int64(i+1), 0), ent.Time, "Unexpected time of record %d.", i)
+		assert.Equal(t, []Field{viper.Int64("i", int64(i))}, fields, "Unexpected fields of record %d.", i)
+	}
+	_, _, err := dec.Decode()
+	assert.Equal(t, io.EOF, err, "Expected io.EOF at the end of the stream.")
+}
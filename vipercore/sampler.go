@@ -18,15 +18,24 @@ type counter struct {
 	counter atomic.Uint64
 }
 
-type counters [_numLevels][_countersPerLevel]counter
+// counters holds a row of counters for each built-in level, plus a final row
+// shared by all levels registered with RegisterLevel.
+type counters [_numLevels + 1][_countersPerLevel]counter
 
 func newCounters() *counters {
 	return &counters{}
 }
 
 func (cs *counters) get(lvl Level, key string) *counter {
-	i := lvl - _minLevel
-	j := fnv32a(key) % _countersPerLevel
+	hash := fnv32a(key)
+	i := int(lvl - _minLevel)
+	if lvl < _minLevel || lvl > _maxLevel {
+		// Custom levels share a row, so mix the level into the hash to keep
+		// their counts apart.
+		i = int(_numLevels)
+		hash = (hash ^ uint32(uint8(lvl))) * 16777619
+	}
+	j := hash % _countersPerLevel
 	return &cs[i][j]
 }
 
@@ -69,6 +78,7 @@ type sampler struct {
 	counts            *counters
 	tick              time.Duration
 	first, thereafter uint64
+	metrics           Metrics
 }
 
 // NewSampler creates a Core that samples incoming entries, which caps the CPU
@@ -78,6 +88,8 @@ type sampler struct {
 // Viper samples by logging the first N entries with a given level and message
 // each tick. If more Entries with the same level and message are seen during
 // the same interval, every Mth message is logged and the rest are dropped.
+// Ticks are measured using the entries' timestamps, so the sampler follows
+// the Clock of the Logger that created them.
 //
 // Keep in mind that viper's sampling implementation is optimized for speed over
 // absolute precision; under load, each tick may be slightly over- or
@@ -92,6 +104,14 @@ func NewSampler(core Core, tick time.Duration, first, thereafter int) Core {
 	}
 }
 
+// NewSamplerWithMetrics creates a Core that samples incoming entries like
+// NewSampler, and reports the entries it drops to m.
+func NewSamplerWithMetrics(core Core, tick time.Duration, first, thereafter int, m Metrics) Core {
+	s := NewSampler(core, tick, first, thereafter).(*sampler)
+	s.metrics = m
+	return s
+}
+
 func (s *sampler) With(fields []Field) Core {
 	return &sampler{
 		Core:       s.Core.With(fields),
@@ -99,6 +119,7 @@ func (s *sampler) With(fields []Field) Core {
 		counts:     s.counts,
 		first:      s.first,
 		thereafter: s.thereafter,
+		metrics:    s.metrics,
 	}
 }
 
@@ -110,6 +131,9 @@ func (s *sampler) Check(ent Entry, ce *CheckedEntry) *CheckedEntry {
 	counter := s.counts.get(ent.Level, ent.Message)
 	n := counter.IncCheckReset(ent.Time, s.tick)
 	if n > s.first && (n-s.first)%s.thereafter != 0 {
+		if s.metrics != nil {
+			s.metrics.EntryDropped(ent.Level)
+		}
 		return ce
 	}
 	return s.Core.Check(ent, ce)
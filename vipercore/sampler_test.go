@@ -47,8 +47,8 @@ func writeSequence(core Core, n int, lvl Level) {
 }
 
 func TestSampler(t *testing.T) {
-	for _, lvl := range []Level{DebugLevel, InfoLevel, WarnLevel, ErrorLevel, DPanicLevel, PanicLevel, FatalLevel} {
-		sampler, logs := fakeSampler(DebugLevel, time.Minute, 2, 3)
+	for _, lvl := range []Level{TraceLevel, DebugLevel, InfoLevel, NoticeLevel, WarnLevel, ErrorLevel, CriticalLevel, DPanicLevel, PanicLevel, FatalLevel} {
+		sampler, logs := fakeSampler(TraceLevel, time.Minute, 2, 3)
 
 		// Ensure that counts aren't shared between levels.
 		probeLevel := DebugLevel
@@ -68,6 +68,21 @@ func TestSampler(t *testing.T) {
 	}
 }
 
+func TestSamplerCustomLevels(t *testing.T) {
+	// Levels outside the built-in range share a row of counters, but shouldn't
+	// share counts.
+	low, high := Level(-20), Level(20)
+	sampler, logs := fakeSampler(low, time.Minute, 2, 3)
+	for i := 1; i < 10; i++ {
+		writeSequence(sampler, i, low)
+	}
+	assertSequence(t, logs.TakeAll(), low, 1, 2, 5, 8)
+	for i := 1; i < 10; i++ {
+		writeSequence(sampler, i, high)
+	}
+	assertSequence(t, logs.TakeAll(), high, 1, 2, 5, 8)
+}
+
 func TestSamplerDisabledLevels(t *testing.T) {
 	sampler, logs := fakeSampler(InfoLevel, time.Minute, 1, 100)
 
@@ -0,0 +1,297 @@
+
+
+package vipercore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gottingen/buffer"
+)
+
+// Formats of the fields placeholder of a template encoder.
+const (
+	TemplateFieldsJSON   = "json"
+	TemplateFieldsLogfmt = "logfmt"
+)
+
+type templatePart int
+
+const (
+	templateLiteral templatePart = iota
+	templateTime
+	templateLevel
+	templateName
+	templateCaller
+	templateMessage
+	templateStacktrace
+	templateFields
+)
+
+var _templateParts = map[string]templatePart{
+	"time":       templateTime,
+	"level":      templateLevel,
+	"name":       templateName,
+	"caller":     templateCaller,
+	"message":    templateMessage,
+	"stacktrace": templateStacktrace,
+	"fields":     templateFields,
+}
+
+type templateSegment struct {
+	part    templatePart
+	literal string
+}
+
+func parseTemplate(tmpl string) ([]templateSegment, error) {
+	var (
+		segments []templateSegment
+		literal  strings.Builder
+	)
+	flush := func() {
+		if literal.Len() > 0 {
+			segments = append(segments, templateSegment{part: templateLiteral, literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(tmpl); {
+		switch {
+		case strings.HasPrefix(tmpl[i:], "{{"):
+			literal.WriteByte('{')
+			i += 2
+		case strings.HasPrefix(tmpl[i:], "}}"):
+			literal.WriteByte('}')
+			i += 2
+		case tmpl[i] == '{':
+			end := strings.IndexByte(tmpl[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed placeholder at offset %d in template %q", i, tmpl)
+			}
+			name := tmpl[i+1 : i+end]
+			part, ok := _templateParts[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown placeholder {%s} in template %q", name, tmpl)
+			}
+			flush()
+			segments = append(segments, templateSegment{part: part})
+			i += end + 1
+		default:
+			literal.WriteByte(tmpl[i])
+			i++
+		}
+	}
+	flush()
+	return segments, nil
+}
+
+type templateEncoder struct {
+	*treeEncoder
+
+	segments     []templateSegment
+	fieldsFormat string
+	hasStack     bool
+}
+
+// NewTemplateEncoder creates an encoder that writes each entry by filling in
+// a template, which is useful for feeding consumers that expect a fixed line
+// format. Templates reference the parts of the entry with the placeholders
+// {time}, {level}, {name}, {caller}, {message}, {stacktrace}, and {fields};
+// literal braces are written as {{ and }}. For example,
+//
+//   {time} [{level}] ({name}) {message} {fields}
+//
+// renders lines like
+//
+//   2026-10-17T12:00:00.000Z [INFO] (svc) started port=8080
+//
+// Entry parts are encoded with the EncoderConfig's encoders and are left
+// empty if their key is empty or the entry doesn't have them. The fields,
+// including those added with With, are rendered in fieldsFormat, either
+// TemplateFieldsJSON or TemplateFieldsLogfmt; objects are flattened into
+// dotted keys in logfmt. Trailing whitespace left by empty placeholders is
+// trimmed. If the template doesn't reference {stacktrace}, stacktraces are
+// written on the lines following the entry, as the console encoder does.
+func NewTemplateEncoder(cfg EncoderConfig, template, fieldsFormat string) (Encoder, error) {
+	segments, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	switch fieldsFormat {
+	case TemplateFieldsJSON, TemplateFieldsLogfmt:
+	default:
+		return nil, fmt.Errorf("unknown template fields format %q", fieldsFormat)
+	}
+
+	enc := &templateEncoder{
+		treeEncoder:  newTreeEncoder(&cfg),
+		segments:     segments,
+		fieldsFormat: fieldsFormat,
+	}
+	for _, s := range segments {
+		enc.hasStack = enc.hasStack || s.part == templateStacktrace
+	}
+	return enc, nil
+}
+
+func (c *templateEncoder) Clone() Encoder {
+	return &templateEncoder{
+		treeEncoder:  c.treeEncoder.clone(),
+		segments:     c.segments,
+		fieldsFormat: c.fieldsFormat,
+		hasStack:     c.hasStack,
+	}
+}
+
+func (c *templateEncoder) EncodeEntry(ent Entry, fields []Field) (*buffer.Buffer, error) {
+	var sb strings.Builder
+	for _, s := range c.segments {
+		switch s.part {
+		case templateLiteral:
+			sb.WriteString(s.literal)
+		case templateTime:
+			if c.TimeKey != "" && c.EncodeTime != nil {
+				sb.WriteString(encodePrimitives(func(arr PrimitiveArrayEncoder) {
+					c.EncodeTime(ent.Time, arr)
+				}))
+			}
+		case templateLevel:
+			if c.LevelKey != "" && c.EncodeLevel != nil {
+				sb.WriteString(encodePrimitives(func(arr PrimitiveArrayEncoder) {
+					c.EncodeLevel(ent.Level, arr)
+				}))
+			}
+		case templateName:
+			if ent.LoggerName != "" && c.NameKey != "" {
+				nameEncoder := c.EncodeName
+				if nameEncoder == nil {
+					nameEncoder = FullNameEncoder
+				}
+				sb.WriteString(encodePrimitives(func(arr PrimitiveArrayEncoder) {
+					nameEncoder(ent.LoggerName, arr)
+				}))
+			}
+		case templateCaller:
+			if ent.Caller.Defined && c.CallerKey != "" && c.EncodeCaller != nil {
+				sb.WriteString(encodePrimitives(func(arr PrimitiveArrayEncoder) {
+					c.EncodeCaller(ent.Caller, arr)
+				}))
+			}
+		case templateMessage:
+			if c.MessageKey != "" {
+				sb.WriteString(ent.Message)
+			}
+		case templateStacktrace:
+			if c.StacktraceKey != "" {
+				sb.WriteString(ent.Stack)
+			}
+		case templateFields:
+			c.writeFields(&sb, fields)
+		}
+	}
+
+	line := buffer.Get()
+	line.WriteString(strings.TrimRight(sb.String(), " \t"))
+	if !c.hasStack && ent.Stack != "" && c.StacktraceKey != "" {
+		line.WriteByte('\n')
+		line.WriteString(ent.Stack)
+	}
+	if c.LineEnding != "" {
+		line.WriteString(c.LineEnding)
+	} else {
+		line.WriteString(DefaultLineEnding)
+	}
+	return line, nil
+}
+
+func (c *templateEncoder) writeFields(sb *strings.Builder, extra []Field) {
+	obj := c.withFields(extra)
+	if c.fieldsFormat == TemplateFieldsJSON {
+		if len(obj) > 0 {
+			out := newJSONEncoder(*c.EncoderConfig, false)
+			writeDecodedJSON(out, obj)
+			sb.Write(out.buf.Bytes())
+			buffer.Put(out.buf)
+		}
+		return
+	}
+
+	first := true
+	c.writeLogfmt(sb, "", obj, &first)
+}
+
+// writeDecodedJSON writes a decoded value as JSON, writing numbers just as
+// they were formatted.
+func writeDecodedJSON(out *jsonEncoder, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		out.buf.WriteByte('"')
+		out.safeAddString(v)
+		out.buf.WriteByte('"')
+	case bool:
+		out.buf.WriteBool(v)
+	case json.Number:
+		out.buf.WriteString(string(v))
+	case decodedObject:
+		out.buf.WriteByte('{')
+		for i, kv := range v {
+			if i > 0 {
+				out.buf.WriteByte(',')
+			}
+			out.buf.WriteByte('"')
+			out.safeAddString(kv.key)
+			out.buf.WriteString(`":`)
+			writeDecodedJSON(out, kv.value)
+		}
+		out.buf.WriteByte('}')
+	case decodedArray:
+		out.buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				out.buf.WriteByte(',')
+			}
+			writeDecodedJSON(out, elem)
+		}
+		out.buf.WriteByte(']')
+	default:
+		out.buf.WriteString("null")
+	}
+}
+
+func (c *templateEncoder) writeLogfmt(sb *strings.Builder, prefix string, obj decodedObject, first *bool) {
+	for _, kv := range obj {
+		key := prefix + kv.key
+		if nested, ok := kv.value.(decodedObject); ok && len(nested) > 0 {
+			c.writeLogfmt(sb, key+".", nested, first)
+			continue
+		}
+		if !*first {
+			sb.WriteByte(' ')
+		}
+		*first = false
+		sb.WriteString(key)
+		sb.WriteByte('=')
+
+		var val string
+		switch v := kv.value.(type) {
+		case string:
+			val = v
+		case decodedObject, decodedArray:
+			// Re-encode composite values as compact JSON.
+			enc := newJSONEncoder(*c.EncoderConfig, false)
+			if arr, ok := v.(decodedArray); ok {
+				enc.AppendArray(arr)
+			} else {
+				enc.AppendObject(v.(decodedObject))
+			}
+			val = enc.buf.String()
+			buffer.Put(enc.buf)
+		default:
+			val = stringOf(v)
+		}
+		if needsQuotes(val) {
+			val = fmt.Sprintf("%q", val)
+		}
+		sb.WriteString(val)
+	}
+}
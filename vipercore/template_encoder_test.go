@@ -0,0 +1,126 @@
+
+
+package vipercore_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gottingen/buffer"
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipercore"
+)
+
+func TestTemplateEncoder(t *testing.T) {
+	type user struct {
+		ID   int      `json:"id"`
+		Tags []string `json:"tags"`
+	}
+
+	cfg := testEncoderConfig()
+	cfg.EncodeTime = ISO8601TimeEncoder
+	cfg.EncodeLevel = CapitalLevelEncoder
+	ent := Entry{
+		Level:      InfoLevel,
+		Time:       time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC),
+		LoggerName: "svc",
+		Caller:     EntryCaller{Defined: true, File: "/src/app/main.go", Line: 42},
+		Message:    "started",
+	}
+	fields := []Field{
+		viper.Int("port", 8080),
+		viper.String("mode", "blue green"),
+		viper.Duration("warmup", 1500*time.Millisecond),
+		viper.Reflect("user", user{ID: 7, Tags: []string{"a"}}),
+		viper.Reflect("empty", struct{}{}),
+	}
+
+	tests := []struct {
+		desc     string
+		template string
+		format   string
+		ent      Entry
+		with     []Field
+		want     string
+	}{
+		{
+			desc:     "logfmt fields",
+			template: "{time} [{level}] ({name}) {message} {fields}",
+			format:   TemplateFieldsLogfmt,
+			ent:      ent,
+			want:     `2026-10-17T12:00:00.000Z [INFO] (svc) started port=8080 mode="blue green" warmup=1.5 user.id=7 user.tags="[\"a\"]" empty={}` + "\n",
+		},
+		{
+			desc:     "JSON fields and context",
+			template: "{level}|{caller}|{message}|{fields}",
+			format:   TemplateFieldsJSON,
+			ent:      ent,
+			with:     []Field{viper.String("request", "abc")},
+			want:     `INFO|app/main.go:42|started|{"request":"abc","port":8080,"mode":"blue green","warmup":1.5,"user":{"id":7,"tags":["a"]},"empty":{}}` + "\n",
+		},
+		{
+			desc:     "escaped braces and missing parts",
+			template: "{{{level}}} {name}: {message} {fields}",
+			format:   TemplateFieldsLogfmt,
+			ent:      Entry{Level: WarnLevel, Message: "no name"},
+			want:     "{WARN} : no name\n",
+		},
+		{
+			desc:     "stacktrace placeholder",
+			template: "{message} <{stacktrace}>",
+			format:   TemplateFieldsJSON,
+			ent:      Entry{Message: "boom", Stack: "fake-stack"},
+			want:     "boom <fake-stack>\n",
+		},
+		{
+			desc:     "stacktrace appended",
+			template: "{message}",
+			format:   TemplateFieldsJSON,
+			ent:      Entry{Message: "boom", Stack: "fake-stack"},
+			want:     "boom\nfake-stack\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enc, err := NewTemplateEncoder(cfg, tt.template, tt.format)
+			require.NoError(t, err, "Unexpected error creating template encoder.")
+			for _, f := range tt.with {
+				f.AddTo(enc)
+			}
+			entFields := fields
+			if tt.ent.LoggerName == "" {
+				entFields = nil
+			}
+			buf, err := enc.Clone().EncodeEntry(tt.ent, entFields)
+			require.NoError(t, err, "Unexpected error encoding entry.")
+			defer buffer.Put(buf)
+			assert.Equal(t, tt.want, buf.String(), "Unexpected output.")
+		})
+	}
+}
+
+func TestTemplateEncoderOmittedKeys(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.LevelKey = ""
+	cfg.MessageKey = ""
+	cfg.LineEnding = "\r\n"
+	enc, err := NewTemplateEncoder(cfg, "[{level}] {message}!", TemplateFieldsLogfmt)
+	require.NoError(t, err, "Unexpected error creating template encoder.")
+
+	buf, err := enc.EncodeEntry(Entry{Level: ErrorLevel, Message: "hidden"}, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	assert.Equal(t, "[] !\r\n", buf.String(), "Unexpected output with omitted keys.")
+}
+
+func TestTemplateEncoderErrors(t *testing.T) {
+	for _, tmpl := range []string{"{message", "{msg}", "{}"} {
+		_, err := NewTemplateEncoder(testEncoderConfig(), tmpl, TemplateFieldsJSON)
+		assert.Error(t, err, "Expected an error for template %q.", tmpl)
+	}
+	_, err := NewTemplateEncoder(testEncoderConfig(), "{message}", "yaml")
+	assert.Error(t, err, "Expected an error for an unknown fields format.")
+}
@@ -0,0 +1,232 @@
+package vipercore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+)
+
+// treeEncoder is an ObjectEncoder that collects fields into a decodedObject,
+// formatting their values the way the JSON encoder does. Encoders that lay
+// out fields freely embed it to hold their context, so that they don't have
+// to encode fields as JSON and parse them again.
+type treeEncoder struct {
+	*EncoderConfig
+
+	// levels holds the fields of the root object, followed by those of each
+	// open namespace.
+	levels []treeLevel
+}
+
+type treeLevel struct {
+	key    string
+	fields decodedObject
+}
+
+func newTreeEncoder(cfg *EncoderConfig) *treeEncoder {
+	return &treeEncoder{EncoderConfig: cfg, levels: []treeLevel{{}}}
+}
+
+// clone copies the encoder. The copy's levels are capped, so that adding to
+// either encoder never changes the other.
+func (enc *treeEncoder) clone() *treeEncoder {
+	levels := make([]treeLevel, len(enc.levels))
+	for i, l := range enc.levels {
+		levels[i] = treeLevel{key: l.key, fields: l.fields[:len(l.fields):len(l.fields)]}
+	}
+	return &treeEncoder{EncoderConfig: enc.EncoderConfig, levels: levels}
+}
+
+// withFields returns the encoder's context with fields added, closing any
+// open namespaces.
+func (enc *treeEncoder) withFields(fields []Field) decodedObject {
+	tree := enc.clone()
+	addFields(tree, fields)
+	return tree.object()
+}
+
+// object returns the fields added so far, closing any open namespaces.
+func (enc *treeEncoder) object() decodedObject {
+	last := len(enc.levels) - 1
+	obj := enc.levels[last].fields
+	for i := last; i > 0; i-- {
+		parent := enc.levels[i-1].fields
+		obj = append(parent[:len(parent):len(parent)], decodedKV{key: enc.levels[i].key, value: obj})
+	}
+	return obj
+}
+
+func (enc *treeEncoder) add(key string, value interface{}) {
+	l := &enc.levels[len(enc.levels)-1]
+	l.fields = append(l.fields, decodedKV{key: key, value: value})
+}
+
+func (enc *treeEncoder) AddArray(key string, marshaler ArrayMarshaler) error {
+	arr := &treeArrayEncoder{EncoderConfig: enc.EncoderConfig, elems: decodedArray{}}
+	err := marshaler.MarshalLogArray(arr)
+	enc.add(key, arr.elems)
+	return err
+}
+
+func (enc *treeEncoder) AddObject(key string, marshaler ObjectMarshaler) error {
+	obj := newTreeEncoder(enc.EncoderConfig)
+	err := marshaler.MarshalLogObject(obj)
+	enc.add(key, nonNilObject(obj.object()))
+	return err
+}
+
+func (enc *treeEncoder) AddReflected(key string, value interface{}) error {
+	v, err := decodeReflected(value)
+	if err != nil {
+		return err
+	}
+	enc.add(key, v)
+	return nil
+}
+
+func (enc *treeEncoder) OpenNamespace(key string) {
+	enc.levels = append(enc.levels, treeLevel{key: key, fields: decodedObject{}})
+}
+
+func (enc *treeEncoder) AddBinary(k string, v []byte)         { enc.add(k, treeBinary(v)) }
+func (enc *treeEncoder) AddByteString(k string, v []byte)     { enc.add(k, string(v)) }
+func (enc *treeEncoder) AddBool(k string, v bool)             { enc.add(k, v) }
+func (enc *treeEncoder) AddComplex128(k string, v complex128) { enc.add(k, treeComplex(v)) }
+func (enc *treeEncoder) AddComplex64(k string, v complex64)   { enc.add(k, treeComplex(complex128(v))) }
+func (enc *treeEncoder) AddDuration(k string, v time.Duration) {
+	enc.add(k, treeDuration(enc.EncoderConfig, v))
+}
+func (enc *treeEncoder) AddFloat64(k string, v float64) { enc.add(k, treeFloat(v, 64)) }
+func (enc *treeEncoder) AddFloat32(k string, v float32) { enc.add(k, treeFloat(float64(v), 64)) }
+func (enc *treeEncoder) AddInt(k string, v int)         { enc.AddInt64(k, int64(v)) }
+func (enc *treeEncoder) AddInt64(k string, v int64) {
+	enc.add(k, json.Number(strconv.FormatInt(v, 10)))
+}
+func (enc *treeEncoder) AddInt32(k string, v int32)    { enc.AddInt64(k, int64(v)) }
+func (enc *treeEncoder) AddInt16(k string, v int16)    { enc.AddInt64(k, int64(v)) }
+func (enc *treeEncoder) AddInt8(k string, v int8)      { enc.AddInt64(k, int64(v)) }
+func (enc *treeEncoder) AddString(k, v string)         { enc.add(k, v) }
+func (enc *treeEncoder) AddTime(k string, v time.Time) { enc.add(k, treeTime(enc.EncoderConfig, v)) }
+func (enc *treeEncoder) AddUint(k string, v uint)      { enc.AddUint64(k, uint64(v)) }
+func (enc *treeEncoder) AddUint64(k string, v uint64) {
+	enc.add(k, json.Number(strconv.FormatUint(v, 10)))
+}
+func (enc *treeEncoder) AddUint32(k string, v uint32)   { enc.AddUint64(k, uint64(v)) }
+func (enc *treeEncoder) AddUint16(k string, v uint16)   { enc.AddUint64(k, uint64(v)) }
+func (enc *treeEncoder) AddUint8(k string, v uint8)     { enc.AddUint64(k, uint64(v)) }
+func (enc *treeEncoder) AddUintptr(k string, v uintptr) { enc.AddUint64(k, uint64(v)) }
+
+// treeArrayEncoder is the ArrayEncoder counterpart of treeEncoder.
+type treeArrayEncoder struct {
+	*EncoderConfig
+	elems decodedArray
+}
+
+func (enc *treeArrayEncoder) AppendArray(marshaler ArrayMarshaler) error {
+	arr := &treeArrayEncoder{EncoderConfig: enc.EncoderConfig, elems: decodedArray{}}
+	err := marshaler.MarshalLogArray(arr)
+	enc.elems = append(enc.elems, arr.elems)
+	return err
+}
+
+func (enc *treeArrayEncoder) AppendObject(marshaler ObjectMarshaler) error {
+	obj := newTreeEncoder(enc.EncoderConfig)
+	err := marshaler.MarshalLogObject(obj)
+	enc.elems = append(enc.elems, nonNilObject(obj.object()))
+	return err
+}
+
+func (enc *treeArrayEncoder) AppendReflected(value interface{}) error {
+	v, err := decodeReflected(value)
+	if err != nil {
+		return err
+	}
+	enc.elems = append(enc.elems, v)
+	return nil
+}
+
+func (enc *treeArrayEncoder) append(v interface{}) { enc.elems = append(enc.elems, v) }
+
+func (enc *treeArrayEncoder) WriteBool(v bool)              { enc.append(v) }
+func (enc *treeArrayEncoder) WriteByteString(v []byte)      { enc.append(string(v)) }
+func (enc *treeArrayEncoder) AppendComplex128(v complex128) { enc.append(treeComplex(v)) }
+func (enc *treeArrayEncoder) AppendComplex64(v complex64)   { enc.append(treeComplex(complex128(v))) }
+func (enc *treeArrayEncoder) AppendDuration(v time.Duration) {
+	enc.append(treeDuration(enc.EncoderConfig, v))
+}
+func (enc *treeArrayEncoder) WriteFloat64(v float64) { enc.append(treeFloat(v, 64)) }
+func (enc *treeArrayEncoder) WriteFloat32(v float32) { enc.append(treeFloat(float64(v), 32)) }
+func (enc *treeArrayEncoder) WriteInt(v int)         { enc.WriteInt64(int64(v)) }
+func (enc *treeArrayEncoder) WriteInt64(v int64)     { enc.append(json.Number(strconv.FormatInt(v, 10))) }
+func (enc *treeArrayEncoder) WriteInt32(v int32)     { enc.WriteInt64(int64(v)) }
+func (enc *treeArrayEncoder) WriteInt16(v int16)     { enc.WriteInt64(int64(v)) }
+func (enc *treeArrayEncoder) WriteInt8(v int8)       { enc.WriteInt64(int64(v)) }
+func (enc *treeArrayEncoder) WriteString(v string)   { enc.append(v) }
+func (enc *treeArrayEncoder) AppendTime(v time.Time) { enc.append(treeTime(enc.EncoderConfig, v)) }
+func (enc *treeArrayEncoder) WriteUint(v uint)       { enc.WriteUint64(uint64(v)) }
+func (enc *treeArrayEncoder) WriteUint64(v uint64) {
+	enc.append(json.Number(strconv.FormatUint(v, 10)))
+}
+func (enc *treeArrayEncoder) WriteUint32(v uint32)   { enc.WriteUint64(uint64(v)) }
+func (enc *treeArrayEncoder) WriteUint16(v uint16)   { enc.WriteUint64(uint64(v)) }
+func (enc *treeArrayEncoder) WriteUint8(v uint8)     { enc.WriteUint64(uint64(v)) }
+func (enc *treeArrayEncoder) WriteUintptr(v uintptr) { enc.WriteUint64(uint64(v)) }
+
+// nonNilObject returns an empty object instead of nil, like decoding "{}".
+func nonNilObject(obj decodedObject) decodedObject {
+	if obj == nil {
+		return decodedObject{}
+	}
+	return obj
+}
+
+func treeBinary(v []byte) string {
+	return base64.StdEncoding.EncodeToString(v)
+}
+
+// treeFloat formats a float like the JSON encoder, which writes NaN and
+// infinities as strings.
+func treeFloat(v float64, bitSize int) interface{} {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return json.Number(strconv.FormatFloat(v, 'f', -1, bitSize))
+}
+
+func treeComplex(v complex128) string {
+	r, i := real(v), imag(v)
+	return strconv.FormatFloat(r, 'f', -1, 64) + "+" + strconv.FormatFloat(i, 'f', -1, 64) + "i"
+}
+
+// treeDuration encodes a duration with the configured DurationEncoder,
+// falling back to nanoseconds like the JSON encoder.
+func treeDuration(cfg *EncoderConfig, v time.Duration) interface{} {
+	if cfg.EncodeDuration != nil {
+		arr := &treeArrayEncoder{EncoderConfig: cfg}
+		cfg.EncodeDuration(v, arr)
+		if len(arr.elems) > 0 {
+			return arr.elems[0]
+		}
+	}
+	return json.Number(strconv.FormatInt(int64(v), 10))
+}
+
+// treeTime encodes a time with the configured TimeEncoder, falling back to
+// nanoseconds since the epoch like the JSON encoder.
+func treeTime(cfg *EncoderConfig, v time.Time) interface{} {
+	if cfg.EncodeTime != nil {
+		arr := &treeArrayEncoder{EncoderConfig: cfg}
+		cfg.EncodeTime(v, arr)
+		if len(arr.elems) > 0 {
+			return arr.elems[0]
+		}
+	}
+	return json.Number(strconv.FormatInt(v.UnixNano(), 10))
+}
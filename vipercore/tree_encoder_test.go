@@ -0,0 +1,85 @@
+package vipercore
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTreeEncoderMatchesDecodedJSON(t *testing.T) {
+	for _, cfg := range []EncoderConfig{
+		{EncodeTime: EpochTimeEncoder, EncodeDuration: SecondsDurationEncoder},
+		{EncodeTime: ISO8601TimeEncoder, EncodeDuration: StringDurationEncoder},
+		{EncodeTime: func(time.Time, PrimitiveArrayEncoder) {}, EncodeDuration: func(time.Duration, PrimitiveArrayEncoder) {}},
+	} {
+		jsonEnc := newJSONEncoder(cfg, false)
+		treeEnc := newTreeEncoder(&cfg)
+		for _, enc := range []ObjectEncoder{jsonEnc, treeEnc} {
+			enc.AddString("service", "api")
+			enc.OpenNamespace("request")
+			enc.AddInt("attempt", 2)
+		}
+
+		fields := []Field{
+			{Key: "int", Type: Int64Type, Integer: -42},
+			{Key: "uint", Type: Uint64Type, Integer: -1},
+			{Key: "float", Type: Float64Type, Integer: int64(math.Float64bits(1.5))},
+			{Key: "float32", Type: Float32Type, Integer: int64(math.Float32bits(0.1))},
+			{Key: "nan", Type: Float64Type, Integer: int64(math.Float64bits(math.NaN()))},
+			{Key: "inf", Type: Float64Type, Integer: int64(math.Float64bits(math.Inf(-1)))},
+			{Key: "complex", Type: Complex128Type, Interface: complex(1.5, -2)},
+			{Key: "binary", Type: BinaryType, Interface: []byte("bytes")},
+			{Key: "bytestring", Type: ByteStringType, Interface: []byte("text")},
+			{Key: "bool", Type: BoolType, Integer: 1},
+			{Key: "duration", Type: DurationType, Integer: int64(1500 * time.Millisecond)},
+			{Key: "time", Type: TimeType, Integer: time.Unix(1, 500).UnixNano(), Interface: time.UTC},
+			{Key: "reflect", Type: ReflectType, Interface: map[string]interface{}{"b": []int{1, 2}, "a": "x<y"}},
+			{Key: "nil", Type: ReflectType},
+			{Key: "unmarshalable", Type: ReflectType, Interface: noJSON{}},
+			{Key: "object", Type: ObjectMarshalerType, Interface: turducken{}},
+			{Key: "array", Type: ArrayMarshalerType, Interface: turduckens(2)},
+			{Key: "empty", Type: ObjectMarshalerType, Interface: ObjectMarshalerFunc(func(ObjectEncoder) error { return nil })},
+			{Key: "failing", Type: ObjectMarshalerType, Interface: loggable{false}},
+			{Key: "error", Type: ErrorType, Interface: errors.New("failed")},
+			{Key: "inner", Type: NamespaceType},
+			{Key: "skipped", Type: SkipType},
+			{Key: "last", Type: StringType, String: "value"},
+		}
+
+		addFields(jsonEnc, fields)
+		jsonEnc.closeOpenNamespaces()
+		js := "{" + jsonEnc.buf.String() + "}"
+		d := json.NewDecoder(strings.NewReader(js))
+		d.UseNumber()
+		want, err := decodeJSONValue(d)
+		require.NoError(t, err, "Unexpected error decoding the JSON encoder's output.")
+
+		got := treeEnc.withFields(fields)
+		assert.Equal(t, want, got, "Expected the same fields as a JSON round trip.")
+		out := newJSONEncoder(cfg, false)
+		writeDecodedJSON(out, got)
+		assert.Equal(t, js, out.buf.String(), "Expected the same JSON as the JSON encoder.")
+	}
+}
+
+func TestTreeEncoderClone(t *testing.T) {
+	parent := newTreeEncoder(&EncoderConfig{})
+	parent.OpenNamespace("ns")
+	clone := parent.clone()
+
+	// Adding to the parent shouldn't affect the clone, and vice versa.
+	parent.AddString("foo", "bar")
+	clone.AddString("baz", "bing")
+
+	assert.Equal(t, decodedObject{{"ns", decodedObject{{"foo", "bar"}}}}, parent.object(), "Unexpected parent fields.")
+	assert.Equal(t, decodedObject{{"ns", decodedObject{{"baz", "bing"}}}}, clone.object(), "Unexpected clone fields.")
+	assert.Equal(t, decodedObject{{"ns", decodedObject{{"foo", "bar"}, {"extra", true}}}},
+		parent.withFields([]Field{{Key: "extra", Type: BoolType, Integer: 1}}), "Unexpected fields with extra fields.")
+	assert.Equal(t, decodedObject{{"ns", decodedObject{{"foo", "bar"}}}}, parent.object(), "Expected extra fields not to change the encoder.")
+}
@@ -0,0 +1,121 @@
+
+
+package vipertest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gottingen/viper/vipercore"
+)
+
+// MockClock is a vipercore.Clock that only moves when told to, which lets
+// tests of time-dependent logging (sampling, deduplication, and the like)
+// run without sleeping.
+//
+//   clock := vipertest.NewMockClock()
+//   logger := viper.New(core, viper.WithClock(clock))
+//   clock.Add(time.Minute)
+//
+// MockClock is safe for concurrent use.
+type MockClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*mockTicker
+}
+
+type mockTicker struct {
+	clock  *MockClock
+	period time.Duration
+	next   time.Time
+	ch     chan time.Time
+}
+
+func (t *mockTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+// Stop removes the ticker from its clock, so that it no longer fires.
+func (t *mockTicker) Stop() {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, other := range c.tickers {
+		if other == t {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+// NewMockClock builds a MockClock set to the Unix epoch.
+func NewMockClock() *MockClock {
+	return NewMockClockAt(time.Unix(0, 0))
+}
+
+// NewMockClockAt builds a MockClock set to the given time.
+func NewMockClockAt(t time.Time) *MockClock {
+	return &MockClock{now: t}
+}
+
+// Now returns the clock's current time.
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker returns a vipercore.Ticker that ticks each time the clock is
+// advanced past a multiple of the given interval. Like a real ticker, it
+// drops ticks that aren't received in time.
+func (c *MockClock) NewTicker(d time.Duration) vipercore.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for MockClock.NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &mockTicker{
+		clock:  c,
+		period: d,
+		next:   c.now.Add(d),
+		ch:     make(chan time.Time, 1),
+	}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Add advances the clock by the given duration, firing any tickers that come
+// due along the way.
+func (c *MockClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		// Fire tickers in chronological order, moving the clock to each tick
+		// so that receivers observe a consistent time.
+		sort.Slice(c.tickers, func(i, j int) bool {
+			return c.tickers[i].next.Before(c.tickers[j].next)
+		})
+		if len(c.tickers) == 0 || c.tickers[0].next.After(end) {
+			break
+		}
+		t := c.tickers[0]
+		c.now = t.next
+		select {
+		case t.ch <- t.next:
+		default:
+		}
+		t.next = t.next.Add(t.period)
+	}
+	c.now = end
+}
+
+// Set moves the clock to the given time, firing any tickers that come due.
+// Moving the clock backwards doesn't fire any tickers.
+func (c *MockClock) Set(t time.Time) {
+	c.Add(t.Sub(c.Now()))
+}
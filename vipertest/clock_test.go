@@ -0,0 +1,85 @@
+
+
+package vipertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gottingen/viper"
+	"github.com/gottingen/viper/vipercore"
+	"github.com/gottingen/viper/vipertest/observer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewMockClockAt(start)
+	assert.Equal(t, start, clock.Now(), "Unexpected initial time.")
+
+	clock.Add(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), clock.Now(), "Unexpected time after Add.")
+
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now(), "Unexpected time after Set.")
+
+	assert.Equal(t, time.Unix(0, 0), NewMockClock().Now(), "Expected default clock to start at the epoch.")
+}
+
+func TestMockClockTickers(t *testing.T) {
+	clock := NewMockClock()
+	ticker := clock.NewTicker(time.Second)
+
+	clock.Add(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("Unexpected tick before the interval elapsed.")
+	default:
+	}
+
+	clock.Add(500 * time.Millisecond)
+	select {
+	case tick := <-ticker.C():
+		assert.Equal(t, time.Unix(1, 0), tick, "Unexpected tick time.")
+	default:
+		t.Fatal("Expected a tick once the interval elapsed.")
+	}
+
+	// Like real tickers, unreceived ticks are dropped.
+	clock.Add(5 * time.Second)
+	assert.Equal(t, time.Unix(2, 0), <-ticker.C(), "Expected the first pending tick.")
+	select {
+	case <-ticker.C():
+		t.Fatal("Expected extra ticks to be dropped.")
+	default:
+	}
+
+	ticker.Stop()
+	clock.Add(5 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("Expected no ticks after stopping the ticker.")
+	default:
+	}
+	assert.Empty(t, clock.tickers, "Expected stopped tickers to be removed from the clock.")
+
+	assert.Panics(t, func() { clock.NewTicker(0) }, "Expected a panic for a non-positive interval.")
+}
+
+func TestMockClockSampling(t *testing.T) {
+	clock := NewMockClock()
+	core, logs := observer.New(vipercore.DebugLevel)
+	logger := viper.New(vipercore.NewSampler(core, time.Second, 1, 100), viper.WithClock(clock))
+
+	for i := 0; i < 10; i++ {
+		logger.Info("sampled")
+	}
+	require.Equal(t, 1, logs.Len(), "Expected sampling within a tick.")
+
+	clock.Add(time.Second)
+	logger.Info("sampled")
+	assert.Equal(t, 2, logs.Len(), "Expected the sampler to reset after the mock clock ticked.")
+	assert.Equal(t, time.Unix(1, 0), logs.All()[1].Time, "Expected entries to use the mock clock.")
+}
@@ -0,0 +1,245 @@
+
+
+package vipertest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gottingen/viper"
+	"github.com/gottingen/viper/vipercore"
+)
+
+// UpdateEnv is the environment variable that makes golden loggers rewrite
+// their golden files instead of comparing against them, unless GoldenUpdate
+// says otherwise. It's read with strconv.ParseBool.
+const UpdateEnv = "VIPERTEST_UPDATE"
+
+// GoldenTime is the timestamp of every entry logged by a golden logger.
+var GoldenTime = time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+// GoldenCaller is the caller reported for every entry logged by a golden
+// logger.
+var GoldenCaller = vipercore.NewEntryCaller(0, "/golden/caller.go", 1, true)
+
+// GoldenStacktrace replaces the stacktraces of entries logged by a golden
+// logger.
+const GoldenStacktrace = "<stacktrace>"
+
+// GoldenOption configures the logger built by NewGolden.
+type GoldenOption interface {
+	applyGoldenOption(*goldenOptions)
+}
+
+type goldenOptions struct {
+	Encoding      string
+	EncoderConfig vipercore.EncoderConfig
+	KeepDurations bool
+	Update        bool
+	viperOptions  []viper.Option
+}
+
+type goldenOptionFunc func(*goldenOptions)
+
+func (f goldenOptionFunc) applyGoldenOption(opts *goldenOptions) {
+	f(opts)
+}
+
+// GoldenEncoding sets the name of the registered encoder used by a golden
+// logger. The default is "json".
+func GoldenEncoding(name string) GoldenOption {
+	return goldenOptionFunc(func(opts *goldenOptions) {
+		opts.Encoding = name
+	})
+}
+
+// GoldenEncoderConfig sets the EncoderConfig used by a golden logger. The
+// default is viper.NewProductionEncoderConfig().
+func GoldenEncoderConfig(cfg vipercore.EncoderConfig) GoldenOption {
+	return goldenOptionFunc(func(opts *goldenOptions) {
+		opts.EncoderConfig = cfg
+	})
+}
+
+// GoldenKeepDurations stops a golden logger from zeroing the values of
+// duration fields.
+func GoldenKeepDurations() GoldenOption {
+	return goldenOptionFunc(func(opts *goldenOptions) {
+		opts.KeepDurations = true
+	})
+}
+
+// GoldenUpdate sets whether a golden logger rewrites its golden file with the
+// current output instead of comparing against it. The default comes from the
+// UpdateEnv environment variable. To rewrite golden files with a test flag
+// instead, register the flag in the test package:
+//
+//   var update = flag.Bool("update", false, "rewrite golden files")
+//
+//   g := vipertest.NewGolden(t, path, vipertest.GoldenUpdate(*update))
+func GoldenUpdate(update bool) GoldenOption {
+	return goldenOptionFunc(func(opts *goldenOptions) {
+		opts.Update = update
+	})
+}
+
+// GoldenWrapOptions adds viper.Options to a golden logger.
+func GoldenWrapOptions(viperOpts ...viper.Option) GoldenOption {
+	return goldenOptionFunc(func(opts *goldenOptions) {
+		opts.viperOptions = viperOpts
+	})
+}
+
+// Golden captures the encoded output of a logger and compares it against a
+// golden file, which guards against accidental changes to the log schema.
+//
+//   g := vipertest.NewGolden(t, "testdata/login.golden")
+//   g.Logger().Info("user logged in", viper.Object("user", user))
+//   g.Verify()
+//
+// Setting VIPERTEST_UPDATE=1 when running go test rewrites the golden files
+// with the current output instead; see GoldenUpdate.
+//
+// To keep the output deterministic, every entry is logged at GoldenTime from
+// GoldenCaller, stacktraces are replaced with GoldenStacktrace, and the
+// values of duration fields are zeroed. Durations nested in marshalers or
+// reflected values aren't normalized.
+type Golden struct {
+	t      TestingT
+	path   string
+	update bool
+	buf    *Buffer
+	logger *viper.Logger
+}
+
+// NewGolden builds a Golden that compares against the file at path. Its
+// logger logs entries of all levels.
+func NewGolden(t TestingT, path string, opts ...GoldenOption) *Golden {
+	update, _ := strconv.ParseBool(os.Getenv(UpdateEnv))
+	cfg := goldenOptions{
+		Encoding:      "json",
+		EncoderConfig: viper.NewProductionEncoderConfig(),
+		Update:        update,
+	}
+	for _, o := range opts {
+		o.applyGoldenOption(&cfg)
+	}
+
+	enc, err := viper.NewEncoder(cfg.Encoding, cfg.EncoderConfig)
+	if err != nil {
+		t.Errorf("can't build %q encoder for golden file %q: %v", cfg.Encoding, path, err)
+		t.FailNow()
+		return nil
+	}
+
+	buf := &Buffer{}
+	writer := newTestingWriter(t)
+	viperOptions := []viper.Option{
+		viper.ErrorOutput(writer.WithMarkFailed(true)),
+		viper.AddCaller(),
+	}
+	viperOptions = append(viperOptions, cfg.viperOptions...)
+
+	core := &goldenCore{
+		Core:          vipercore.NewCore(enc, vipercore.Lock(buf), viper.LevelEnablerFunc(func(vipercore.Level) bool { return true })),
+		keepDurations: cfg.KeepDurations,
+	}
+	return &Golden{
+		t:      t,
+		path:   path,
+		update: cfg.Update,
+		buf:    buf,
+		logger: viper.New(core, viperOptions...),
+	}
+}
+
+// Logger returns the logger whose output is compared against the golden file.
+func (g *Golden) Logger() *viper.Logger {
+	return g.logger
+}
+
+// Output returns the output captured so far.
+func (g *Golden) Output() string {
+	return g.buf.String()
+}
+
+// Verify compares the captured output against the golden file, or rewrites
+// the golden file if the Golden was built to update it. It returns whether
+// the output matched.
+func (g *Golden) Verify() bool {
+	got := g.Output()
+
+	if g.update {
+		if err := os.MkdirAll(filepath.Dir(g.path), 0755); err != nil {
+			g.t.Errorf("can't create directory for golden file %q: %v", g.path, err)
+			return false
+		}
+		if err := ioutil.WriteFile(g.path, []byte(got), 0644); err != nil {
+			g.t.Errorf("can't update golden file %q: %v", g.path, err)
+			return false
+		}
+		return true
+	}
+
+	want, err := ioutil.ReadFile(g.path)
+	if err != nil {
+		g.t.Errorf("can't read golden file %q (set "+UpdateEnv+"=1 to create it): %v", g.path, err)
+		return false
+	}
+	if string(want) != got {
+		g.t.Errorf(
+			"output doesn't match golden file %q (set "+UpdateEnv+"=1 to accept it):\n--- want\n%s+++ got\n%s",
+			g.path, want, got,
+		)
+		return false
+	}
+	return true
+}
+
+// goldenCore normalizes the volatile parts of entries before writing them.
+type goldenCore struct {
+	vipercore.Core
+	keepDurations bool
+}
+
+func (c *goldenCore) With(fields []vipercore.Field) vipercore.Core {
+	return &goldenCore{
+		Core:          c.Core.With(c.normalize(fields)),
+		keepDurations: c.keepDurations,
+	}
+}
+
+func (c *goldenCore) Check(ent vipercore.Entry, ce *vipercore.CheckedEntry) *vipercore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *goldenCore) Write(ent vipercore.Entry, fields []vipercore.Field) error {
+	ent.Time = GoldenTime
+	if ent.Caller.Defined {
+		ent.Caller = GoldenCaller
+	}
+	if ent.Stack != "" {
+		ent.Stack = GoldenStacktrace
+	}
+	return c.Core.Write(ent, c.normalize(fields))
+}
+
+func (c *goldenCore) normalize(fields []vipercore.Field) []vipercore.Field {
+	if c.keepDurations {
+		return fields
+	}
+	normalized := make([]vipercore.Field, len(fields))
+	for i, f := range fields {
+		if f.Type == vipercore.DurationType {
+			f.Integer = 0
+		}
+		normalized[i] = f
+	}
+	return normalized
+}
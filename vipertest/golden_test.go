@@ -0,0 +1,163 @@
+
+
+package vipertest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gottingen/viper"
+	"github.com/gottingen/viper/vipercore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// goldenSpy is a testLogSpy that also captures errors instead of failing the
+// test.
+type goldenSpy struct {
+	*testLogSpy
+
+	Errors []string
+}
+
+func newGoldenSpy(t testing.TB) *goldenSpy {
+	return &goldenSpy{testLogSpy: newTestLogSpy(t)}
+}
+
+func (t *goldenSpy) FailNow() {
+	t.Fail()
+	runtime.Goexit()
+}
+
+func (t *goldenSpy) Errorf(format string, args ...interface{}) {
+	t.Errors = append(t.Errors, fmt.Sprintf(format, args...))
+	t.Fail()
+}
+
+func logGoldenEntries(logger *viper.Logger) {
+	logger = logger.Named("golden").With(viper.String("service", "api"))
+	logger.Info("request served", viper.Duration("elapsed", 123*time.Millisecond), viper.Int("status", 200))
+	logger.Error("request failed", viper.Error(fmt.Errorf("connection reset")))
+}
+
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		path string
+		opts []GoldenOption
+	}{
+		{"testdata/golden_json.golden", nil},
+		{
+			"testdata/golden_console.golden",
+			[]GoldenOption{
+				GoldenEncoding("console"),
+				GoldenEncoderConfig(viper.NewDevelopmentEncoderConfig()),
+				GoldenWrapOptions(viper.AddStacktrace(viper.ErrorLevel)),
+			},
+		},
+		{"testdata/golden_durations.golden", []GoldenOption{GoldenKeepDurations()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			g := NewGolden(t, tt.path, tt.opts...)
+			logGoldenEntries(g.Logger())
+			assert.True(t, g.Verify(), "Expected output to match golden file.")
+		})
+	}
+}
+
+func TestGoldenMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vipertest-golden")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mismatch.golden")
+	require.NoError(t, ioutil.WriteFile(path, []byte("{}\n"), 0644), "Failed to write golden file.")
+
+	spy := newGoldenSpy(t)
+	g := NewGolden(spy, path, GoldenUpdate(false))
+	g.Logger().Info("changed")
+	assert.False(t, g.Verify(), "Expected mismatched output to fail.")
+	spy.AssertFailed()
+	require.Equal(t, 1, len(spy.Errors), "Expected a single error.")
+	assert.Contains(t, spy.Errors[0], "--- want\n{}\n+++ got\n", "Expected error to show the golden file.")
+	assert.Contains(t, spy.Errors[0], `"msg":"changed"`, "Expected error to show the output.")
+}
+
+func TestGoldenMissingFile(t *testing.T) {
+	spy := newGoldenSpy(t)
+	g := NewGolden(spy, "testdata/not-there.golden", GoldenUpdate(false))
+	g.Logger().Info("msg")
+	assert.False(t, g.Verify(), "Expected a missing golden file to fail.")
+	spy.AssertFailed()
+	require.Equal(t, 1, len(spy.Errors), "Expected a single error.")
+	assert.Contains(t, spy.Errors[0], UpdateEnv, "Expected error to suggest updating.")
+}
+
+func TestGoldenUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vipertest-golden")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nested", "updated.golden")
+	g := NewGolden(t, path, GoldenUpdate(true))
+	g.Logger().Info("msg")
+	require.True(t, g.Verify(), "Expected update to succeed.")
+
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "Failed to read updated golden file.")
+	assert.Equal(t, g.Output(), string(contents), "Unexpected golden file contents.")
+}
+
+func TestGoldenUpdateEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vipertest-golden")
+	require.NoError(t, err, "Failed to create temp dir.")
+	defer os.RemoveAll(dir)
+
+	old, set := os.LookupEnv(UpdateEnv)
+	defer func() {
+		if set {
+			os.Setenv(UpdateEnv, old)
+		} else {
+			os.Unsetenv(UpdateEnv)
+		}
+	}()
+	require.NoError(t, os.Setenv(UpdateEnv, "1"), "Failed to set %s.", UpdateEnv)
+
+	path := filepath.Join(dir, "env.golden")
+	g := NewGolden(t, path)
+	g.Logger().Info("msg")
+	require.True(t, g.Verify(), "Expected update to succeed.")
+	_, err = os.Stat(path)
+	assert.NoError(t, err, "Expected %s to rewrite the golden file.", UpdateEnv)
+
+	spy := newGoldenSpy(t)
+	g = NewGolden(spy, filepath.Join(dir, "not-there.golden"), GoldenUpdate(false))
+	g.Logger().Info("msg")
+	assert.False(t, g.Verify(), "Expected GoldenUpdate to take precedence over %s.", UpdateEnv)
+}
+
+func TestGoldenUnknownEncoding(t *testing.T) {
+	spy := newGoldenSpy(t)
+	done := make(chan struct{})
+	go func() {
+		// FailNow stops the goroutine, like it would a test.
+		defer close(done)
+		NewGolden(spy, "testdata/unused.golden", GoldenEncoding("not-there"))
+	}()
+	<-done
+	spy.AssertFailed()
+	assert.Equal(t, 1, len(spy.Errors), "Expected a single error.")
+}
+
+func TestGoldenLevels(t *testing.T) {
+	g := NewGolden(t, "unused", GoldenEncoderConfig(vipercore.EncoderConfig{MessageKey: "m"}))
+	g.Logger().Trace("trace")
+	assert.Equal(t, `{"m":"trace"}`+"\n", g.Output(), "Expected all levels to be logged.")
+}
@@ -0,0 +1,146 @@
+
+
+package observer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TestingT is the subset of the API provided by *testing.T and *testing.B
+// that ObservedLogs' assertions rely on.
+type TestingT interface {
+	// Logs the given message and marks the test as failed.
+	Errorf(string, ...interface{})
+}
+
+// AssertLen checks that exactly n entries were observed. On failure, it
+// reports the observed entries. It returns whether the assertion succeeded.
+func (o *ObservedLogs) AssertLen(t TestingT, n int) bool {
+	helper(t)
+	logs := o.All()
+	if len(logs) == n {
+		return true
+	}
+	lines := make([]string, len(logs))
+	for i, e := range logs {
+		lines[i] = "\t" + formatEntry(e)
+	}
+	t.Errorf("expected %d observed logs, got %d:\n%s", n, len(logs), strings.Join(lines, "\n"))
+	return false
+}
+
+// AssertMessages checks that the observed entries have exactly the specified
+// messages, in order. On failure, it reports a line-by-line diff of the
+// expected and observed messages. It returns whether the assertion
+// succeeded.
+func (o *ObservedLogs) AssertMessages(t TestingT, want ...string) bool {
+	helper(t)
+	got := messages(o.All())
+	if equalStrings(want, got) {
+		return true
+	}
+	t.Errorf("unexpected observed messages (-want +got):\n%s", diffLines(want, got))
+	return false
+}
+
+// AssertMessagesInOrder checks that entries with the specified messages were
+// observed in that relative order, possibly interleaved with other entries.
+// It returns whether the assertion succeeded.
+func (o *ObservedLogs) AssertMessagesInOrder(t TestingT, want ...string) bool {
+	helper(t)
+	got := messages(o.All())
+	i := 0
+	for _, msg := range got {
+		if i < len(want) && msg == want[i] {
+			i++
+		}
+	}
+	if i == len(want) {
+		return true
+	}
+	t.Errorf(
+		"expected message %q (#%d) after %q, observed messages:\n\t%s",
+		want[i], i+1, want[:i], strings.Join(quoteAll(got), "\n\t"),
+	)
+	return false
+}
+
+func helper(t TestingT) {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+}
+
+func formatEntry(e LoggedEntry) string {
+	return fmt.Sprintf("%s\t%q\t%v", e.Level.CapitalString(), e.Message, e.ContextMap())
+}
+
+func messages(logs []LoggedEntry) []string {
+	msgs := make([]string, len(logs))
+	for i, e := range logs {
+		msgs[i] = e.Message
+	}
+	return msgs
+}
+
+func quoteAll(ss []string) []string {
+	quoted := make([]string, len(ss))
+	for i, s := range ss {
+		quoted[i] = fmt.Sprintf("%q", s)
+	}
+	return quoted
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffLines renders a minimal line diff of want and got, marking lines only
+// in want with "-" and lines only in got with "+".
+func diffLines(want, got []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of want[i:]
+	// and got[j:].
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			switch {
+			case want[i] == got[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i] == got[j]:
+			fmt.Fprintf(&sb, "\t  %q\n", want[i])
+			i++
+			j++
+		case j == len(got) || (i < len(want) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&sb, "\t- %q\n", want[i])
+			i++
+		default:
+			fmt.Fprintf(&sb, "\t+ %q\n", got[j])
+			j++
+		}
+	}
+	return sb.String()
+}
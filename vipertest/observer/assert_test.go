@@ -0,0 +1,86 @@
+
+
+package observer_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/gottingen/viper"
+	. "github.com/gottingen/viper/vipertest/observer"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func observeMessages(msgs ...string) *ObservedLogs {
+	observer, logs := New(viper.InfoLevel)
+	logger := viper.New(observer)
+	for _, msg := range msgs {
+		logger.Info(msg, viper.String("k", "v"))
+	}
+	return logs
+}
+
+func TestAssertLen(t *testing.T) {
+	logs := observeMessages("a", "b")
+
+	rt := &recordingT{}
+	assert.True(t, logs.AssertLen(rt, 2), "Expected assertion to pass.")
+	assert.Empty(t, rt.errors, "Unexpected failure.")
+
+	assert.False(t, logs.AssertLen(rt, 1), "Expected assertion to fail.")
+	assert.Equal(
+		t,
+		[]string{"expected 1 observed logs, got 2:\n" +
+			"\tINFO\t\"a\"\tmap[k:v]\n" +
+			"\tINFO\t\"b\"\tmap[k:v]"},
+		rt.errors,
+		"Unexpected failure message.",
+	)
+}
+
+func TestAssertMessages(t *testing.T) {
+	logs := observeMessages("start", "retry", "done")
+
+	rt := &recordingT{}
+	assert.True(t, logs.AssertMessages(rt, "start", "retry", "done"), "Expected assertion to pass.")
+	assert.Empty(t, rt.errors, "Unexpected failure.")
+
+	assert.False(t, logs.AssertMessages(rt, "start", "done", "exit"), "Expected assertion to fail.")
+	assert.Equal(
+		t,
+		[]string{"unexpected observed messages (-want +got):\n" +
+			"\t  \"start\"\n" +
+			"\t+ \"retry\"\n" +
+			"\t  \"done\"\n" +
+			"\t- \"exit\"\n"},
+		rt.errors,
+		"Unexpected failure message.",
+	)
+}
+
+func TestAssertMessagesInOrder(t *testing.T) {
+	logs := observeMessages("start", "retry", "retry", "done")
+
+	rt := &recordingT{}
+	assert.True(t, logs.AssertMessagesInOrder(rt, "start", "done"), "Expected assertion to pass.")
+	assert.True(t, logs.AssertMessagesInOrder(rt), "Expected empty assertion to pass.")
+	assert.Empty(t, rt.errors, "Unexpected failure.")
+
+	assert.False(t, logs.AssertMessagesInOrder(rt, "start", "done", "retry"), "Expected assertion to fail.")
+	assert.Equal(
+		t,
+		[]string{"expected message \"retry\" (#3) after [\"start\" \"done\"], observed messages:\n" +
+			"\t\"start\"\n\t\"retry\"\n\t\"retry\"\n\t\"done\""},
+		rt.errors,
+		"Unexpected failure message.",
+	)
+}
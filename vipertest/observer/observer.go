@@ -15,9 +15,14 @@ import (
 )
 
 // ObservedLogs is a concurrency-safe, ordered collection of observed logs.
+//
+// The Filter methods return a snapshot of the matching logs, so they can be
+// chained to narrow down a query; entries logged after the snapshot was taken
+// only appear in the original collection.
 type ObservedLogs struct {
-	mu   sync.RWMutex
-	logs []LoggedEntry
+	mu      sync.RWMutex
+	logs    []LoggedEntry
+	changed chan struct{} // closed and replaced when logs are added
 }
 
 // Len returns the number of items in the collection.
@@ -86,6 +91,100 @@ func (o *ObservedLogs) FilterField(field vipercore.Field) *ObservedLogs {
 	})
 }
 
+// FilterLevelRange filters entries to those logged at or above min and at or
+// below max.
+func (o *ObservedLogs) FilterLevelRange(min, max vipercore.Level) *ObservedLogs {
+	return o.filter(func(e LoggedEntry) bool {
+		return min.Enabled(e.Level) && e.Level.Enabled(max)
+	})
+}
+
+// FilterLoggerName filters entries to those logged by the named logger.
+func (o *ObservedLogs) FilterLoggerName(name string) *ObservedLogs {
+	return o.filter(func(e LoggedEntry) bool {
+		return e.LoggerName == name
+	})
+}
+
+// FilterCallerFile filters entries to those logged from the specified file.
+// The file matches if it's either the caller's full path or a suffix of it
+// starting at a path separator, like "viper/logger.go".
+func (o *ObservedLogs) FilterCallerFile(file string) *ObservedLogs {
+	return o.filter(func(e LoggedEntry) bool {
+		if !e.Caller.Defined {
+			return false
+		}
+		return e.Caller.File == file || strings.HasSuffix(e.Caller.File, "/"+file)
+	})
+}
+
+// FilterFieldKey filters entries to those that have a field with the
+// specified key.
+func (o *ObservedLogs) FilterFieldKey(key string) *ObservedLogs {
+	return o.FilterFieldFunc(func(f vipercore.Field) bool {
+		return f.Key == key
+	})
+}
+
+// FilterFieldFunc filters entries to those that have a field for which the
+// predicate returns true.
+func (o *ObservedLogs) FilterFieldFunc(match func(vipercore.Field) bool) *ObservedLogs {
+	return o.filter(func(e LoggedEntry) bool {
+		for _, ctxField := range e.Context {
+			if match(ctxField) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// FilterTimeRange filters entries to those logged at or after start and
+// before end.
+func (o *ObservedLogs) FilterTimeRange(start, end time.Time) *ObservedLogs {
+	return o.filter(func(e LoggedEntry) bool {
+		return !e.Time.Before(start) && e.Time.Before(end)
+	})
+}
+
+// Filter filters entries to those for which the predicate returns true.
+func (o *ObservedLogs) Filter(keep func(LoggedEntry) bool) *ObservedLogs {
+	return o.filter(keep)
+}
+
+// WaitFor blocks until an entry matching the predicate has been observed or
+// the timeout expires, which is useful when testing code that logs from
+// other goroutines. It returns the first matching entry and whether one was
+// found. Entries observed before the call are considered too.
+func (o *ObservedLogs) WaitFor(match func(LoggedEntry) bool, timeout time.Duration) (LoggedEntry, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		o.mu.Lock()
+		logs := o.logs
+		if o.changed == nil {
+			o.changed = make(chan struct{})
+		}
+		changed := o.changed
+		o.mu.Unlock()
+
+		// Entries are only ever appended, so it's safe to run the predicate
+		// without holding the lock.
+		for _, entry := range logs {
+			if match(entry) {
+				return entry, true
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return LoggedEntry{}, false
+		}
+	}
+}
+
 func (o *ObservedLogs) filter(match func(LoggedEntry) bool) *ObservedLogs {
 	o.mu.RLock()
 	defer o.mu.RUnlock()
@@ -102,6 +201,10 @@ func (o *ObservedLogs) filter(match func(LoggedEntry) bool) *ObservedLogs {
 func (o *ObservedLogs) add(log LoggedEntry) {
 	o.mu.Lock()
 	o.logs = append(o.logs, log)
+	if o.changed != nil {
+		close(o.changed)
+		o.changed = nil
+	}
 	o.mu.Unlock()
 }
 
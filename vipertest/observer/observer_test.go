@@ -195,3 +195,138 @@ func TestFilters(t *testing.T) {
 		assert.Equal(t, tt.want, got, tt.msg)
 	}
 }
+
+func TestRichFilters(t *testing.T) {
+	start := time.Unix(1000, 0)
+	logs := []LoggedEntry{
+		{
+			Entry: vipercore.Entry{
+				Level:      viper.DebugLevel,
+				Time:       start,
+				LoggerName: "db",
+				Message:    "query",
+				Caller:     vipercore.NewEntryCaller(0, "/src/app/db/conn.go", 10, true),
+			},
+			Context: []vipercore.Field{viper.Int("rows", 3)},
+		},
+		{
+			Entry: vipercore.Entry{
+				Level:      viper.WarnLevel,
+				Time:       start.Add(time.Second),
+				LoggerName: "db",
+				Message:    "slow query",
+				Caller:     vipercore.NewEntryCaller(0, "/src/app/db/conn.go", 20, true),
+			},
+			Context: []vipercore.Field{viper.Int("rows", 300), viper.Duration("elapsed", time.Second)},
+		},
+		{
+			Entry: vipercore.Entry{
+				Level:      viper.ErrorLevel,
+				Time:       start.Add(2 * time.Second),
+				LoggerName: "http",
+				Message:    "request failed",
+				Caller:     vipercore.NewEntryCaller(0, "/src/app/http/handler.go", 30, true),
+			},
+			Context: []vipercore.Field{viper.String("path", "/")},
+		},
+		{
+			Entry:   vipercore.Entry{Level: viper.InfoLevel, Time: start.Add(3 * time.Second), Message: "no caller"},
+			Context: []vipercore.Field{},
+		},
+	}
+
+	logger, sink := New(viper.DebugLevel)
+	for _, log := range logs {
+		logger.Write(log.Entry, log.Context)
+	}
+
+	tests := []struct {
+		msg      string
+		filtered *ObservedLogs
+		want     []LoggedEntry
+	}{
+		{
+			msg:      "filter by level range",
+			filtered: sink.FilterLevelRange(viper.InfoLevel, viper.WarnLevel),
+			want:     []LoggedEntry{logs[1], logs[3]},
+		},
+		{
+			msg:      "filter by logger name",
+			filtered: sink.FilterLoggerName("db"),
+			want:     logs[0:2],
+		},
+		{
+			msg:      "filter by caller file suffix",
+			filtered: sink.FilterCallerFile("db/conn.go"),
+			want:     logs[0:2],
+		},
+		{
+			msg:      "filter by full caller path",
+			filtered: sink.FilterCallerFile("/src/app/http/handler.go"),
+			want:     logs[2:3],
+		},
+		{
+			msg:      "caller file must match whole path components",
+			filtered: sink.FilterCallerFile("andler.go"),
+			want:     []LoggedEntry{},
+		},
+		{
+			msg:      "filter by field key",
+			filtered: sink.FilterFieldKey("rows"),
+			want:     logs[0:2],
+		},
+		{
+			msg: "filter by field predicate",
+			filtered: sink.FilterFieldFunc(func(f vipercore.Field) bool {
+				return f.Key == "rows" && f.Integer > 100
+			}),
+			want: logs[1:2],
+		},
+		{
+			msg:      "filter by time range",
+			filtered: sink.FilterTimeRange(start.Add(time.Second), start.Add(3*time.Second)),
+			want:     logs[1:3],
+		},
+		{
+			msg: "filter by entry predicate",
+			filtered: sink.Filter(func(e LoggedEntry) bool {
+				return e.Caller.Line == 30
+			}),
+			want: logs[2:3],
+		},
+		{
+			msg:      "chained filters",
+			filtered: sink.FilterLoggerName("db").FilterLevelRange(viper.InfoLevel, viper.FatalLevel).FilterFieldKey("elapsed"),
+			want:     logs[1:2],
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.filtered.All(), tt.msg)
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	observer, logs := New(viper.InfoLevel)
+	logger := viper.New(observer)
+	logger.Info("already logged")
+
+	entry, ok := logs.WaitFor(func(e LoggedEntry) bool { return e.Message == "already logged" }, time.Millisecond)
+	assert.True(t, ok, "Expected to find an entry logged before waiting.")
+	assert.Equal(t, "already logged", entry.Message, "Unexpected entry.")
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(time.Millisecond)
+			logger.Info("async", viper.Int("i", i))
+		}
+	}()
+	entry, ok = logs.WaitFor(func(e LoggedEntry) bool {
+		return e.Message == "async" && e.ContextMap()["i"] == int64(2)
+	}, time.Second)
+	require.True(t, ok, "Expected to find an entry logged asynchronously.")
+	assert.Equal(t, int64(2), entry.ContextMap()["i"], "Unexpected entry.")
+
+	_, ok = logs.WaitFor(func(e LoggedEntry) bool { return e.Message == "never" }, 10*time.Millisecond)
+	assert.False(t, ok, "Expected to time out waiting for a missing entry.")
+}
@@ -22,8 +22,17 @@ import (
 // factories for other schemes using RegisterSink.
 //
 // URLs with the "file" scheme must use absolute paths on the local
-// filesystem. No user, password, port, fragments, or query parameters are
-// allowed, and the hostname must be empty or "localhost".
+// filesystem. No user, password, port, or fragments are allowed, the only
+// query parameter allowed is compress, and the hostname must be empty or
+// "localhost".
+//
+// Files whose paths end in ".gz" or ".zst" are written compressed with gzip or
+// zstd, as are files with a compress=gzip or compress=zstd query parameter;
+// compress=none turns this off. Other compressions can be added with
+// RegisterCompression. Syncing a compressed file flushes the compressor, so
+// that everything logged so far can be decompressed, and the returned function
+// finishes the compressed stream. Loggers built from a Config close their
+// outputs with the function returned by Config.BuildWithClose.
 //
 // Since it's common to write logs to the local filesystem, URLs without a
 // scheme (e.g., "/var/log/foo.log") are treated as local file paths. Without